@@ -0,0 +1,19 @@
+package bucket
+
+import (
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/principal"
+)
+
+// RoleOf returns the role principal has in the bucket
+func RoleOf(id events.EntityID, p principal.ID, stream ...events.Event) (Role, error) {
+	const op errors.Op = "bucket.RoleOf"
+
+	s, err := buildState(id, stream)
+	if err != nil {
+		return RoleNone, errors.New(op, errors.KindUnexpected, "Error when building state for access", err)
+	}
+
+	return s.role(p), nil
+}
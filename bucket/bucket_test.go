@@ -1,11 +1,15 @@
 package bucket
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/juelko/bucket/pkg/errors"
 	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/events/eventstest"
+	"github.com/juelko/bucket/pkg/principal"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -15,10 +19,11 @@ func TestOpen(t *testing.T) {
 	t.Parallel()
 
 	testCases := []struct {
-		desc string
-		args *OpenRequest
-		want *Opened
-		err  error
+		desc  string
+		args  *OpenRequest
+		owner principal.ID
+		want  *Opened
+		err   error
 	}{
 		{
 			desc: "happy",
@@ -27,12 +32,27 @@ func TestOpen(t *testing.T) {
 				Title: "TestTitle",
 				Desc:  "Test Descritption",
 			},
+			owner: "TestOwner",
 			want: &Opened{
-				events.Base{ID: "TestBucket", V: 1},
-				BucketData{Title: "TestTitle", Description: "Test Descritption"},
+				Base:       events.Base{ID: "TestBucket", V: 1},
+				BucketData: BucketData{Title: "TestTitle", Description: "Test Descritption"},
+				Owner:      "TestOwner",
 			},
 			err: nil,
 		},
+		{
+			desc: "invalid owner",
+			args: &OpenRequest{
+				ID:    "TestBucket",
+				Title: "TestTitle",
+				Desc:  "Test Descritption",
+			},
+			owner: "",
+			want:  nil,
+			err: &errors.Error{Op: "bucket.Open", Kind: errors.KindValidation, Msg: "invalid owner", Wraps: &errors.Error{
+				Op: "principal.ID.Validate", Kind: errors.KindValidation, Msg: "Invalid value for principal.ID",
+			}},
+		},
 		{
 			desc: "invalid id",
 			args: &OpenRequest{
@@ -40,8 +60,9 @@ func TestOpen(t *testing.T) {
 				Title: "TestTitle",
 				Desc:  "Test Descritption",
 			},
-			want: nil,
-			err:  &errors.Error{Op: "bucket.Open", Kind: 3, Msg: "invalid request", Wraps: nil},
+			owner: "TestOwner",
+			want:  nil,
+			err:   &errors.Error{Op: "bucket.Open", Kind: 3, Msg: "invalid request", Wraps: nil},
 		},
 	}
 
@@ -51,7 +72,7 @@ func TestOpen(t *testing.T) {
 		t.Run(tC.desc, func(t *testing.T) {
 			t.Parallel()
 
-			got, err := Open(tC.args)
+			got, err := Open(tC.args, tC.owner)
 
 			if tC.want != nil {
 				require.Nil(t, err, "require error to be nil")
@@ -61,6 +82,7 @@ func TestOpen(t *testing.T) {
 				assert.Equal(t, tC.want.EntityVersion(), got.EntityVersion())
 				assert.Equal(t, tC.want.Title, got.Title)
 				assert.Equal(t, tC.want.Description, got.Description)
+				assert.Equal(t, tC.want.Owner, got.Owner)
 			} else {
 				require.Nil(t, got, "require got to be nil")
 				assert.Equal(t, tC.err, err)
@@ -220,197 +242,1239 @@ func TestClose(t *testing.T) {
 	}
 }
 
-func TestNewView(t *testing.T) {
+func TestReopen(t *testing.T) {
 	t.Parallel()
 
 	testCases := []struct {
 		desc   string
-		id     events.EntityID
+		req    *ReopenRequest
 		stream []events.Event
-		want   *View
+		want   events.Event
 		err    error
 	}{
 		{
-			desc:   "ok",
-			id:     events.EntityID("TestView"),
-			stream: closedTestStream("TestView"),
-			want:   &View{ID: "TestView", Title: "ClosedTitle", Description: "Closed Description", Version: 3, IsClosed: true},
-			err:    nil,
+			desc:   "happy",
+			req:    &ReopenRequest{ID: "TestBucket"},
+			stream: closedTestStream("TestBucket"),
+			want:   &Reopened{events.Base{ID: "TestBucket", V: 4}},
+		},
+		{
+			desc:   "open stream",
+			req:    &ReopenRequest{ID: "TestBucket"},
+			stream: openTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.reopening", Kind: 2, Msg: "Bucket is not closed", Wraps: error(nil)},
+		},
+		{
+			desc:   "reopened stream",
+			req:    &ReopenRequest{ID: "TestBucket"},
+			stream: reopenedTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.reopening", Kind: 2, Msg: "Bucket is not closed", Wraps: error(nil)},
 		},
 		{
 			desc:   "empty stream",
-			id:     events.EntityID("TestView"),
+			req:    &ReopenRequest{ID: "TestBucket"},
 			stream: []events.Event{},
 			want:   nil,
-			err:    &errors.Error{Op: "bucket.NewView", Kind: errors.KindUnexpected, Msg: "Error when build state for view", Wraps: fmt.Errorf("Empty stream")},
+			err:    &errors.Error{Op: "bucket.stateForReopening", Kind: 1, Msg: "Error when building state for reopening", Wraps: fmt.Errorf("Empty stream")},
 		},
 	}
+
 	for i := range testCases {
 		tC := testCases[i]
+
 		t.Run(tC.desc, func(t *testing.T) {
 			t.Parallel()
 
-			got, err := NewView(tC.id, tC.stream...)
+			got, err := Reopen(tC.req, tC.stream)
 
-			assert.Equal(t, tC.want, got)
-			assert.Equal(t, tC.err, err)
+			if tC.want != nil {
+				require.Nil(t, err, "require error to be nil")
+				want := tC.want.(*Reopened)
+				e, ok := got.(*Reopened)
+				require.True(t, ok, "failed type casting got to Reopened")
 
+				assert.Equal(t, want.Type(), e.Type())
+				assert.Equal(t, want.EntityID(), e.EntityID())
+				assert.Equal(t, want.EntityVersion(), e.EntityVersion())
+			} else {
+				require.Nil(t, got, "require got to be nil")
+				assert.Equal(t, tC.err, err)
+			}
 		})
 	}
 }
 
-// Tests for internals
-func TestBuildState(t *testing.T) {
+func TestAddItem(t *testing.T) {
+	t.Parallel()
+
 	testCases := []struct {
 		desc   string
-		id     events.EntityID
+		req    *AddItemRequest
 		stream []events.Event
-		want   state
+		want   events.Event
 		err    error
 	}{
 		{
-			desc:   "open",
-			id:     events.EntityID("TestView"),
-			stream: openTestStream("TestView"),
-			want: state{
-				id:     events.EntityID("TestView"),
-				title:  Title("TestTitle"),
-				desc:   Description("Test Description"),
-				closed: false,
-				v:      1,
+			desc:   "happy",
+			req:    &AddItemRequest{ID: "TestBucket", ItemID: "Item3", Name: "Third", Payload: Payload("3")},
+			stream: itemsTestStream("TestBucket"),
+			want: &ItemAdded{
+				events.Base{ID: "TestBucket", V: 4},
+				Item{ID: "Item3", Name: "Third", Payload: Payload("3")},
 			},
-			err: nil,
 		},
 		{
-			desc:   "updated",
-			id:     events.EntityID("TestView"),
-			stream: updatedTestStream("TestView"),
-			want: state{
-				id:     events.EntityID("TestView"),
-				title:  Title("UpdatedTitle"),
-				desc:   Description("Updated Description"),
-				closed: false,
-				v:      2,
-			},
-			err: nil,
+			desc:   "duplicate",
+			req:    &AddItemRequest{ID: "TestBucket", ItemID: "Item1", Name: "First"},
+			stream: itemsTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.addingItem", Kind: errors.KindExpected, Msg: "Item allready exists", Wraps: error(nil)},
 		},
 		{
-			desc:   "closed",
-			id:     events.EntityID("TestView"),
-			stream: closedTestStream("TestView"),
-			want: state{
-				id:     events.EntityID("TestView"),
-				title:  Title("ClosedTitle"),
-				desc:   Description("Closed Description"),
-				closed: true,
-				v:      3,
-			},
-			err: nil,
+			desc:   "too many items",
+			req:    &AddItemRequest{ID: "TestBucket", ItemID: "Item3", Name: "Third"},
+			stream: append(itemsTestStream("TestBucket"), &CapacityChanged{events.Base{ID: "TestBucket", V: 4}, Capacity{MaxItems: 2}}),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.addingItem", Kind: errors.KindExpected, Msg: "Bucket full", Wraps: error(nil)},
 		},
 		{
-			desc:   "id mismatch",
-			id:     events.EntityID("TestView"),
-			stream: openTestStream("DifferentStream"),
-			want:   state{},
-			err:    fmt.Errorf("ID Mismatch"),
+			desc:   "too large",
+			req:    &AddItemRequest{ID: "TestBucket", ItemID: "Item3", Name: "Third", Payload: Payload("33")},
+			stream: append(itemsTestStream("TestBucket"), &CapacityChanged{events.Base{ID: "TestBucket", V: 4}, Capacity{MaxSize: 3}}),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.addingItem", Kind: errors.KindExpected, Msg: "Bucket full", Wraps: error(nil)},
 		},
 		{
-			desc:   "empty stream",
-			id:     events.EntityID("TestView"),
-			stream: []events.Event{},
-			want:   state{},
-			err:    fmt.Errorf("Empty stream"),
+			desc:   "closed stream",
+			req:    &AddItemRequest{ID: "TestBucket", ItemID: "Item1", Name: "First"},
+			stream: closedTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.addingItem", Kind: errors.KindExpected, Msg: "Bucket is closed", Wraps: error(nil)},
 		},
 	}
+
 	for i := range testCases {
 		tC := testCases[i]
+
 		t.Run(tC.desc, func(t *testing.T) {
 			t.Parallel()
 
-			got, err := buildState(tC.id, tC.stream)
-
-			assert.Equal(t, tC.want, got, "state should be equal")
-			assert.Equal(t, tC.err, err, "state should be equal")
+			got, err := AddItem(tC.req, tC.stream)
 
+			assert.Equal(t, tC.want, got)
+			if tC.want == nil {
+				assert.Equal(t, tC.err, err)
+			}
 		})
 	}
 }
 
-func TestTitleValidation(t *testing.T) {
+func TestAddItemCopiesPayload(t *testing.T) {
+	t.Parallel()
+
+	req := &AddItemRequest{ID: "TestBucket", ItemID: "Item3", Name: "Third", Payload: Payload("3")}
+
+	got, err := AddItem(req, itemsTestStream("TestBucket"))
+	require.Nil(t, err)
+
+	req.Payload[0] = 'x'
+
+	assert.Equal(t, Payload("3"), got.(*ItemAdded).Payload, "changing the request should not change the event")
+}
+
+func TestRemoveItem(t *testing.T) {
 	t.Parallel()
+
 	testCases := []struct {
-		desc  string
-		title Title
-		want  *errors.Error
+		desc   string
+		req    *RemoveItemRequest
+		stream []events.Event
+		want   events.Event
+		err    error
 	}{
 		{
-			desc:  "ok",
-			title: Title("Test-Bucket Title_1234"),
-			want:  nil,
-		},
-		{
-			desc:  "illegal chars",
-			title: Title(`<sript>alert("PWND")</script>`),
-			want:  &errors.Error{Op: "bucket.Title.Validate", Kind: errors.KindValidation, Msg: "Invalid value for Title", Wraps: nil},
+			desc:   "happy",
+			req:    &RemoveItemRequest{ID: "TestBucket", ItemID: "Item1"},
+			stream: itemsTestStream("TestBucket"),
+			want:   &ItemRemoved{events.Base{ID: "TestBucket", V: 4}, "Item1"},
 		},
 		{
-			desc:  "too short",
-			title: Title("ID"),
-			want:  &errors.Error{Op: "bucket.Title.Validate", Kind: errors.KindValidation, Msg: "Invalid value for Title", Wraps: nil},
+			desc:   "not found",
+			req:    &RemoveItemRequest{ID: "TestBucket", ItemID: "Item3"},
+			stream: itemsTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.removingItem", Kind: errors.KindExpected, Msg: "Item not found", Wraps: error(nil)},
 		},
 		{
-			desc:  "too long",
-			title: Title("thisBucketNameisMoreThan64CharactersLong012345678901234567890123456789"),
-			want:  &errors.Error{Op: "bucket.Title.Validate", Kind: errors.KindValidation, Msg: "Invalid value for Title", Wraps: nil},
+			desc:   "closed stream",
+			req:    &RemoveItemRequest{ID: "TestBucket", ItemID: "Item1"},
+			stream: closedTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.removingItem", Kind: errors.KindExpected, Msg: "Bucket is closed", Wraps: error(nil)},
 		},
 	}
+
 	for i := range testCases {
 		tC := testCases[i]
+
 		t.Run(tC.desc, func(t *testing.T) {
 			t.Parallel()
 
-			got := tC.title.Validate()
+			got, err := RemoveItem(tC.req, tC.stream)
 
 			assert.Equal(t, tC.want, got)
-
+			if tC.want == nil {
+				assert.Equal(t, tC.err, err)
+			}
 		})
 	}
 }
 
-// helper funcs for testing
-func openTestStream(id events.EntityID) []events.Event {
+func TestMoveItem(t *testing.T) {
+	t.Parallel()
 
-	return []events.Event{
-		&Opened{
-			events.Base{ID: id, V: 1},
-			BucketData{"TestTitle", "Test Description"},
+	testCases := []struct {
+		desc   string
+		req    *MoveItemRequest
+		stream []events.Event
+		want   events.Event
+		err    error
+	}{
+		{
+			desc:   "happy",
+			req:    &MoveItemRequest{ID: "TestBucket", ItemID: "Item2", Position: 0},
+			stream: itemsTestStream("TestBucket"),
+			want:   &ItemMoved{events.Base{ID: "TestBucket", V: 4}, ItemPosition{ItemID: "Item2", Position: 0}},
+		},
+		{
+			desc:   "out of range",
+			req:    &MoveItemRequest{ID: "TestBucket", ItemID: "Item2", Position: 2},
+			stream: itemsTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.movingItem", Kind: errors.KindExpected, Msg: "Position out of range", Wraps: error(nil)},
 		},
+		{
+			desc:   "not found",
+			req:    &MoveItemRequest{ID: "TestBucket", ItemID: "Item3", Position: 0},
+			stream: itemsTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.movingItem", Kind: errors.KindExpected, Msg: "Item not found", Wraps: error(nil)},
+		},
+	}
+
+	for i := range testCases {
+		tC := testCases[i]
+
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := MoveItem(tC.req, tC.stream)
+
+			assert.Equal(t, tC.want, got)
+			if tC.want == nil {
+				assert.Equal(t, tC.err, err)
+			}
+		})
 	}
 }
 
-func updatedTestStream(id events.EntityID) []events.Event {
-	return []events.Event{
-		&Opened{
-			events.Base{ID: id, V: 1},
-			BucketData{"TestTitle", "Test Description"},
+func TestChangeCapacity(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		desc   string
+		req    *ChangeCapacityRequest
+		stream []events.Event
+		want   events.Event
+		err    error
+	}{
+		{
+			desc:   "happy",
+			req:    &ChangeCapacityRequest{ID: "TestBucket", Capacity: Capacity{MaxItems: 2, MaxSize: 2}},
+			stream: itemsTestStream("TestBucket"),
+			want:   &CapacityChanged{events.Base{ID: "TestBucket", V: 4}, Capacity{MaxItems: 2, MaxSize: 2}},
 		},
-		&Updated{
-			events.Base{ID: id, V: 2},
-			BucketData{"UpdatedTitle", "Updated Description"},
+		{
+			desc:   "unlimited",
+			req:    &ChangeCapacityRequest{ID: "TestBucket"},
+			stream: itemsTestStream("TestBucket"),
+			want:   &CapacityChanged{events.Base{ID: "TestBucket", V: 4}, Capacity{}},
+		},
+		{
+			desc:   "less than contents",
+			req:    &ChangeCapacityRequest{ID: "TestBucket", Capacity: Capacity{MaxItems: 1}},
+			stream: itemsTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.changingCapacity", Kind: errors.KindExpected, Msg: "Capacity is less than current contents", Wraps: error(nil)},
+		},
+		{
+			desc:   "closed stream",
+			req:    &ChangeCapacityRequest{ID: "TestBucket", Capacity: Capacity{MaxItems: 1}},
+			stream: closedTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.changingCapacity", Kind: errors.KindExpected, Msg: "Bucket is closed", Wraps: error(nil)},
 		},
 	}
+
+	for i := range testCases {
+		tC := testCases[i]
+
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ChangeCapacity(tC.req, tC.stream)
+
+			assert.Equal(t, tC.want, got)
+			if tC.want == nil {
+				assert.Equal(t, tC.err, err)
+			}
+		})
+	}
 }
 
-func closedTestStream(id events.EntityID) []events.Event {
-	return []events.Event{
-		&Opened{
-			events.Base{ID: id, V: 1},
-			BucketData{"TestTitle", "Test Description"},
+func TestGrantAccess(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		desc   string
+		req    *GrantRequest
+		stream []events.Event
+		want   events.Event
+		err    error
+	}{
+		{
+			desc:   "happy",
+			req:    &GrantRequest{ID: "TestBucket", Principal: "NewEditor", Role: RoleEditor},
+			stream: sharedTestStream("TestBucket"),
+			want:   &AccessGranted{events.Base{ID: "TestBucket", V: 4}, Grant{Principal: "NewEditor", Role: RoleEditor}},
 		},
-		&Updated{
-			events.Base{ID: id, V: 2},
-			BucketData{"ClosedTitle", "Closed Description"},
+		{
+			desc:   "change role",
+			req:    &GrantRequest{ID: "TestBucket", Principal: "TestViewer", Role: RoleEditor},
+			stream: sharedTestStream("TestBucket"),
+			want:   &AccessGranted{events.Base{ID: "TestBucket", V: 4}, Grant{Principal: "TestViewer", Role: RoleEditor}},
 		},
-		&Closed{events.Base{ID: id, V: 3}},
+		{
+			desc:   "same role",
+			req:    &GrantRequest{ID: "TestBucket", Principal: "TestViewer", Role: RoleViewer},
+			stream: sharedTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.granting", Kind: errors.KindExpected, Msg: "Principal allready has the role", Wraps: error(nil)},
+		},
+		{
+			desc:   "owner",
+			req:    &GrantRequest{ID: "TestBucket", Principal: "TestOwner", Role: RoleViewer},
+			stream: sharedTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.granting", Kind: errors.KindExpected, Msg: "Owner's role can not be changed", Wraps: error(nil)},
+		},
+		{
+			desc:   "owner role",
+			req:    &GrantRequest{ID: "TestBucket", Principal: "NewOwner", Role: RoleOwner},
+			stream: sharedTestStream("TestBucket"),
+			want:   nil,
+			err: &errors.Error{Op: "bucket.GrantAccess", Kind: errors.KindValidation, Msg: "invalid request", Wraps: &errors.Error{
+				Op: "bucket.GrantRequest.Validate", Kind: errors.KindValidation, Msg: "Invalid arguments", Wraps: &errors.Error{
+					Op: "bucket.Role.Validate", Kind: errors.KindValidation, Msg: "Invalid value for Role",
+				},
+			}},
+		},
+	}
+
+	for i := range testCases {
+		tC := testCases[i]
+
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := GrantAccess(tC.req, tC.stream)
+
+			assert.Equal(t, tC.want, got)
+			if tC.want == nil {
+				assert.Equal(t, tC.err, err)
+			}
+		})
 	}
 }
+
+func TestRevokeAccess(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		desc   string
+		req    *RevokeRequest
+		stream []events.Event
+		want   events.Event
+		err    error
+	}{
+		{
+			desc:   "happy",
+			req:    &RevokeRequest{ID: "TestBucket", Principal: "TestEditor"},
+			stream: sharedTestStream("TestBucket"),
+			want:   &AccessRevoked{events.Base{ID: "TestBucket", V: 4}, "TestEditor"},
+		},
+		{
+			desc:   "no access",
+			req:    &RevokeRequest{ID: "TestBucket", Principal: "Stranger"},
+			stream: sharedTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.revoking", Kind: errors.KindExpected, Msg: "Principal has no access", Wraps: error(nil)},
+		},
+		{
+			desc:   "owner",
+			req:    &RevokeRequest{ID: "TestBucket", Principal: "TestOwner"},
+			stream: sharedTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.revoking", Kind: errors.KindExpected, Msg: "Owner's role can not be changed", Wraps: error(nil)},
+		},
+	}
+
+	for i := range testCases {
+		tC := testCases[i]
+
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := RevokeAccess(tC.req, tC.stream)
+
+			assert.Equal(t, tC.want, got)
+			if tC.want == nil {
+				assert.Equal(t, tC.err, err)
+			}
+		})
+	}
+}
+
+func TestRoleOf(t *testing.T) {
+	t.Parallel()
+
+	revoked := append(sharedTestStream("TestBucket"), &AccessRevoked{events.Base{ID: "TestBucket", V: 4}, "TestEditor"})
+
+	testCases := []struct {
+		desc   string
+		p      principal.ID
+		stream []events.Event
+		want   Role
+	}{
+		{desc: "owner", p: "TestOwner", stream: sharedTestStream("TestBucket"), want: RoleOwner},
+		{desc: "editor", p: "TestEditor", stream: sharedTestStream("TestBucket"), want: RoleEditor},
+		{desc: "viewer", p: "TestViewer", stream: sharedTestStream("TestBucket"), want: RoleViewer},
+		{desc: "stranger", p: "Stranger", stream: sharedTestStream("TestBucket"), want: RoleNone},
+		{desc: "revoked", p: "TestEditor", stream: revoked, want: RoleNone},
+		{desc: "deleted", p: "TestOwner", stream: []events.Event{&Purged{events.Base{ID: "TestBucket", V: 5}}}, want: RoleNone},
+	}
+
+	for i := range testCases {
+		tC := testCases[i]
+
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := RoleOf("TestBucket", tC.p, tC.stream...)
+
+			require.Nil(t, err)
+			assert.Equal(t, tC.want, got)
+		})
+	}
+}
+
+func TestAddTag(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		desc   string
+		req    *AddTagRequest
+		stream []events.Event
+		want   events.Event
+		err    error
+	}{
+		{
+			desc:   "happy",
+			req:    &AddTagRequest{ID: "TestBucket", Tag: "team:core"},
+			stream: taggedTestStream("TestBucket"),
+			want:   &TagAdded{events.Base{ID: "TestBucket", V: 3}, "team:core"},
+		},
+		{
+			desc:   "allready added",
+			req:    &AddTagRequest{ID: "TestBucket", Tag: "project:apollo"},
+			stream: taggedTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.addingTag", Kind: errors.KindExpected, Msg: "Tag allready added", Wraps: error(nil)},
+		},
+		{
+			desc:   "closed stream",
+			req:    &AddTagRequest{ID: "TestBucket", Tag: "team:core"},
+			stream: closedTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.addingTag", Kind: errors.KindExpected, Msg: "Bucket is closed", Wraps: error(nil)},
+		},
+	}
+
+	for i := range testCases {
+		tC := testCases[i]
+
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := AddTag(tC.req, tC.stream)
+
+			assert.Equal(t, tC.want, got)
+			if tC.want == nil {
+				assert.Equal(t, tC.err, err)
+			}
+		})
+	}
+}
+
+func TestRemoveTag(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		desc   string
+		req    *RemoveTagRequest
+		stream []events.Event
+		want   events.Event
+		err    error
+	}{
+		{
+			desc:   "happy",
+			req:    &RemoveTagRequest{ID: "TestBucket", Tag: "project:apollo"},
+			stream: taggedTestStream("TestBucket"),
+			want:   &TagRemoved{events.Base{ID: "TestBucket", V: 3}, "project:apollo"},
+		},
+		{
+			desc:   "not found",
+			req:    &RemoveTagRequest{ID: "TestBucket", Tag: "team:core"},
+			stream: taggedTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.removingTag", Kind: errors.KindExpected, Msg: "Tag not found", Wraps: error(nil)},
+		},
+	}
+
+	for i := range testCases {
+		tC := testCases[i]
+
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := RemoveTag(tC.req, tC.stream)
+
+			assert.Equal(t, tC.want, got)
+			if tC.want == nil {
+				assert.Equal(t, tC.err, err)
+			}
+		})
+	}
+}
+
+func TestArchive(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		desc   string
+		req    *ArchiveRequest
+		stream []events.Event
+		want   *Archived
+		err    error
+	}{
+		{
+			desc:   "happy",
+			req:    &ArchiveRequest{ID: "TestBucket"},
+			stream: closedTestStream("TestBucket"),
+			want:   &Archived{events.Base{ID: "TestBucket", V: 4}},
+		},
+		{
+			desc:   "open",
+			req:    &ArchiveRequest{ID: "TestBucket"},
+			stream: openTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.archiving", Kind: errors.KindExpected, Msg: "Bucket is not closed", Wraps: error(nil)},
+		},
+		{
+			desc:   "archived",
+			req:    &ArchiveRequest{ID: "TestBucket"},
+			stream: archivedTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.archiving", Kind: errors.KindExpected, Msg: "Bucket is archived", Wraps: error(nil)},
+		},
+	}
+
+	for i := range testCases {
+		tC := testCases[i]
+
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := Archive(tC.req, tC.stream)
+
+			assert.Equal(t, tC.want, got)
+			if tC.want == nil {
+				assert.Equal(t, tC.err, err)
+			}
+		})
+	}
+}
+
+func TestPurge(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		desc   string
+		req    *PurgeRequest
+		stream []events.Event
+		want   *Purged
+		err    error
+	}{
+		{
+			desc:   "open",
+			req:    &PurgeRequest{ID: "TestBucket"},
+			stream: openTestStream("TestBucket"),
+			want:   &Purged{events.Base{ID: "TestBucket", V: 2}},
+		},
+		{
+			desc:   "archived",
+			req:    &PurgeRequest{ID: "TestBucket"},
+			stream: archivedTestStream("TestBucket"),
+			want:   &Purged{events.Base{ID: "TestBucket", V: 5}},
+		},
+		{
+			desc:   "deleted",
+			req:    &PurgeRequest{ID: "TestBucket"},
+			stream: []events.Event{&Purged{events.Base{ID: "TestBucket", V: 5}}},
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.purging", Kind: errors.KindExpected, Msg: "Bucket allready deleted", Wraps: error(nil)},
+		},
+	}
+
+	for i := range testCases {
+		tC := testCases[i]
+
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := Purge(tC.req, tC.stream)
+
+			assert.Equal(t, tC.want, got)
+			if tC.want == nil {
+				assert.Equal(t, tC.err, err)
+			}
+		})
+	}
+}
+
+func TestForget(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		desc   string
+		req    *ForgetRequest
+		stream []events.Event
+		want   *Forgotten
+		err    error
+	}{
+		{
+			desc:   "happy",
+			req:    &ForgetRequest{ID: "TestBucket"},
+			stream: updatedTestStream("TestBucket"),
+			want:   &Forgotten{events.Base{ID: "TestBucket", V: 3}},
+		},
+		{
+			desc:   "invalid request",
+			req:    &ForgetRequest{ID: "Test Bucket"},
+			stream: updatedTestStream("TestBucket"),
+			want:   nil,
+		},
+		{
+			desc:   "archived",
+			req:    &ForgetRequest{ID: "TestBucket"},
+			stream: archivedTestStream("TestBucket"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.forgetting", Kind: errors.KindExpected, Msg: "Bucket is archived", Wraps: error(nil)},
+		},
+	}
+
+	for i := range testCases {
+		tC := testCases[i]
+
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := Forget(tC.req, tC.stream)
+
+			assert.Equal(t, tC.want, got)
+			if tC.want == nil {
+				assert.NotNil(t, err)
+			}
+			if tC.err != nil {
+				assert.Equal(t, tC.err, err)
+			}
+		})
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	t.Parallel()
+
+	tombstone := []events.Event{&Purged{events.Base{ID: "TestBucket", V: 5}}}
+
+	testCases := []struct {
+		desc string
+		call func() (events.Event, error)
+		err  error
+	}{
+		{
+			desc: "reopen archived",
+			call: func() (events.Event, error) {
+				return Reopen(&ReopenRequest{ID: "TestBucket"}, archivedTestStream("TestBucket"))
+			},
+			err: &errors.Error{Op: "bucket.reopening", Kind: errors.KindExpected, Msg: "Bucket is archived"},
+		},
+		{
+			desc: "grant archived",
+			call: func() (events.Event, error) {
+				return GrantAccess(&GrantRequest{ID: "TestBucket", Principal: "TestViewer", Role: RoleViewer}, archivedTestStream("TestBucket"))
+			},
+			err: &errors.Error{Op: "bucket.granting", Kind: errors.KindExpected, Msg: "Bucket is archived"},
+		},
+		{
+			desc: "update deleted",
+			call: func() (events.Event, error) {
+				return Update(&UpdateRequest{ID: "TestBucket", Title: "NewTitle"}, tombstone)
+			},
+			err: &errors.Error{Op: "bucket.updating", Kind: errors.KindExpected, Msg: "Bucket is deleted"},
+		},
+		{
+			desc: "reopen deleted",
+			call: func() (events.Event, error) { return Reopen(&ReopenRequest{ID: "TestBucket"}, tombstone) },
+			err:  &errors.Error{Op: "bucket.reopening", Kind: errors.KindExpected, Msg: "Bucket is deleted"},
+		},
+	}
+
+	for i := range testCases {
+		tC := testCases[i]
+
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := tC.call()
+
+			assert.Nil(t, got)
+			assert.Equal(t, tC.err, err)
+		})
+	}
+}
+
+func TestNewView(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		desc   string
+		id     events.EntityID
+		stream []events.Event
+		want   *View
+		err    error
+	}{
+		{
+			desc:   "ok",
+			id:     events.EntityID("TestView"),
+			stream: closedTestStream("TestView"),
+			want:   &View{ID: "TestView", Title: "ClosedTitle", Description: "Closed Description", Version: 3, IsClosed: true},
+			err:    nil,
+		},
+		{
+			desc: "items",
+			id:   events.EntityID("TestView"),
+			stream: append(itemsTestStream("TestView"),
+				&ItemMoved{events.Base{ID: "TestView", V: 4}, ItemPosition{ItemID: "Item2", Position: 0}},
+				&ItemAdded{events.Base{ID: "TestView", V: 5}, Item{ID: "Item3", Name: "Third", Payload: Payload("3")}},
+				&ItemRemoved{events.Base{ID: "TestView", V: 6}, "Item1"},
+			),
+			want: &View{ID: "TestView", Title: "TestTitle", Description: "Test Description", Version: 6, Items: []ItemView{
+				{ID: "Item2", Name: "Second", Payload: []byte("2")},
+				{ID: "Item3", Name: "Third", Payload: []byte("3")},
+			}},
+			err: nil,
+		},
+		{
+			desc:   "archived",
+			id:     events.EntityID("TestView"),
+			stream: archivedTestStream("TestView"),
+			want:   &View{ID: "TestView", Title: "ClosedTitle", Description: "Closed Description", Version: 4, IsClosed: true, IsArchived: true},
+			err:    nil,
+		},
+		{
+			desc:   "deleted",
+			id:     events.EntityID("TestView"),
+			stream: []events.Event{&Purged{events.Base{ID: "TestView", V: 5}}},
+			want:   &View{ID: "TestView", Version: 5, IsClosed: true, IsDeleted: true},
+			err:    nil,
+		},
+		{
+			desc:   "empty stream",
+			id:     events.EntityID("TestView"),
+			stream: []events.Event{},
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.NewView", Kind: errors.KindUnexpected, Msg: "Error when build state for view", Wraps: fmt.Errorf("Empty stream")},
+		},
+	}
+	for i := range testCases {
+		tC := testCases[i]
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := NewView(tC.id, tC.stream...)
+
+			assert.Equal(t, tC.want, got)
+			assert.Equal(t, tC.err, err)
+
+		})
+	}
+}
+
+func TestNewViewCopiesPayload(t *testing.T) {
+	t.Parallel()
+
+	stream := itemsTestStream("TestView")
+
+	view, err := NewView("TestView", stream...)
+	require.Nil(t, err)
+
+	view.Items[0].Payload[0] = 'x'
+
+	again, err := NewView("TestView", stream...)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("1"), again.Items[0].Payload, "changing the view should not change the events")
+}
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		desc   string
+		id     events.EntityID
+		stream []events.Event
+		want   []Change
+		err    error
+	}{
+		{
+			desc:   "closed",
+			id:     events.EntityID("TestHistory"),
+			stream: closedTestStream("TestHistory"),
+			want: []Change{
+				{Version: 1, Type: "bucket.Opened", Diff: []FieldDiff{
+					{Field: "Title", From: "", To: "TestTitle"},
+					{Field: "Description", From: "", To: "Test Description"},
+				}},
+				{Version: 2, Type: "bucket.Updated", Diff: []FieldDiff{
+					{Field: "Title", From: "TestTitle", To: "ClosedTitle"},
+					{Field: "Description", From: "Test Description", To: "Closed Description"},
+				}},
+				{Version: 3, Type: "bucket.Closed", Diff: nil},
+			},
+			err: nil,
+		},
+		{
+			desc:   "id mismatch",
+			id:     events.EntityID("TestHistory"),
+			stream: openTestStream("DifferentStream"),
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.History", Kind: errors.KindUnexpected, Msg: "Error when building history", Wraps: fmt.Errorf("ID Mismatch")},
+		},
+		{
+			desc:   "empty stream",
+			id:     events.EntityID("TestHistory"),
+			stream: []events.Event{},
+			want:   nil,
+			err:    &errors.Error{Op: "bucket.History", Kind: errors.KindUnexpected, Msg: "Error when building history", Wraps: fmt.Errorf("Empty stream")},
+		},
+	}
+	for i := range testCases {
+		tC := testCases[i]
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := History(tC.id, tC.stream...)
+
+			assert.Equal(t, tC.want, got)
+			assert.Equal(t, tC.err, err)
+		})
+	}
+}
+
+// Tests for internals
+func TestBuildState(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		id     events.EntityID
+		stream []events.Event
+		want   state
+		err    error
+	}{
+		{
+			desc:   "open",
+			id:     events.EntityID("TestView"),
+			stream: openTestStream("TestView"),
+			want: state{
+				id:     events.EntityID("TestView"),
+				title:  Title("TestTitle"),
+				desc:   Description("Test Description"),
+				closed: false,
+				v:      1,
+			},
+			err: nil,
+		},
+		{
+			desc:   "updated",
+			id:     events.EntityID("TestView"),
+			stream: updatedTestStream("TestView"),
+			want: state{
+				id:     events.EntityID("TestView"),
+				title:  Title("UpdatedTitle"),
+				desc:   Description("Updated Description"),
+				closed: false,
+				v:      2,
+			},
+			err: nil,
+		},
+		{
+			desc:   "closed",
+			id:     events.EntityID("TestView"),
+			stream: closedTestStream("TestView"),
+			want: state{
+				id:     events.EntityID("TestView"),
+				title:  Title("ClosedTitle"),
+				desc:   Description("Closed Description"),
+				closed: true,
+				v:      3,
+			},
+			err: nil,
+		},
+		{
+			desc:   "reopened",
+			id:     events.EntityID("TestView"),
+			stream: reopenedTestStream("TestView"),
+			want: state{
+				id:     events.EntityID("TestView"),
+				title:  Title("ClosedTitle"),
+				desc:   Description("Closed Description"),
+				closed: false,
+				v:      4,
+			},
+			err: nil,
+		},
+		{
+			desc:   "id mismatch",
+			id:     events.EntityID("TestView"),
+			stream: openTestStream("DifferentStream"),
+			want:   state{},
+			err:    fmt.Errorf("ID Mismatch"),
+		},
+		{
+			desc:   "empty stream",
+			id:     events.EntityID("TestView"),
+			stream: []events.Event{},
+			want:   state{},
+			err:    fmt.Errorf("Empty stream"),
+		},
+	}
+	for i := range testCases {
+		tC := testCases[i]
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := buildState(tC.id, tC.stream)
+
+			assert.Equal(t, tC.want, got, "state should be equal")
+			assert.Equal(t, tC.err, err, "state should be equal")
+
+		})
+	}
+}
+
+func TestTagValidation(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		desc string
+		tag  Tag
+		want *errors.Error
+	}{
+		{desc: "plain", tag: Tag("urgent"), want: nil},
+		{desc: "label", tag: Tag("project:apollo-2"), want: nil},
+		{desc: "upper case", tag: Tag("Urgent"), want: &errors.Error{Op: "bucket.Tag.Validate", Kind: errors.KindValidation, Msg: "Invalid value for Tag"}},
+		{desc: "empty label value", tag: Tag("project:"), want: &errors.Error{Op: "bucket.Tag.Validate", Kind: errors.KindValidation, Msg: "Invalid value for Tag"}},
+		{desc: "spaces", tag: Tag("two words"), want: &errors.Error{Op: "bucket.Tag.Validate", Kind: errors.KindValidation, Msg: "Invalid value for Tag"}},
+	}
+	for i := range testCases {
+		tC := testCases[i]
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tC.want, tC.tag.Validate())
+		})
+	}
+}
+
+func TestTitleValidation(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		desc  string
+		title Title
+		want  *errors.Error
+	}{
+		{
+			desc:  "ok",
+			title: Title("Test-Bucket Title_1234"),
+			want:  nil,
+		},
+		{
+			desc:  "illegal chars",
+			title: Title(`<sript>alert("PWND")</script>`),
+			want:  &errors.Error{Op: "bucket.Title.Validate", Kind: errors.KindValidation, Msg: "Invalid value for Title", Wraps: nil},
+		},
+		{
+			desc:  "too short",
+			title: Title("ID"),
+			want:  &errors.Error{Op: "bucket.Title.Validate", Kind: errors.KindValidation, Msg: "Invalid value for Title", Wraps: nil},
+		},
+		{
+			desc:  "too long",
+			title: Title("thisBucketNameisMoreThan64CharactersLong012345678901234567890123456789"),
+			want:  &errors.Error{Op: "bucket.Title.Validate", Kind: errors.KindValidation, Msg: "Invalid value for Title", Wraps: nil},
+		},
+	}
+	for i := range testCases {
+		tC := testCases[i]
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got := tC.title.Validate()
+
+			assert.Equal(t, tC.want, got)
+
+		})
+	}
+}
+
+func TestScenarios(t *testing.T) {
+	t.Parallel()
+
+	updateCmd := func(stream []events.Event) (events.Event, error) {
+		return Update(&UpdateRequest{ID: "TestBucket", Title: "NewTitle", Desc: "New Description"}, stream)
+	}
+	closeCmd := func(stream []events.Event) (events.Event, error) {
+		return Close(&CloseRequest{ID: "TestBucket"}, stream)
+	}
+	reopenCmd := func(stream []events.Event) (events.Event, error) {
+		return Reopen(&ReopenRequest{ID: "TestBucket"}, stream)
+	}
+
+	t.Run("update", func(t *testing.T) {
+		eventstest.Given(t, openTestStream("TestBucket")...).
+			When(updateCmd).
+			Then(&Updated{events.Base{ID: "TestBucket", V: 2}, BucketData{"NewTitle", "New Description"}})
+
+		eventstest.Given(t, closedTestStream("TestBucket")...).When(updateCmd).ThenFails(errors.KindExpected)
+		eventstest.Given(t, archivedTestStream("TestBucket")...).When(updateCmd).ThenFails(errors.KindExpected)
+		eventstest.Given(t).When(updateCmd).ThenFails(errors.KindUnexpected)
+	})
+
+	t.Run("close", func(t *testing.T) {
+		eventstest.Given(t, updatedTestStream("TestBucket")...).
+			When(closeCmd).
+			Then(&Closed{events.Base{ID: "TestBucket", V: 3}})
+
+		eventstest.Given(t, closedTestStream("TestBucket")...).When(closeCmd).ThenFails(errors.KindExpected)
+	})
+
+	t.Run("reopen", func(t *testing.T) {
+		eventstest.Given(t, closedTestStream("TestBucket")...).
+			When(reopenCmd).
+			Then(&Reopened{events.Base{ID: "TestBucket", V: 4}})
+
+		eventstest.Given(t, openTestStream("TestBucket")...).When(reopenCmd).ThenFails(errors.KindExpected)
+	})
+}
+
+// testStore implements only Store, testIndex also TagIndex and testDecorator decorates another store
+type testStore struct{ Store }
+
+type testIndex struct{ testStore }
+
+func (testIndex) FindByTags(ctx context.Context, tags []Tag, match Match) ([]events.EntityID, error) {
+	return nil, nil
+}
+
+type testDecorator struct {
+	testStore
+	next Store
+}
+
+func (d testDecorator) Unwrap() Store {
+	return d.next
+}
+
+func TestAs(t *testing.T) {
+	t.Parallel()
+
+	index := testIndex{}
+
+	got, ok := As[TagIndex](testDecorator{next: testDecorator{next: index}})
+	require.True(t, ok, "port should be found by unwrapping decorators")
+	assert.Equal(t, index, got)
+
+	_, ok = As[TagIndex](testDecorator{next: testStore{}})
+	assert.False(t, ok)
+
+	_, ok = As[TagIndex](nil)
+	assert.False(t, ok)
+}
+
+// helper funcs for testing
+func openTestStream(id events.EntityID) []events.Event {
+
+	return []events.Event{
+		&Opened{
+			Base:       events.Base{ID: id, V: 1},
+			BucketData: BucketData{"TestTitle", "Test Description"},
+		},
+	}
+}
+
+func updatedTestStream(id events.EntityID) []events.Event {
+	return []events.Event{
+		&Opened{
+			Base:       events.Base{ID: id, V: 1},
+			BucketData: BucketData{"TestTitle", "Test Description"},
+		},
+		&Updated{
+			events.Base{ID: id, V: 2},
+			BucketData{"UpdatedTitle", "Updated Description"},
+		},
+	}
+}
+
+func closedTestStream(id events.EntityID) []events.Event {
+	return []events.Event{
+		&Opened{
+			Base:       events.Base{ID: id, V: 1},
+			BucketData: BucketData{"TestTitle", "Test Description"},
+		},
+		&Updated{
+			events.Base{ID: id, V: 2},
+			BucketData{"ClosedTitle", "Closed Description"},
+		},
+		&Closed{events.Base{ID: id, V: 3}},
+	}
+}
+
+func reopenedTestStream(id events.EntityID) []events.Event {
+	return append(closedTestStream(id), &Reopened{events.Base{ID: id, V: 4}})
+}
+
+func itemsTestStream(id events.EntityID) []events.Event {
+	return append(openTestStream(id),
+		&ItemAdded{events.Base{ID: id, V: 2}, Item{ID: "Item1", Name: "First", Payload: Payload("1")}},
+		&ItemAdded{events.Base{ID: id, V: 3}, Item{ID: "Item2", Name: "Second", Payload: Payload("2")}},
+	)
+}
+
+func sharedTestStream(id events.EntityID) []events.Event {
+	return []events.Event{
+		&Opened{
+			Base:       events.Base{ID: id, V: 1},
+			BucketData: BucketData{"TestTitle", "Test Description"},
+			Owner:      "TestOwner",
+		},
+		&AccessGranted{events.Base{ID: id, V: 2}, Grant{Principal: "TestViewer", Role: RoleViewer}},
+		&AccessGranted{events.Base{ID: id, V: 3}, Grant{Principal: "TestEditor", Role: RoleEditor}},
+	}
+}
+
+func taggedTestStream(id events.EntityID) []events.Event {
+	return append(openTestStream(id), &TagAdded{events.Base{ID: id, V: 2}, "project:apollo"})
+}
+
+func archivedTestStream(id events.EntityID) []events.Event {
+	return append(closedTestStream(id), &Archived{events.Base{ID: id, V: 4}})
+}
+
+// word reports whether c matches \w of the validation regexps
+func word(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_'
+}
+
+// FuzzTitle checks the validation of titles against the rule it implements: 3 to 64 word characters, spaces and hyphens
+func FuzzTitle(f *testing.F) {
+	for _, seed := range []string{"TestTitle", "Other Title", "x", "Renamed-2", "Invalid!", "Title\n", "Tïtle"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, title string) {
+		want := len(title) >= 3 && len(title) <= 64
+		for _, c := range []byte(title) {
+			if !word(c) && c != ' ' && c != '-' {
+				want = false
+			}
+		}
+
+		if got := Title(title).Validate() == nil; got != want {
+			t.Fatalf("Title(%q) valid %v, want %v", title, got, want)
+		}
+	})
+}
+
+// FuzzItem checks the validation of item ids and names against the rules they implement
+func FuzzItem(f *testing.F) {
+	for _, seed := range []string{"Item1", "Item Name", "", "item_1", "Item\n", "Ítem"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		id, name := len(s) >= 1 && len(s) <= 64, len(s) >= 1 && len(s) <= 64
+		for _, c := range []byte(s) {
+			if !word(c) || c == '_' {
+				id = false
+			}
+			if !word(c) && c != ' ' && c != '-' {
+				name = false
+			}
+		}
+
+		if got := ItemID(s).Validate() == nil; got != id {
+			t.Fatalf("ItemID(%q) valid %v, want %v", s, got, id)
+		}
+		if got := ItemName(s).Validate() == nil; got != name {
+			t.Fatalf("ItemName(%q) valid %v, want %v", s, got, name)
+		}
+	})
+}
+
+// FuzzTag checks the validation of tags against the rule it implements: one or two colon separated parts
+// of 1 to 32 lower case letters, digits, underscores and hyphens, starting with a letter or digit
+func FuzzTag(f *testing.F) {
+	for _, seed := range []string{"team:a", "urgent", "Team", ":a", "a:", "a:b:c", "-a", "a_b-c:d", "tag\n"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, tag string) {
+		parts := strings.Split(tag, ":")
+
+		want := len(parts) <= 2
+		for _, p := range parts {
+			if len(p) < 1 || len(p) > 32 || p[0] == '_' || p[0] == '-' {
+				want = false
+			}
+			for _, c := range []byte(p) {
+				if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '_' || c == '-') {
+					want = false
+				}
+			}
+		}
+
+		if got := Tag(tag).Validate() == nil; got != want {
+			t.Fatalf("Tag(%q) valid %v, want %v", tag, got, want)
+		}
+	})
+}
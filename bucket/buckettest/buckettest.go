@@ -0,0 +1,90 @@
+// Package buckettest builds bucket events from fuzzed inputs, for the fuzz targets of the packages consuming them
+package buckettest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/principal"
+)
+
+// Kinds is the number of event types built by Event
+const Kinds = 15
+
+// Event returns event of type kind % Kinds with the fields set from text, payload and n. Values are not validated,
+// so the events can have anything a corrupted or hostile stream could
+func Event(kind uint8, id events.EntityID, v events.EntityVersion, text string, payload []byte, n uint) events.Event {
+	// times and hashes are set by the stores, so they are kept in the range the stores set them in
+	b := events.Base{ID: id, V: v, At: time.Unix(int64(n%(1<<34)), int64(n%1e9)).UTC(), Sum: fmt.Sprintf("%x", text)}
+
+	switch kind % Kinds {
+	case 0:
+		return &bucket.Opened{
+			Base:       b,
+			BucketData: bucket.BucketData{Title: bucket.Title(text), Description: bucket.Description(payload)},
+			Capacity:   bucket.Capacity{MaxItems: n, MaxSize: n / 2},
+			Owner:      principal.ID(text),
+		}
+	case 1:
+		return &bucket.Updated{Base: b, BucketData: bucket.BucketData{Title: bucket.Title(text), Description: bucket.Description(payload)}}
+	case 2:
+		return &bucket.Closed{Base: b}
+	case 3:
+		return &bucket.Reopened{Base: b}
+	case 4:
+		return &bucket.ItemAdded{Base: b, Item: bucket.Item{ID: bucket.ItemID(text), Name: bucket.ItemName(text), Payload: payload}}
+	case 5:
+		return &bucket.ItemRemoved{Base: b, ItemID: bucket.ItemID(text)}
+	case 6:
+		return &bucket.ItemMoved{Base: b, ItemPosition: bucket.ItemPosition{ItemID: bucket.ItemID(text), Position: n}}
+	case 7:
+		return &bucket.CapacityChanged{Base: b, Capacity: bucket.Capacity{MaxItems: n, MaxSize: n * 2}}
+	case 8:
+		return &bucket.AccessGranted{Base: b, Grant: bucket.Grant{Principal: principal.ID(text), Role: bucket.Role(n % 5)}}
+	case 9:
+		return &bucket.AccessRevoked{Base: b, Principal: principal.ID(text)}
+	case 10:
+		return &bucket.TagAdded{Base: b, Tag: bucket.Tag(text)}
+	case 11:
+		return &bucket.TagRemoved{Base: b, Tag: bucket.Tag(text)}
+	case 12:
+		return &bucket.Archived{Base: b}
+	case 13:
+		return &bucket.Forgotten{Base: b}
+	default:
+		return &bucket.Purged{Base: b}
+	}
+}
+
+// Stream returns events of the stream id built from data, four bytes per event: type, version step,
+// value and text. Version steps are mostly one, but can repeat, skip or go back versions,
+// and the events can have other id
+func Stream(id events.EntityID, data []byte) []events.Event {
+	texts := []string{"Item0", "Item1", "Item2", "team:a", "Principal", "", "in valid"}
+
+	ret := []events.Event{}
+	v := events.EntityVersion(0)
+
+	for ; len(data) >= 4; data = data[4:] {
+		switch step := data[1] % 8; step {
+		case 0:
+		case 1:
+			v += 2
+		case 2:
+			v--
+		default:
+			v++
+		}
+
+		eid := id
+		if data[1] == 255 {
+			eid = "OtherStream"
+		}
+
+		ret = append(ret, Event(data[0], eid, v, texts[int(data[3])%len(texts)], data[2:3], uint(data[2])))
+	}
+
+	return ret
+}
@@ -3,6 +3,7 @@ package bucket
 import (
 	"github.com/juelko/bucket/pkg/errors"
 	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/principal"
 )
 
 type BucketData struct {
@@ -12,8 +13,10 @@ type BucketData struct {
 
 // Opened is a domain event and is emitted when bucket is opened
 type Opened struct {
-	events.Base // Base event
-	BucketData  // Bucket data
+	events.Base              // Base event
+	BucketData               // Bucket data
+	Capacity    Capacity     // Bucket capacity
+	Owner       principal.ID // Principal who opened the bucket
 }
 
 func (e *Opened) Type() string {
@@ -21,20 +24,33 @@ func (e *Opened) Type() string {
 }
 
 func (e *Opened) Data() interface{} {
-	return e.BucketData
+	return OpenedData{BucketData: e.BucketData, Capacity: e.Capacity, Owner: e.Owner}
 }
 
-// Business logic for opening
-func Open(req *OpenRequest) (*Opened, error) {
+// OpenedData is the data of Opened event
+type OpenedData struct {
+	BucketData
+	Capacity Capacity
+	Owner    principal.ID
+}
+
+// Business logic for opening, owner is the principal opening the bucket
+func Open(req *OpenRequest, owner principal.ID) (*Opened, error) {
 	const op errors.Op = "bucket.Open"
 
 	if err := req.Validate(); err != nil {
 		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
 	}
 
+	if err := owner.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid owner", err)
+	}
+
 	return &Opened{
-		events.Base{ID: req.ID, V: 1},
-		BucketData{Title: req.Title, Description: req.Desc},
+		Base:       events.Base{ID: req.ID, V: 1},
+		BucketData: BucketData{Title: req.Title, Description: req.Desc},
+		Capacity:   req.Capacity,
+		Owner:      owner,
 	}, nil
 }
 
@@ -77,6 +93,10 @@ func stateForUpdating(req *UpdateRequest, stream []events.Event) (events.Event,
 func newUpdate(req *UpdateRequest, s *state) (events.Event, error) {
 	const op errors.Op = "bucket.updating"
 
+	if err := s.readOnly(op); err != nil {
+		return nil, err
+	}
+
 	if s.closed {
 		return nil, errors.New(op, errors.KindExpected, "Bucket is closed")
 	}
@@ -124,6 +144,10 @@ func stateForClosing(req *CloseRequest, stream []events.Event) (events.Event, er
 func newClosed(req *CloseRequest, s *state) (events.Event, error) {
 	const op errors.Op = "bucket.closing"
 
+	if err := s.readOnly(op); err != nil {
+		return nil, err
+	}
+
 	if s.closed {
 		return nil, errors.New(op, errors.KindExpected, "Bucket allready closed")
 	}
@@ -132,3 +156,685 @@ func newClosed(req *CloseRequest, s *state) (events.Event, error) {
 		events.Base{ID: req.ID, V: s.v + 1},
 	}, nil
 }
+
+// Reopened is a domain event and is emitted when closed bucket is reopened
+type Reopened struct {
+	events.Base // Base event
+}
+
+func (e *Reopened) Type() string {
+	return "bucket.Reopened"
+}
+func (e *Reopened) Data() interface{} {
+	return nil
+}
+
+// Business logic for reopening
+func Reopen(req *ReopenRequest, stream []events.Event) (events.Event, error) {
+	const op errors.Op = "bucket.Reopen"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return stateForReopening(req, stream)
+}
+
+func stateForReopening(req *ReopenRequest, stream []events.Event) (events.Event, error) {
+	const op errors.Op = "bucket.stateForReopening"
+
+	s, err := buildState(req.ID, stream)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Error when building state for reopening", err)
+	}
+
+	return newReopened(req, &s)
+}
+
+func newReopened(req *ReopenRequest, s *state) (events.Event, error) {
+	const op errors.Op = "bucket.reopening"
+
+	if err := s.readOnly(op); err != nil {
+		return nil, err
+	}
+
+	if !s.closed {
+		return nil, errors.New(op, errors.KindExpected, "Bucket is not closed")
+	}
+
+	return &Reopened{
+		events.Base{ID: req.ID, V: s.v + 1},
+	}, nil
+}
+
+// Item is a single entry inside the bucket
+type Item struct {
+	ID      ItemID   // item identifier, unique inside the bucket
+	Name    ItemName // item name
+	Payload Payload  // item content
+}
+
+// ItemAdded is a domain event and is emitted when item is added to the bucket
+type ItemAdded struct {
+	events.Base // Base event
+	Item        // Added item
+}
+
+func (e *ItemAdded) Type() string {
+	return "bucket.ItemAdded"
+}
+
+func (e *ItemAdded) Data() interface{} {
+	return e.Item
+}
+
+// Business logic for adding items
+func AddItem(req *AddItemRequest, stream []events.Event) (events.Event, error) {
+	const op errors.Op = "bucket.AddItem"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return stateForAddingItem(req, stream)
+}
+
+func stateForAddingItem(req *AddItemRequest, stream []events.Event) (events.Event, error) {
+	const op errors.Op = "bucket.stateForAddingItem"
+
+	s, err := buildState(req.ID, stream)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Error when building state for adding item", err)
+	}
+
+	return newItemAdded(req, &s)
+}
+
+func newItemAdded(req *AddItemRequest, s *state) (events.Event, error) {
+	const op errors.Op = "bucket.addingItem"
+
+	if err := s.readOnly(op); err != nil {
+		return nil, err
+	}
+
+	if s.closed {
+		return nil, errors.New(op, errors.KindExpected, "Bucket is closed")
+	}
+
+	if s.itemIndex(req.ItemID) >= 0 {
+		return nil, errors.New(op, errors.KindExpected, "Item allready exists")
+	}
+
+	if !s.capacity.allows(uint(len(s.items))+1, s.size()+uint(len(req.Payload))) {
+		return nil, errors.New(op, errors.KindExpected, "Bucket full")
+	}
+
+	return &ItemAdded{
+		events.Base{ID: req.ID, V: s.v + 1},
+		// copied, so the caller can reuse the request without changing the event given to the store
+		Item{ID: req.ItemID, Name: req.Name, Payload: append(Payload(nil), req.Payload...)},
+	}, nil
+}
+
+// ItemRemoved is a domain event and is emitted when item is removed from the bucket
+type ItemRemoved struct {
+	events.Base        // Base event
+	ItemID      ItemID // Removed item
+}
+
+func (e *ItemRemoved) Type() string {
+	return "bucket.ItemRemoved"
+}
+
+func (e *ItemRemoved) Data() interface{} {
+	return e.ItemID
+}
+
+// Business logic for removing items
+func RemoveItem(req *RemoveItemRequest, stream []events.Event) (events.Event, error) {
+	const op errors.Op = "bucket.RemoveItem"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return stateForRemovingItem(req, stream)
+}
+
+func stateForRemovingItem(req *RemoveItemRequest, stream []events.Event) (events.Event, error) {
+	const op errors.Op = "bucket.stateForRemovingItem"
+
+	s, err := buildState(req.ID, stream)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Error when building state for removing item", err)
+	}
+
+	return newItemRemoved(req, &s)
+}
+
+func newItemRemoved(req *RemoveItemRequest, s *state) (events.Event, error) {
+	const op errors.Op = "bucket.removingItem"
+
+	if err := s.readOnly(op); err != nil {
+		return nil, err
+	}
+
+	if s.closed {
+		return nil, errors.New(op, errors.KindExpected, "Bucket is closed")
+	}
+
+	if s.itemIndex(req.ItemID) < 0 {
+		return nil, errors.New(op, errors.KindExpected, "Item not found")
+	}
+
+	return &ItemRemoved{
+		events.Base{ID: req.ID, V: s.v + 1},
+		req.ItemID,
+	}, nil
+}
+
+// ItemPosition is a zero based position of the item in the bucket
+type ItemPosition struct {
+	ItemID   ItemID // Moved item
+	Position uint   // New position
+}
+
+// ItemMoved is a domain event and is emitted when item is moved to a new position
+type ItemMoved struct {
+	events.Base  // Base event
+	ItemPosition // Item and its new position
+}
+
+func (e *ItemMoved) Type() string {
+	return "bucket.ItemMoved"
+}
+
+func (e *ItemMoved) Data() interface{} {
+	return e.ItemPosition
+}
+
+// Business logic for moving items
+func MoveItem(req *MoveItemRequest, stream []events.Event) (events.Event, error) {
+	const op errors.Op = "bucket.MoveItem"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return stateForMovingItem(req, stream)
+}
+
+func stateForMovingItem(req *MoveItemRequest, stream []events.Event) (events.Event, error) {
+	const op errors.Op = "bucket.stateForMovingItem"
+
+	s, err := buildState(req.ID, stream)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Error when building state for moving item", err)
+	}
+
+	return newItemMoved(req, &s)
+}
+
+func newItemMoved(req *MoveItemRequest, s *state) (events.Event, error) {
+	const op errors.Op = "bucket.movingItem"
+
+	if err := s.readOnly(op); err != nil {
+		return nil, err
+	}
+
+	if s.closed {
+		return nil, errors.New(op, errors.KindExpected, "Bucket is closed")
+	}
+
+	if s.itemIndex(req.ItemID) < 0 {
+		return nil, errors.New(op, errors.KindExpected, "Item not found")
+	}
+
+	if req.Position >= uint(len(s.items)) {
+		return nil, errors.New(op, errors.KindExpected, "Position out of range")
+	}
+
+	return &ItemMoved{
+		events.Base{ID: req.ID, V: s.v + 1},
+		ItemPosition{ItemID: req.ItemID, Position: req.Position},
+	}, nil
+}
+
+// CapacityChanged is a domain event and is emitted when bucket capacity is adjusted
+type CapacityChanged struct {
+	events.Base // Base event
+	Capacity    // New capacity
+}
+
+func (e *CapacityChanged) Type() string {
+	return "bucket.CapacityChanged"
+}
+
+func (e *CapacityChanged) Data() interface{} {
+	return e.Capacity
+}
+
+// Business logic for changing capacity
+func ChangeCapacity(req *ChangeCapacityRequest, stream []events.Event) (events.Event, error) {
+	const op errors.Op = "bucket.ChangeCapacity"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return stateForChangingCapacity(req, stream)
+}
+
+func stateForChangingCapacity(req *ChangeCapacityRequest, stream []events.Event) (events.Event, error) {
+	const op errors.Op = "bucket.stateForChangingCapacity"
+
+	s, err := buildState(req.ID, stream)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Error when building state for changing capacity", err)
+	}
+
+	return newCapacityChanged(req, &s)
+}
+
+func newCapacityChanged(req *ChangeCapacityRequest, s *state) (events.Event, error) {
+	const op errors.Op = "bucket.changingCapacity"
+
+	if err := s.readOnly(op); err != nil {
+		return nil, err
+	}
+
+	if s.closed {
+		return nil, errors.New(op, errors.KindExpected, "Bucket is closed")
+	}
+
+	if !req.Capacity.allows(uint(len(s.items)), s.size()) {
+		return nil, errors.New(op, errors.KindExpected, "Capacity is less than current contents")
+	}
+
+	return &CapacityChanged{
+		events.Base{ID: req.ID, V: s.v + 1},
+		req.Capacity,
+	}, nil
+}
+
+// Grant gives the role in the bucket to the principal
+type Grant struct {
+	Principal principal.ID
+	Role      Role
+}
+
+// AccessGranted is a domain event and is emitted when bucket is shared with a principal
+type AccessGranted struct {
+	events.Base // Base event
+	Grant       // Granted access
+}
+
+func (e *AccessGranted) Type() string {
+	return "bucket.AccessGranted"
+}
+
+func (e *AccessGranted) Data() interface{} {
+	return e.Grant
+}
+
+// Business logic for sharing
+func GrantAccess(req *GrantRequest, stream []events.Event) (events.Event, error) {
+	const op errors.Op = "bucket.GrantAccess"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return stateForGranting(req, stream)
+}
+
+func stateForGranting(req *GrantRequest, stream []events.Event) (events.Event, error) {
+	const op errors.Op = "bucket.stateForGranting"
+
+	s, err := buildState(req.ID, stream)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Error when building state for granting", err)
+	}
+
+	return newAccessGranted(req, &s)
+}
+
+func newAccessGranted(req *GrantRequest, s *state) (events.Event, error) {
+	const op errors.Op = "bucket.granting"
+
+	if err := s.readOnly(op); err != nil {
+		return nil, err
+	}
+
+	if req.Principal == s.owner {
+		return nil, errors.New(op, errors.KindExpected, "Owner's role can not be changed")
+	}
+
+	if s.role(req.Principal) == req.Role {
+		return nil, errors.New(op, errors.KindExpected, "Principal allready has the role")
+	}
+
+	return &AccessGranted{
+		events.Base{ID: req.ID, V: s.v + 1},
+		Grant{Principal: req.Principal, Role: req.Role},
+	}, nil
+}
+
+// AccessRevoked is a domain event and is emitted when principal's access to bucket is removed
+type AccessRevoked struct {
+	events.Base              // Base event
+	Principal   principal.ID // Principal losing the access
+}
+
+func (e *AccessRevoked) Type() string {
+	return "bucket.AccessRevoked"
+}
+
+func (e *AccessRevoked) Data() interface{} {
+	return e.Principal
+}
+
+// Business logic for revoking access
+func RevokeAccess(req *RevokeRequest, stream []events.Event) (events.Event, error) {
+	const op errors.Op = "bucket.RevokeAccess"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return stateForRevoking(req, stream)
+}
+
+func stateForRevoking(req *RevokeRequest, stream []events.Event) (events.Event, error) {
+	const op errors.Op = "bucket.stateForRevoking"
+
+	s, err := buildState(req.ID, stream)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Error when building state for revoking", err)
+	}
+
+	return newAccessRevoked(req, &s)
+}
+
+func newAccessRevoked(req *RevokeRequest, s *state) (events.Event, error) {
+	const op errors.Op = "bucket.revoking"
+
+	if err := s.readOnly(op); err != nil {
+		return nil, err
+	}
+
+	if req.Principal == s.owner {
+		return nil, errors.New(op, errors.KindExpected, "Owner's role can not be changed")
+	}
+
+	if _, ok := s.grants[req.Principal]; !ok {
+		return nil, errors.New(op, errors.KindExpected, "Principal has no access")
+	}
+
+	return &AccessRevoked{
+		events.Base{ID: req.ID, V: s.v + 1},
+		req.Principal,
+	}, nil
+}
+
+// TagAdded is a domain event and is emitted when bucket is tagged
+type TagAdded struct {
+	events.Base     // Base event
+	Tag         Tag // Added tag
+}
+
+func (e *TagAdded) Type() string {
+	return "bucket.TagAdded"
+}
+
+func (e *TagAdded) Data() interface{} {
+	return e.Tag
+}
+
+// Business logic for tagging
+func AddTag(req *AddTagRequest, stream []events.Event) (events.Event, error) {
+	const op errors.Op = "bucket.AddTag"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return stateForAddingTag(req, stream)
+}
+
+func stateForAddingTag(req *AddTagRequest, stream []events.Event) (events.Event, error) {
+	const op errors.Op = "bucket.stateForAddingTag"
+
+	s, err := buildState(req.ID, stream)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Error when building state for adding tag", err)
+	}
+
+	return newTagAdded(req, &s)
+}
+
+func newTagAdded(req *AddTagRequest, s *state) (events.Event, error) {
+	const op errors.Op = "bucket.addingTag"
+
+	if err := s.readOnly(op); err != nil {
+		return nil, err
+	}
+
+	if s.closed {
+		return nil, errors.New(op, errors.KindExpected, "Bucket is closed")
+	}
+
+	if s.tagIndex(req.Tag) >= 0 {
+		return nil, errors.New(op, errors.KindExpected, "Tag allready added")
+	}
+
+	return &TagAdded{
+		events.Base{ID: req.ID, V: s.v + 1},
+		req.Tag,
+	}, nil
+}
+
+// TagRemoved is a domain event and is emitted when tag is removed from bucket
+type TagRemoved struct {
+	events.Base     // Base event
+	Tag         Tag // Removed tag
+}
+
+func (e *TagRemoved) Type() string {
+	return "bucket.TagRemoved"
+}
+
+func (e *TagRemoved) Data() interface{} {
+	return e.Tag
+}
+
+// Business logic for removing tags
+func RemoveTag(req *RemoveTagRequest, stream []events.Event) (events.Event, error) {
+	const op errors.Op = "bucket.RemoveTag"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return stateForRemovingTag(req, stream)
+}
+
+func stateForRemovingTag(req *RemoveTagRequest, stream []events.Event) (events.Event, error) {
+	const op errors.Op = "bucket.stateForRemovingTag"
+
+	s, err := buildState(req.ID, stream)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Error when building state for removing tag", err)
+	}
+
+	return newTagRemoved(req, &s)
+}
+
+func newTagRemoved(req *RemoveTagRequest, s *state) (events.Event, error) {
+	const op errors.Op = "bucket.removingTag"
+
+	if err := s.readOnly(op); err != nil {
+		return nil, err
+	}
+
+	if s.closed {
+		return nil, errors.New(op, errors.KindExpected, "Bucket is closed")
+	}
+
+	if s.tagIndex(req.Tag) < 0 {
+		return nil, errors.New(op, errors.KindExpected, "Tag not found")
+	}
+
+	return &TagRemoved{
+		events.Base{ID: req.ID, V: s.v + 1},
+		req.Tag,
+	}, nil
+}
+
+// Archived is a domain event and is emitted when closed bucket is moved to cold storage.
+// Archived bucket is read-only
+type Archived struct {
+	events.Base // Base event
+}
+
+func (e *Archived) Type() string {
+	return "bucket.Archived"
+}
+
+func (e *Archived) Data() interface{} {
+	return nil
+}
+
+// Business logic for archiving
+func Archive(req *ArchiveRequest, stream []events.Event) (*Archived, error) {
+	const op errors.Op = "bucket.Archive"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return stateForArchiving(req, stream)
+}
+
+func stateForArchiving(req *ArchiveRequest, stream []events.Event) (*Archived, error) {
+	const op errors.Op = "bucket.stateForArchiving"
+
+	s, err := buildState(req.ID, stream)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Error when building state for archiving", err)
+	}
+
+	return newArchived(req, &s)
+}
+
+func newArchived(req *ArchiveRequest, s *state) (*Archived, error) {
+	const op errors.Op = "bucket.archiving"
+
+	if err := s.readOnly(op); err != nil {
+		return nil, err
+	}
+
+	if !s.closed {
+		return nil, errors.New(op, errors.KindExpected, "Bucket is not closed")
+	}
+
+	return &Archived{
+		events.Base{ID: req.ID, V: s.v + 1},
+	}, nil
+}
+
+// Purged is a domain event and is the tombstone left when bucket is erased.
+// When purged, stores replace the whole stream with it
+type Purged struct {
+	events.Base // Base event
+}
+
+func (e *Purged) Type() string {
+	return "bucket.Purged"
+}
+
+func (e *Purged) Data() interface{} {
+	return nil
+}
+
+// Business logic for erasing
+func Purge(req *PurgeRequest, stream []events.Event) (*Purged, error) {
+	const op errors.Op = "bucket.Purge"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return stateForPurging(req, stream)
+}
+
+func stateForPurging(req *PurgeRequest, stream []events.Event) (*Purged, error) {
+	const op errors.Op = "bucket.stateForPurging"
+
+	s, err := buildState(req.ID, stream)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Error when building state for purging", err)
+	}
+
+	return newPurged(req, &s)
+}
+
+func newPurged(req *PurgeRequest, s *state) (*Purged, error) {
+	const op errors.Op = "bucket.purging"
+
+	if s.deleted {
+		return nil, errors.New(op, errors.KindExpected, "Bucket allready deleted")
+	}
+
+	return &Purged{
+		events.Base{ID: req.ID, V: s.v + 1},
+	}, nil
+}
+
+// Forgotten is a domain event and is emitted when the personal data of bucket is shredded.
+// Stores destroy the data keys of the bucket, so encrypted fields of earlier events can not be read anymore
+type Forgotten struct {
+	events.Base // Base event
+}
+
+func (e *Forgotten) Type() string {
+	return "bucket.Forgotten"
+}
+
+func (e *Forgotten) Data() interface{} {
+	return nil
+}
+
+// Business logic for forgetting
+func Forget(req *ForgetRequest, stream []events.Event) (*Forgotten, error) {
+	const op errors.Op = "bucket.Forget"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return stateForForgetting(req, stream)
+}
+
+func stateForForgetting(req *ForgetRequest, stream []events.Event) (*Forgotten, error) {
+	const op errors.Op = "bucket.stateForForgetting"
+
+	s, err := buildState(req.ID, stream)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Error when building state for forgetting", err)
+	}
+
+	return newForgotten(req, &s)
+}
+
+func newForgotten(req *ForgetRequest, s *state) (*Forgotten, error) {
+	const op errors.Op = "bucket.forgetting"
+
+	if err := s.readOnly(op); err != nil {
+		return nil, err
+	}
+
+	return &Forgotten{
+		events.Base{ID: req.ID, V: s.v + 1},
+	}, nil
+}
@@ -0,0 +1,49 @@
+package bucket_test
+
+import (
+	"testing"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/bucket/buckettest"
+	"github.com/juelko/bucket/pkg/events"
+)
+
+// FuzzNewView folds arbitrary event sequences to views. Folding must not panic, and streams with events of other
+// streams or versions not following each other must be rejected
+func FuzzNewView(f *testing.F) {
+	f.Add([]byte{0, 3, 0, 0})
+	f.Add([]byte{0, 3, 0, 0, 4, 3, 1, 0, 4, 3, 2, 1, 6, 3, 1, 1, 5, 3, 0, 0})
+	f.Add([]byte{0, 3, 0, 0, 2, 3, 0, 0, 12, 3, 0, 0, 14, 1, 0, 0})
+	f.Add([]byte{0, 3, 0, 0, 1, 0, 0, 0})
+	f.Add([]byte{0, 3, 0, 0, 1, 255, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		stream := buckettest.Stream("TestStream", data)
+
+		v, err := bucket.NewView("TestStream", stream...)
+		if err != nil {
+			return
+		}
+
+		if len(stream) == 0 {
+			t.Fatal("empty stream folded to view")
+		}
+
+		for i, e := range stream {
+			if e.EntityID() != "TestStream" {
+				t.Fatalf("event %d of stream %s folded", i, e.EntityID())
+			}
+			if i > 0 && e.EntityVersion() != stream[i-1].EntityVersion()+1 {
+				t.Fatalf("version %d folded after %d", e.EntityVersion(), stream[i-1].EntityVersion())
+			}
+		}
+
+		if last := stream[len(stream)-1].EntityVersion(); events.EntityVersion(v.Version) != last {
+			t.Fatalf("view has version %d, last event %d", v.Version, last)
+		}
+
+		if _, err := bucket.History("TestStream", stream...); err != nil {
+			t.Fatalf("history failed for a valid stream: %v", err)
+		}
+	})
+}
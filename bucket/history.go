@@ -0,0 +1,67 @@
+package bucket
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+)
+
+// History lists every event of the stream with the field level changes it made
+func History(id events.EntityID, stream ...events.Event) ([]Change, error) {
+	const op errors.Op = "bucket.History"
+
+	if len(stream) == 0 {
+		return nil, errors.New(op, errors.KindUnexpected, "Error when building history", fmt.Errorf("Empty stream"))
+	}
+
+	ret := make([]Change, 0, len(stream))
+	s := state{}
+
+	for _, e := range stream {
+		title, desc := s.title, s.desc
+
+		if err := s.apply(id, e); err != nil {
+			return nil, errors.New(op, errors.KindUnexpected, "Error when building history", err)
+		}
+
+		ret = append(ret, Change{
+			Version: uint(e.EntityVersion()),
+			Type:    e.Type(),
+			At:      e.Timestamp(),
+			Diff:    diff(title, desc, &s),
+		})
+	}
+
+	return ret, nil
+}
+
+// Change is a single entry in the bucket history
+type Change struct {
+	Version uint
+	Type    string
+	At      time.Time
+	Diff    []FieldDiff
+}
+
+// FieldDiff describes change of a single field compared to previous state
+type FieldDiff struct {
+	Field string
+	From  string
+	To    string
+}
+
+func diff(title Title, desc Description, s *state) []FieldDiff {
+	var ret []FieldDiff
+
+	if title != s.title {
+		ret = append(ret, FieldDiff{Field: "Title", From: string(title), To: string(s.title)})
+	}
+
+	if desc != s.desc {
+		ret = append(ret, FieldDiff{Field: "Description", From: string(desc), To: string(s.desc)})
+	}
+
+	return ret
+}
@@ -3,7 +3,9 @@ package bucket
 import (
 	"fmt"
 
+	"github.com/juelko/bucket/pkg/errors"
 	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/principal"
 )
 
 func buildState(id events.EntityID, stream []events.Event) (state, error) {
@@ -15,36 +17,205 @@ func buildState(id events.EntityID, stream []events.Event) (state, error) {
 	}
 
 	for _, e := range stream {
+		if err := ret.apply(id, e); err != nil {
+			return state{}, err
+		}
+	}
+
+	return ret, nil
+}
+
+// apply folds single event to the state
+func (s *state) apply(id events.EntityID, e events.Event) error {
+
+	if id != e.EntityID() {
+		return fmt.Errorf("ID Mismatch")
+	}
+
+	// first event can have any version, as the tombstone of a purged stream keeps the version of the stream
+	if e.EntityVersion() == 0 || (s.v != 0 && e.EntityVersion() != s.v+1) {
+		return fmt.Errorf("Version Mismatch")
+	}
+
+	switch event := e.(type) {
+	case *Opened:
+		s.id = event.EntityID()
+		s.title = event.Title
+		s.desc = event.Description
+		s.capacity = event.Capacity
+		s.owner = event.Owner
+		s.v = event.EntityVersion()
+	case *Updated:
+		s.title = event.Title
+		s.desc = event.Description
+		s.v = event.EntityVersion()
+	case *Closed:
+		s.closed = true
+		s.v = event.EntityVersion()
+	case *Reopened:
+		s.closed = false
+		s.v = event.EntityVersion()
+	case *ItemAdded:
+		// full slice expression makes append to copy, so earlier states keep their items
+		s.items = append(s.items[:len(s.items):len(s.items)], event.Item)
+		s.v = event.EntityVersion()
+	case *ItemRemoved:
+		s.items = s.withoutItem(s.itemIndex(event.ItemID))
+		s.v = event.EntityVersion()
+	case *ItemMoved:
+		s.items = s.movedItem(s.itemIndex(event.ItemID), event.Position)
+		s.v = event.EntityVersion()
+	case *CapacityChanged:
+		s.capacity = event.Capacity
+		s.v = event.EntityVersion()
+	case *AccessGranted:
+		s.grants = s.withGrant(event.Principal, event.Role)
+		s.v = event.EntityVersion()
+	case *AccessRevoked:
+		s.grants = s.withGrant(event.Principal, RoleNone)
+		s.v = event.EntityVersion()
+	case *TagAdded:
+		s.tags = append(s.tags[:len(s.tags):len(s.tags)], event.Tag)
+		s.v = event.EntityVersion()
+	case *TagRemoved:
+		s.tags = s.withoutTag(s.tagIndex(event.Tag))
+		s.v = event.EntityVersion()
+	case *Archived:
+		s.archived = true
+		s.v = event.EntityVersion()
+	case *Forgotten:
+		s.v = event.EntityVersion()
+	case *Purged:
+		// tombstone replaces the stream, nothing from the earlier events is kept
+		*s = state{id: event.EntityID(), closed: true, deleted: true, v: event.EntityVersion()}
+	default:
+		return fmt.Errorf("Stream contains unkown events")
+	}
+
+	return nil
+}
 
-		if id != e.EntityID() {
-			return state{}, fmt.Errorf("ID Mismatch")
+type state struct {
+	id       events.EntityID
+	title    Title
+	desc     Description
+	closed   bool
+	items    []Item
+	capacity Capacity
+	owner    principal.ID
+	grants   map[principal.ID]Role
+	tags     []Tag
+	archived bool
+	deleted  bool
+	v        events.EntityVersion
+}
+
+// readOnly returns error if bucket can not be modified anymore
+func (s *state) readOnly(op errors.Op) *errors.Error {
+	if s.deleted {
+		return errors.New(op, errors.KindExpected, "Bucket is deleted")
+	}
+
+	if s.archived {
+		return errors.New(op, errors.KindExpected, "Bucket is archived")
+	}
+
+	return nil
+}
+
+// tagIndex returns position of the tag or -1 if bucket does not have it
+func (s *state) tagIndex(t Tag) int {
+	for i, tag := range s.tags {
+		if tag == t {
+			return i
 		}
+	}
+	return -1
+}
+
+// withoutTag returns copy of the tags without tag in index i
+func (s *state) withoutTag(i int) []Tag {
+	if i < 0 {
+		return s.tags
+	}
 
-		switch event := e.(type) {
-		case *Opened:
-			ret.id = event.EntityID()
-			ret.title = event.Title
-			ret.desc = event.Description
-			ret.v = event.EntityVersion()
-		case *Updated:
-			ret.title = event.Title
-			ret.desc = event.Description
-			ret.v = event.EntityVersion()
-		case *Closed:
-			ret.closed = true
-			ret.v = event.EntityVersion()
-		default:
-			return state{}, fmt.Errorf("Stream contains unkown events")
+	ret := make([]Tag, 0, len(s.tags)-1)
+	ret = append(ret, s.tags[:i]...)
+
+	return append(ret, s.tags[i+1:]...)
+}
+
+// role returns the role of the principal in the bucket.
+// Tombstone of deleted bucket has no owner or grants, so no one has a role in it
+func (s *state) role(p principal.ID) Role {
+	if s.deleted {
+		return RoleNone
+	}
+	if p == s.owner {
+		return RoleOwner
+	}
+	return s.grants[p]
+}
+
+// withGrant returns copy of the grants where principal has the role. RoleNone removes the grant
+func (s *state) withGrant(p principal.ID, r Role) map[principal.ID]Role {
+	ret := make(map[principal.ID]Role, len(s.grants)+1)
+	for k, v := range s.grants {
+		ret[k] = v
+	}
+
+	if r == RoleNone {
+		delete(ret, p)
+	} else {
+		ret[p] = r
+	}
+
+	return ret
+}
+
+// size returns total size of item payloads
+func (s *state) size() uint {
+	var ret uint
+	for _, item := range s.items {
+		ret += uint(len(item.Payload))
+	}
+	return ret
+}
+
+// itemIndex returns position of the item or -1 if bucket does not have it
+func (s *state) itemIndex(id ItemID) int {
+	for i, item := range s.items {
+		if item.ID == id {
+			return i
 		}
 	}
+	return -1
+}
 
-	return ret, nil
+// withoutItem returns copy of the items without item in index i
+func (s *state) withoutItem(i int) []Item {
+	if i < 0 {
+		return s.items
+	}
+
+	ret := make([]Item, 0, len(s.items)-1)
+	ret = append(ret, s.items[:i]...)
+
+	return append(ret, s.items[i+1:]...)
 }
 
-type state struct {
-	id     events.EntityID
-	title  Title
-	desc   Description
-	closed bool
-	v      events.EntityVersion
+// movedItem returns copy of the items where item in index i is moved to the position p
+func (s *state) movedItem(i int, p uint) []Item {
+	if i < 0 || p >= uint(len(s.items)) {
+		return s.items
+	}
+
+	item := s.items[i]
+	rest := s.withoutItem(i)
+
+	ret := make([]Item, 0, len(s.items))
+	ret = append(ret, rest[:p]...)
+	ret = append(ret, item)
+
+	return append(ret, rest[p:]...)
 }
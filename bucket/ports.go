@@ -4,13 +4,29 @@ import (
 	"context"
 
 	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
 )
 
 type Service interface {
 	Open(ctx context.Context, req *OpenRequest) (events.Event, error)
 	Update(ctx context.Context, req *UpdateRequest) (events.Event, error)
 	Close(ctx context.Context, req *CloseRequest) (events.Event, error)
+	Reopen(ctx context.Context, req *ReopenRequest) (events.Event, error)
+	AddItem(ctx context.Context, req *AddItemRequest) (events.Event, error)
+	RemoveItem(ctx context.Context, req *RemoveItemRequest) (events.Event, error)
+	MoveItem(ctx context.Context, req *MoveItemRequest) (events.Event, error)
+	ChangeCapacity(ctx context.Context, req *ChangeCapacityRequest) (events.Event, error)
+	Grant(ctx context.Context, req *GrantRequest) (events.Event, error)
+	Revoke(ctx context.Context, req *RevokeRequest) (events.Event, error)
+	AddTag(ctx context.Context, req *AddTagRequest) (events.Event, error)
+	RemoveTag(ctx context.Context, req *RemoveTagRequest) (events.Event, error)
+	Archive(ctx context.Context, req *ArchiveRequest) (events.Event, error)
+	Purge(ctx context.Context, req *PurgeRequest) (events.Event, error)
+	Forget(ctx context.Context, req *ForgetRequest) (events.Event, error)
+	Verify(ctx context.Context, id events.EntityID) error
 	Get(ctx context.Context, id events.EntityID) (*View, error)
+	List(ctx context.Context, req *ListRequest) ([]*View, error)
+	History(ctx context.Context, id events.EntityID) ([]Change, error)
 }
 
 type Store interface {
@@ -18,3 +34,106 @@ type Store interface {
 	InsertEvent(ctx context.Context, e events.Event) error
 	GetStream(ctx context.Context, id events.EntityID) ([]events.Event, error)
 }
+
+// Unwrapper is implemented by stores decorating another store, like caching or encrypting stores.
+// Decorator implements only the optional ports it changes, the others are found from the decorated store with As
+type Unwrapper interface {
+	// Unwrap returns the decorated store
+	Unwrap() Store
+}
+
+// As returns the optional port P of the store. The port is implemented by the first store having it,
+// starting from s and unwrapping the decorators
+func As[P any](s Store) (P, bool) {
+	for s != nil {
+		if p, ok := s.(P); ok {
+			return p, true
+		}
+
+		u, ok := s.(Unwrapper)
+		if !ok {
+			break
+		}
+		s = u.Unwrap()
+	}
+
+	var none P
+	return none, false
+}
+
+// TagIndex is implemented by stores which index buckets by their tags
+type TagIndex interface {
+	// FindByTags returns sorted IDs of the buckets matching the tags
+	FindByTags(ctx context.Context, tags []Tag, match Match) ([]events.EntityID, error)
+}
+
+// Archiver is implemented by stores supporting data retention
+type Archiver interface {
+	// ArchiveStream appends the event to the stream and moves the stream to cold storage.
+	// Archived stream can be read but not appended, except by PurgeStream
+	ArchiveStream(ctx context.Context, e *Archived) error
+	// PurgeStream erases the stream and leaves only the tombstone event
+	PurgeStream(ctx context.Context, e *Purged) error
+}
+
+// Importer is implemented by stores, which can take streams copied from other stores
+type Importer interface {
+	// ImportTombstone erases the stream like PurgeStream, but the tombstone can skip versions after the last event,
+	// as the last events of a stream purged in the source are erased before they are copied
+	ImportTombstone(ctx context.Context, e *Purged) error
+	// ImportEvent writes the event like OpenStream, InsertEvent or ArchiveStream, but at the store time of the source
+	// given by e.Timestamp, so the history and the hash chain of the stream are kept
+	ImportEvent(ctx context.Context, e events.Event) error
+}
+
+// Shredder is implemented by stores encrypting personal data with per bucket data keys
+type Shredder interface {
+	// ShredStream appends the event to the stream and destroys the data keys of the bucket.
+	// Encrypted fields of the stream can not be decrypted afterwards
+	ShredStream(ctx context.Context, e *Forgotten) error
+}
+
+// Verifier is implemented by stores chaining the events of stream with events.Hash
+type Verifier interface {
+	// VerifyStream recomputes the hash chain of the stored stream
+	VerifyStream(ctx context.Context, id events.EntityID) error
+}
+
+// Scanner is implemented by stores which can list all of their streams
+type Scanner interface {
+	// Streams returns references to the streams of all tenants
+	Streams(ctx context.Context) ([]StreamRef, error)
+}
+
+// Feed is implemented by stores keeping a global, ordered feed of the events of all streams of all tenants
+type Feed interface {
+	// ReadFeed returns at most limit entries with position after given position, ordered by position.
+	// Events erased by PurgeStream are left out, so positions can have gaps. Events the store has lost
+	// are returned as entries with Err, see FeedEntry
+	ReadFeed(ctx context.Context, after uint64, limit int) ([]FeedEntry, error)
+}
+
+// FeedEntry is an event in the global feed
+type FeedEntry struct {
+	Position uint64 // position in the feed, starting from 1
+	Tenant   tenant.ID
+	Event    events.Event
+	// Err is set instead of Event when the store has lost the event, e.g. evicted it without keeping it.
+	// Readers can not copy the event, but can report the loss and continue after Position
+	Err error
+}
+
+// StreamRef identifies the stream of a tenant
+type StreamRef struct {
+	Tenant tenant.ID
+	ID     events.EntityID
+}
+
+// TitleConflict is wrapped in KindAllreadyExists errors by stores enforcing unique titles
+type TitleConflict struct {
+	ID events.EntityID // bucket holding the title
+}
+
+func (e *TitleConflict) Error() string {
+	return "title is in use by bucket " + string(e.ID)
+}
@@ -3,14 +3,16 @@ package bucket
 import (
 	"github.com/juelko/bucket/pkg/errors"
 	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/principal"
 	"github.com/juelko/bucket/pkg/validator"
 )
 
 // OpenRequest represent arguments for opening new bucket
 type OpenRequest struct {
-	ID    events.EntityID
-	Title Title
-	Desc  Description
+	ID       events.EntityID
+	Title    Title
+	Desc     Description
+	Capacity Capacity // optional, zero value for unlimited
 }
 
 func (req *OpenRequest) Validate() error {
@@ -39,6 +41,21 @@ func (req *CloseRequest) Validate() error {
 
 }
 
+type ReopenRequest struct {
+	ID events.EntityID
+}
+
+func (req *ReopenRequest) Validate() error {
+	const op errors.Op = "bucket.ReopenRequest.Validate"
+
+	if err := validator.Validate(req.ID); err != nil {
+		return errors.New(op, errors.KindValidation, "Invalid arguments", err)
+	}
+
+	return nil
+
+}
+
 type UpdateRequest struct {
 	ID    events.EntityID
 	Title Title
@@ -56,6 +73,200 @@ func (req *UpdateRequest) Validate() error {
 
 }
 
+// AddItemRequest represent arguments for adding new item to the bucket
+type AddItemRequest struct {
+	ID      events.EntityID
+	ItemID  ItemID
+	Name    ItemName
+	Payload Payload
+}
+
+func (req *AddItemRequest) Validate() error {
+	const op errors.Op = "bucket.AddItemRequest.Validate"
+
+	if err := validator.Validate(req.ID, req.ItemID, req.Name); err != nil {
+		return errors.New(op, errors.KindValidation, "Invalid arguments", err)
+	}
+
+	return nil
+}
+
+type RemoveItemRequest struct {
+	ID     events.EntityID
+	ItemID ItemID
+}
+
+func (req *RemoveItemRequest) Validate() error {
+	const op errors.Op = "bucket.RemoveItemRequest.Validate"
+
+	if err := validator.Validate(req.ID, req.ItemID); err != nil {
+		return errors.New(op, errors.KindValidation, "Invalid arguments", err)
+	}
+
+	return nil
+}
+
+// MoveItemRequest represent arguments for moving item to the given zero based position
+type MoveItemRequest struct {
+	ID       events.EntityID
+	ItemID   ItemID
+	Position uint
+}
+
+func (req *MoveItemRequest) Validate() error {
+	const op errors.Op = "bucket.MoveItemRequest.Validate"
+
+	if err := validator.Validate(req.ID, req.ItemID); err != nil {
+		return errors.New(op, errors.KindValidation, "Invalid arguments", err)
+	}
+
+	return nil
+}
+
+type ChangeCapacityRequest struct {
+	ID       events.EntityID
+	Capacity Capacity
+}
+
+func (req *ChangeCapacityRequest) Validate() error {
+	const op errors.Op = "bucket.ChangeCapacityRequest.Validate"
+
+	if err := validator.Validate(req.ID); err != nil {
+		return errors.New(op, errors.KindValidation, "Invalid arguments", err)
+	}
+
+	return nil
+}
+
+// GrantRequest represent arguments for sharing the bucket with a principal
+type GrantRequest struct {
+	ID        events.EntityID
+	Principal principal.ID
+	Role      Role
+}
+
+func (req *GrantRequest) Validate() error {
+	const op errors.Op = "bucket.GrantRequest.Validate"
+
+	if err := validator.Validate(req.ID, req.Principal, req.Role); err != nil {
+		return errors.New(op, errors.KindValidation, "Invalid arguments", err)
+	}
+
+	return nil
+}
+
+type RevokeRequest struct {
+	ID        events.EntityID
+	Principal principal.ID
+}
+
+func (req *RevokeRequest) Validate() error {
+	const op errors.Op = "bucket.RevokeRequest.Validate"
+
+	if err := validator.Validate(req.ID, req.Principal); err != nil {
+		return errors.New(op, errors.KindValidation, "Invalid arguments", err)
+	}
+
+	return nil
+}
+
+type AddTagRequest struct {
+	ID  events.EntityID
+	Tag Tag
+}
+
+func (req *AddTagRequest) Validate() error {
+	const op errors.Op = "bucket.AddTagRequest.Validate"
+
+	if err := validator.Validate(req.ID, req.Tag); err != nil {
+		return errors.New(op, errors.KindValidation, "Invalid arguments", err)
+	}
+
+	return nil
+}
+
+type RemoveTagRequest struct {
+	ID  events.EntityID
+	Tag Tag
+}
+
+func (req *RemoveTagRequest) Validate() error {
+	const op errors.Op = "bucket.RemoveTagRequest.Validate"
+
+	if err := validator.Validate(req.ID, req.Tag); err != nil {
+		return errors.New(op, errors.KindValidation, "Invalid arguments", err)
+	}
+
+	return nil
+}
+
+// ListRequest represent arguments for listing buckets by tags
+type ListRequest struct {
+	Tags  []Tag
+	Match Match
+}
+
+func (req *ListRequest) Validate() error {
+	const op errors.Op = "bucket.ListRequest.Validate"
+
+	if len(req.Tags) == 0 {
+		return errors.New(op, errors.KindValidation, "Tags missing")
+	}
+
+	args := []validator.Validator{req.Match}
+	for _, t := range req.Tags {
+		args = append(args, t)
+	}
+
+	if err := validator.Validate(args...); err != nil {
+		return errors.New(op, errors.KindValidation, "Invalid arguments", err)
+	}
+
+	return nil
+}
+
+type ArchiveRequest struct {
+	ID events.EntityID
+}
+
+func (req *ArchiveRequest) Validate() error {
+	const op errors.Op = "bucket.ArchiveRequest.Validate"
+
+	if err := validator.Validate(req.ID); err != nil {
+		return errors.New(op, errors.KindValidation, "Invalid arguments", err)
+	}
+
+	return nil
+}
+
+type PurgeRequest struct {
+	ID events.EntityID
+}
+
+func (req *PurgeRequest) Validate() error {
+	const op errors.Op = "bucket.PurgeRequest.Validate"
+
+	if err := validator.Validate(req.ID); err != nil {
+		return errors.New(op, errors.KindValidation, "Invalid arguments", err)
+	}
+
+	return nil
+}
+
+type ForgetRequest struct {
+	ID events.EntityID
+}
+
+func (req *ForgetRequest) Validate() error {
+	const op errors.Op = "bucket.ForgetRequest.Validate"
+
+	if err := validator.Validate(req.ID); err != nil {
+		return errors.New(op, errors.KindValidation, "Invalid arguments", err)
+	}
+
+	return nil
+}
+
 type Reponse struct {
 	View *View
 	Err  string
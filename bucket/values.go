@@ -23,3 +23,118 @@ func (t Title) Validate() *errors.Error {
 
 // Desription for the bucket
 type Description string
+
+// ItemID identifies item inside the bucket
+type ItemID string
+
+var itemIDRegexp = regexp.MustCompile(`^[A-Za-z0-9]{1,64}$`)
+
+func (id ItemID) Validate() *errors.Error {
+	const op errors.Op = "bucket.ItemID.Validate"
+
+	if !itemIDRegexp.Match([]byte(id)) {
+		return errors.New(op, errors.KindValidation, "Invalid value for ItemID")
+	}
+
+	return nil
+}
+
+// ItemName for the item
+type ItemName string
+
+var itemNameRegexp = regexp.MustCompile(`^[\w -]{1,64}$`)
+
+func (n ItemName) Validate() *errors.Error {
+	const op errors.Op = "bucket.ItemName.Validate"
+
+	if !itemNameRegexp.Match([]byte(n)) {
+		return errors.New(op, errors.KindValidation, "Invalid value for ItemName")
+	}
+
+	return nil
+}
+
+// Payload is the content of the item
+type Payload []byte
+
+// Capacity limits the contents of the bucket. Zero value means no limit
+type Capacity struct {
+	MaxItems uint // maximum number of items, 0 for unlimited
+	MaxSize  uint // maximum total size of item payloads in bytes, 0 for unlimited
+}
+
+// allows reports whether contents of given item count and size fits in the capacity
+func (c Capacity) allows(items, size uint) bool {
+	if c.MaxItems > 0 && items > c.MaxItems {
+		return false
+	}
+
+	if c.MaxSize > 0 && size > c.MaxSize {
+		return false
+	}
+
+	return true
+}
+
+// Role of the principal in the bucket. Each role includes rights of the lesser roles
+type Role int
+
+const (
+	RoleNone   Role = iota // no access
+	RoleViewer             // can read the bucket
+	RoleEditor             // can modify bucket contents
+	RoleOwner              // can close, reopen and share the bucket
+)
+
+var roleStrings = []string{"None", "Viewer", "Editor", "Owner"}
+
+func (r Role) String() string {
+	if r < 0 || int(r) >= len(roleStrings) {
+		return roleStrings[0]
+	}
+	return roleStrings[r]
+}
+
+// Validate reports error if role can not be granted. Ownership is set when bucket is opened
+func (r Role) Validate() *errors.Error {
+	const op errors.Op = "bucket.Role.Validate"
+
+	if r != RoleViewer && r != RoleEditor {
+		return errors.New(op, errors.KindValidation, "Invalid value for Role")
+	}
+
+	return nil
+}
+
+// Tag classifies the bucket, e.g. "project:apollo" or "urgent"
+type Tag string
+
+var tagRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}(:[a-z0-9][a-z0-9_-]{0,31})?$`)
+
+func (t Tag) Validate() *errors.Error {
+	const op errors.Op = "bucket.Tag.Validate"
+
+	if !tagRegexp.Match([]byte(t)) {
+		return errors.New(op, errors.KindValidation, "Invalid value for Tag")
+	}
+
+	return nil
+}
+
+// Match tells how multiple tags are combined in queries
+type Match int
+
+const (
+	MatchAll Match = iota // bucket has every tag
+	MatchAny              // bucket has at least one of the tags
+)
+
+func (m Match) Validate() *errors.Error {
+	const op errors.Op = "bucket.Match.Validate"
+
+	if m != MatchAll && m != MatchAny {
+		return errors.New(op, errors.KindValidation, "Invalid value for Match")
+	}
+
+	return nil
+}
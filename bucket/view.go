@@ -13,13 +13,32 @@ func NewView(id events.EntityID, stream ...events.Event) (*View, error) {
 		return nil, errors.New(op, errors.KindUnexpected, "Error when build state for view", err)
 	}
 
-	return &View{
+	v := &View{
 		ID:          string(s.id),
 		Title:       string(s.title),
 		Description: string(s.desc),
 		Version:     uint(s.v),
 		IsClosed:    s.closed,
-	}, nil
+		Owner:       string(s.owner),
+		IsArchived:  s.archived,
+		IsDeleted:   s.deleted,
+		MaxItems:    s.capacity.MaxItems,
+		MaxSize:     s.capacity.MaxSize,
+	}
+
+	for _, item := range s.items {
+		v.Items = append(v.Items, ItemView{
+			ID:      string(item.ID),
+			Name:    string(item.Name),
+			Payload: append([]byte(nil), item.Payload...), // copied, so changing the view does not change the events
+		})
+	}
+
+	for _, tag := range s.tags {
+		v.Tags = append(v.Tags, string(tag))
+	}
+
+	return v, nil
 }
 
 type View struct {
@@ -28,4 +47,17 @@ type View struct {
 	Description string
 	Version     uint
 	IsClosed    bool
+	Items       []ItemView // items in order
+	MaxItems    uint       // 0 for unlimited
+	MaxSize     uint       // 0 for unlimited
+	Owner       string
+	Tags        []string
+	IsArchived  bool // bucket is in cold storage and read-only
+	IsDeleted   bool // bucket is erased, only ID and Version are left
+}
+
+type ItemView struct {
+	ID      string
+	Name    string
+	Payload []byte
 }
@@ -0,0 +1,230 @@
+// Package cache provides read-through caching of bucket streams and views.
+//
+// Cache keeps the entries in memory with a size limit and time to live. It is composed
+// around a bucket.Store with NewStore, which caches the streams, or around a bucket.Service
+// with NewService, which caches the views returned by Get. Both decorators invalidate the
+// entries of a bucket when they write to it. Writes by other processes sharing the store are
+// seen after the time to live, or immediately if the cache Watches the feed of the store.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/principal"
+	"github.com/juelko/bucket/pkg/tenant"
+)
+
+// Options controls the Cache
+type Options struct {
+	TTL        time.Duration // time to live of an entry, 0 keeps entries until evicted or invalidated
+	MaxEntries int           // entries kept, least recently used are evicted first, defaults to 1000
+}
+
+// Stats tells how the cache is used
+type Stats struct {
+	Hits          int64
+	Misses        int64 // includes expired entries
+	Evictions     int64 // entries evicted by size limit
+	Invalidations int64 // entries dropped because the bucket was written
+	Entries       int
+}
+
+// Cache keeps streams and views by tenant, bucket id and version
+type Cache struct {
+	opts Options
+	now  func() time.Time
+
+	mtx     sync.Mutex
+	lru     *list.List                          // entries, least recently used at the back
+	buckets map[bucketKey]map[key]*list.Element // entries of each bucket, for invalidation
+	epoch   uint64                              // incremented by invalidations
+	stats   Stats
+
+	// epochs of the last invalidation of buckets. When it grows over MaxEntries it is cleared,
+	// and values read before the epoch of clearing are dropped as if their bucket was invalidated
+	invalidated map[bucketKey]uint64
+	floor       uint64
+}
+
+// New returns empty Cache
+func New(opts Options) *Cache {
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = 1000
+	}
+
+	return &Cache{
+		opts:    opts,
+		now:     time.Now,
+		lru:     list.New(),
+		buckets: map[bucketKey]map[key]*list.Element{},
+
+		invalidated: map[bucketKey]uint64{},
+	}
+}
+
+// bucketKey identifies bucket of a tenant
+type bucketKey struct {
+	tenant tenant.ID
+	id     events.EntityID
+}
+
+// key identifies an entry of the bucket. Views are authorized, so they are cached for each principal
+type key struct {
+	kind      string // "stream" or "view"
+	principal principal.ID
+}
+
+type entry struct {
+	bucket  bucketKey
+	key     key
+	version events.EntityVersion
+	value   interface{}
+	expires time.Time // zero if entry does not expire
+}
+
+// get returns the value of the entry, or false if there is none or it has expired.
+// On miss the returned epoch is given to put with the value read from the underlying layer
+func (c *Cache) get(ctx context.Context, id events.EntityID, k key) (interface{}, bool, uint64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	el, ok := c.buckets[bucketKey{tenant.Of(ctx), id}][k]
+	if !ok {
+		c.stats.Misses++
+		return nil, false, c.epoch
+	}
+
+	e := el.Value.(*entry)
+	if !e.expires.IsZero() && !c.now().Before(e.expires) {
+		c.remove(el)
+		c.stats.Misses++
+		return nil, false, c.epoch
+	}
+
+	c.lru.MoveToFront(el)
+	c.stats.Hits++
+
+	return e.value, true, c.epoch
+}
+
+// put adds the value of given version. Value is not added if its bucket was invalidated after the epoch,
+// as it could have been read before the write which invalidated it
+func (c *Cache) put(ctx context.Context, id events.EntityID, k key, epoch uint64, v events.EntityVersion, value interface{}) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	bk := bucketKey{tenant.Of(ctx), id}
+
+	if epoch < c.floor || c.invalidated[bk] > epoch {
+		return
+	}
+
+	if el, ok := c.buckets[bk][k]; ok {
+		c.remove(el)
+	}
+
+	e := &entry{bucket: bk, key: k, version: v, value: value}
+	if c.opts.TTL > 0 {
+		e.expires = c.now().Add(c.opts.TTL)
+	}
+
+	if c.buckets[bk] == nil {
+		c.buckets[bk] = map[key]*list.Element{}
+	}
+	c.buckets[bk][k] = c.lru.PushFront(e)
+
+	for c.lru.Len() > c.opts.MaxEntries {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// Invalidate drops the entries of the bucket of the tenant in context
+func (c *Cache) Invalidate(ctx context.Context, id events.EntityID) {
+	c.invalidate(bucketKey{tenant.Of(ctx), id}, 0)
+}
+
+// invalidate drops the entries of the bucket older than version, or all if version is 0
+func (c *Cache) invalidate(bk bucketKey, version events.EntityVersion) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.epoch++
+
+	if len(c.invalidated) >= c.opts.MaxEntries {
+		c.invalidated = map[bucketKey]uint64{}
+		c.floor = c.epoch
+	}
+	c.invalidated[bk] = c.epoch
+
+	for _, el := range c.buckets[bk] {
+		if version == 0 || el.Value.(*entry).version < version {
+			c.remove(el)
+			c.stats.Invalidations++
+		}
+	}
+}
+
+// remove drops the entry, caller holds the lock
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*entry)
+
+	delete(c.buckets[e.bucket], e.key)
+	if len(c.buckets[e.bucket]) == 0 {
+		delete(c.buckets, e.bucket)
+	}
+}
+
+// Stats returns the statistics of the cache
+func (c *Cache) Stats() Stats {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	s := c.stats
+	s.Entries = c.lru.Len()
+
+	return s
+}
+
+// Watch follows the feed of the store and invalidates the buckets written by other processes,
+// until ctx is done. Events allready in the feed are skipped. Returns ctx.Err() or the error of the feed
+func (c *Cache) Watch(ctx context.Context, f bucket.Feed, interval time.Duration) error {
+	const op errors.Op = "cache.Cache.Watch"
+
+	const batch = 100
+
+	var position uint64
+	skipping := true
+
+	for {
+		entries, err := f.ReadFeed(ctx, position, batch)
+		if err != nil {
+			return errors.New(op, errors.KindUnexpected, "Could not read feed", err)
+		}
+
+		for _, e := range entries {
+			if !skipping && e.Err == nil {
+				// entries read after the write through this cache allready have the event
+				c.invalidate(bucketKey{e.Tenant, e.Event.EntityID()}, e.Event.EntityVersion())
+			}
+			position = e.Position
+		}
+
+		if len(entries) == batch {
+			continue
+		}
+		skipping = false
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
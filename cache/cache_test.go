@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/principal"
+	"github.com/juelko/bucket/pkg/tenant"
+	svc "github.com/juelko/bucket/service"
+	"github.com/juelko/bucket/store/inmem"
+	"github.com/juelko/bucket/store/shred"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+
+	c := New(Options{})
+	s := NewStore(inmem.NewTestBucketStore(), c)
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+	first, err := s.GetStream(ctx, "OpenID")
+	require.Nil(t, err)
+	second, err := s.GetStream(ctx, "OpenID")
+	require.Nil(t, err)
+	require.Equal(t, first, second)
+	require.Equal(t, Stats{Hits: 1, Misses: 1, Entries: 1}, c.Stats())
+
+	_, err = s.GetStream(tenant.NewContext(ctx, "Other"), "OpenID")
+	require.NotNil(t, err, "tenants should not share entries")
+
+	require.Nil(t, s.InsertEvent(ctx, &bucket.Closed{Base: events.Base{ID: "OpenID", V: 2}}))
+
+	stream, err := s.GetStream(ctx, "OpenID")
+	require.Nil(t, err)
+	require.Len(t, stream, 2, "insert should invalidate the stream")
+	require.Equal(t, Stats{Hits: 1, Misses: 3, Invalidations: 1, Entries: 1}, c.Stats())
+
+	require.Nil(t, s.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: "NewID", V: 1}, BucketData: bucket.BucketData{Title: "NewTitle"}, Owner: "TestOwner"}))
+
+	_, ok := s.(bucket.Verifier)
+	require.False(t, ok, "cache should implement only the ports invalidating it")
+
+	v, ok := bucket.As[bucket.Verifier](s)
+	require.True(t, ok, "other ports should be found from the store")
+	require.Nil(t, v.VerifyStream(ctx, "NewID"))
+
+	err = s.(bucket.Shredder).ShredStream(ctx, &bucket.Forgotten{Base: events.Base{ID: "OpenID", V: 3}})
+	require.Equal(t, &errors.Error{Op: "cache.store.ShredStream", Kind: errors.KindUnexpected, Msg: "Store does not support forgetting"}, err)
+
+	shredded := NewStore(shred.NewBucketStore(inmem.NewBucketStore(), shred.NewKeyStore()), c)
+	require.Nil(t, shredded.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: "NewID", V: 1}, BucketData: bucket.BucketData{Title: "NewTitle"}, Owner: "TestOwner"}))
+	require.Nil(t, shredded.(bucket.Shredder).ShredStream(ctx, &bucket.Forgotten{Base: events.Base{ID: "NewID", V: 2}}))
+}
+
+func TestService(t *testing.T) {
+	t.Parallel()
+
+	c := New(Options{})
+	s := NewService(svc.NewService(inmem.NewTestBucketStore()), c)
+	owner := principal.NewContext(tenant.NewContext(context.Background(), tenant.Default), "TestOwner")
+	viewer := principal.NewContext(tenant.NewContext(context.Background(), tenant.Default), "TestViewer")
+	stranger := principal.NewContext(tenant.NewContext(context.Background(), tenant.Default), "Stranger")
+
+	v, err := s.Get(owner, "SharedID")
+	require.Nil(t, err)
+	v.Title = "Modified"
+
+	v, err = s.Get(owner, "SharedID")
+	require.Nil(t, err)
+	require.Equal(t, "OpenTitle", v.Title, "cached view should not be modified by callers")
+
+	_, err = s.Get(viewer, "SharedID")
+	require.Nil(t, err)
+
+	_, err = s.Get(stranger, "SharedID")
+	require.NotNil(t, err, "view should be authorized for each principal")
+
+	require.Equal(t, Stats{Hits: 1, Misses: 3, Entries: 2}, c.Stats())
+
+	_, err = s.Update(owner, &bucket.UpdateRequest{ID: "SharedID", Title: "NewTitle"})
+	require.Nil(t, err)
+
+	v, err = s.Get(viewer, "SharedID")
+	require.Nil(t, err)
+	require.Equal(t, "NewTitle", v.Title, "update should invalidate views of all principals")
+	require.Equal(t, uint(4), v.Version)
+
+	_, err = s.AddItem(owner, &bucket.AddItemRequest{ID: "SharedID", ItemID: "Item1", Name: "Item", Payload: bucket.Payload("data")})
+	require.Nil(t, err)
+
+	v, err = s.Get(owner, "SharedID")
+	require.Nil(t, err)
+	v.Items[0].Payload[0] = 'x'
+
+	v, err = s.Get(owner, "SharedID")
+	require.Nil(t, err)
+	require.Equal(t, []byte("data"), v.Items[0].Payload, "cached payload should not be modified by callers")
+}
+
+func TestLimits(t *testing.T) {
+	t.Parallel()
+
+	c := New(Options{TTL: time.Minute, MaxEntries: 2})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+	c.put(ctx, "FirstID", streamKey, 0, 1, "first")
+	c.put(ctx, "SecondID", streamKey, 0, 1, "second")
+	_, ok, _ := c.get(ctx, "FirstID", streamKey)
+	require.True(t, ok)
+
+	c.put(ctx, "ThirdID", streamKey, 0, 1, "third")
+	_, ok, _ = c.get(ctx, "SecondID", streamKey)
+	require.False(t, ok, "least recently used should be evicted")
+
+	now = now.Add(time.Minute)
+	_, ok, _ = c.get(ctx, "FirstID", streamKey)
+	require.False(t, ok, "entry should expire")
+
+	require.Equal(t, Stats{Hits: 1, Misses: 2, Evictions: 1, Entries: 1}, c.Stats())
+
+	_, _, epoch := c.get(ctx, "FourthID", streamKey)
+	c.Invalidate(ctx, "FourthID")
+	c.put(ctx, "FourthID", streamKey, epoch, 1, "stale")
+	_, ok, _ = c.get(ctx, "FourthID", streamKey)
+	require.False(t, ok, "value read before invalidation should not be cached")
+
+	_, _, epoch = c.get(ctx, "FourthID", streamKey)
+	c.Invalidate(ctx, "OtherID")
+	c.put(ctx, "FourthID", streamKey, epoch, 1, "fresh")
+	_, ok, _ = c.get(ctx, "FourthID", streamKey)
+	require.True(t, ok, "invalidation of other bucket should not drop the value")
+
+	_, _, epoch = c.get(ctx, "FifthID", streamKey)
+	c.Invalidate(ctx, "FifthID")
+	c.Invalidate(ctx, "SixthID")
+	c.Invalidate(ctx, "SeventhID")
+	c.put(ctx, "FifthID", streamKey, epoch, 1, "stale")
+	_, ok, _ = c.get(ctx, "FifthID", streamKey)
+	require.False(t, ok, "value read before invalidations are forgotten should not be cached")
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	shared := inmem.NewTestBucketStore()
+	c := New(Options{})
+	s := NewStore(shared, c)
+	ctx, cancel := context.WithCancel(tenant.NewContext(context.Background(), tenant.Default))
+	defer cancel()
+
+	done := make(chan error)
+	go func() { done <- c.Watch(ctx, shared.(bucket.Feed), time.Millisecond) }()
+
+	// written by other process sharing the store, until the watcher has started and seen the write
+	v := events.EntityVersion(1)
+	require.Eventually(t, func() bool {
+		if _, err := s.GetStream(ctx, "OpenID"); err != nil {
+			return false
+		}
+		v++
+		require.Nil(t, shared.InsertEvent(ctx, &bucket.TagAdded{Base: events.Base{ID: "OpenID", V: v}, Tag: "tag"}))
+		time.Sleep(5 * time.Millisecond)
+		return c.Stats().Invalidations > 0
+	}, time.Second, time.Millisecond)
+
+	stream, err := s.GetStream(ctx, "OpenID")
+	require.Nil(t, err)
+	require.Equal(t, v, stream[len(stream)-1].EntityVersion())
+
+	cancel()
+	require.Equal(t, context.Canceled, <-done)
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/principal"
+)
+
+// NewService returns bucket.Service caching the views returned by Get of svc in c. Views are cached
+// for each principal, as svc authorizes them. Other reads are passed to svc as they are
+func NewService(svc bucket.Service, c *Cache) bucket.Service {
+	return &service{Service: svc, cache: c}
+}
+
+type service struct {
+	bucket.Service
+	cache *Cache
+}
+
+func (s *service) Get(ctx context.Context, id events.EntityID) (*bucket.View, error) {
+	p, ok := principal.FromContext(ctx)
+	if !ok {
+		return s.Service.Get(ctx, id)
+	}
+
+	k := key{kind: "view", principal: p}
+
+	cached, ok, epoch := s.cache.get(ctx, id, k)
+	if ok {
+		return copyView(cached.(*bucket.View)), nil
+	}
+
+	v, err := s.Service.Get(ctx, id)
+	if err != nil {
+		return v, err
+	}
+
+	s.cache.put(ctx, id, k, epoch, events.EntityVersion(v.Version), copyView(v))
+
+	return v, nil
+}
+
+// copyView returns copy of the view, so callers can not modify the cached one
+func copyView(v *bucket.View) *bucket.View {
+	ret := *v
+	ret.Items = append([]bucket.ItemView(nil), v.Items...)
+	for i := range ret.Items {
+		ret.Items[i].Payload = append([]byte(nil), v.Items[i].Payload...)
+	}
+	ret.Tags = append([]string(nil), v.Tags...)
+
+	return &ret
+}
+
+// written invalidates the views of the bucket after write, also when the write fails
+func (s *service) written(ctx context.Context, id events.EntityID, e events.Event, err error) (events.Event, error) {
+	s.cache.Invalidate(ctx, id)
+	return e, err
+}
+
+func (s *service) Open(ctx context.Context, req *bucket.OpenRequest) (events.Event, error) {
+	e, err := s.Service.Open(ctx, req)
+	return s.written(ctx, req.ID, e, err)
+}
+
+func (s *service) Update(ctx context.Context, req *bucket.UpdateRequest) (events.Event, error) {
+	e, err := s.Service.Update(ctx, req)
+	return s.written(ctx, req.ID, e, err)
+}
+
+func (s *service) Close(ctx context.Context, req *bucket.CloseRequest) (events.Event, error) {
+	e, err := s.Service.Close(ctx, req)
+	return s.written(ctx, req.ID, e, err)
+}
+
+func (s *service) Reopen(ctx context.Context, req *bucket.ReopenRequest) (events.Event, error) {
+	e, err := s.Service.Reopen(ctx, req)
+	return s.written(ctx, req.ID, e, err)
+}
+
+func (s *service) AddItem(ctx context.Context, req *bucket.AddItemRequest) (events.Event, error) {
+	e, err := s.Service.AddItem(ctx, req)
+	return s.written(ctx, req.ID, e, err)
+}
+
+func (s *service) RemoveItem(ctx context.Context, req *bucket.RemoveItemRequest) (events.Event, error) {
+	e, err := s.Service.RemoveItem(ctx, req)
+	return s.written(ctx, req.ID, e, err)
+}
+
+func (s *service) MoveItem(ctx context.Context, req *bucket.MoveItemRequest) (events.Event, error) {
+	e, err := s.Service.MoveItem(ctx, req)
+	return s.written(ctx, req.ID, e, err)
+}
+
+func (s *service) ChangeCapacity(ctx context.Context, req *bucket.ChangeCapacityRequest) (events.Event, error) {
+	e, err := s.Service.ChangeCapacity(ctx, req)
+	return s.written(ctx, req.ID, e, err)
+}
+
+func (s *service) Grant(ctx context.Context, req *bucket.GrantRequest) (events.Event, error) {
+	e, err := s.Service.Grant(ctx, req)
+	return s.written(ctx, req.ID, e, err)
+}
+
+func (s *service) Revoke(ctx context.Context, req *bucket.RevokeRequest) (events.Event, error) {
+	e, err := s.Service.Revoke(ctx, req)
+	return s.written(ctx, req.ID, e, err)
+}
+
+func (s *service) AddTag(ctx context.Context, req *bucket.AddTagRequest) (events.Event, error) {
+	e, err := s.Service.AddTag(ctx, req)
+	return s.written(ctx, req.ID, e, err)
+}
+
+func (s *service) RemoveTag(ctx context.Context, req *bucket.RemoveTagRequest) (events.Event, error) {
+	e, err := s.Service.RemoveTag(ctx, req)
+	return s.written(ctx, req.ID, e, err)
+}
+
+func (s *service) Archive(ctx context.Context, req *bucket.ArchiveRequest) (events.Event, error) {
+	e, err := s.Service.Archive(ctx, req)
+	return s.written(ctx, req.ID, e, err)
+}
+
+func (s *service) Purge(ctx context.Context, req *bucket.PurgeRequest) (events.Event, error) {
+	e, err := s.Service.Purge(ctx, req)
+	return s.written(ctx, req.ID, e, err)
+}
+
+func (s *service) Forget(ctx context.Context, req *bucket.ForgetRequest) (events.Event, error) {
+	e, err := s.Service.Forget(ctx, req)
+	return s.written(ctx, req.ID, e, err)
+}
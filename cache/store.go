@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+)
+
+// NewStore returns bucket.Store caching the streams read from s in c. Returned store implements the write ports,
+// which invalidate the cache, the other optional ports of s are found with bucket.As
+func NewStore(s bucket.Store, c *Cache) bucket.Store {
+	return &store{next: s, cache: c}
+}
+
+type store struct {
+	next  bucket.Store
+	cache *Cache
+}
+
+var streamKey = key{kind: "stream"}
+
+func (s *store) OpenStream(ctx context.Context, o *bucket.Opened) error {
+	defer s.cache.Invalidate(ctx, o.EntityID())
+
+	return s.next.OpenStream(ctx, o)
+}
+
+// InsertEvent invalidates the stream also when the insert fails, as the failure can be caused by stale cache
+func (s *store) InsertEvent(ctx context.Context, e events.Event) error {
+	defer s.cache.Invalidate(ctx, e.EntityID())
+
+	return s.next.InsertEvent(ctx, e)
+}
+
+// GetStream returns the cached stream, or reads it from the underlying store. Returned slice is a copy,
+// but the events are shared and must not be modified
+func (s *store) GetStream(ctx context.Context, id events.EntityID) ([]events.Event, error) {
+	v, ok, epoch := s.cache.get(ctx, id, streamKey)
+	if ok {
+		return append([]events.Event(nil), v.([]events.Event)...), nil
+	}
+
+	stream, err := s.next.GetStream(ctx, id)
+	if err != nil || len(stream) == 0 {
+		return stream, err
+	}
+
+	s.cache.put(ctx, id, streamKey, epoch, stream[len(stream)-1].EntityVersion(), append([]events.Event(nil), stream...))
+
+	return stream, nil
+}
+
+// Unwrap returns the cached store
+func (s *store) Unwrap() bucket.Store {
+	return s.next
+}
+
+func (s *store) ArchiveStream(ctx context.Context, e *bucket.Archived) error {
+	const op errors.Op = "cache.store.ArchiveStream"
+
+	a, ok := bucket.As[bucket.Archiver](s.next)
+	if !ok {
+		return errors.New(op, errors.KindUnexpected, "Store does not support archiving")
+	}
+
+	defer s.cache.Invalidate(ctx, e.EntityID())
+
+	return a.ArchiveStream(ctx, e)
+}
+
+func (s *store) PurgeStream(ctx context.Context, e *bucket.Purged) error {
+	const op errors.Op = "cache.store.PurgeStream"
+
+	a, ok := bucket.As[bucket.Archiver](s.next)
+	if !ok {
+		return errors.New(op, errors.KindUnexpected, "Store does not support purging")
+	}
+
+	defer s.cache.Invalidate(ctx, e.EntityID())
+
+	return a.PurgeStream(ctx, e)
+}
+
+func (s *store) ImportTombstone(ctx context.Context, e *bucket.Purged) error {
+	const op errors.Op = "cache.store.ImportTombstone"
+
+	imp, ok := bucket.As[bucket.Importer](s.next)
+	if !ok {
+		return errors.New(op, errors.KindUnexpected, "Store does not support importing")
+	}
+
+	defer s.cache.Invalidate(ctx, e.EntityID())
+
+	return imp.ImportTombstone(ctx, e)
+}
+
+func (s *store) ImportEvent(ctx context.Context, e events.Event) error {
+	const op errors.Op = "cache.store.ImportEvent"
+
+	imp, ok := bucket.As[bucket.Importer](s.next)
+	if !ok {
+		return errors.New(op, errors.KindUnexpected, "Store does not support importing")
+	}
+
+	defer s.cache.Invalidate(ctx, e.EntityID())
+
+	return imp.ImportEvent(ctx, e)
+}
+
+func (s *store) ShredStream(ctx context.Context, e *bucket.Forgotten) error {
+	const op errors.Op = "cache.store.ShredStream"
+
+	sh, ok := bucket.As[bucket.Shredder](s.next)
+	if !ok {
+		return errors.New(op, errors.KindUnexpected, "Store does not support forgetting")
+	}
+
+	defer s.cache.Invalidate(ctx, e.EntityID())
+
+	return sh.ShredStream(ctx, e)
+}
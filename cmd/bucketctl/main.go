@@ -0,0 +1,291 @@
+// Command bucketctl runs maintenance tasks against bucket event stores.
+//
+// Usage:
+//
+//	bucketctl verify [-store dsn] [-keys file]
+//	bucketctl export [-store dsn] [-keys file] [-out file]
+//	bucketctl import [-store dsn] [-keys file] [-in file] [-dry-run] [-conflict abort|skip|resume]
+//	bucketctl migrate [-from dsn] [-from-keys file] [-to dsn] [-to-keys file] [-position n]
+//	bucketctl compact -store bolt:file -out file
+//
+// Store dsn is mem for an empty in-memory store, bolt:file for a bbolt database file, a postgres:// or
+// postgresql:// connection string of lib/pq for a PostgreSQL database, or a redis:// or rediss:// URL
+// for a Redis database.
+//
+// verify scans all streams of all tenants, recomputes their hash chains and reports
+// the streams which are corrupted or tampered. Exit status is 1 if any stream fails.
+//
+// export writes all streams to a JSON Lines archive and import replays an archive to the store,
+// see package backup for the format. Standard output and input are used when file is not given.
+//
+// migrate copies all streams from one store to another and keeps copying new events until it
+// receives interrupt or terminate signal. On the signal it copies the remaining events, verifies
+// that the stores have equal streams and exits. Exit status is 1 if any stream differs
+//
+// compact copies a bbolt database file to a new file without the space and feed entries left by
+// purged streams. The store must not be in use, and the new file replaces it only when moved in place
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/juelko/bucket/store/audit"
+	"github.com/juelko/bucket/store/backup"
+	"github.com/juelko/bucket/store/bolt"
+	"github.com/juelko/bucket/store/migrate"
+)
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "usage: bucketctl <command> [flags]")
+		fmt.Fprintln(stderr, "commands: verify, export, import, migrate, compact")
+		return 2
+	}
+
+	switch args[0] {
+	case "verify":
+		return verify(ctx, args[1:], stdout, stderr)
+	case "export":
+		return export(ctx, args[1:], stdout, stderr)
+	case "import":
+		return restore(ctx, args[1:], stdin, stdout, stderr)
+	case "migrate":
+		return migration(ctx, args[1:], stdout, stderr)
+	case "compact":
+		return compact(args[1:], stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n", args[0])
+		return 2
+	}
+}
+
+func verify(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	cfg := storeFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	s, err := cfg.open(ctx)
+	if err != nil {
+		report(stderr, err)
+		return 1
+	}
+	defer closeStore(s)
+
+	findings, err := audit.Scan(ctx, s)
+	if err != nil {
+		report(stderr, err)
+		return 1
+	}
+
+	for _, f := range findings {
+		fmt.Fprintf(stdout, "%s/%s: ", f.Stream.Tenant, f.Stream.ID)
+		report(stdout, f.Err)
+	}
+
+	if len(findings) > 0 {
+		fmt.Fprintf(stderr, "%d corrupted streams\n", len(findings))
+		return 1
+	}
+
+	return 0
+}
+
+func export(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	cfg := storeFlags(fs)
+	out := fs.String("out", "", "archive file to write, standard output if empty")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	s, err := cfg.open(ctx)
+	if err != nil {
+		report(stderr, err)
+		return 1
+	}
+	defer closeStore(s)
+
+	w := stdout
+	if *out != "" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			report(stderr, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	sum, err := backup.Export(ctx, s, w)
+	if err != nil {
+		report(stderr, err)
+		return 1
+	}
+
+	fmt.Fprintf(stderr, "exported %d streams with %d events\n", sum.Streams, sum.Events)
+
+	return 0
+}
+
+// restore runs the import command, import being a keyword
+func restore(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	cfg := storeFlags(fs)
+	in := fs.String("in", "", "archive file to read, standard input if empty")
+	dryRun := fs.Bool("dry-run", false, "check the archive against the store without writing")
+	conflict := fs.String("conflict", backup.ConflictAbort.String(), "handling of existing streams: abort, skip or resume")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	c, err := backup.ParseConflict(*conflict)
+	if err != nil {
+		report(stderr, err)
+		return 2
+	}
+
+	s, err := cfg.open(ctx)
+	if err != nil {
+		report(stderr, err)
+		return 1
+	}
+	defer closeStore(s)
+
+	r := stdin
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			report(stderr, err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+
+	sum, err := backup.Import(ctx, s, r, backup.ImportOptions{DryRun: *dryRun, Conflict: c})
+
+	fmt.Fprintf(stdout, "streams: %d, events: %d, imported: %d, skipped: %d, tombstones: %d\n",
+		sum.Streams, sum.Events, sum.Imported, sum.Skipped, sum.Tombstones)
+
+	if err != nil {
+		report(stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// migration runs the migrate command
+func migration(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	from := namedStoreFlags(fs, "from", "from-keys")
+	to := namedStoreFlags(fs, "to", "to-keys")
+	position := fs.Uint64("position", 0, "feed position to continue from, printed by earlier run")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	src, err := from.open(ctx)
+	if err != nil {
+		report(stderr, err)
+		return 1
+	}
+	defer closeStore(src)
+
+	dst, err := to.open(ctx)
+	if err != nil {
+		report(stderr, err)
+		return 1
+	}
+	defer closeStore(dst)
+
+	m, err := migrate.New(src, dst, migrate.Options{From: *position})
+	if err != nil {
+		report(stderr, err)
+		return 1
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	go func() {
+		<-signals
+		fmt.Fprintln(stderr, "cutting over")
+		m.CutOver()
+	}()
+
+	r, err := m.Run(ctx)
+
+	for _, mm := range r.Mismatches {
+		fmt.Fprintf(stdout, "%s/%s: %s\n", mm.Stream.Tenant, mm.Stream.ID, mm.Reason)
+	}
+	fmt.Fprintf(stdout, "position: %d, events: %d, streams: %d, mismatches: %d\n", r.Position, r.Events, r.Streams, len(r.Mismatches))
+
+	if err != nil {
+		report(stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+func compact(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("compact", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dsn := fs.String("store", "", "bbolt store to compact, bolt:file")
+	out := fs.String("out", "", "compacted file to create")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if !strings.HasPrefix(*dsn, "bolt:") || *out == "" {
+		fmt.Fprintln(stderr, "compact needs -store bolt:file and -out file")
+		return 2
+	}
+
+	r, err := bolt.Compact(strings.TrimPrefix(*dsn, "bolt:"), *out)
+	if err != nil {
+		report(stderr, err)
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "before: %d bytes, after: %d bytes, pruned feed entries: %d\n", r.Before, r.After, r.Pruned)
+
+	return 0
+}
+
+// report writes err and the errors it wraps on one line
+func report(w io.Writer, err error) {
+	sep := ""
+
+	for ; err != nil; err = errors.Unwrap(err) {
+		fmt.Fprint(w, sep, err)
+		sep = ": "
+	}
+
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	goredis "github.com/go-redis/redis/v8"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/store/bolt"
+	"github.com/juelko/bucket/store/codec"
+	"github.com/juelko/bucket/store/inmem"
+	"github.com/juelko/bucket/store/postgres"
+	"github.com/juelko/bucket/store/redis"
+)
+
+// storeConfig has the flags selecting the store to operate on
+type storeConfig struct {
+	dsn  string
+	keys string
+}
+
+func storeFlags(fs *flag.FlagSet) *storeConfig {
+	return namedStoreFlags(fs, "store", "keys")
+}
+
+// namedStoreFlags defines the store flags with given names, for commands using more than one store
+func namedStoreFlags(fs *flag.FlagSet, store, keys string) *storeConfig {
+	cfg := &storeConfig{}
+
+	fs.StringVar(&cfg.dsn, store, "mem", "store to use, mem for empty in-memory store, bolt:file for bbolt database file, "+
+		"postgres://... for PostgreSQL or redis://... for Redis database")
+	fs.StringVar(&cfg.keys, keys, "", "key file for stores encrypted at rest, see codec.LoadKeyFile")
+
+	return cfg
+}
+
+// codec returns the codec for the stored records
+func (cfg *storeConfig) codec() (codec.Codec, error) {
+	if cfg.keys == "" {
+		return codec.JSON(), nil
+	}
+
+	keys, err := codec.LoadKeyFile(cfg.keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return codec.NewEncrypted(codec.JSON(), keys), nil
+}
+
+func (cfg *storeConfig) open(ctx context.Context) (bucket.Store, error) {
+	c, err := cfg.codec()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case cfg.dsn == "mem":
+		return inmem.NewBucketStore(inmem.Encoding(c)), nil
+	case strings.HasPrefix(cfg.dsn, "bolt:"):
+		s, err := bolt.Open(strings.TrimPrefix(cfg.dsn, "bolt:"), bolt.Encoding(c))
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case strings.HasPrefix(cfg.dsn, "postgres://"), strings.HasPrefix(cfg.dsn, "postgresql://"):
+		s, err := postgres.Open(ctx, cfg.dsn, postgres.Encoding(c))
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case strings.HasPrefix(cfg.dsn, "redis://"), strings.HasPrefix(cfg.dsn, "rediss://"):
+		opts, err := goredis.ParseURL(cfg.dsn)
+		if err != nil {
+			return nil, err
+		}
+		client := goredis.NewClient(opts)
+		if err := client.Ping(ctx).Err(); err != nil {
+			client.Close()
+			return nil, err
+		}
+		return &redisStore{Store: redis.New(client, redis.Encoding(c)), client: client}, nil
+	default:
+		return nil, fmt.Errorf("unsupported store %q", cfg.dsn)
+	}
+}
+
+// redisStore closes the client it is given, which the Redis store leaves for the caller
+type redisStore struct {
+	*redis.Store
+	client *goredis.Client
+}
+
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
+
+// closeStore closes the store if it keeps files or connections open
+func closeStore(s bucket.Store) {
+	if c, ok := s.(io.Closer); ok {
+		c.Close()
+	}
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+
+	"github.com/juelko/bucket/pkg/errors"
+)
+
+// NewAPIKeys returns Authenticator for static API keys. Keys maps API key to its identity
+func NewAPIKeys(keys map[string]Identity) Authenticator {
+	ret := apiKeys{}
+
+	for k, id := range keys {
+		ret[sha256.Sum256([]byte(k))] = id
+	}
+
+	return ret
+}
+
+// apiKeys are stored as digests, so lookup time does not reveal the keys
+type apiKeys map[[sha256.Size]byte]Identity
+
+func (a apiKeys) Authenticate(ctx context.Context, token string) (Identity, error) {
+	const op errors.Op = "auth.apiKeys.Authenticate"
+
+	id, ok := a[sha256.Sum256([]byte(token))]
+	if !ok {
+		return Identity{}, errors.New(op, errors.KindUnauthenticated, "Unknown API key")
+	}
+
+	return id, nil
+}
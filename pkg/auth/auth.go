@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/principal"
+	"github.com/juelko/bucket/pkg/request"
+	"github.com/juelko/bucket/pkg/tenant"
+)
+
+// Identity is the authenticated principal and the tenant it acts in
+type Identity struct {
+	Principal principal.ID
+	Tenant    tenant.ID
+}
+
+// Authenticator resolves the identity from bearer token
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (Identity, error)
+}
+
+// Chain returns Authenticator which tries given authenticators in order and
+// returns the first identity found
+func Chain(auths ...Authenticator) Authenticator {
+	return chain(auths)
+}
+
+type chain []Authenticator
+
+func (c chain) Authenticate(ctx context.Context, token string) (Identity, error) {
+	const op errors.Op = "auth.chain.Authenticate"
+
+	var last error
+
+	for _, a := range c {
+		id, err := a.Authenticate(ctx, token)
+		if err == nil {
+			return id, nil
+		}
+		last = err
+	}
+
+	return Identity{}, errors.New(op, errors.KindUnauthenticated, "Invalid credentials", last)
+}
+
+// SingleTenant returns Authenticator placing all identities authenticated by a in tenant.Default.
+// Deployments without tenants use it, otherwise identities without tenant are rejected
+func SingleTenant(a Authenticator) Authenticator {
+	return singleTenant{a}
+}
+
+type singleTenant struct {
+	next Authenticator
+}
+
+func (s singleTenant) Authenticate(ctx context.Context, token string) (Identity, error) {
+	id, err := s.next.Authenticate(ctx, token)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	id.Tenant = tenant.Default
+
+	return id, nil
+}
+
+// NewContext authenticates the bearer token in the value of Authorization header and returns
+// context with the principal, tenant and request.ID. New request.ID is created if rid is not valid.
+// Transports call NewContext before passing the request to the bucket.Service
+func NewContext(ctx context.Context, a Authenticator, authorization string, rid request.ID) (context.Context, error) {
+	const op errors.Op = "auth.NewContext"
+
+	token, err := bearer(authorization)
+	if err != nil {
+		return ctx, err
+	}
+
+	id, err := a.Authenticate(ctx, token)
+	if err != nil {
+		return ctx, errors.New(op, errors.KindUnauthenticated, "Authentication failed", err)
+	}
+
+	if err := id.Principal.Validate(); err != nil {
+		return ctx, errors.New(op, errors.KindUnauthenticated, "Authentication failed", err)
+	}
+
+	if err := id.Tenant.Validate(); err != nil {
+		return ctx, errors.New(op, errors.KindUnauthenticated, "Authentication failed", err)
+	}
+
+	if rid.Validate() != nil {
+		rid = request.New()
+	}
+
+	ctx = request.NewContext(ctx, rid)
+	ctx = tenant.NewContext(ctx, id.Tenant)
+
+	return principal.NewContext(ctx, id.Principal), nil
+}
+
+// bearer returns token from the value of Authorization header
+func bearer(authorization string) (string, error) {
+	const op errors.Op = "auth.bearer"
+
+	const prefix = "bearer "
+
+	if len(authorization) <= len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return "", errors.New(op, errors.KindUnauthenticated, "Bearer token missing")
+	}
+
+	return strings.TrimSpace(authorization[len(prefix):]), nil
+}
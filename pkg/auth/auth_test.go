@@ -0,0 +1,301 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/principal"
+	"github.com/juelko/bucket/pkg/request"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWT(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+
+	now := time.Unix(1600000000, 0)
+
+	a := &jwtAuth{
+		cfg: JWTConfig{
+			HMACKeys: map[string][]byte{"": []byte("TestSecret"), "other": []byte("OtherSecret")},
+			RSAKeys:  map[string]*rsa.PublicKey{"rsa": &rsaKey.PublicKey},
+			Issuer:   "TestIssuer",
+			Audience: "bucket",
+		},
+		now: func() time.Time { return now },
+	}
+
+	claims := map[string]interface{}{"sub": "TestUser", "tenant": "TestTenant", "iss": "TestIssuer", "aud": []string{"bucket"}, "exp": now.Unix() + 60}
+
+	with := func(k string, v interface{}) map[string]interface{} {
+		ret := map[string]interface{}{}
+		for key, val := range claims {
+			ret[key] = val
+		}
+		ret[k] = v
+		return ret
+	}
+
+	testCases := []struct {
+		desc  string
+		token string
+		want  principal.ID
+		err   string
+	}{
+		{
+			desc:  "hmac",
+			token: hmacToken(t, map[string]interface{}{"alg": "HS256"}, claims, []byte("TestSecret")),
+			want:  "TestUser",
+		},
+		{
+			desc:  "hmac with kid",
+			token: hmacToken(t, map[string]interface{}{"alg": "HS512", "kid": "other"}, claims, []byte("OtherSecret")),
+			want:  "TestUser",
+		},
+		{
+			desc:  "rsa",
+			token: rsaToken(t, map[string]interface{}{"alg": "RS256", "kid": "rsa"}, claims, rsaKey),
+			want:  "TestUser",
+		},
+		{
+			desc:  "wrong secret",
+			token: hmacToken(t, map[string]interface{}{"alg": "HS256"}, claims, []byte("WrongSecret")),
+			err:   "Invalid signature",
+		},
+		{
+			desc:  "unknown kid",
+			token: hmacToken(t, map[string]interface{}{"alg": "HS256", "kid": "unknown"}, claims, []byte("TestSecret")),
+			err:   "Unknown key",
+		},
+		{
+			desc:  "rsa key used as hmac",
+			token: hmacToken(t, map[string]interface{}{"alg": "HS256", "kid": "rsa"}, claims, x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)),
+			err:   "Unknown key",
+		},
+		{
+			desc:  "alg none",
+			token: segment(t, map[string]interface{}{"alg": "none"}) + "." + segment(t, claims) + ".",
+			err:   "Unsupported algorithm: none",
+		},
+		{
+			desc:  "expired",
+			token: hmacToken(t, map[string]interface{}{"alg": "HS256"}, with("exp", now.Unix()), []byte("TestSecret")),
+			err:   "Token expired",
+		},
+		{
+			desc:  "not yet valid",
+			token: hmacToken(t, map[string]interface{}{"alg": "HS256"}, with("nbf", now.Unix()+10), []byte("TestSecret")),
+			err:   "Token not yet valid",
+		},
+		{
+			desc:  "wrong issuer",
+			token: hmacToken(t, map[string]interface{}{"alg": "HS256"}, with("iss", "Other"), []byte("TestSecret")),
+			err:   "Invalid issuer",
+		},
+		{
+			desc:  "single audience",
+			token: hmacToken(t, map[string]interface{}{"alg": "HS256"}, with("aud", "bucket"), []byte("TestSecret")),
+			want:  "TestUser",
+		},
+		{
+			desc:  "wrong audience",
+			token: hmacToken(t, map[string]interface{}{"alg": "HS256"}, with("aud", "other"), []byte("TestSecret")),
+			err:   "Invalid audience",
+		},
+		{
+			desc:  "malformed",
+			token: "not-a-jwt",
+			err:   "Malformed token",
+		},
+	}
+	for i := range testCases {
+		tC := testCases[i]
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := a.Authenticate(context.Background(), tC.token)
+
+			if tC.err == "" {
+				require.Nil(t, err)
+				assert.Equal(t, Identity{Principal: tC.want, Tenant: "TestTenant"}, got)
+			} else {
+				require.NotNil(t, err)
+				e, ok := err.(*errors.Error)
+				require.True(t, ok)
+				assert.Equal(t, errors.KindUnauthenticated, e.Kind)
+				assert.Equal(t, tC.err, e.Msg)
+			}
+		})
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	t.Parallel()
+
+	a := NewAPIKeys(map[string]Identity{"TestKey": {Principal: "TestService", Tenant: "TestTenant"}})
+
+	got, err := a.Authenticate(context.Background(), "TestKey")
+	require.Nil(t, err)
+	assert.Equal(t, Identity{Principal: "TestService", Tenant: "TestTenant"}, got)
+
+	_, err = a.Authenticate(context.Background(), "WrongKey")
+	assert.Equal(t, &errors.Error{Op: "auth.apiKeys.Authenticate", Kind: errors.KindUnauthenticated, Msg: "Unknown API key"}, err)
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	a := Chain(
+		NewJWT(JWTConfig{HMACKeys: map[string][]byte{"": []byte("TestSecret")}}),
+		NewAPIKeys(map[string]Identity{"TestKey": {Principal: "TestService", Tenant: "TestTenant"}}),
+	)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := principal.FromContext(r.Context())
+		tid, _ := tenant.FromContext(r.Context())
+		rid, _ := request.FromContext(r.Context())
+		w.Header().Set(RequestIDHeader, string(rid))
+		w.Write([]byte(string(tid) + "/" + string(p)))
+	})
+
+	tenantless := hmacToken(t, map[string]interface{}{"alg": "HS256"}, map[string]interface{}{"sub": "TestUser"}, []byte("TestSecret"))
+
+	testCases := []struct {
+		desc   string
+		auth   Authenticator
+		header http.Header
+		code   int
+		want   string
+	}{
+		{
+			desc:   "jwt",
+			auth:   a,
+			header: http.Header{"Authorization": {"Bearer " + hmacToken(t, map[string]interface{}{"alg": "HS256"}, map[string]interface{}{"sub": "TestUser", "tenant": "TestTenant"}, []byte("TestSecret"))}},
+			code:   http.StatusOK,
+			want:   "TestTenant/TestUser",
+		},
+		{
+			desc:   "api key",
+			auth:   a,
+			header: http.Header{"Authorization": {"bearer TestKey"}, RequestIDHeader: {"10c0d59e-ca70-46d8-87fb-738be0c9b035"}},
+			code:   http.StatusOK,
+			want:   "TestTenant/TestService",
+		},
+		{
+			desc:   "tenant missing",
+			auth:   a,
+			header: http.Header{"Authorization": {"Bearer " + tenantless}},
+			code:   http.StatusUnauthorized,
+		},
+		{
+			desc:   "single tenant",
+			auth:   SingleTenant(a),
+			header: http.Header{"Authorization": {"Bearer " + tenantless}},
+			code:   http.StatusOK,
+			want:   "default/TestUser",
+		},
+		{
+			desc:   "wrong key",
+			auth:   a,
+			header: http.Header{"Authorization": {"Bearer WrongKey"}},
+			code:   http.StatusUnauthorized,
+		},
+		{
+			desc:   "missing",
+			auth:   a,
+			header: http.Header{},
+			code:   http.StatusUnauthorized,
+		},
+		{
+			desc:   "basic auth",
+			auth:   a,
+			header: http.Header{"Authorization": {"Basic VGVzdDpUZXN0"}},
+			code:   http.StatusUnauthorized,
+		},
+	}
+	for i := range testCases {
+		tC := testCases[i]
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header = tC.header
+			w := httptest.NewRecorder()
+
+			Middleware(tC.auth, next).ServeHTTP(w, r)
+
+			require.Equal(t, tC.code, w.Code)
+
+			if tC.code == http.StatusOK {
+				assert.Equal(t, tC.want, w.Body.String())
+				rid := request.ID(w.Header().Get(RequestIDHeader))
+				assert.Nil(t, rid.Validate())
+				if h := tC.header.Get(RequestIDHeader); h != "" {
+					assert.Equal(t, request.ID(h), rid)
+				}
+			} else {
+				assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestParseRSAPublicKey(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.Nil(t, err)
+
+	got, err := ParseRSAPublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	require.Nil(t, err)
+	assert.True(t, key.PublicKey.Equal(got))
+
+	_, err = ParseRSAPublicKey([]byte("not a key"))
+	assert.NotNil(t, err)
+}
+
+// Test helpers
+func segment(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	require.Nil(t, err)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hmacToken(t *testing.T, header, claims map[string]interface{}, secret []byte) string {
+	signed := segment(t, header) + "." + segment(t, claims)
+
+	mac := hmac.New(hashes[header["alg"].(string)].New, secret)
+	mac.Write([]byte(signed))
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func rsaToken(t *testing.T, header, claims map[string]interface{}, key *rsa.PrivateKey) string {
+	signed := segment(t, header) + "." + segment(t, claims)
+
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	require.Nil(t, err)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/juelko/bucket/pkg/request"
+)
+
+// RequestIDHeader is read for the request.ID of incoming requests
+const RequestIDHeader = "X-Request-ID"
+
+// Middleware authenticates HTTP requests before passing them to next handler.
+// Unauthenticated requests are rejected with status 401
+func Middleware(a Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		ctx, err := NewContext(r.Context(), a, r.Header.Get("Authorization"), request.ID(r.Header.Get(RequestIDHeader)))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="bucket"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
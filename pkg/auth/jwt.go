@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers SHA256 for crypto.Hash
+	_ "crypto/sha512" // registers SHA384 and SHA512 for crypto.Hash
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"time"
+
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/principal"
+	"github.com/juelko/bucket/pkg/tenant"
+)
+
+// JWTConfig has locally configured keys and rules for validating JSON Web Tokens.
+// Keys are looked up with the "kid" header of the token, empty key id matches tokens without "kid"
+type JWTConfig struct {
+	HMACKeys map[string][]byte         // shared secrets for HS256, HS384 and HS512
+	RSAKeys  map[string]*rsa.PublicKey // public keys for RS256, RS384 and RS512
+	Issuer   string                    // required "iss" claim, empty accepts any
+	Audience string                    // required "aud" claim, empty accepts any
+	Leeway   time.Duration             // allowed clock skew for "exp" and "nbf"
+}
+
+// NewJWT returns Authenticator for signed JSON Web Tokens. Principal is the "sub" claim and tenant the "tenant" claim
+func NewJWT(cfg JWTConfig) Authenticator {
+	return &jwtAuth{cfg: cfg, now: time.Now}
+}
+
+type jwtAuth struct {
+	cfg JWTConfig
+	now func() time.Time
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Sub    string      `json:"sub"`
+	Tenant string      `json:"tenant"`
+	Iss    string      `json:"iss"`
+	Aud    jwtAudience `json:"aud"`
+	Exp    *int64      `json:"exp"`
+	Nbf    *int64      `json:"nbf"`
+}
+
+// jwtAudience is either single string or array of strings
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = jwtAudience{single}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+
+	return nil
+}
+
+func (j *jwtAuth) Authenticate(ctx context.Context, token string) (Identity, error) {
+	const op errors.Op = "auth.jwtAuth.Authenticate"
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Identity{}, errors.New(op, errors.KindUnauthenticated, "Malformed token")
+	}
+
+	var h jwtHeader
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return Identity{}, errors.New(op, errors.KindUnauthenticated, "Malformed token header", err)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Identity{}, errors.New(op, errors.KindUnauthenticated, "Malformed token signature", err)
+	}
+
+	if err := j.verify(h, parts[0]+"."+parts[1], sig); err != nil {
+		return Identity{}, err
+	}
+
+	var c jwtClaims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return Identity{}, errors.New(op, errors.KindUnauthenticated, "Malformed token claims", err)
+	}
+
+	if err := j.validate(&c); err != nil {
+		return Identity{}, err
+	}
+
+	return Identity{Principal: principal.ID(c.Sub), Tenant: tenant.ID(c.Tenant)}, nil
+}
+
+// verify checks the signature with the key of the algorithm family given in header,
+// so token can not switch e.g. RSA public key to be used as HMAC secret
+func (j *jwtAuth) verify(h jwtHeader, signed string, sig []byte) error {
+	const op errors.Op = "auth.jwtAuth.verify"
+
+	switch h.Alg {
+	case "HS256", "HS384", "HS512":
+		key, ok := j.cfg.HMACKeys[h.Kid]
+		if !ok {
+			return errors.New(op, errors.KindUnauthenticated, "Unknown key")
+		}
+
+		mac := hmac.New(hashes[h.Alg].New, key)
+		mac.Write([]byte(signed))
+
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return errors.New(op, errors.KindUnauthenticated, "Invalid signature")
+		}
+
+	case "RS256", "RS384", "RS512":
+		key, ok := j.cfg.RSAKeys[h.Kid]
+		if !ok {
+			return errors.New(op, errors.KindUnauthenticated, "Unknown key")
+		}
+
+		hash := hashes[h.Alg]
+		digest := hash.New()
+		digest.Write([]byte(signed))
+
+		if err := rsa.VerifyPKCS1v15(key, hash, digest.Sum(nil), sig); err != nil {
+			return errors.New(op, errors.KindUnauthenticated, "Invalid signature", err)
+		}
+
+	default:
+		return errors.New(op, errors.KindUnauthenticated, "Unsupported algorithm: "+h.Alg)
+	}
+
+	return nil
+}
+
+var hashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256,
+	"HS384": crypto.SHA384,
+	"HS512": crypto.SHA512,
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+func (j *jwtAuth) validate(c *jwtClaims) error {
+	const op errors.Op = "auth.jwtAuth.validate"
+
+	now := j.now()
+
+	if c.Sub == "" {
+		return errors.New(op, errors.KindUnauthenticated, "Subject missing")
+	}
+
+	if c.Exp != nil && !now.Before(time.Unix(*c.Exp, 0).Add(j.cfg.Leeway)) {
+		return errors.New(op, errors.KindUnauthenticated, "Token expired")
+	}
+
+	if c.Nbf != nil && now.Add(j.cfg.Leeway).Before(time.Unix(*c.Nbf, 0)) {
+		return errors.New(op, errors.KindUnauthenticated, "Token not yet valid")
+	}
+
+	if j.cfg.Issuer != "" && c.Iss != j.cfg.Issuer {
+		return errors.New(op, errors.KindUnauthenticated, "Invalid issuer")
+	}
+
+	if j.cfg.Audience != "" && !c.Aud.contains(j.cfg.Audience) {
+		return errors.New(op, errors.KindUnauthenticated, "Invalid audience")
+	}
+
+	return nil
+}
+
+func (a jwtAudience) contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// ParseRSAPublicKey parses PEM encoded PKIX or PKCS1 RSA public key, e.g. from a local key file
+func ParseRSAPublicKey(b []byte) (*rsa.PublicKey, error) {
+	const op errors.Op = "auth.ParseRSAPublicKey"
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New(op, errors.KindValidation, "No PEM data found")
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New(op, errors.KindValidation, "Invalid public key", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New(op, errors.KindValidation, "Not a RSA public key")
+	}
+
+	return rsaKey, nil
+}
@@ -7,10 +7,10 @@ import (
 
 type Kind int
 
-var kindStrings = []string{"Unknown", "Unexpected", "Expected", "Validation", "Not Found"}
+var kindStrings = []string{"Unknown", "Unexpected", "Expected", "Validation", "Not Found", "Allready Exists", "Forbidden", "Unauthenticated"}
 
 func (k Kind) String() string {
-	if k < 1 || k > 4 {
+	if k < 1 || int(k) >= len(kindStrings) {
 		return kindStrings[0]
 	}
 	return kindStrings[k]
@@ -22,6 +22,8 @@ const (
 	KindValidation
 	KindNotFound
 	KindAllreadyExists
+	KindForbidden
+	KindUnauthenticated
 )
 
 type Op string
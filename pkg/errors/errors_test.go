@@ -240,6 +240,21 @@ func TestKindString(t *testing.T) {
 			args: KindNotFound,
 			want: "Not Found",
 		},
+		{
+			desc: "Allready Exists",
+			args: KindAllreadyExists,
+			want: "Allready Exists",
+		},
+		{
+			desc: "Forbidden",
+			args: KindForbidden,
+			want: "Forbidden",
+		},
+		{
+			desc: "Unauthenticated",
+			args: KindUnauthenticated,
+			want: "Unauthenticated",
+		},
 		{
 			desc: "Zero value",
 			args: 0,
@@ -0,0 +1,94 @@
+package events
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/juelko/bucket/pkg/errors"
+)
+
+// Hash returns the hash chaining the event stored at given time to the previous event of the stream.
+// The first event of the stream is chained to empty prev. Hash covers type, id, version, time and data of the event,
+// so changing any of them or removing, adding or reordering events breaks the chain
+func Hash(prev string, at time.Time, e Event) (string, error) {
+	const op errors.Op = "events.Hash"
+
+	data, err := json.Marshal(e.Data())
+	if err != nil {
+		return "", errors.New(op, errors.KindUnexpected, "Could not encode event data", err)
+	}
+
+	h := sha256.New()
+
+	// fields are length prefixed, so their boundaries can not be moved
+	for _, f := range [][]byte{
+		[]byte(prev),
+		[]byte(e.Type()),
+		[]byte(e.EntityID()),
+		[]byte(fmt.Sprint(e.EntityVersion())),
+		[]byte(at.UTC().Format(time.RFC3339Nano)),
+		data,
+	} {
+		var n [8]byte
+		binary.BigEndian.PutUint64(n[:], uint64(len(f)))
+		h.Write(n[:])
+		h.Write(f)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Verify recomputes the hash chain of the stream. Returned error tells the version of the first event breaking the chain
+func Verify(stream []Event) error {
+	const op errors.Op = "events.Verify"
+
+	if len(stream) == 0 {
+		return errors.New(op, errors.KindUnexpected, "Empty stream")
+	}
+
+	prev := ""
+
+	for i, e := range stream {
+		if i > 0 && (e.EntityID() != stream[0].EntityID() || e.EntityVersion() != stream[i-1].EntityVersion()+1) {
+			return errors.New(op, errors.KindUnexpected, fmt.Sprintf("Stream is not continuous at version %d", e.EntityVersion()))
+		}
+
+		h, err := Hash(prev, e.Timestamp(), e)
+		if err != nil {
+			return errors.New(op, errors.KindUnexpected, fmt.Sprintf("Could not hash version %d", e.EntityVersion()), err)
+		}
+
+		if h != e.Hash() {
+			return errors.New(op, errors.KindUnexpected, fmt.Sprintf("Hash chain broken at version %d", e.EntityVersion()))
+		}
+
+		prev = h
+	}
+
+	return nil
+}
+
+// Equal tells if the events have the same type, id, version and data. Store times and hashes are not compared,
+// so events copied between stores are equal to their originals
+func Equal(a, b Event) bool {
+	if a.Type() != b.Type() || a.EntityID() != b.EntityID() || a.EntityVersion() != b.EntityVersion() {
+		return false
+	}
+
+	da, err := json.Marshal(a.Data())
+	if err != nil {
+		return false
+	}
+
+	db, err := json.Marshal(b.Data())
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(da, db)
+}
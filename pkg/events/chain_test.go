@@ -0,0 +1,137 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testEvent struct {
+	Base
+	Value string
+}
+
+func (e *testEvent) Type() string {
+	return "test.Event"
+}
+
+func (e *testEvent) Data() interface{} {
+	return e.Value
+}
+
+func testChain(t *testing.T, values ...string) []Event {
+	at := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+	prev := ""
+
+	ret := []Event{}
+	for i, v := range values {
+		e := &testEvent{Base: Base{ID: "TestStream", V: EntityVersion(i + 1), At: at.Add(time.Duration(i) * time.Second)}, Value: v}
+
+		h, err := Hash(prev, e.At, e)
+		require.Nil(t, err)
+
+		e.Sum = h
+		prev = h
+		ret = append(ret, e)
+	}
+
+	return ret
+}
+
+func TestHash(t *testing.T) {
+	t.Parallel()
+
+	e := &testEvent{Base: Base{ID: "TestStream", V: 1}, Value: "first"}
+	at := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+
+	h1, err := Hash("", at, e)
+	require.Nil(t, err)
+
+	h2, err := Hash("", at.In(time.FixedZone("X", 3600)), e)
+	require.Nil(t, err)
+	require.Equal(t, h1, h2, "hash should not depend on time zone")
+
+	h3, err := Hash(h1, at, e)
+	require.Nil(t, err)
+	require.NotEqual(t, h1, h3, "hash should depend on previous hash")
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		desc   string
+		stream func() []Event
+		err    string
+	}{
+		{
+			desc:   "happy",
+			stream: func() []Event { return testChain(t, "first", "second", "third") },
+		},
+		{
+			desc:   "empty",
+			stream: func() []Event { return []Event{} },
+			err:    "Empty stream",
+		},
+		{
+			desc: "data changed",
+			stream: func() []Event {
+				s := testChain(t, "first", "second", "third")
+				s[1].(*testEvent).Value = "changed"
+				return s
+			},
+			err: "Hash chain broken at version 2",
+		},
+		{
+			desc: "time changed",
+			stream: func() []Event {
+				s := testChain(t, "first", "second", "third")
+				s[2].(*testEvent).At = time.Time{}
+				return s
+			},
+			err: "Hash chain broken at version 3",
+		},
+		{
+			desc: "first removed",
+			stream: func() []Event {
+				return testChain(t, "first", "second", "third")[1:]
+			},
+			err: "Hash chain broken at version 2",
+		},
+		{
+			desc: "middle removed",
+			stream: func() []Event {
+				s := testChain(t, "first", "second", "third")
+				return []Event{s[0], s[2]}
+			},
+			err: "Stream is not continuous at version 3",
+		},
+		{
+			desc: "replaced with rehashed",
+			stream: func() []Event {
+				s := testChain(t, "first", "second", "third")
+				return append(testChain(t, "first", "changed"), s[2])
+			},
+			err: "Hash chain broken at version 3",
+		},
+	}
+
+	for i := range testCases {
+		tC := testCases[i]
+
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			err := Verify(tC.stream())
+
+			if tC.err == "" {
+				require.Nil(t, err)
+				return
+			}
+
+			require.NotNil(t, err)
+			require.Contains(t, err.Error(), tC.err)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package events
 
 import (
 	"regexp"
+	"time"
 
 	"github.com/juelko/bucket/pkg/errors"
 	"github.com/juelko/bucket/pkg/validator"
@@ -10,6 +11,8 @@ import (
 type Event interface {
 	EntityID() EntityID
 	EntityVersion() EntityVersion
+	Timestamp() time.Time
+	Hash() string
 	Type() string
 	Data() interface{}
 }
@@ -31,8 +34,10 @@ func NewBase(id EntityID, v EntityVersion) (Base, error) {
 // Base has common fields and methods to all domain events.
 // When Base is embedded, Domain Event needs Type() and Data() methods to satisfy Event interface
 type Base struct {
-	ID EntityID
-	V  EntityVersion
+	ID  EntityID
+	V   EntityVersion
+	At  time.Time // time when event was stored, set by the store
+	Sum string    // hash chaining the event to the previous event of the stream, set by the store
 }
 
 func (be Base) EntityID() EntityID {
@@ -43,6 +48,14 @@ func (be Base) EntityVersion() EntityVersion {
 	return be.V
 }
 
+func (be Base) Timestamp() time.Time {
+	return be.At
+}
+
+func (be Base) Hash() string {
+	return be.Sum
+}
+
 // EntityID is identifies for stream of domain events. Use domain entity's identifier as EntityID
 type EntityID string
 
@@ -0,0 +1,23 @@
+package events
+
+import "testing"
+
+// FuzzEntityID checks the validation of ids against the rule it implements: 3 to 64 ASCII letters and digits
+func FuzzEntityID(f *testing.F) {
+	for _, seed := range []string{"TestStream", "ab", "abc", "a-b-c", "Åbc", "abc\n", string(make([]byte, 65))} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, id string) {
+		want := len(id) >= 3 && len(id) <= 64
+		for _, c := range []byte(id) {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+				want = false
+			}
+		}
+
+		if got := EntityID(id).Validate() == nil; got != want {
+			t.Fatalf("EntityID(%q) valid %v, want %v", id, got, want)
+		}
+	})
+}
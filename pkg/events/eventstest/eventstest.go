@@ -0,0 +1,206 @@
+// Package eventstest is a Given-When-Then DSL for testing domain logic built on pkg/events.
+// Test declares the prior events of the stream, the command run on them and the events or
+// the kind of error it should result in:
+//
+//	eventstest.Given(t, opened, closed).
+//		When(func(stream []events.Event) (events.Event, error) {
+//			return bucket.Reopen(&bucket.ReopenRequest{ID: "TestBucket"}, stream)
+//		}).
+//		Then(&bucket.Reopened{Base: events.Base{ID: "TestBucket", V: 3}})
+//
+// Events are compared with events.Equal, and differences are reported as a diff of the events
+// rendered with their type, id, version and data
+package eventstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+)
+
+// Scenario is a test of a command run on prior events
+type Scenario struct {
+	t     testing.TB
+	given []events.Event
+	when  func([]events.Event) ([]events.Event, error)
+}
+
+// Given starts scenario with the prior events of the stream
+func Given(t testing.TB, given ...events.Event) *Scenario {
+	return &Scenario{t: t, given: given}
+}
+
+// When sets the command emitting one event. Nil event with nil error is no events
+func (s *Scenario) When(cmd func(stream []events.Event) (events.Event, error)) *Scenario {
+	return s.WhenAll(func(stream []events.Event) ([]events.Event, error) {
+		e, err := cmd(stream)
+		if e == nil || err != nil {
+			return nil, err
+		}
+		return []events.Event{e}, nil
+	})
+}
+
+// WhenAll sets the command emitting any number of events
+func (s *Scenario) WhenAll(cmd func(stream []events.Event) ([]events.Event, error)) *Scenario {
+	s.when = cmd
+	return s
+}
+
+// run runs the command on a copy of the prior events
+func (s *Scenario) run() ([]events.Event, error) {
+	return s.when(append([]events.Event{}, s.given...))
+}
+
+// ready reports whether the scenario has a command, and fails the test if not
+func (s *Scenario) ready() bool {
+	s.t.Helper()
+
+	if s.when == nil {
+		s.t.Errorf("scenario has no command, call When before Then")
+		return false
+	}
+
+	return true
+}
+
+// Then expects the command to succeed and emit the events
+func (s *Scenario) Then(want ...events.Event) {
+	s.t.Helper()
+
+	if !s.ready() {
+		return
+	}
+
+	got, err := s.run()
+
+	if err != nil {
+		s.t.Errorf("command failed, want events:\n%s\nerror: %v", render(want, " "), err)
+		return
+	}
+
+	if !equal(want, got) {
+		s.t.Errorf("events differ (- want, + got):\n%s", diff(want, got))
+	}
+}
+
+// ThenFails expects the command to fail with error of the kind and no events
+func (s *Scenario) ThenFails(kind errors.Kind) {
+	s.t.Helper()
+
+	if !s.ready() {
+		return
+	}
+
+	got, err := s.run()
+
+	if err == nil {
+		s.t.Errorf("command succeeded, want %q error, got events:\n%s", kind, render(got, " "))
+		return
+	}
+
+	e, isError := err.(*errors.Error)
+	if !isError || e.Kind != kind {
+		s.t.Errorf("got error %v, want %q error", err, kind)
+		return
+	}
+
+	if len(got) > 0 {
+		s.t.Errorf("command failed with %q error, but emitted events:\n%s", kind, render(got, " "))
+	}
+}
+
+func equal(a, b []events.Event) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !events.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// line renders the event with its type, id, version and data
+func line(e events.Event) string {
+	if e == nil {
+		return "<nil>"
+	}
+
+	data, err := json.Marshal(e.Data())
+	if err != nil {
+		data = []byte(fmt.Sprintf("<%v>", err))
+	}
+
+	return fmt.Sprintf("%s %s v%d %s", e.Type(), e.EntityID(), e.EntityVersion(), data)
+}
+
+func render(es []events.Event, prefix string) string {
+	if len(es) == 0 {
+		return prefix + " (no events)"
+	}
+
+	var b strings.Builder
+	for i, e := range es {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(prefix + " " + line(e))
+	}
+	return b.String()
+}
+
+// diff returns the lines of the events which are removed from want or added in got, and the ones in both
+func diff(want, got []events.Event) string {
+	a := make([]string, len(want))
+	for i, e := range want {
+		a[i] = line(e)
+	}
+
+	b := make([]string, len(got))
+	for i, e := range got {
+		b[i] = line(e)
+	}
+
+	// lengths of the longest common subsequences of the suffixes
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := []string{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, "  "+a[i])
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "- "+a[i])
+			i++
+		default:
+			lines = append(lines, "+ "+b[j])
+			j++
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
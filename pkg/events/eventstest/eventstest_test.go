@@ -0,0 +1,144 @@
+package eventstest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/stretchr/testify/require"
+)
+
+// incremented is the event of a counter aggregate, which can be incremented up to 3
+type incremented struct {
+	events.Base
+	By int
+}
+
+func (e *incremented) Type() string {
+	return "test.Incremented"
+}
+
+func (e *incremented) Data() interface{} {
+	return e.By
+}
+
+func increment(by int) func([]events.Event) (events.Event, error) {
+	const op errors.Op = "test.increment"
+
+	return func(stream []events.Event) (events.Event, error) {
+		sum := 0
+		for _, e := range stream {
+			sum += e.(*incremented).By
+		}
+
+		if sum+by > 3 {
+			return nil, errors.New(op, errors.KindExpected, "Counter full")
+		}
+
+		return &incremented{Base: events.Base{ID: "TestCounter", V: events.EntityVersion(len(stream) + 1)}, By: by}, nil
+	}
+}
+
+func inc(v events.EntityVersion, by int) *incremented {
+	return &incremented{Base: events.Base{ID: "TestCounter", V: v}, By: by}
+}
+
+// recorder records the failures of the scenarios
+type recorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func TestScenario(t *testing.T) {
+	t.Parallel()
+
+	Given(t).When(increment(1)).Then(inc(1, 1))
+	Given(t, inc(1, 1), inc(2, 1)).When(increment(1)).Then(inc(3, 1))
+	Given(t, inc(1, 2)).When(increment(2)).ThenFails(errors.KindExpected)
+	Given(t, inc(1, 1)).WhenAll(func(stream []events.Event) ([]events.Event, error) {
+		return []events.Event{inc(2, 1), inc(3, 1)}, nil
+	}).Then(inc(2, 1), inc(3, 1))
+	Given(t).When(func([]events.Event) (events.Event, error) { return nil, nil }).Then()
+}
+
+func TestFailures(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		desc     string
+		scenario func(r *recorder)
+		want     string
+	}{
+		{
+			desc: "different events",
+			scenario: func(r *recorder) {
+				Given(r, inc(1, 1)).When(increment(1)).Then(inc(2, 2), inc(3, 1))
+			},
+			want: "events differ (- want, + got):\n- test.Incremented TestCounter v2 2\n- test.Incremented TestCounter v3 1\n+ test.Incremented TestCounter v2 1",
+		},
+		{
+			desc: "common events",
+			scenario: func(r *recorder) {
+				Given(r).WhenAll(func([]events.Event) ([]events.Event, error) {
+					return []events.Event{inc(1, 1), inc(2, 1)}, nil
+				}).Then(inc(1, 1), inc(2, 2))
+			},
+			want: "events differ (- want, + got):\n  test.Incremented TestCounter v1 1\n- test.Incremented TestCounter v2 2\n+ test.Incremented TestCounter v2 1",
+		},
+		{
+			desc: "unexpected error",
+			scenario: func(r *recorder) {
+				Given(r, inc(1, 3)).When(increment(1)).Then(inc(2, 1))
+			},
+			want: "command failed, want events:\n  test.Incremented TestCounter v2 1\nerror: operation: test.increment, kind: Expected, error: Counter full",
+		},
+		{
+			desc: "unexpected success",
+			scenario: func(r *recorder) {
+				Given(r).When(increment(1)).ThenFails(errors.KindExpected)
+			},
+			want: "command succeeded, want \"Expected\" error, got events:\n  test.Incremented TestCounter v1 1",
+		},
+		{
+			desc: "wrong kind",
+			scenario: func(r *recorder) {
+				Given(r, inc(1, 3)).When(increment(1)).ThenFails(errors.KindValidation)
+			},
+			want: "got error operation: test.increment, kind: Expected, error: Counter full, want \"Validation\" error",
+		},
+		{
+			desc: "no command",
+			scenario: func(r *recorder) {
+				Given(r).Then()
+			},
+			want: "scenario has no command, call When before Then",
+		},
+	}
+
+	for _, tC := range testCases {
+		r := &recorder{TB: t}
+		tC.scenario(r)
+
+		require.Equal(t, []string{tC.want}, r.failures, tC.desc)
+	}
+}
+
+func TestGivenIsNotModified(t *testing.T) {
+	t.Parallel()
+
+	given := []events.Event{inc(1, 1)}
+
+	Given(t, given...).WhenAll(func(stream []events.Event) ([]events.Event, error) {
+		stream[0] = inc(1, 2)
+		return nil, nil
+	}).Then()
+
+	require.Equal(t, inc(1, 1), given[0])
+}
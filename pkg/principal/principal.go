@@ -0,0 +1,36 @@
+package principal
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/juelko/bucket/pkg/errors"
+)
+
+// ID identifies the caller of the service, e.g. user name or service account
+type ID string
+
+var idRegexp = regexp.MustCompile(`^[\w@.:-]{1,128}$`)
+
+func (id ID) Validate() *errors.Error {
+	const op errors.Op = "principal.ID.Validate"
+
+	if !idRegexp.Match([]byte(id)) {
+		return errors.New(op, errors.KindValidation, "Invalid value for principal.ID")
+	}
+
+	return nil
+}
+
+func NewContext(ctx context.Context, id ID) context.Context {
+	return context.WithValue(ctx, idKey, id)
+}
+
+func FromContext(ctx context.Context) (ID, bool) {
+	id, ok := ctx.Value(idKey).(ID)
+	return id, ok
+}
+
+type key int
+
+var idKey key
@@ -0,0 +1,78 @@
+package principal
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrincipalIDValidation(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		desc string
+		id   ID
+		want *errors.Error
+	}{
+		{
+			desc: "ok",
+			id:   ID("test.user@example.com"),
+			want: nil,
+		},
+		{
+			desc: "empty",
+			id:   ID(""),
+			want: &errors.Error{Op: "principal.ID.Validate", Kind: errors.KindValidation, Msg: "Invalid value for principal.ID", Wraps: error(nil)},
+		},
+		{
+			desc: "illegal chars",
+			id:   ID("<script>"),
+			want: &errors.Error{Op: "principal.ID.Validate", Kind: errors.KindValidation, Msg: "Invalid value for principal.ID", Wraps: error(nil)},
+		},
+	}
+	for i := range testCases {
+		tC := testCases[i]
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got := tC.id.Validate()
+
+			assert.Equal(t, tC.want, got)
+		})
+	}
+}
+
+func TestContext(t *testing.T) {
+	t.Parallel()
+
+	ctx := NewContext(context.Background(), ID("TestUser"))
+
+	id, ok := FromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, ID("TestUser"), id)
+
+	_, ok = FromContext(context.Background())
+	assert.False(t, ok)
+}
+
+// FuzzID checks the validation of ids against the rule it implements: 1 to 128 ASCII letters, digits and _@.:-
+func FuzzID(f *testing.F) {
+	for _, seed := range []string{"test.user@example.com", "", "user name", "user\n", "ünïcode", string(make([]byte, 129))} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, id string) {
+		want := len(id) >= 1 && len(id) <= 128
+		for _, c := range []byte(id) {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("_@.:-", c) >= 0) {
+				want = false
+			}
+		}
+
+		if got := ID(id).Validate() == nil; got != want {
+			t.Fatalf("ID(%q) valid %v, want %v", id, got, want)
+		}
+	})
+}
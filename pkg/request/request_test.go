@@ -4,6 +4,7 @@ import (
 	"context"
 	"testing"
 
+	"github.com/google/uuid"
 	"github.com/juelko/bucket/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
@@ -61,3 +62,19 @@ func TestNew(t *testing.T) {
 func testReqID() ID {
 	return ID("10c0d59e-ca70-46d8-87fb-738be0c9b035")
 }
+
+// FuzzID checks that the ids passing validation are the UUIDs in their canonical 36 character form
+func FuzzID(f *testing.F) {
+	for _, seed := range []string{string(New()), "", "123e4567-e89b-12d3-a456-42661417400", "urn:uuid:123e4567-e89b-12d3-a456-426614174000", "123e4567-e89b-12d3-a456-426614174000\n"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, id string) {
+		_, err := uuid.Parse(id)
+		want := err == nil && len(id) == 36
+
+		if got := ID(id).Validate() == nil; got != want {
+			t.Fatalf("ID(%q) valid %v, want %v", id, got, want)
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package tenant
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/juelko/bucket/pkg/errors"
+)
+
+// ID identifies the tenant owning the streams. Streams of different tenants are isolated from each other
+type ID string
+
+// Default tenant owns the streams of single tenant deployments. It is never used in place of missing tenant,
+// but set to the context explicitly, e.g. by auth.SingleTenant
+const Default ID = "default"
+
+var idRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+func (id ID) Validate() *errors.Error {
+	const op errors.Op = "tenant.ID.Validate"
+
+	if !idRegexp.Match([]byte(id)) {
+		return errors.New(op, errors.KindValidation, "Invalid value for tenant.ID")
+	}
+
+	return nil
+}
+
+func NewContext(ctx context.Context, id ID) context.Context {
+	return context.WithValue(ctx, idKey, id)
+}
+
+func FromContext(ctx context.Context) (ID, bool) {
+	id, ok := ctx.Value(idKey).(ID)
+	return id, ok
+}
+
+// Of returns the tenant in context, or empty ID, which is not valid, if context has none
+func Of(ctx context.Context) ID {
+	id, _ := FromContext(ctx)
+	return id
+}
+
+type key int
+
+var idKey key
@@ -0,0 +1,80 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantIDValidation(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		desc string
+		id   ID
+		want *errors.Error
+	}{
+		{
+			desc: "ok",
+			id:   ID("test-tenant_1"),
+			want: nil,
+		},
+		{
+			desc: "default",
+			id:   Default,
+			want: nil,
+		},
+		{
+			desc: "illegal chars",
+			id:   ID("tenant/1"),
+			want: &errors.Error{Op: "tenant.ID.Validate", Kind: errors.KindValidation, Msg: "Invalid value for tenant.ID", Wraps: error(nil)},
+		},
+	}
+	for i := range testCases {
+		tC := testCases[i]
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got := tC.id.Validate()
+
+			assert.Equal(t, tC.want, got)
+		})
+	}
+}
+
+func TestContext(t *testing.T) {
+	t.Parallel()
+
+	ctx := NewContext(context.Background(), ID("TestTenant"))
+
+	id, ok := FromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, ID("TestTenant"), id)
+	assert.Equal(t, ID("TestTenant"), Of(ctx))
+
+	_, ok = FromContext(context.Background())
+	assert.False(t, ok)
+	assert.Equal(t, ID(""), Of(context.Background()), "missing tenant should not be Default")
+}
+
+// FuzzID checks the validation of ids against the rule it implements: 1 to 64 ASCII letters, digits, underscores
+// and hyphens. Stores rely on tenant ids not having other characters, like colons and null bytes
+func FuzzID(f *testing.F) {
+	for _, seed := range []string{"default", "test-tenant_1", "", "a:b", "a\x00b", "tenant\n", string(make([]byte, 65))} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, id string) {
+		want := len(id) >= 1 && len(id) <= 64
+		for _, c := range []byte(id) {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '-') {
+				want = false
+			}
+		}
+
+		if got := ID(id).Validate() == nil; got != want {
+			t.Fatalf("ID(%q) valid %v, want %v", id, got, want)
+		}
+	})
+}
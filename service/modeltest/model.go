@@ -0,0 +1,96 @@
+package modeltest
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+)
+
+// model is the expected state of the buckets
+type model map[events.EntityID]*modelBucket
+
+type modelBucket struct {
+	title   bucket.Title
+	desc    bucket.Description
+	closed  bool
+	version events.EntityVersion
+}
+
+// apply applies the command to the model, and returns the version of the event the command should emit,
+// or the kind of error it should fail with
+func (m model) apply(c Command) (events.EntityVersion, errors.Kind) {
+	b, found := m[c.ID]
+
+	if (c.Op == Open || c.Op == Update) && c.Title.Validate() != nil {
+		return 0, errors.KindValidation
+	}
+
+	if c.Op == Open {
+		if found {
+			return 0, errors.KindAllreadyExists
+		}
+		m[c.ID] = &modelBucket{title: c.Title, desc: c.Desc, version: 1}
+		return 1, 0
+	}
+
+	if !found {
+		return 0, errors.KindNotFound
+	}
+
+	switch c.Op {
+	case Update:
+		if b.closed {
+			return 0, errors.KindExpected
+		}
+		b.title, b.desc = c.Title, c.Desc
+
+	case Close:
+		if b.closed {
+			return 0, errors.KindExpected
+		}
+		b.closed = true
+
+	case Reopen:
+		if !b.closed {
+			return 0, errors.KindExpected
+		}
+		b.closed = false
+	}
+
+	b.version++
+
+	return b.version, 0
+}
+
+// compare compares the views of the service to the model
+func (m model) compare(ctx context.Context, service bucket.Service) error {
+	for _, id := range ids {
+		v, err := service.Get(ctx, id)
+
+		b, found := m[id]
+		if !found {
+			if err == nil {
+				return fmt.Errorf("bucket %s should not exist", id)
+			}
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("could not get bucket %s: %v", id, err)
+		}
+
+		got := modelBucket{title: bucket.Title(v.Title), desc: bucket.Description(v.Description), closed: v.IsClosed, version: events.EntityVersion(v.Version)}
+		if got != *b {
+			return fmt.Errorf("bucket %s is %+v, want %+v", id, got, *b)
+		}
+
+		if v.Owner != string(Owner) {
+			return fmt.Errorf("bucket %s has owner %q, want %q", id, v.Owner, Owner)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+// Package modeltest checks the bucket service against a reference model with random sequences of commands.
+//
+// Each sequence is run against a service on a new store and after every command the outcome and the views
+// of all buckets are compared to those of the model. Failing sequence is shrunk to a minimal one, which is
+// reported with the seed it was generated from, so it can be reproduced with Run.
+// The store must not reserve titles, as the model does not
+package modeltest
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/principal"
+	"github.com/juelko/bucket/pkg/tenant"
+	svc "github.com/juelko/bucket/service"
+)
+
+// Owner is the caller of the commands
+const Owner principal.ID = "ModelOwner"
+
+// Op is the operation of the command
+type Op string
+
+const (
+	Open   Op = "Open"
+	Update Op = "Update"
+	Close  Op = "Close"
+	Reopen Op = "Reopen"
+)
+
+// Command is a call of the service
+type Command struct {
+	Op    Op
+	ID    events.EntityID
+	Title bucket.Title       // of Open and Update
+	Desc  bucket.Description // of Open and Update
+}
+
+func (c Command) String() string {
+	if c.Op == Open || c.Op == Update {
+		return fmt.Sprintf("%s(%s, %q, %q)", c.Op, c.ID, c.Title, c.Desc)
+	}
+	return fmt.Sprintf("%s(%s)", c.Op, c.ID)
+}
+
+// Values the commands are generated from. Some of the titles are invalid,
+// and the first values are the simplest ones commands are shrunk to
+var (
+	ops    = []Op{Open, Update, Close, Reopen}
+	ids    = []events.EntityID{"FirstBucket", "SecondBucket", "ThirdBucket", "FourthBucket", "FifthBucket"}
+	titles = []bucket.Title{"Title", "Other Title", "x", "Renamed-2", "Invalid!"}
+	descs  = []bucket.Description{"", "Description", "Other description"}
+)
+
+// Config of the check
+type Config struct {
+	Seed    int64 // of the first sequence, following ones have the next seeds. Time based if zero
+	Runs    int   // number of sequences, 100 if zero
+	Steps   int   // number of commands in a sequence at most, 50 if zero
+	Buckets int   // number of bucket ids the commands choose from, at most 5, 3 if zero
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+	if cfg.Runs <= 0 {
+		cfg.Runs = 100
+	}
+	if cfg.Steps <= 0 {
+		cfg.Steps = 50
+	}
+	if cfg.Buckets <= 0 {
+		cfg.Buckets = 3
+	}
+	if cfg.Buckets > len(ids) {
+		cfg.Buckets = len(ids)
+	}
+	return cfg
+}
+
+// Check runs random sequences of commands against services on the stores of newStore, a new store for each sequence,
+// and fails t with the shrunk sequence if the service does not behave like the model
+func Check(t testing.TB, newStore func() bucket.Store, cfg Config) {
+	t.Helper()
+
+	cfg = cfg.withDefaults()
+
+	for i := 0; i < cfg.Runs; i++ {
+		seed := cfg.Seed + int64(i)
+		cmds := Generate(rand.New(rand.NewSource(seed)), cfg)
+
+		if err := Run(newStore(), cmds); err != nil {
+			shrunk := Shrink(cmds, func(cmds []Command) bool {
+				return Run(newStore(), cmds) != nil
+			})
+
+			t.Fatalf("service differs from model with seed %d, shrunk from %d to %d commands:\n%s\nerror: %v",
+				seed, len(cmds), len(shrunk), format(shrunk), Run(newStore(), shrunk))
+			return
+		}
+	}
+}
+
+func format(cmds []Command) string {
+	var b strings.Builder
+	for _, c := range cmds {
+		fmt.Fprintf(&b, "\t%s\n", c)
+	}
+	return b.String()
+}
+
+// Generate returns a random sequence of commands
+func Generate(rnd *rand.Rand, cfg Config) []Command {
+	cfg = cfg.withDefaults()
+
+	cmds := make([]Command, rnd.Intn(cfg.Steps)+1)
+	for i := range cmds {
+		cmds[i] = Command{
+			Op:    ops[rnd.Intn(len(ops))],
+			ID:    ids[rnd.Intn(cfg.Buckets)],
+			Title: titles[rnd.Intn(len(titles))],
+			Desc:  descs[rnd.Intn(len(descs))],
+		}
+	}
+
+	return cmds
+}
+
+// Run runs the commands against the service on s, and returns error describing the first difference from the model
+func Run(s bucket.Store, cmds []Command) error {
+	ctx := principal.NewContext(tenant.NewContext(context.Background(), tenant.Default), Owner)
+	service := svc.NewService(s)
+	m := model{}
+
+	for i, c := range cmds {
+		want, wantKind := m.apply(c)
+		got, err := execute(ctx, service, c)
+
+		gotKind := errors.Kind(0)
+		if err != nil {
+			e, ok := err.(*errors.Error)
+			if !ok {
+				return fmt.Errorf("step %d %s: unexpected error %v", i+1, c, err)
+			}
+			gotKind = e.Kind
+		}
+
+		if gotKind != wantKind {
+			return fmt.Errorf("step %d %s: got %s, want %s, error: %v", i+1, c, outcome(gotKind), outcome(wantKind), err)
+		}
+
+		if err == nil && got.EntityVersion() != want {
+			return fmt.Errorf("step %d %s: got event version %d, want %d", i+1, c, got.EntityVersion(), want)
+		}
+
+		if err := m.compare(ctx, service); err != nil {
+			return fmt.Errorf("step %d %s: %v", i+1, c, err)
+		}
+	}
+
+	return nil
+}
+
+// outcome describes the kind of the error of the command
+func outcome(k errors.Kind) string {
+	if k == 0 {
+		return "success"
+	}
+	return fmt.Sprintf("%q error", k)
+}
+
+func execute(ctx context.Context, service bucket.Service, c Command) (events.Event, error) {
+	switch c.Op {
+	case Open:
+		return service.Open(ctx, &bucket.OpenRequest{ID: c.ID, Title: c.Title, Desc: c.Desc})
+	case Update:
+		return service.Update(ctx, &bucket.UpdateRequest{ID: c.ID, Title: c.Title, Desc: c.Desc})
+	case Close:
+		return service.Close(ctx, &bucket.CloseRequest{ID: c.ID})
+	case Reopen:
+		return service.Reopen(ctx, &bucket.ReopenRequest{ID: c.ID})
+	default:
+		return nil, fmt.Errorf("unknown command %q", c.Op)
+	}
+}
@@ -0,0 +1,126 @@
+package modeltest
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/cache"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/store/inmem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	stores := map[string]func() bucket.Store{
+		"shards=1": func() bucket.Store { return inmem.NewBucketStore(inmem.Shards(1)) },
+		fmt.Sprintf("shards=%d", inmem.DefaultShards): func() bucket.Store { return inmem.NewBucketStore() },
+		"cached": func() bucket.Store {
+			return cache.NewStore(inmem.NewBucketStore(), cache.New(cache.Options{MaxEntries: 2}))
+		},
+	}
+
+	for name, newStore := range stores {
+		newStore := newStore
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			Check(t, newStore, Config{Seed: 1})
+		})
+	}
+}
+
+// lossyStore loses the updates with given description, but reports them written
+type lossyStore struct {
+	bucket.Store
+	desc bucket.Description
+}
+
+func (s *lossyStore) InsertEvent(ctx context.Context, e events.Event) error {
+	if u, ok := e.(*bucket.Updated); ok && u.Description == s.desc {
+		return nil
+	}
+	return s.Store.InsertEvent(ctx, e)
+}
+
+func newLossyStore() bucket.Store {
+	return &lossyStore{Store: inmem.NewBucketStore(), desc: "Other description"}
+}
+
+// recorder records the failure of the check
+type recorder struct {
+	testing.TB
+	failure string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.failure = fmt.Sprintf(format, args...)
+}
+
+func TestShrink(t *testing.T) {
+	t.Parallel()
+
+	var cmds []Command
+	for seed := int64(1); cmds == nil; seed++ {
+		c := Generate(rand.New(rand.NewSource(seed)), Config{Steps: 30})
+		if Run(newLossyStore(), c) != nil {
+			cmds = c
+		}
+	}
+
+	shrunk := Shrink(cmds, func(cmds []Command) bool {
+		return Run(newLossyStore(), cmds) != nil
+	})
+
+	require.Equal(t, []Command{
+		{Op: Open, ID: "FirstBucket", Title: "Title", Desc: ""},
+		{Op: Update, ID: "FirstBucket", Title: "Title", Desc: "Other description"},
+	}, shrunk)
+
+	r := &recorder{TB: t}
+	Check(r, newLossyStore, Config{Seed: 1})
+
+	require.Contains(t, r.failure, "shrunk from")
+	require.Contains(t, r.failure, "Update(FirstBucket, \"Title\", \"Other description\")")
+}
+
+func TestModel(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		desc string
+		cmds []Command
+	}{
+		{
+			desc: "lifecycle",
+			cmds: []Command{
+				{Op: Open, ID: "FirstBucket", Title: "Title"},
+				{Op: Update, ID: "FirstBucket", Title: "Renamed-2", Desc: "Description"},
+				{Op: Close, ID: "FirstBucket"},
+				{Op: Update, ID: "FirstBucket", Title: "Title"},
+				{Op: Reopen, ID: "FirstBucket"},
+				{Op: Reopen, ID: "FirstBucket"},
+			},
+		},
+		{
+			desc: "invalid and missing",
+			cmds: []Command{
+				{Op: Open, ID: "FirstBucket", Title: "x"},
+				{Op: Close, ID: "FirstBucket"},
+				{Op: Update, ID: "FirstBucket", Title: "Invalid!"},
+				{Op: Open, ID: "FirstBucket", Title: "Title"},
+				{Op: Open, ID: "FirstBucket", Title: "Title"},
+			},
+		},
+	}
+
+	for _, tC := range testCases {
+		require.Nil(t, Run(inmem.NewBucketStore(), tC.cmds), tC.desc)
+	}
+}
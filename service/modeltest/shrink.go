@@ -0,0 +1,117 @@
+package modeltest
+
+import "github.com/juelko/bucket/pkg/events"
+
+// Shrink returns the shortest and simplest sequence found, which still fails. It removes chunks of commands,
+// from halves to single commands, and then simplifies the arguments of the remaining ones, until neither
+// makes progress. Fails reports whether the sequence fails and has to be deterministic
+func Shrink(cmds []Command, fails func([]Command) bool) []Command {
+	for {
+		shrunk := simplify(remove(cmds, fails), fails)
+
+		if len(shrunk) == len(cmds) && equal(shrunk, cmds) {
+			return cmds
+		}
+		cmds = shrunk
+	}
+}
+
+// remove removes chunks of commands as long as the sequence still fails
+func remove(cmds []Command, fails func([]Command) bool) []Command {
+	for size := len(cmds) / 2; size > 0; size /= 2 {
+		for start := 0; start+size <= len(cmds); {
+			candidate := append(append([]Command{}, cmds[:start]...), cmds[start+size:]...)
+
+			if len(candidate) > 0 && fails(candidate) {
+				cmds = candidate
+				continue
+			}
+			start += size
+		}
+	}
+
+	return cmds
+}
+
+// simplify replaces the arguments of the commands with the simplest values, when the sequence still fails.
+// Buckets are renamed in all commands at once, as the commands of a bucket usually fail together
+func simplify(cmds []Command, fails func([]Command) bool) []Command {
+	cmds = append([]Command{}, cmds...)
+
+	for i := 1; i < len(ids); i++ {
+		for j := 0; j < i; j++ {
+			candidate := swap(cmds, ids[i], ids[j])
+
+			if simpler(candidate, cmds) && fails(candidate) {
+				cmds = candidate
+				break
+			}
+		}
+	}
+
+	for i := range cmds {
+		for _, change := range []func(*Command){
+			func(c *Command) { c.ID = ids[0] },
+			func(c *Command) { c.Title = titles[0] },
+			func(c *Command) { c.Desc = descs[0] },
+		} {
+			c := cmds[i]
+			change(&c)
+			if c == cmds[i] {
+				continue
+			}
+
+			candidate := append([]Command{}, cmds...)
+			candidate[i] = c
+
+			if fails(candidate) {
+				cmds = candidate
+			}
+		}
+	}
+
+	return cmds
+}
+
+// swap swaps the bucket ids a and b in the commands
+func swap(cmds []Command, a, b events.EntityID) []Command {
+	ret := make([]Command, len(cmds))
+	for i, c := range cmds {
+		switch c.ID {
+		case a:
+			c.ID = b
+		case b:
+			c.ID = a
+		}
+		ret[i] = c
+	}
+	return ret
+}
+
+// simpler reports whether the bucket ids of a come earlier in ids than those of b, at the first command they differ
+func simpler(a, b []Command) bool {
+	for i := range a {
+		if a[i].ID != b[i].ID {
+			return index(a[i].ID) < index(b[i].ID)
+		}
+	}
+	return false
+}
+
+func index(id events.EntityID) int {
+	for i := range ids {
+		if ids[i] == id {
+			return i
+		}
+	}
+	return len(ids)
+}
+
+func equal(a, b []Command) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package bucket
+
+import (
+	"context"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/principal"
+)
+
+// policy has the least role required for each service operation on existing bucket.
+// Operations missing from the policy are denied
+var policy = map[errors.Op]bucket.Role{
+	"bucket.service.Get":            bucket.RoleViewer,
+	"bucket.service.Verify":         bucket.RoleViewer,
+	"bucket.service.History":        bucket.RoleViewer,
+	"bucket.service.Update":         bucket.RoleEditor,
+	"bucket.service.AddItem":        bucket.RoleEditor,
+	"bucket.service.RemoveItem":     bucket.RoleEditor,
+	"bucket.service.MoveItem":       bucket.RoleEditor,
+	"bucket.service.AddTag":         bucket.RoleEditor,
+	"bucket.service.RemoveTag":      bucket.RoleEditor,
+	"bucket.service.Close":          bucket.RoleOwner,
+	"bucket.service.Reopen":         bucket.RoleOwner,
+	"bucket.service.ChangeCapacity": bucket.RoleOwner,
+	"bucket.service.Grant":          bucket.RoleOwner,
+	"bucket.service.Revoke":         bucket.RoleOwner,
+	"bucket.service.Archive":        bucket.RoleOwner,
+	"bucket.service.Purge":          bucket.RoleOwner,
+	"bucket.service.Forget":         bucket.RoleOwner,
+}
+
+// authorize checks that the principal in context has the role required by policy for the operation.
+// Principal without any role gets KindNotFound, as the bucket is not revealed to it
+func authorize(ctx context.Context, operation errors.Op, id events.EntityID, stream []events.Event) *errors.Error {
+	const op errors.Op = "bucket.service.authorize"
+
+	p, cerr := caller(ctx)
+	if cerr != nil {
+		return cerr
+	}
+
+	required, ok := policy[operation]
+	if !ok {
+		return errors.New(op, errors.KindForbidden, "Operation not allowed by policy")
+	}
+
+	role, err := bucket.RoleOf(id, p, stream...)
+	if err != nil {
+		return errors.New(op, errors.KindUnexpected, "Error when resolving role", err)
+	}
+
+	if role == bucket.RoleNone {
+		return errors.New(op, errors.KindNotFound, "No role in bucket")
+	}
+
+	if role < required {
+		return errors.New(op, errors.KindForbidden, "Role "+required.String()+" required")
+	}
+
+	return nil
+}
+
+// caller returns the principal from context
+func caller(ctx context.Context) (principal.ID, *errors.Error) {
+	const op errors.Op = "bucket.service.caller"
+
+	p, ok := principal.FromContext(ctx)
+	if !ok {
+		return "", errors.New(op, errors.KindUnauthenticated, "Principal missing")
+	}
+
+	return p, nil
+}
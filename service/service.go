@@ -17,8 +17,14 @@ type service struct {
 }
 
 func (svc *service) Open(ctx context.Context, req *bucket.OpenRequest) (events.Event, error) {
+	const op errors.Op = "bucket.service.Open"
 
-	o, err := bucket.Open(req)
+	owner, cerr := caller(ctx)
+	if cerr != nil {
+		return nil, errors.New(op, errors.KindUnauthenticated, "not authenticated", cerr)
+	}
+
+	o, err := bucket.Open(req, owner)
 	if err != nil {
 		return nil, err
 	}
@@ -37,47 +43,239 @@ func (svc *service) Update(ctx context.Context, req *bucket.UpdateRequest) (even
 		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
 	}
 
-	stream, err := svc.store.GetStream(ctx, req.ID)
-	if err != nil {
-		return nil, errors.New(op, errors.KindNotFound, "Entity not found", err)
+	return svc.execute(ctx, op, req.ID, "update not allowed", func(stream []events.Event) (events.Event, error) {
+		return bucket.Update(req, stream)
+	})
+}
+
+func (svc *service) Close(ctx context.Context, req *bucket.CloseRequest) (events.Event, error) {
+	const op errors.Op = "bucket.service.Close"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return svc.execute(ctx, op, req.ID, "closing not allowed", func(stream []events.Event) (events.Event, error) {
+		return bucket.Close(req, stream)
+	})
+}
+
+func (svc *service) Reopen(ctx context.Context, req *bucket.ReopenRequest) (events.Event, error) {
+	const op errors.Op = "bucket.service.Reopen"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
 	}
 
-	u, err := bucket.Update(req, stream)
+	return svc.execute(ctx, op, req.ID, "reopening not allowed", func(stream []events.Event) (events.Event, error) {
+		return bucket.Reopen(req, stream)
+	})
+}
+
+func (svc *service) AddItem(ctx context.Context, req *bucket.AddItemRequest) (events.Event, error) {
+	const op errors.Op = "bucket.service.AddItem"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return svc.execute(ctx, op, req.ID, "adding item not allowed", func(stream []events.Event) (events.Event, error) {
+		return bucket.AddItem(req, stream)
+	})
+}
+
+func (svc *service) RemoveItem(ctx context.Context, req *bucket.RemoveItemRequest) (events.Event, error) {
+	const op errors.Op = "bucket.service.RemoveItem"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return svc.execute(ctx, op, req.ID, "removing item not allowed", func(stream []events.Event) (events.Event, error) {
+		return bucket.RemoveItem(req, stream)
+	})
+}
+
+func (svc *service) MoveItem(ctx context.Context, req *bucket.MoveItemRequest) (events.Event, error) {
+	const op errors.Op = "bucket.service.MoveItem"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return svc.execute(ctx, op, req.ID, "moving item not allowed", func(stream []events.Event) (events.Event, error) {
+		return bucket.MoveItem(req, stream)
+	})
+}
+
+func (svc *service) ChangeCapacity(ctx context.Context, req *bucket.ChangeCapacityRequest) (events.Event, error) {
+	const op errors.Op = "bucket.service.ChangeCapacity"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return svc.execute(ctx, op, req.ID, "changing capacity not allowed", func(stream []events.Event) (events.Event, error) {
+		return bucket.ChangeCapacity(req, stream)
+	})
+}
+
+func (svc *service) Grant(ctx context.Context, req *bucket.GrantRequest) (events.Event, error) {
+	const op errors.Op = "bucket.service.Grant"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return svc.execute(ctx, op, req.ID, "granting not allowed", func(stream []events.Event) (events.Event, error) {
+		return bucket.GrantAccess(req, stream)
+	})
+}
+
+func (svc *service) Revoke(ctx context.Context, req *bucket.RevokeRequest) (events.Event, error) {
+	const op errors.Op = "bucket.service.Revoke"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return svc.execute(ctx, op, req.ID, "revoking not allowed", func(stream []events.Event) (events.Event, error) {
+		return bucket.RevokeAccess(req, stream)
+	})
+}
+
+func (svc *service) AddTag(ctx context.Context, req *bucket.AddTagRequest) (events.Event, error) {
+	const op errors.Op = "bucket.service.AddTag"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return svc.execute(ctx, op, req.ID, "adding tag not allowed", func(stream []events.Event) (events.Event, error) {
+		return bucket.AddTag(req, stream)
+	})
+}
+
+func (svc *service) RemoveTag(ctx context.Context, req *bucket.RemoveTagRequest) (events.Event, error) {
+	const op errors.Op = "bucket.service.RemoveTag"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	return svc.execute(ctx, op, req.ID, "removing tag not allowed", func(stream []events.Event) (events.Event, error) {
+		return bucket.RemoveTag(req, stream)
+	})
+}
+
+func (svc *service) Archive(ctx context.Context, req *bucket.ArchiveRequest) (events.Event, error) {
+	const op errors.Op = "bucket.service.Archive"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	archiver, ok := bucket.As[bucket.Archiver](svc.store)
+	if !ok {
+		return nil, errors.New(op, errors.KindUnexpected, "Store does not support archiving")
+	}
+
+	stream, err := svc.load(ctx, op, req.ID)
 	if err != nil {
-		return nil, errors.New(op, errors.KindExpected, "update not allowed", err)
+		return nil, err
 	}
 
-	err = svc.store.InsertEvent(ctx, u)
+	a, err := bucket.Archive(req, stream)
 	if err != nil {
-		return nil, errors.New(op, errors.KindUnexpected, "could not insert", err)
+		return nil, errors.New(op, errors.KindExpected, "archiving not allowed", err)
 	}
 
-	return u, nil
+	if err := archiver.ArchiveStream(ctx, a); err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "could not archive", err)
+	}
+
+	return a, nil
 }
 
-func (svc *service) Close(ctx context.Context, req *bucket.CloseRequest) (events.Event, error) {
-	const op errors.Op = "bucket.service.Close"
+func (svc *service) Purge(ctx context.Context, req *bucket.PurgeRequest) (events.Event, error) {
+	const op errors.Op = "bucket.service.Purge"
 
 	if err := req.Validate(); err != nil {
 		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
 	}
 
-	stream, err := svc.store.GetStream(ctx, req.ID)
+	archiver, ok := bucket.As[bucket.Archiver](svc.store)
+	if !ok {
+		return nil, errors.New(op, errors.KindUnexpected, "Store does not support purging")
+	}
+
+	stream, err := svc.load(ctx, op, req.ID)
 	if err != nil {
-		return nil, errors.New(op, errors.KindNotFound, "Entity not found", err)
+		return nil, err
 	}
 
-	c, err := bucket.Close(req, stream)
+	p, err := bucket.Purge(req, stream)
 	if err != nil {
-		return nil, errors.New(op, errors.KindExpected, "closing not allowed", err)
+		return nil, errors.New(op, errors.KindExpected, "purging not allowed", err)
 	}
 
-	err = svc.store.InsertEvent(ctx, c)
+	if err := archiver.PurgeStream(ctx, p); err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "could not purge", err)
+	}
+
+	return p, nil
+}
+
+func (svc *service) Forget(ctx context.Context, req *bucket.ForgetRequest) (events.Event, error) {
+	const op errors.Op = "bucket.service.Forget"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	shredder, ok := bucket.As[bucket.Shredder](svc.store)
+	if !ok {
+		return nil, errors.New(op, errors.KindUnexpected, "Store does not support forgetting")
+	}
+
+	stream, err := svc.load(ctx, op, req.ID)
 	if err != nil {
-		return nil, errors.New(op, errors.KindUnexpected, "could not insert", err)
+		return nil, err
+	}
+
+	f, err := bucket.Forget(req, stream)
+	if err != nil {
+		return nil, errors.New(op, errors.KindExpected, "forgetting not allowed", err)
+	}
+
+	if err := shredder.ShredStream(ctx, f); err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "could not forget", err)
 	}
 
-	return c, nil
+	return f, nil
+}
+
+func (svc *service) Verify(ctx context.Context, id events.EntityID) error {
+	const op errors.Op = "bucket.service.Verify"
+
+	if err := id.Validate(); err != nil {
+		return errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	verifier, ok := bucket.As[bucket.Verifier](svc.store)
+	if !ok {
+		return errors.New(op, errors.KindUnexpected, "Store does not support verification")
+	}
+
+	if _, err := svc.load(ctx, op, id); err != nil {
+		return err
+	}
+
+	if err := verifier.VerifyStream(ctx, id); err != nil {
+		return errors.New(op, errors.KindUnexpected, "verification failed", err)
+	}
+
+	return nil
 }
 
 func (svc *service) Get(ctx context.Context, id events.EntityID) (*bucket.View, error) {
@@ -87,10 +285,128 @@ func (svc *service) Get(ctx context.Context, id events.EntityID) (*bucket.View,
 		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
 	}
 
-	stream, err := svc.store.GetStream(ctx, id)
+	stream, err := svc.load(ctx, op, id)
 	if err != nil {
-		return nil, errors.New(op, errors.KindNotFound, "Entity not found", err)
+		return nil, err
 	}
 
 	return bucket.NewView(id, stream...)
 }
+
+func (svc *service) History(ctx context.Context, id events.EntityID) ([]bucket.Change, error) {
+	const op errors.Op = "bucket.service.History"
+
+	if err := id.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	stream, err := svc.load(ctx, op, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return bucket.History(id, stream...)
+}
+
+// List returns views of the buckets matching the tags. Buckets caller is not allowed to view are left out
+func (svc *service) List(ctx context.Context, req *bucket.ListRequest) ([]*bucket.View, error) {
+	const op errors.Op = "bucket.service.List"
+
+	if err := req.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "invalid request", err)
+	}
+
+	if _, err := caller(ctx); err != nil {
+		return nil, errors.New(op, errors.KindUnauthenticated, "not authenticated", err)
+	}
+
+	index, ok := bucket.As[bucket.TagIndex](svc.store)
+	if !ok {
+		return nil, errors.New(op, errors.KindUnexpected, "Store does not support tag queries")
+	}
+
+	ids, err := index.FindByTags(ctx, req.Tags, req.Match)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "could not query tags", err)
+	}
+
+	ret := []*bucket.View{}
+
+	for _, id := range ids {
+		// buckets the caller has no role in are not found
+		v, err := svc.Get(ctx, id)
+		if isKind(err, errors.KindNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.New(op, errors.KindUnexpected, "could not get bucket", err)
+		}
+		ret = append(ret, v)
+	}
+
+	return ret, nil
+}
+
+// execute runs the business logic against the stream of the bucket and stores the resulting event.
+// msg describes the error when business logic does not allow the operation
+func (svc *service) execute(ctx context.Context, op errors.Op, id events.EntityID, msg string, logic func([]events.Event) (events.Event, error)) (events.Event, error) {
+
+	stream, err := svc.load(ctx, op, id)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := logic(stream)
+	if err != nil {
+		return nil, errors.New(op, errors.KindExpected, msg, err)
+	}
+
+	err = svc.store.InsertEvent(ctx, e)
+	if isKind(err, errors.KindAllreadyExists) {
+		return nil, errors.New(op, errors.KindAllreadyExists, "could not insert", err)
+	}
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "could not insert", err)
+	}
+
+	return e, nil
+}
+
+// load returns the stream of the bucket if caller is authorized for the operation.
+// Callers without role in the bucket get the same error as for a missing bucket, so they can not find out
+// which buckets exist
+func (svc *service) load(ctx context.Context, op errors.Op, id events.EntityID) ([]events.Event, error) {
+
+	if _, err := caller(ctx); err != nil {
+		return nil, errors.New(op, err.Kind, "access denied", err)
+	}
+
+	stream, err := svc.store.GetStream(ctx, id)
+	if isKind(err, errors.KindNotFound) {
+		return nil, errors.New(op, errors.KindNotFound, "Entity not found", err)
+	}
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "could not load", err)
+	}
+
+	// tombstone has no one to authorize, so deleted bucket is not found by anyone
+	if n := len(stream); n > 0 {
+		if _, ok := stream[n-1].(*bucket.Purged); ok {
+			return nil, errors.New(op, errors.KindNotFound, "Bucket deleted")
+		}
+	}
+
+	if err := authorize(ctx, op, id, stream); err != nil {
+		if err.Kind == errors.KindNotFound {
+			return nil, errors.New(op, errors.KindNotFound, "Entity not found", err)
+		}
+		return nil, errors.New(op, err.Kind, "access denied", err)
+	}
+
+	return stream, nil
+}
+
+func isKind(err error, kind errors.Kind) bool {
+	e, ok := err.(*errors.Error)
+	return ok && e.Kind == kind
+}
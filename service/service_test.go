@@ -7,7 +7,10 @@ import (
 	"github.com/juelko/bucket/bucket"
 	"github.com/juelko/bucket/pkg/errors"
 	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/principal"
+	"github.com/juelko/bucket/pkg/tenant"
 	"github.com/juelko/bucket/store/inmem"
+	"github.com/juelko/bucket/store/shred"
 	"github.com/stretchr/testify/require"
 )
 
@@ -25,7 +28,13 @@ func TestOpen(t *testing.T) {
 		{
 			desc: "happy",
 			args: &bucket.OpenRequest{ID: "NewID", Title: "NewTitle", Desc: "New Description"},
-			want: &bucket.Opened{Base: events.Base{ID: "NewID", V: 1}, BucketData: bucket.BucketData{Title: "NewTitle", Description: "New Description"}},
+			want: &bucket.Opened{Base: events.Base{ID: "NewID", V: 1}, BucketData: bucket.BucketData{Title: "NewTitle", Description: "New Description"}, Owner: "TestOwner"},
+			err:  nil,
+		},
+		{
+			desc: "capacity",
+			args: &bucket.OpenRequest{ID: "CappedID", Title: "NewTitle", Desc: "New Description", Capacity: bucket.Capacity{MaxItems: 10}},
+			want: &bucket.Opened{Base: events.Base{ID: "CappedID", V: 1}, BucketData: bucket.BucketData{Title: "NewTitle", Description: "New Description"}, Capacity: bucket.Capacity{MaxItems: 10}, Owner: "TestOwner"},
 			err:  nil,
 		},
 		{
@@ -44,7 +53,7 @@ func TestOpen(t *testing.T) {
 		t.Run(tC.desc, func(t *testing.T) {
 			t.Parallel()
 
-			got, err := svc.Open(context.Background(), tC.args)
+			got, err := svc.Open(testContext("TestOwner"), tC.args)
 
 			if tC.want != nil {
 				require.Nil(t, err, "error should be nil")
@@ -108,7 +117,7 @@ func TestUpdate(t *testing.T) {
 		t.Run(tC.desc, func(t *testing.T) {
 			t.Parallel()
 
-			got, err := svc.Update(context.Background(), tC.args)
+			got, err := svc.Update(testContext("TestOwner"), tC.args)
 
 			if tC.want != nil {
 				require.Nil(t, err, "error should be nil")
@@ -172,7 +181,122 @@ func TestClose(t *testing.T) {
 		t.Run(tC.desc, func(t *testing.T) {
 			t.Parallel()
 
-			got, err := svc.Close(context.Background(), tC.args)
+			got, err := svc.Close(testContext("TestOwner"), tC.args)
+
+			if tC.want != nil {
+				require.Nil(t, err, "error should be nil")
+				require.Equal(t, tC.want, got, "events should be equal")
+			} else {
+				require.Nil(t, got, "event should be nil")
+				require.Equal(t, tC.err, err, "errors should be equal")
+			}
+
+		})
+	}
+}
+
+func TestReopen(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService(inmem.NewTestBucketStore())
+
+	testCases := []struct {
+		desc string
+		args *bucket.ReopenRequest
+		want events.Event
+		err  error
+	}{
+		{
+			desc: "happy",
+			args: &bucket.ReopenRequest{ID: "ClosedID"},
+			want: &bucket.Reopened{Base: events.Base{ID: "ClosedID", V: 4}},
+			err:  nil,
+		},
+		{
+			desc: "not found",
+			args: &bucket.ReopenRequest{ID: "NotFoundID"},
+			want: nil,
+			err: &errors.Error{
+				Op:   "bucket.service.Reopen",
+				Kind: 4, Msg: "Entity not found",
+				Wraps: &errors.Error{
+					Op:    "inmem.store.GetStream",
+					Kind:  4,
+					Msg:   "Stream not found",
+					Wraps: nil}},
+		},
+		{
+			desc: "open",
+			args: &bucket.ReopenRequest{ID: "OpenID"},
+			want: nil,
+			err: &errors.Error{
+				Op:   "bucket.service.Reopen",
+				Kind: 2,
+				Msg:  "reopening not allowed",
+				Wraps: &errors.Error{
+					Op:    "bucket.reopening",
+					Kind:  2,
+					Msg:   "Bucket is not closed",
+					Wraps: nil}},
+		},
+	}
+	for i := range testCases {
+		tC := testCases[i]
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := svc.Reopen(testContext("TestOwner"), tC.args)
+
+			if tC.want != nil {
+				require.Nil(t, err, "error should be nil")
+				require.Equal(t, tC.want, got, "events should be equal")
+			} else {
+				require.Nil(t, got, "event should be nil")
+				require.Equal(t, tC.err, err, "errors should be equal")
+			}
+
+		})
+	}
+}
+
+func TestAddItem(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService(inmem.NewTestBucketStore())
+
+	testCases := []struct {
+		desc string
+		args *bucket.AddItemRequest
+		want events.Event
+		err  error
+	}{
+		{
+			desc: "happy",
+			args: &bucket.AddItemRequest{ID: "OpenID", ItemID: "Item1", Name: "First", Payload: bucket.Payload("1")},
+			want: &bucket.ItemAdded{Base: events.Base{ID: "OpenID", V: 2}, Item: bucket.Item{ID: "Item1", Name: "First", Payload: bucket.Payload("1")}},
+			err:  nil,
+		},
+		{
+			desc: "closed",
+			args: &bucket.AddItemRequest{ID: "ClosedID", ItemID: "Item1", Name: "First"},
+			want: nil,
+			err: &errors.Error{
+				Op:   "bucket.service.AddItem",
+				Kind: 2,
+				Msg:  "adding item not allowed",
+				Wraps: &errors.Error{
+					Op:    "bucket.addingItem",
+					Kind:  2,
+					Msg:   "Bucket is closed",
+					Wraps: nil}},
+		},
+	}
+	for i := range testCases {
+		tC := testCases[i]
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := svc.AddItem(testContext("TestOwner"), tC.args)
 
 			if tC.want != nil {
 				require.Nil(t, err, "error should be nil")
@@ -206,6 +330,7 @@ func TestGet(t *testing.T) {
 				Description: "Open Description",
 				Version:     1,
 				IsClosed:    false,
+				Owner:       "TestOwner",
 			},
 			err: nil,
 		},
@@ -245,7 +370,7 @@ func TestGet(t *testing.T) {
 		t.Run(tC.desc, func(t *testing.T) {
 			t.Parallel()
 
-			got, err := svc.Get(context.Background(), tC.args)
+			got, err := svc.Get(testContext("TestOwner"), tC.args)
 
 			if tC.want != nil {
 				require.Nil(t, err, "error should be nil")
@@ -258,3 +383,453 @@ func TestGet(t *testing.T) {
 		})
 	}
 }
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService(inmem.NewTestBucketStore())
+
+	testCases := []struct {
+		desc string
+		args events.EntityID
+		want []bucket.Change
+		err  error
+	}{
+		{
+			desc: "happy",
+			args: "UpdatedID",
+			want: []bucket.Change{
+				{Version: 1, Type: "bucket.Opened", Diff: []bucket.FieldDiff{
+					{Field: "Title", From: "", To: "OpenTitle"},
+					{Field: "Description", From: "", To: "Open Description"},
+				}},
+				{Version: 2, Type: "bucket.Updated", Diff: []bucket.FieldDiff{
+					{Field: "Title", From: "OpenTitle", To: "UpdatedTitle"},
+					{Field: "Description", From: "Open Description", To: "Updated Description"},
+				}},
+			},
+			err: nil,
+		},
+		{
+			desc: "not found",
+			args: "NotFoundID",
+			want: nil,
+			err: &errors.Error{
+				Op:   "bucket.service.History",
+				Kind: 4,
+				Msg:  "Entity not found",
+				Wraps: &errors.Error{
+					Op:    "inmem.store.GetStream",
+					Kind:  4,
+					Msg:   "Stream not found",
+					Wraps: nil}},
+		},
+	}
+	for i := range testCases {
+		tC := testCases[i]
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := svc.History(testContext("TestOwner"), tC.args)
+
+			if tC.want != nil {
+				require.Nil(t, err, "error should be nil")
+				require.Equal(t, tC.want, got, "history should be equal")
+			} else {
+				require.Nil(t, got, "history should be nil")
+				require.Equal(t, tC.err, err, "errors should be equal")
+			}
+
+		})
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService(inmem.NewTestBucketStore())
+
+	forbidden := func(op errors.Op, msg string) error {
+		return &errors.Error{
+			Op:   op,
+			Kind: errors.KindForbidden,
+			Msg:  "access denied",
+			Wraps: &errors.Error{
+				Op:   "bucket.service.authorize",
+				Kind: errors.KindForbidden,
+				Msg:  msg,
+			}}
+	}
+
+	testCases := []struct {
+		desc string
+		ctx  context.Context
+		call func(ctx context.Context) error
+		err  error
+	}{
+		{
+			desc: "viewer can get",
+			ctx:  testContext("TestViewer"),
+			call: func(ctx context.Context) error { _, err := svc.Get(ctx, "SharedID"); return err },
+			err:  nil,
+		},
+		{
+			desc: "stranger can not get",
+			ctx:  testContext("Stranger"),
+			call: func(ctx context.Context) error { _, err := svc.Get(ctx, "SharedID"); return err },
+			err: &errors.Error{
+				Op:   "bucket.service.Get",
+				Kind: errors.KindNotFound,
+				Msg:  "Entity not found",
+				Wraps: &errors.Error{
+					Op:   "bucket.service.authorize",
+					Kind: errors.KindNotFound,
+					Msg:  "No role in bucket",
+				}},
+		},
+		{
+			desc: "missing principal on missing bucket",
+			ctx:  tenant.NewContext(context.Background(), tenant.Default),
+			call: func(ctx context.Context) error { _, err := svc.Get(ctx, "MissingID"); return err },
+			err: &errors.Error{
+				Op:   "bucket.service.Get",
+				Kind: errors.KindUnauthenticated,
+				Msg:  "access denied",
+				Wraps: &errors.Error{
+					Op:   "bucket.service.caller",
+					Kind: errors.KindUnauthenticated,
+					Msg:  "Principal missing",
+				}},
+		},
+		{
+			desc: "viewer can not update",
+			ctx:  testContext("TestViewer"),
+			call: func(ctx context.Context) error {
+				_, err := svc.Update(ctx, &bucket.UpdateRequest{ID: "SharedID", Title: "NewTitle"})
+				return err
+			},
+			err: forbidden("bucket.service.Update", "Role Editor required"),
+		},
+		{
+			desc: "editor can add items",
+			ctx:  testContext("TestEditor"),
+			call: func(ctx context.Context) error {
+				_, err := svc.AddItem(ctx, &bucket.AddItemRequest{ID: "SharedID", ItemID: "Item1", Name: "First"})
+				return err
+			},
+			err: nil,
+		},
+		{
+			desc: "editor can not close",
+			ctx:  testContext("TestEditor"),
+			call: func(ctx context.Context) error {
+				_, err := svc.Close(ctx, &bucket.CloseRequest{ID: "SharedID"})
+				return err
+			},
+			err: forbidden("bucket.service.Close", "Role Owner required"),
+		},
+		{
+			desc: "editor can not share",
+			ctx:  testContext("TestEditor"),
+			call: func(ctx context.Context) error {
+				_, err := svc.Grant(ctx, &bucket.GrantRequest{ID: "SharedID", Principal: "Stranger", Role: bucket.RoleEditor})
+				return err
+			},
+			err: forbidden("bucket.service.Grant", "Role Owner required"),
+		},
+		{
+			desc: "owner can share",
+			ctx:  testContext("TestOwner"),
+			call: func(ctx context.Context) error {
+				_, err := svc.Grant(ctx, &bucket.GrantRequest{ID: "OpenID", Principal: "NewViewer", Role: bucket.RoleViewer})
+				return err
+			},
+			err: nil,
+		},
+		{
+			desc: "missing principal",
+			ctx:  tenant.NewContext(context.Background(), tenant.Default),
+			call: func(ctx context.Context) error { _, err := svc.Get(ctx, "SharedID"); return err },
+			err: &errors.Error{
+				Op:   "bucket.service.Get",
+				Kind: errors.KindUnauthenticated,
+				Msg:  "access denied",
+				Wraps: &errors.Error{
+					Op:   "bucket.service.caller",
+					Kind: errors.KindUnauthenticated,
+					Msg:  "Principal missing",
+				}},
+		},
+		{
+			desc: "missing principal on open",
+			ctx:  tenant.NewContext(context.Background(), tenant.Default),
+			call: func(ctx context.Context) error {
+				_, err := svc.Open(ctx, &bucket.OpenRequest{ID: "AnonID", Title: "AnonTitle"})
+				return err
+			},
+			err: &errors.Error{
+				Op:   "bucket.service.Open",
+				Kind: errors.KindUnauthenticated,
+				Msg:  "not authenticated",
+				Wraps: &errors.Error{
+					Op:   "bucket.service.caller",
+					Kind: errors.KindUnauthenticated,
+					Msg:  "Principal missing",
+				}},
+		},
+	}
+	for i := range testCases {
+		tC := testCases[i]
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			err := tC.call(tC.ctx)
+
+			if tC.err == nil {
+				require.Nil(t, err, "error should be nil")
+			} else {
+				require.Equal(t, tC.err, err, "errors should be equal")
+			}
+		})
+	}
+}
+
+func testContext(p principal.ID) context.Context {
+	return principal.NewContext(tenant.NewContext(context.Background(), tenant.Default), p)
+}
+
+func TestTenantIsolation(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService(inmem.NewBucketStore())
+
+	first := tenant.NewContext(testContext("TestOwner"), "First")
+	second := tenant.NewContext(testContext("TestOwner"), "Second")
+
+	_, err := svc.Open(first, &bucket.OpenRequest{ID: "TenantID", Title: "FirstTitle"})
+	require.Nil(t, err)
+
+	_, err = svc.Get(second, "TenantID")
+	require.NotNil(t, err, "other tenant should not see the bucket")
+	require.Equal(t, errors.KindNotFound, err.(*errors.Error).Kind)
+
+	_, err = svc.Open(second, &bucket.OpenRequest{ID: "TenantID", Title: "SecondTitle"})
+	require.Nil(t, err, "same id should be available in other tenant")
+
+	got, err := svc.Get(first, "TenantID")
+	require.Nil(t, err)
+	require.Equal(t, "FirstTitle", got.Title)
+}
+
+func TestList(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService(inmem.NewBucketStore())
+
+	owner := testContext("TestOwner")
+	other := testContext("OtherOwner")
+
+	buckets := []struct {
+		ctx  context.Context
+		id   events.EntityID
+		tags []bucket.Tag
+	}{
+		{owner, "Apollo", []bucket.Tag{"project:apollo", "team:core"}},
+		{owner, "Gemini", []bucket.Tag{"project:gemini", "team:core"}},
+		{owner, "Mercury", []bucket.Tag{"project:mercury"}},
+		{other, "Hidden", []bucket.Tag{"project:apollo", "team:core"}},
+	}
+	for _, b := range buckets {
+		_, err := svc.Open(b.ctx, &bucket.OpenRequest{ID: b.id, Title: bucket.Title(b.id)})
+		require.Nil(t, err)
+		for _, tag := range b.tags {
+			_, err := svc.AddTag(b.ctx, &bucket.AddTagRequest{ID: b.id, Tag: tag})
+			require.Nil(t, err)
+		}
+	}
+	_, err := svc.RemoveTag(owner, &bucket.RemoveTagRequest{ID: "Gemini", Tag: "team:core"})
+	require.Nil(t, err)
+
+	testCases := []struct {
+		desc string
+		args *bucket.ListRequest
+		want []string
+	}{
+		{
+			desc: "single",
+			args: &bucket.ListRequest{Tags: []bucket.Tag{"team:core"}},
+			want: []string{"Apollo"},
+		},
+		{
+			desc: "all",
+			args: &bucket.ListRequest{Tags: []bucket.Tag{"project:apollo", "team:core"}, Match: bucket.MatchAll},
+			want: []string{"Apollo"},
+		},
+		{
+			desc: "any",
+			args: &bucket.ListRequest{Tags: []bucket.Tag{"project:gemini", "project:mercury", "team:core"}, Match: bucket.MatchAny},
+			want: []string{"Apollo", "Gemini", "Mercury"},
+		},
+		{
+			desc: "none",
+			args: &bucket.ListRequest{Tags: []bucket.Tag{"project:gemini", "project:mercury"}, Match: bucket.MatchAll},
+			want: []string{},
+		},
+	}
+	for i := range testCases {
+		tC := testCases[i]
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := svc.List(owner, tC.args)
+			require.Nil(t, err)
+
+			ids := []string{}
+			for _, v := range got {
+				ids = append(ids, v.ID)
+			}
+			require.Equal(t, tC.want, ids)
+		})
+	}
+}
+
+func TestUniqueTitle(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService(inmem.NewBucketStore(inmem.UniqueTitles(true)))
+	ctx := testContext("TestOwner")
+
+	_, err := svc.Open(ctx, &bucket.OpenRequest{ID: "FirstID", Title: "Title"})
+	require.Nil(t, err)
+	_, err = svc.Open(ctx, &bucket.OpenRequest{ID: "SecondID", Title: "Other"})
+	require.Nil(t, err)
+
+	_, err = svc.Update(ctx, &bucket.UpdateRequest{ID: "SecondID", Title: "title"})
+	require.Equal(t, &errors.Error{
+		Op:   "bucket.service.Update",
+		Kind: errors.KindAllreadyExists,
+		Msg:  "could not insert",
+		Wraps: &errors.Error{
+			Op:    "inmem.store.InsertEvent",
+			Kind:  errors.KindAllreadyExists,
+			Msg:   "Title allready in use",
+			Wraps: &bucket.TitleConflict{ID: "FirstID"},
+		}}, err)
+}
+
+func TestArchiveAndPurge(t *testing.T) {
+	t.Parallel()
+
+	store := inmem.NewBucketStore(inmem.UniqueTitles(false))
+	svc := NewService(store)
+	ctx := testContext("TestOwner")
+
+	_, err := svc.Open(ctx, &bucket.OpenRequest{ID: "RetainedID", Title: "Personal", Desc: "Personal data"})
+	require.Nil(t, err)
+
+	_, err = svc.Archive(ctx, &bucket.ArchiveRequest{ID: "RetainedID"})
+	require.NotNil(t, err, "open bucket should not be archived")
+
+	_, err = svc.Close(ctx, &bucket.CloseRequest{ID: "RetainedID"})
+	require.Nil(t, err)
+
+	got, err := svc.Archive(ctx, &bucket.ArchiveRequest{ID: "RetainedID"})
+	require.Nil(t, err)
+	require.Equal(t, &bucket.Archived{Base: events.Base{ID: "RetainedID", V: 3}}, got)
+
+	_, err = svc.Reopen(ctx, &bucket.ReopenRequest{ID: "RetainedID"})
+	require.Equal(t, &errors.Error{
+		Op:   "bucket.service.Reopen",
+		Kind: errors.KindExpected,
+		Msg:  "reopening not allowed",
+		Wraps: &errors.Error{
+			Op:   "bucket.reopening",
+			Kind: errors.KindExpected,
+			Msg:  "Bucket is archived",
+		}}, err)
+
+	view, err := svc.Get(ctx, "RetainedID")
+	require.Nil(t, err)
+	require.True(t, view.IsArchived)
+	require.Equal(t, "Personal", view.Title)
+
+	_, err = svc.Purge(testContext("Stranger"), &bucket.PurgeRequest{ID: "RetainedID"})
+	require.NotNil(t, err, "only owner can purge")
+
+	got, err = svc.Purge(ctx, &bucket.PurgeRequest{ID: "RetainedID"})
+	require.Nil(t, err)
+	require.Equal(t, &bucket.Purged{Base: events.Base{ID: "RetainedID", V: 4}}, got)
+
+	deleted := &errors.Error{Op: "bucket.service.Get", Kind: errors.KindNotFound, Msg: "Bucket deleted"}
+
+	_, err = svc.Get(ctx, "RetainedID")
+	require.Equal(t, deleted, err, "tombstone has no owner")
+
+	_, err = svc.Get(testContext("Stranger"), "RetainedID")
+	require.Equal(t, deleted, err)
+
+	_, err = svc.History(ctx, "RetainedID")
+	require.Equal(t, errors.KindNotFound, err.(*errors.Error).Kind)
+
+	stream, err := store.GetStream(tenant.NewContext(context.Background(), tenant.Default), "RetainedID")
+	require.Nil(t, err)
+	require.Len(t, stream, 1, "only tombstone should be left")
+
+	_, err = svc.Open(ctx, &bucket.OpenRequest{ID: "OtherID", Title: "Personal"})
+	require.Nil(t, err, "title of purged bucket should be released")
+
+	_, err = svc.Open(ctx, &bucket.OpenRequest{ID: "RetainedID", Title: "Reused"})
+	require.NotNil(t, err, "id of purged bucket should not be reused")
+}
+
+func TestForget(t *testing.T) {
+	t.Parallel()
+
+	ctx := testContext("TestOwner")
+
+	_, err := NewService(inmem.NewTestBucketStore()).Forget(ctx, &bucket.ForgetRequest{ID: "OpenID"})
+	require.Equal(t, &errors.Error{Op: "bucket.service.Forget", Kind: errors.KindUnexpected, Msg: "Store does not support forgetting"}, err)
+
+	svc := NewService(shred.NewBucketStore(inmem.NewBucketStore(), shred.NewKeyStore()))
+
+	_, err = svc.Open(ctx, &bucket.OpenRequest{ID: "PersonalID", Title: "Personal", Desc: "Personal data"})
+	require.Nil(t, err)
+
+	_, err = svc.Forget(testContext("Stranger"), &bucket.ForgetRequest{ID: "PersonalID"})
+	require.NotNil(t, err, "only owner can forget")
+
+	got, err := svc.Forget(ctx, &bucket.ForgetRequest{ID: "PersonalID"})
+	require.Nil(t, err)
+	require.Equal(t, &bucket.Forgotten{Base: events.Base{ID: "PersonalID", V: 2}}, got)
+
+	view, err := svc.Get(ctx, "PersonalID")
+	require.Nil(t, err)
+	require.Equal(t, "Personal", view.Title)
+	require.Equal(t, "", view.Description)
+	require.Equal(t, uint(2), view.Version)
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	svc := NewService(inmem.NewBucketStore())
+	ctx := testContext("TestOwner")
+
+	_, err := svc.Open(ctx, &bucket.OpenRequest{ID: "VerifiedID", Title: "Verified"})
+	require.Nil(t, err)
+
+	_, err = svc.Update(ctx, &bucket.UpdateRequest{ID: "VerifiedID", Title: "Still Verified"})
+	require.Nil(t, err)
+
+	require.Nil(t, svc.Verify(ctx, "VerifiedID"))
+
+	err = svc.Verify(testContext("Stranger"), "VerifiedID")
+	require.NotNil(t, err)
+	require.Equal(t, errors.KindNotFound, err.(*errors.Error).Kind, "stranger should not tell existing bucket from missing")
+	require.Equal(t, "Entity not found", err.(*errors.Error).Msg)
+
+	err = svc.Verify(ctx, "MissingID")
+	require.NotNil(t, err)
+	require.Equal(t, errors.KindNotFound, err.(*errors.Error).Kind)
+	require.Equal(t, "Entity not found", err.(*errors.Error).Msg)
+}
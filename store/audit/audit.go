@@ -0,0 +1,51 @@
+// Package audit checks the integrity of stored event streams
+package audit
+
+import (
+	"context"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/tenant"
+)
+
+// Finding is a stream which failed the verification
+type Finding struct {
+	Stream bucket.StreamRef
+	Err    error // reason of the failure, e.g. broken hash chain or undecodable event
+}
+
+// Scan verifies the hash chains of all streams of all tenants in the store and returns the streams failing it.
+// Store has to implement bucket.Scanner and bucket.Verifier
+func Scan(ctx context.Context, s bucket.Store) ([]Finding, error) {
+	const op errors.Op = "audit.Scan"
+
+	scanner, ok := bucket.As[bucket.Scanner](s)
+	if !ok {
+		return nil, errors.New(op, errors.KindUnexpected, "Store does not support scanning")
+	}
+
+	verifier, ok := bucket.As[bucket.Verifier](s)
+	if !ok {
+		return nil, errors.New(op, errors.KindUnexpected, "Store does not support verification")
+	}
+
+	refs, err := scanner.Streams(ctx)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not list streams", err)
+	}
+
+	ret := []Finding{}
+
+	for _, ref := range refs {
+		if err := ctx.Err(); err != nil {
+			return ret, errors.New(op, errors.KindUnexpected, "Scan cancelled", err)
+		}
+
+		if err := verifier.VerifyStream(tenant.NewContext(ctx, ref.Tenant), ref.ID); err != nil {
+			ret = append(ret, Finding{Stream: ref, Err: err})
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/juelko/bucket/store/inmem"
+	"github.com/stretchr/testify/require"
+)
+
+// brokenStore reports broken hash chain for the streams in broken
+type brokenStore struct {
+	bucket.Store
+	broken map[bucket.StreamRef]bool
+}
+
+func (s *brokenStore) Streams(ctx context.Context) ([]bucket.StreamRef, error) {
+	return s.Store.(bucket.Scanner).Streams(ctx)
+}
+
+func (s *brokenStore) VerifyStream(ctx context.Context, id events.EntityID) error {
+	if s.broken[bucket.StreamRef{Tenant: tenant.Of(ctx), ID: id}] {
+		return errors.New(errors.Op("test"), errors.KindUnexpected, "Hash chain broken at version 1")
+	}
+	return s.Store.(bucket.Verifier).VerifyStream(ctx, id)
+}
+
+func TestScan(t *testing.T) {
+	t.Parallel()
+
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	store := inmem.NewBucketStore()
+
+	for _, ctx := range []context.Context{ctx, tenant.NewContext(ctx, "Other")} {
+		for _, id := range []events.EntityID{"FirstID", "SecondID"} {
+			require.Nil(t, store.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: id, V: 1}, BucketData: bucket.BucketData{Title: "TestTitle"}, Owner: "TestOwner"}))
+		}
+	}
+
+	findings, err := Scan(ctx, store)
+	require.Nil(t, err)
+	require.Empty(t, findings)
+
+	broken := bucket.StreamRef{Tenant: "Other", ID: "SecondID"}
+
+	findings, err = Scan(ctx, &brokenStore{Store: store, broken: map[bucket.StreamRef]bool{broken: true}})
+	require.Nil(t, err)
+	require.Len(t, findings, 1)
+	require.Equal(t, broken, findings[0].Stream)
+
+	_, err = Scan(ctx, struct{ bucket.Store }{store})
+	require.NotNil(t, err, "store without scanning should fail")
+}
@@ -0,0 +1,94 @@
+// Package backup exports the streams of a bucket.Store to a portable archive and imports them to any store.
+//
+// The archive is JSON Lines. The first line is a header, each stream is on its own line with
+// the checksum of its records and the last line is a trailer with the number of streams and
+// the checksum over all stream checksums, so changed, missing and truncated streams are detected:
+//
+//	{"format":"bucket-export/v1","created":"2021-02-03T04:05:06Z"}
+//	{"tenant":"default","id":"...","records":[...],"sha256":"..."}
+//	{"streams":1,"sha256":"..."}
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/juelko/bucket/store/codec"
+)
+
+// Format is the format identifier in the header of archives
+const Format = "bucket-export/v1"
+
+// header is the first line of the archive
+type header struct {
+	Format  string    `json:"format"`
+	Created time.Time `json:"created"`
+}
+
+// stream is a line of the archive with one stream
+type stream struct {
+	Tenant  tenant.ID       `json:"tenant"`
+	ID      events.EntityID `json:"id"`
+	Records []codec.Record  `json:"records"`
+	Sha256  string          `json:"sha256"`
+}
+
+// trailer is the last line of the archive
+type trailer struct {
+	Streams int    `json:"streams"`
+	Sha256  string `json:"sha256"`
+}
+
+// line has the fields of all line types, so lines can be decoded before knowing their type
+type line struct {
+	Format  string          `json:"format"`
+	Created time.Time       `json:"created"`
+	Tenant  tenant.ID       `json:"tenant"`
+	ID      events.EntityID `json:"id"`
+	Records []codec.Record  `json:"records"`
+	Streams *int            `json:"streams"`
+	Sha256  string          `json:"sha256"`
+}
+
+// Encrypted is implemented by stores encrypting fields of the events they write, like the store of package shred,
+// and is found from the decorators around them with bucket.As.
+// Streams are exported and compared from the store returned by Encrypted, as the hash chains are computed
+// over the encrypted values. The archive keeps them encrypted, so forgetting a bucket forgets it from the
+// archive too. Encrypted values are restored with bucket.Importer, as the other writes encrypt every value
+type Encrypted interface {
+	Encrypted() bucket.Store
+}
+
+// stored returns the store having the events as they are stored
+func stored(s bucket.Store) bucket.Store {
+	if e, ok := bucket.As[Encrypted](s); ok {
+		return e.Encrypted()
+	}
+	return s
+}
+
+// Summary tells what was exported or imported
+type Summary struct {
+	Streams    int // streams in the archive
+	Events     int // events in the archive
+	Imported   int // streams written to the store, including resumed streams
+	Skipped    int // streams existing in the store and left untouched
+	Tombstones int // purged streams, which can not be replayed and are not imported
+}
+
+// checksum returns hex encoded SHA-256 of the JSON encoded records
+func checksum(records []codec.Record) (string, error) {
+	b, err := json.Marshal(records)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,309 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/juelko/bucket/store/inmem"
+	"github.com/juelko/bucket/store/shred"
+	"github.com/stretchr/testify/require"
+)
+
+// testStore returns store with open, archived and purged streams in two tenants
+func testStore(t *testing.T) bucket.Store {
+	s := inmem.NewBucketStore()
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	other := tenant.NewContext(ctx, "Other")
+	base := func(id events.EntityID, v events.EntityVersion) events.Base {
+		return events.Base{ID: id, V: v}
+	}
+
+	require.Nil(t, s.OpenStream(ctx, &bucket.Opened{Base: base("OpenID", 1), BucketData: bucket.BucketData{Title: "Open", Description: "Open Description"}, Owner: "TestOwner"}))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.ItemAdded{Base: base("OpenID", 2), Item: bucket.Item{ID: "Item1", Name: "Item", Payload: bucket.Payload("data")}}))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.TagAdded{Base: base("OpenID", 3), Tag: "team:a"}))
+
+	require.Nil(t, s.OpenStream(other, &bucket.Opened{Base: base("ArchivedID", 1), BucketData: bucket.BucketData{Title: "Archived"}, Owner: "TestOwner"}))
+	require.Nil(t, s.InsertEvent(other, &bucket.Closed{Base: base("ArchivedID", 2)}))
+	require.Nil(t, s.(bucket.Archiver).ArchiveStream(other, &bucket.Archived{Base: base("ArchivedID", 3)}))
+
+	require.Nil(t, s.OpenStream(other, &bucket.Opened{Base: base("PurgedID", 1), BucketData: bucket.BucketData{Title: "Purged"}, Owner: "TestOwner"}))
+	require.Nil(t, s.(bucket.Archiver).PurgeStream(other, &bucket.Purged{Base: base("PurgedID", 2)}))
+
+	return s
+}
+
+func export(t *testing.T, s bucket.Store) []byte {
+	var buf bytes.Buffer
+
+	sum, err := Export(tenant.NewContext(context.Background(), tenant.Default), s, &buf)
+	require.Nil(t, err)
+	require.Equal(t, Summary{Streams: 3, Events: 7}, sum)
+
+	return buf.Bytes()
+}
+
+// views returns the views of the streams, which are compared instead of events having store times and hashes
+func views(t *testing.T, s bucket.Store) map[bucket.StreamRef]*bucket.View {
+	scanner, _ := bucket.As[bucket.Scanner](s)
+	refs, err := scanner.Streams(tenant.NewContext(context.Background(), tenant.Default))
+	require.Nil(t, err)
+
+	ret := map[bucket.StreamRef]*bucket.View{}
+	for _, ref := range refs {
+		stream, err := s.GetStream(tenant.NewContext(context.Background(), ref.Tenant), ref.ID)
+		require.Nil(t, err)
+
+		v, err := bucket.NewView(ref.ID, stream...)
+		require.Nil(t, err)
+		ret[ref] = v
+	}
+
+	return ret
+}
+
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	src := testStore(t)
+	archive := export(t, src)
+
+	dst := inmem.NewBucketStore()
+
+	sum, err := Import(tenant.NewContext(context.Background(), tenant.Default), dst, bytes.NewReader(archive), ImportOptions{})
+	require.Nil(t, err)
+	require.Equal(t, Summary{Streams: 3, Events: 7, Imported: 2, Tombstones: 1}, sum)
+
+	want := views(t, src)
+	delete(want, bucket.StreamRef{Tenant: "Other", ID: "PurgedID"})
+	require.Equal(t, want, views(t, dst))
+
+	stream, err := dst.GetStream(tenant.NewContext(context.Background(), tenant.Default), "OpenID")
+	require.Nil(t, err)
+	require.Nil(t, events.Verify(stream), "target store should chain imported events")
+
+	original, err := src.GetStream(tenant.NewContext(context.Background(), tenant.Default), "OpenID")
+	require.Nil(t, err)
+	require.Len(t, stream, len(original))
+	for i := range original {
+		require.True(t, original[i].Timestamp().Equal(stream[i].Timestamp()), "import should keep the store time of version %d", i+1)
+		require.Equal(t, original[i].Hash(), stream[i].Hash(), "import should keep the hash chain")
+	}
+}
+
+func TestShreddedRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	keys := shred.NewKeyStore()
+	src := shred.NewBucketStore(inmem.NewBucketStore(), keys)
+
+	require.Nil(t, src.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: "KeptID", V: 1}, BucketData: bucket.BucketData{Title: "Kept", Description: "Personal data"}, Owner: "TestOwner"}))
+	require.Nil(t, src.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: "ForgottenID", V: 1}, BucketData: bucket.BucketData{Title: "Forgotten", Description: "Personal data"}, Owner: "TestOwner"}))
+	require.Nil(t, src.(bucket.Shredder).ShredStream(ctx, &bucket.Forgotten{Base: events.Base{ID: "ForgottenID", V: 2}}))
+
+	var buf bytes.Buffer
+	_, err := Export(ctx, src, &buf)
+	require.Nil(t, err)
+	require.NotContains(t, buf.String(), "Personal data", "archive should keep the fields encrypted")
+
+	dst := shred.NewBucketStore(inmem.NewBucketStore(), keys)
+
+	sum, err := Import(ctx, dst, bytes.NewReader(buf.Bytes()), ImportOptions{})
+	require.Nil(t, err)
+	require.Equal(t, 2, sum.Imported)
+	require.Equal(t, views(t, src), views(t, dst))
+
+	stream, err := dst.GetStream(ctx, "KeptID")
+	require.Nil(t, err)
+	require.Equal(t, bucket.Description("Personal data"), stream[0].(*bucket.Opened).Description)
+
+	stream, err = dst.GetStream(ctx, "ForgottenID")
+	require.Nil(t, err)
+	require.Equal(t, bucket.Description(""), stream[0].(*bucket.Opened).Description, "restore should not bring back forgotten data")
+
+	sum, err = Import(ctx, dst, bytes.NewReader(buf.Bytes()), ImportOptions{Conflict: ConflictResume})
+	require.Nil(t, err)
+	require.Equal(t, 2, sum.Imported, "restored streams should be equal to the archived")
+}
+
+func TestImportConflicts(t *testing.T) {
+	t.Parallel()
+
+	archive := export(t, testStore(t))
+
+	testCases := []struct {
+		desc     string
+		opts     ImportOptions
+		existing func(s bucket.Store)
+		want     Summary
+		kind     errors.Kind
+		length   int // length of OpenID stream after import
+	}{
+		{
+			desc: "abort",
+			opts: ImportOptions{Conflict: ConflictAbort},
+			existing: func(s bucket.Store) {
+				s.OpenStream(tenant.NewContext(context.Background(), tenant.Default), &bucket.Opened{Base: events.Base{ID: "OpenID", V: 1}, BucketData: bucket.BucketData{Title: "Other"}, Owner: "TestOwner"})
+			},
+			want: Summary{Streams: 3, Events: 7, Imported: 1, Tombstones: 1},
+			kind: errors.KindAllreadyExists,
+		},
+		{
+			desc: "skip",
+			opts: ImportOptions{Conflict: ConflictSkip},
+			existing: func(s bucket.Store) {
+				s.OpenStream(tenant.NewContext(context.Background(), tenant.Default), &bucket.Opened{Base: events.Base{ID: "OpenID", V: 1}, BucketData: bucket.BucketData{Title: "Other"}, Owner: "TestOwner"})
+			},
+			want:   Summary{Streams: 3, Events: 7, Imported: 1, Skipped: 1, Tombstones: 1},
+			length: 1,
+		},
+		{
+			desc: "resume",
+			opts: ImportOptions{Conflict: ConflictResume},
+			existing: func(s bucket.Store) {
+				s.OpenStream(tenant.NewContext(context.Background(), tenant.Default), &bucket.Opened{Base: events.Base{ID: "OpenID", V: 1}, BucketData: bucket.BucketData{Title: "Open", Description: "Open Description"}, Owner: "TestOwner"})
+			},
+			want:   Summary{Streams: 3, Events: 7, Imported: 2, Tombstones: 1},
+			length: 3,
+		},
+		{
+			desc: "resume differing",
+			opts: ImportOptions{Conflict: ConflictResume},
+			existing: func(s bucket.Store) {
+				s.OpenStream(tenant.NewContext(context.Background(), tenant.Default), &bucket.Opened{Base: events.Base{ID: "OpenID", V: 1}, BucketData: bucket.BucketData{Title: "Other"}, Owner: "TestOwner"})
+			},
+			want: Summary{Streams: 3, Events: 7, Imported: 1, Tombstones: 1},
+			kind: errors.KindAllreadyExists,
+		},
+		{
+			desc:     "dry run",
+			opts:     ImportOptions{DryRun: true},
+			existing: func(s bucket.Store) {},
+			want:     Summary{Streams: 3, Events: 7, Imported: 2, Tombstones: 1},
+		},
+	}
+
+	for i := range testCases {
+		tC := testCases[i]
+
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dst := inmem.NewBucketStore()
+			tC.existing(dst)
+
+			sum, err := Import(tenant.NewContext(context.Background(), tenant.Default), dst, bytes.NewReader(archive), tC.opts)
+			require.Equal(t, tC.want, sum)
+
+			if tC.kind != 0 {
+				require.NotNil(t, err)
+				require.Equal(t, tC.kind, err.(*errors.Error).Kind)
+				return
+			}
+			require.Nil(t, err)
+
+			if tC.opts.DryRun {
+				refs, err := dst.(bucket.Scanner).Streams(tenant.NewContext(context.Background(), tenant.Default))
+				require.Nil(t, err)
+				require.Empty(t, refs, "dry run should not write")
+				return
+			}
+
+			stream, err := dst.GetStream(tenant.NewContext(context.Background(), tenant.Default), "OpenID")
+			require.Nil(t, err)
+			require.Len(t, stream, tC.length)
+		})
+	}
+}
+
+func TestImportCorrupted(t *testing.T) {
+	t.Parallel()
+
+	archive := export(t, testStore(t))
+
+	lines := func() []string {
+		ret := []string{}
+		s := bufio.NewScanner(bytes.NewReader(archive))
+		s.Buffer(nil, len(archive))
+		for s.Scan() {
+			ret = append(ret, s.Text())
+		}
+		return ret
+	}
+
+	// rehashed changes the stream on the line and updates its checksum, but not the trailer
+	rehashed := func(i int, f func(l *stream)) []string {
+		ls := lines()
+		var l stream
+		require.Nil(t, json.Unmarshal([]byte(ls[i]), &l))
+		f(&l)
+		sum, err := checksum(l.Records)
+		require.Nil(t, err)
+		l.Sha256 = sum
+		b, err := json.Marshal(l)
+		require.Nil(t, err)
+		ls[i] = string(b)
+		return ls
+	}
+
+	testCases := []struct {
+		desc  string
+		lines []string
+		msg   string
+	}{
+		{
+			desc: "checksum",
+			lines: func() []string {
+				ls := lines()
+				ls[3] = strings.Replace(ls[3], "bucket.TagAdded", "bucket.TagRemoved", 1)
+				return ls
+			}(),
+			msg: "Invalid stream OpenID",
+		},
+		{
+			desc: "hash chain",
+			lines: rehashed(3, func(l *stream) {
+				l.Records[0].Data = []byte(strings.Replace(string(l.Records[0].Data), "Open Description", "Changed", 1))
+			}),
+			msg: "Invalid stream OpenID",
+		},
+		{
+			desc:  "stream removed",
+			lines: func() []string { ls := lines(); return append(ls[:1], ls[2:]...) }(),
+			msg:   "Archive checksum mismatch",
+		},
+		{
+			desc:  "truncated",
+			lines: lines()[:3],
+			msg:   "Archive is truncated",
+		},
+		{
+			desc:  "format",
+			lines: append([]string{`{"format":"other"}`}, lines()[1:]...),
+			msg:   "Unsupported format: other",
+		},
+	}
+
+	for i := range testCases {
+		tC := testCases[i]
+
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dst := inmem.NewBucketStore()
+
+			_, err := Import(tenant.NewContext(context.Background(), tenant.Default), dst, strings.NewReader(strings.Join(tC.lines, "\n")), ImportOptions{DryRun: true})
+			require.NotNil(t, err)
+			require.Equal(t, errors.KindValidation, err.(*errors.Error).Kind)
+			require.Equal(t, tC.msg, err.(*errors.Error).Msg)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/juelko/bucket/store/codec"
+)
+
+// Export writes all streams of all tenants in the store to w. Store has to implement bucket.Scanner.
+// Events are written as plain JSON records, the archive has to be protected like the store itself.
+// Stores implementing Encrypted are exported with the fields encrypted
+func Export(ctx context.Context, s bucket.Store, w io.Writer) (Summary, error) {
+	const op errors.Op = "backup.Export"
+
+	var sum Summary
+
+	scanner, ok := bucket.As[bucket.Scanner](s)
+	if !ok {
+		return sum, errors.New(op, errors.KindUnexpected, "Store does not support scanning")
+	}
+
+	refs, err := scanner.Streams(ctx)
+	if err != nil {
+		return sum, errors.New(op, errors.KindUnexpected, "Could not list streams", err)
+	}
+
+	enc := json.NewEncoder(w)
+
+	if err := enc.Encode(header{Format: Format, Created: time.Now().UTC()}); err != nil {
+		return sum, errors.New(op, errors.KindUnexpected, "Could not write header", err)
+	}
+
+	total := sha256.New()
+	c := codec.JSON()
+	src := stored(s)
+
+	for _, ref := range refs {
+		tctx := tenant.NewContext(ctx, ref.Tenant)
+
+		events, err := src.GetStream(tctx, ref.ID)
+		if err != nil {
+			return sum, errors.New(op, errors.KindUnexpected, "Could not read stream "+string(ref.ID), err)
+		}
+
+		line := stream{Tenant: ref.Tenant, ID: ref.ID, Records: make([]codec.Record, len(events))}
+
+		for i, e := range events {
+			r, err := c.Encode(tctx, e)
+			if err != nil {
+				return sum, errors.New(op, errors.KindUnexpected, "Could not encode stream "+string(ref.ID), err)
+			}
+			line.Records[i] = r
+		}
+
+		if line.Sha256, err = checksum(line.Records); err != nil {
+			return sum, errors.New(op, errors.KindUnexpected, "Could not compute checksum", err)
+		}
+
+		if err := enc.Encode(line); err != nil {
+			return sum, errors.New(op, errors.KindUnexpected, "Could not write stream "+string(ref.ID), err)
+		}
+
+		total.Write([]byte(line.Sha256))
+		sum.Streams++
+		sum.Events += len(events)
+	}
+
+	if err := enc.Encode(trailer{Streams: sum.Streams, Sha256: hex.EncodeToString(total.Sum(nil))}); err != nil {
+		return sum, errors.New(op, errors.KindUnexpected, "Could not write trailer", err)
+	}
+
+	return sum, nil
+}
@@ -0,0 +1,268 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/juelko/bucket/store/codec"
+)
+
+// Conflict tells how Import handles streams which allready exist in the store
+type Conflict int
+
+const (
+	ConflictAbort  Conflict = iota // import stops with KindAllreadyExists error
+	ConflictSkip                   // existing stream is left untouched
+	ConflictResume                 // missing events are appended, if the existing stream is the beginning of the imported one
+)
+
+var conflictStrings = []string{"abort", "skip", "resume"}
+
+func (c Conflict) String() string {
+	if int(c) < 0 || int(c) >= len(conflictStrings) {
+		return "unknown"
+	}
+	return conflictStrings[c]
+}
+
+// ParseConflict returns the Conflict with the name given by Conflict.String
+func ParseConflict(s string) (Conflict, error) {
+	const op errors.Op = "backup.ParseConflict"
+
+	for i, v := range conflictStrings {
+		if v == s {
+			return Conflict(i), nil
+		}
+	}
+
+	return 0, errors.New(op, errors.KindValidation, "Unknown conflict mode: "+s)
+}
+
+// ImportOptions controls Import
+type ImportOptions struct {
+	DryRun   bool     // archive is read and checked against the store, but nothing is written
+	Conflict Conflict // handling of streams allready in the store
+}
+
+// Import replays the streams of the archive read from r to the store, preserving tenants, ids and versions.
+// Checksums and hash chains of the archive are verified before the stream is written. Events keep their store times
+// in stores implementing bucket.Importer, other stores give them new times and need to implement bucket.Archiver
+// for archived streams. Purged streams are counted but not imported.
+// Existing streams of stores implementing Encrypted are compared with the fields encrypted, and the streams are
+// imported as they are in the archive, so these stores have to implement bucket.Importer.
+// Trailer is verified only after all streams are read, so run with DryRun first to find a corrupted archive
+// before writing anything
+func Import(ctx context.Context, s bucket.Store, r io.Reader, opts ImportOptions) (Summary, error) {
+	const op errors.Op = "backup.Import"
+
+	var sum Summary
+
+	dec := json.NewDecoder(r)
+
+	var h line
+	if err := dec.Decode(&h); err != nil {
+		return sum, errors.New(op, errors.KindValidation, "Could not read header", err)
+	}
+
+	if h.Format != Format {
+		return sum, errors.New(op, errors.KindValidation, "Unsupported format: "+h.Format)
+	}
+
+	total := sha256.New()
+
+	for {
+		var l line
+		if err := dec.Decode(&l); err == io.EOF {
+			return sum, errors.New(op, errors.KindValidation, "Archive is truncated")
+		} else if err != nil {
+			return sum, errors.New(op, errors.KindValidation, "Could not read stream", err)
+		}
+
+		if l.Streams != nil {
+			if *l.Streams != sum.Streams || l.Sha256 != hex.EncodeToString(total.Sum(nil)) {
+				return sum, errors.New(op, errors.KindValidation, "Archive checksum mismatch")
+			}
+
+			if dec.More() {
+				return sum, errors.New(op, errors.KindValidation, "Data after trailer")
+			}
+
+			return sum, nil
+		}
+
+		stream, err := read(ctx, &l)
+		if err != nil {
+			return sum, errors.New(op, errors.KindValidation, "Invalid stream "+string(l.ID), err)
+		}
+
+		total.Write([]byte(l.Sha256))
+		sum.Streams++
+		sum.Events += len(stream)
+
+		if _, ok := stream[0].(*bucket.Purged); ok {
+			sum.Tombstones++
+			continue
+		}
+
+		tctx := tenant.NewContext(ctx, l.Tenant)
+
+		from, err := start(tctx, stored(s), l.ID, stream, opts.Conflict)
+		if err != nil {
+			return sum, errors.New(op, errors.KindAllreadyExists, "Conflict with stream "+string(l.ID), err)
+		}
+
+		if from < 0 {
+			sum.Skipped++
+			continue
+		}
+
+		if !opts.DryRun {
+			if err := replay(tctx, s, stream[from:]); err != nil {
+				return sum, errors.New(op, errors.KindUnexpected, "Could not import stream "+string(l.ID), err)
+			}
+		}
+
+		sum.Imported++
+	}
+}
+
+// read verifies the stream line and decodes its events
+func read(ctx context.Context, l *line) ([]events.Event, error) {
+	const op errors.Op = "backup.read"
+
+	if err := l.Tenant.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "Invalid tenant", err)
+	}
+
+	if err := l.ID.Validate(); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "Invalid ID", err)
+	}
+
+	if len(l.Records) == 0 {
+		return nil, errors.New(op, errors.KindValidation, "Empty stream")
+	}
+
+	sum, err := checksum(l.Records)
+	if err != nil || sum != l.Sha256 {
+		return nil, errors.New(op, errors.KindValidation, "Checksum mismatch")
+	}
+
+	c := codec.JSON()
+	ret := make([]events.Event, len(l.Records))
+	chained := true
+
+	for i, r := range l.Records {
+		if r.ID != l.ID {
+			return nil, errors.New(op, errors.KindValidation, "ID mismatch")
+		}
+
+		e, err := c.Decode(ctx, r)
+		if err != nil {
+			return nil, errors.New(op, errors.KindValidation, "Could not decode event", err)
+		}
+
+		ret[i] = e
+		chained = chained && r.Hash != ""
+	}
+
+	// streams exported from stores without hash chains are checked only by the checksum
+	if chained {
+		if err := events.Verify(ret); err != nil {
+			return nil, errors.New(op, errors.KindValidation, "Hash chain broken", err)
+		}
+	}
+
+	if _, err := bucket.NewView(l.ID, ret...); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "Invalid stream", err)
+	}
+
+	return ret, nil
+}
+
+// start returns the index of the first event to import, or -1 if the stream is skipped
+func start(ctx context.Context, s bucket.Store, id events.EntityID, stream []events.Event, c Conflict) (int, error) {
+	const op errors.Op = "backup.start"
+
+	existing, err := s.GetStream(ctx, id)
+	if e, ok := err.(*errors.Error); ok && e.Kind == errors.KindNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.New(op, errors.KindUnexpected, "Could not read existing stream", err)
+	}
+
+	switch c {
+	case ConflictSkip:
+		return -1, nil
+
+	case ConflictResume:
+		if len(existing) > len(stream) {
+			return 0, errors.New(op, errors.KindAllreadyExists, "Existing stream is longer")
+		}
+
+		for i := range existing {
+			if !events.Equal(existing[i], stream[i]) {
+				return 0, errors.New(op, errors.KindAllreadyExists, "Existing stream differs")
+			}
+		}
+
+		return len(existing), nil
+
+	default:
+		return 0, errors.New(op, errors.KindAllreadyExists, "Stream allready exists")
+	}
+}
+
+// replay writes the events to the store, at their store times if the store is bucket.Importer
+func replay(ctx context.Context, s bucket.Store, stream []events.Event) error {
+	const op errors.Op = "backup.replay"
+
+	imp, ok := bucket.As[bucket.Importer](s)
+	if _, enc := bucket.As[Encrypted](s); enc && !ok {
+		return errors.New(op, errors.KindUnexpected, "Encrypting store does not support importing")
+	}
+
+	for _, e := range stream {
+		var err error
+
+		if imp != nil {
+			err = imp.ImportEvent(ctx, e)
+		} else {
+			err = write(ctx, s, e)
+		}
+
+		if err != nil {
+			return errors.New(op, errors.KindUnexpected, fmt.Sprintf("Could not write %s version %d", e.Type(), e.EntityVersion()), err)
+		}
+	}
+
+	return nil
+}
+
+// write writes the event with the operation which wrote it to the source
+func write(ctx context.Context, s bucket.Store, e events.Event) error {
+	const op errors.Op = "backup.write"
+
+	switch e := e.(type) {
+	case *bucket.Opened:
+		return s.OpenStream(ctx, e)
+
+	case *bucket.Archived:
+		a, ok := bucket.As[bucket.Archiver](s)
+		if !ok {
+			return errors.New(op, errors.KindUnexpected, "Store does not support archiving")
+		}
+		return a.ArchiveStream(ctx, e)
+
+	default:
+		return s.InsertEvent(ctx, e)
+	}
+}
@@ -0,0 +1,154 @@
+package bolt
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/juelko/bucket/store/storetest"
+	"github.com/stretchr/testify/require"
+)
+
+func open(t *testing.T, path string, opts ...Option) *Store {
+	s, err := Open(path, opts...)
+	require.Nil(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func opened(id events.EntityID, title bucket.Title) *bucket.Opened {
+	return &bucket.Opened{Base: events.Base{ID: id, V: 1}, BucketData: bucket.BucketData{Title: title}, Owner: "TestOwner"}
+}
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.Check(t, func(t *testing.T, unique bool) storetest.Store {
+		var opts []Option
+		if unique {
+			opts = append(opts, UniqueTitles(true))
+		}
+		return open(t, filepath.Join(t.TempDir(), "bucket.db"), opts...)
+	})
+}
+
+func TestReopen(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "bucket.db")
+	s := open(t, path)
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	other := tenant.NewContext(ctx, "Other")
+
+	require.Nil(t, s.OpenStream(ctx, opened("TestBucket", "TestTitle")))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.ItemAdded{Base: events.Base{ID: "TestBucket", V: 2}, Item: bucket.Item{ID: "Item1", Name: "Item", Payload: bucket.Payload("data")}}))
+	require.Nil(t, s.OpenStream(other, opened("TestBucket", "TestTitle")))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.Closed{Base: events.Base{ID: "TestBucket", V: 3}}))
+	require.Nil(t, s.ArchiveStream(ctx, &bucket.Archived{Base: events.Base{ID: "TestBucket", V: 4}}))
+
+	before, err := s.GetStream(ctx, "TestBucket")
+	require.Nil(t, err)
+	require.Nil(t, s.Close())
+
+	// reopened file has the streams and continues the feed
+	s = open(t, path)
+
+	after, err := s.GetStream(ctx, "TestBucket")
+	require.Nil(t, err)
+	require.Equal(t, before, after)
+	require.Nil(t, s.VerifyStream(ctx, "TestBucket"))
+
+	err = s.InsertEvent(ctx, &bucket.Reopened{Base: events.Base{ID: "TestBucket", V: 5}})
+	require.NotNil(t, err)
+	require.Equal(t, "Stream is archived", err.(*errors.Error).Msg, "archive should survive reopening")
+
+	refs, err := s.Streams(ctx)
+	require.Nil(t, err)
+	require.Equal(t, []bucket.StreamRef{{Tenant: "Other", ID: "TestBucket"}, {Tenant: tenant.Default, ID: "TestBucket"}}, refs)
+
+	require.Nil(t, s.InsertEvent(other, &bucket.Closed{Base: events.Base{ID: "TestBucket", V: 2}}))
+
+	entries, err := s.ReadFeed(ctx, 4, 10)
+	require.Nil(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, uint64(6), entries[1].Position)
+	require.Equal(t, tenant.ID("Other"), entries[1].Tenant)
+}
+
+func TestHeldTitles(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "bucket.db")
+	s := open(t, path, UniqueTitles(true))
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+	require.Nil(t, s.OpenStream(ctx, opened("FirstBucket", "Title")))
+	require.Nil(t, s.OpenStream(ctx, opened("SecondBucket", "Other")))
+
+	// held titles are read from the file
+	require.Nil(t, s.Close())
+
+	s = open(t, path, UniqueTitles(true))
+
+	require.Nil(t, s.InsertEvent(ctx, &bucket.Updated{Base: events.Base{ID: "FirstBucket", V: 2}, BucketData: bucket.BucketData{Title: "Renamed"}}))
+	require.Nil(t, s.OpenStream(ctx, opened("ThirdBucket", "TITLE")), "old title should be released")
+
+	require.Nil(t, s.PurgeStream(ctx, &bucket.Purged{Base: events.Base{ID: "SecondBucket", V: 2}}))
+	require.Nil(t, s.OpenStream(ctx, opened("FourthBucket", "other")), "purge should release title")
+
+	err := s.OpenStream(ctx, opened("FifthBucket", "renamed"))
+	require.NotNil(t, err)
+	require.Equal(t, &bucket.TitleConflict{ID: "FirstBucket"}, err.(*errors.Error).Wraps)
+}
+
+func TestCompact(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "bucket.db")
+	dst := filepath.Join(dir, "compacted.db")
+
+	s, err := Open(src)
+	require.Nil(t, err)
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+	require.Nil(t, s.OpenStream(ctx, opened("KeptBucket", "Kept")))
+	require.Nil(t, s.OpenStream(ctx, opened("PurgedBucket", "Purged")))
+	for v := events.EntityVersion(2); v <= 100; v++ {
+		require.Nil(t, s.InsertEvent(ctx, &bucket.ItemAdded{Base: events.Base{ID: "PurgedBucket", V: v}, Item: bucket.Item{ID: bucket.ItemID(fmt.Sprint(v)), Name: "Item", Payload: make([]byte, 1000)}}))
+	}
+	require.Nil(t, s.PurgeStream(ctx, &bucket.Purged{Base: events.Base{ID: "PurgedBucket", V: 101}}))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.Closed{Base: events.Base{ID: "KeptBucket", V: 2}}))
+
+	before, err := s.ReadFeed(ctx, 0, 1000)
+	require.Nil(t, err)
+
+	_, err = Compact(src, dst)
+	require.NotNil(t, err, "source should be locked while open")
+	require.Nil(t, s.Close())
+
+	r, err := Compact(src, dst)
+	require.Nil(t, err)
+	require.Equal(t, 100, r.Pruned)
+	require.True(t, r.After < r.Before, "compacted file should be smaller")
+
+	_, err = Compact(src, dst)
+	require.NotNil(t, err)
+	require.Equal(t, errors.KindAllreadyExists, err.(*errors.Error).Kind)
+
+	s = open(t, dst)
+
+	after, err := s.ReadFeed(ctx, 0, 1000)
+	require.Nil(t, err)
+	require.Equal(t, before, after, "positions should not change")
+
+	require.Nil(t, s.InsertEvent(ctx, &bucket.Reopened{Base: events.Base{ID: "KeptBucket", V: 3}}))
+	entries, err := s.ReadFeed(ctx, after[len(after)-1].Position, 10)
+	require.Nil(t, err)
+	require.Equal(t, uint64(104), entries[0].Position, "sequence should continue")
+}
@@ -0,0 +1,617 @@
+// Package bolt provides bucket.Store persisting the event streams to a file with the embedded bbolt database.
+//
+// Each stream has its own bbolt bucket with the events keyed by version. Every append runs in one
+// transaction, which checks the version, writes the event, increments the global sequence and adds
+// the event to the feed, so a crash leaves either all or none of them. The feed keeps only references
+// to the events, and references to purged events are removed by Compact.
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/juelko/bucket/store/codec"
+	"github.com/juelko/bucket/store/internal/backend"
+)
+
+// Format of the database file, written when the file is created
+const Format = "bucket-bolt/v1"
+
+// top level bbolt buckets
+var (
+	metaBucket     = []byte("meta")     // format and sequence
+	streamsBucket  = []byte("streams")  // bucket of each stream, events by version
+	archivedBucket = []byte("archived") // names of the archived streams
+	feedBucket     = []byte("feed")     // references to the events by sequence
+	tagsBucket     = []byte("tags")     // tag index, tenant, tag and id
+	titlesBucket   = []byte("titles")   // title reservations, tenant and title to id
+	heldBucket     = []byte("held")     // title reservation of each stream, name of the stream to key in titles
+
+	formatKey   = []byte("format")
+	sequenceKey = []byte("sequence") // position of the last event in the feed
+)
+
+// Option configures the store
+type Option func(*Store)
+
+// UniqueTitles makes store to reserve bucket titles per tenant, see inmem.UniqueTitles
+func UniqueTitles(ignoreCase bool) Option {
+	return func(s *Store) {
+		s.uniqueTitles = true
+		s.ignoreCase = ignoreCase
+	}
+}
+
+// Encoding sets the codec of the stored events, codec.JSON by default
+func Encoding(c codec.Codec) Option {
+	return func(s *Store) {
+		s.codec = c
+	}
+}
+
+// Store keeps the streams in bbolt database file. It implements bucket.Store
+// with bucket.TagIndex, bucket.Archiver, bucket.Importer, bucket.Verifier, bucket.Scanner and bucket.Feed
+type Store struct {
+	db           *bbolt.DB
+	codec        codec.Codec
+	uniqueTitles bool
+	ignoreCase   bool
+}
+
+// Open opens the database file at path, creating it if it does not exist.
+// File is locked until Close, and Open fails if other process has it open
+func Open(path string, opts ...Option) (*Store, error) {
+	const op errors.Op = "bolt.Open"
+
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not open database", err)
+	}
+
+	s := &Store{db: db, codec: codec.JSON()}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if err := s.init(); err != nil {
+		db.Close()
+		return nil, errors.New(op, errors.KindUnexpected, "Could not initialize database", err)
+	}
+
+	return s, nil
+}
+
+// init creates the top level buckets and checks the format of existing file
+func (s *Store) init() error {
+	const op errors.Op = "bolt.Store.init"
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{metaBucket, streamsBucket, archivedBucket, feedBucket, tagsBucket, titlesBucket, heldBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		meta := tx.Bucket(metaBucket)
+
+		switch f := meta.Get(formatKey); {
+		case f == nil:
+			return meta.Put(formatKey, []byte(Format))
+		case string(f) != Format:
+			return errors.New(op, errors.KindUnexpected, "Unsupported format: "+string(f))
+		}
+
+		return nil
+	})
+}
+
+// Close closes the database file
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// feedRef refers to the event in the stream, so purged events are not kept in the feed
+type feedRef struct {
+	Tenant  tenant.ID            `json:"t"`
+	ID      events.EntityID      `json:"id"`
+	Version events.EntityVersion `json:"v"`
+}
+
+func (s *Store) OpenStream(ctx context.Context, o *bucket.Opened) error {
+	const op errors.Op = "bolt.Store.OpenStream"
+
+	return s.open(ctx, op, o, time.Now())
+}
+
+// open starts the stream with the event stored at given time
+func (s *Store) open(ctx context.Context, op errors.Op, o *bucket.Opened, at time.Time) error {
+	k, err := backend.KeyOf(ctx, o.EntityID())
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if tx.Bucket(streamsBucket).Bucket([]byte(k.Name())) != nil {
+			return errors.New(op, errors.KindAllreadyExists, "Allready exists")
+		}
+
+		if err := s.reserve(tx, k, "", o.Title); err != nil {
+			return errors.New(op, errors.KindAllreadyExists, "Title allready in use", err)
+		}
+
+		return s.append(ctx, tx, k, o, at)
+	})
+}
+
+func (s *Store) InsertEvent(ctx context.Context, e events.Event) error {
+	const op errors.Op = "bolt.Store.InsertEvent"
+
+	return s.insert(ctx, op, e, time.Now())
+}
+
+// insert appends the event stored at given time to the stream
+func (s *Store) insert(ctx context.Context, op errors.Op, e events.Event, at time.Time) error {
+	k, err := backend.KeyOf(ctx, e.EntityID())
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := s.check(tx, op, k, e.EntityVersion()); err != nil {
+			return err
+		}
+
+		if u, ok := e.(*bucket.Updated); ok {
+			if err := s.reserve(tx, k, s.title(tx, k), u.Title); err != nil {
+				return errors.New(op, errors.KindAllreadyExists, "Title allready in use", err)
+			}
+		}
+
+		return s.append(ctx, tx, k, e, at)
+	})
+}
+
+// check returns error if the stream can not be appended with the version
+func (s *Store) check(tx *bbolt.Tx, op errors.Op, k backend.Key, v events.EntityVersion) error {
+	if tx.Bucket(archivedBucket).Get([]byte(k.Name())) != nil {
+		return errors.New(op, errors.KindExpected, "Stream is archived")
+	}
+
+	b := tx.Bucket(streamsBucket).Bucket([]byte(k.Name()))
+	if b == nil {
+		return errors.New(op, errors.KindNotFound, "Stream not found")
+	}
+
+	last, _ := b.Cursor().Last()
+	if events.EntityVersion(binary.BigEndian.Uint64(last))+1 != v {
+		return errors.New(op, errors.KindUnexpected, "version error")
+	}
+
+	return nil
+}
+
+// append writes the event stored at given time chained to the last event of the stream,
+// and adds it to the feed and the tag index
+func (s *Store) append(ctx context.Context, tx *bbolt.Tx, k backend.Key, e events.Event, at time.Time) error {
+	const op errors.Op = "bolt.Store.append"
+
+	b, err := tx.Bucket(streamsBucket).CreateBucketIfNotExists([]byte(k.Name()))
+	if err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not create stream", err)
+	}
+
+	prev := ""
+	if _, last := b.Cursor().Last(); last != nil {
+		var r codec.Record
+		if err := json.Unmarshal(last, &r); err != nil {
+			return errors.New(op, errors.KindUnexpected, "decoding error", err)
+		}
+		prev = r.Hash
+	}
+
+	r, err := backend.Record(ctx, s.codec, prev, at, e)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return errors.New(op, errors.KindUnexpected, "encoding error", err)
+	}
+
+	if err := b.Put(itob(uint64(e.EntityVersion())), data); err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not write event", err)
+	}
+
+	meta := tx.Bucket(metaBucket)
+
+	var seq uint64
+	if v := meta.Get(sequenceKey); v != nil {
+		seq = binary.BigEndian.Uint64(v)
+	}
+	seq++
+
+	ref, err := json.Marshal(feedRef{Tenant: k.Tenant, ID: k.ID, Version: e.EntityVersion()})
+	if err != nil {
+		return errors.New(op, errors.KindUnexpected, "encoding error", err)
+	}
+
+	if err := meta.Put(sequenceKey, itob(seq)); err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not write sequence", err)
+	}
+
+	if err := tx.Bucket(feedBucket).Put(itob(seq), ref); err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not write feed", err)
+	}
+
+	return s.index(tx, k, e)
+}
+
+// tagKey returns key of the tag index, or its prefix for all ids if id is empty
+func tagKey(t tenant.ID, tag bucket.Tag, id events.EntityID) []byte {
+	return []byte(string(t) + "\x00" + string(tag) + "\x00" + string(id))
+}
+
+// index updates tag index with the tag events
+func (s *Store) index(tx *bbolt.Tx, k backend.Key, e events.Event) error {
+	tags := tx.Bucket(tagsBucket)
+
+	switch e := e.(type) {
+	case *bucket.TagAdded:
+		return tags.Put(tagKey(k.Tenant, e.Tag, k.ID), []byte{})
+
+	case *bucket.TagRemoved:
+		return tags.Delete(tagKey(k.Tenant, e.Tag, k.ID))
+	}
+
+	return nil
+}
+
+func (s *Store) titleKey(t tenant.ID, title bucket.Title) []byte {
+	if s.ignoreCase {
+		title = bucket.Title(strings.ToLower(string(title)))
+	}
+	return []byte(string(t) + "\x00" + string(title))
+}
+
+// reserve moves the title reservation of the stream from one title to another.
+// Returns *bucket.TitleConflict if other bucket of the tenant has the title
+func (s *Store) reserve(tx *bbolt.Tx, k backend.Key, from, to bucket.Title) error {
+	if !s.uniqueTitles {
+		return nil
+	}
+
+	titles := tx.Bucket(titlesBucket)
+
+	if id := titles.Get(s.titleKey(k.Tenant, to)); id != nil && events.EntityID(id) != k.ID {
+		return &bucket.TitleConflict{ID: events.EntityID(id)}
+	}
+
+	if from != "" {
+		if err := titles.Delete(s.titleKey(k.Tenant, from)); err != nil {
+			return err
+		}
+	}
+
+	if err := titles.Put(s.titleKey(k.Tenant, to), []byte(k.ID)); err != nil {
+		return err
+	}
+
+	return tx.Bucket(heldBucket).Put([]byte(k.Name()), s.titleKey(k.Tenant, to))
+}
+
+// title returns the title reserved for the stream, in the form it is reserved in
+func (s *Store) title(tx *bbolt.Tx, k backend.Key) bucket.Title {
+	key := tx.Bucket(heldBucket).Get([]byte(k.Name()))
+	if key == nil {
+		return ""
+	}
+
+	return bucket.Title(key[len(k.Tenant)+1:])
+}
+
+// release removes title reservation and tags of the stream
+func (s *Store) release(tx *bbolt.Tx, k backend.Key) error {
+	held := tx.Bucket(heldBucket)
+
+	if key := held.Get([]byte(k.Name())); key != nil {
+		if err := tx.Bucket(titlesBucket).Delete(key); err != nil {
+			return err
+		}
+		if err := held.Delete([]byte(k.Name())); err != nil {
+			return err
+		}
+	}
+
+	tags := tx.Bucket(tagsBucket)
+	prefix := []byte(string(k.Tenant) + "\x00")
+	suffix := []byte("\x00" + string(k.ID))
+	found := [][]byte{}
+
+	// deleting while iterating would skip keys
+	c := tags.Cursor()
+	for key, _ := c.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = c.Next() {
+		if bytes.HasSuffix(key, suffix) && bytes.Count(key, []byte{0}) == 2 {
+			found = append(found, append([]byte(nil), key...))
+		}
+	}
+
+	for _, key := range found {
+		if err := tags.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) FindByTags(ctx context.Context, tags []bucket.Tag, match bucket.Match) ([]events.EntityID, error) {
+	const op errors.Op = "bolt.Store.FindByTags"
+
+	t, err := backend.TenantOf(ctx)
+	if err != nil {
+		return nil, errors.New(op, errors.KindValidation, "Invalid tenant", err)
+	}
+
+	counts := map[events.EntityID]int{}
+
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(tagsBucket).Cursor()
+
+		for _, tag := range tags {
+			prefix := tagKey(t, tag, "")
+			for key, _ := c.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = c.Next() {
+				counts[events.EntityID(key[len(prefix):])]++
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not read tag index", err)
+	}
+
+	ret := []events.EntityID{}
+	for id, n := range counts {
+		if match == bucket.MatchAny || n == len(tags) {
+			ret = append(ret, id)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+
+	return ret, nil
+}
+
+func (s *Store) GetStream(ctx context.Context, id events.EntityID) ([]events.Event, error) {
+	const op errors.Op = "bolt.Store.GetStream"
+
+	k, err := backend.KeyOf(ctx, id)
+	if err != nil {
+		return []events.Event{}, err
+	}
+
+	var ret []events.Event
+
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(streamsBucket).Bucket([]byte(k.Name()))
+		if b == nil {
+			return errors.New(op, errors.KindNotFound, "Stream not found")
+		}
+
+		return b.ForEach(func(_, data []byte) error {
+			e, err := s.decode(ctx, data)
+			if err != nil {
+				return errors.New(op, errors.KindUnexpected, "decoding error", err)
+			}
+			ret = append(ret, e)
+			return nil
+		})
+	})
+	if err != nil {
+		return []events.Event{}, err
+	}
+
+	return ret, nil
+}
+
+func (s *Store) decode(ctx context.Context, data []byte) (events.Event, error) {
+	var r codec.Record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+
+	return s.codec.Decode(ctx, r)
+}
+
+func (s *Store) VerifyStream(ctx context.Context, id events.EntityID) error {
+	stream, err := s.GetStream(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return events.Verify(stream)
+}
+
+// Streams returns references to the hot and archived streams of all tenants, sorted by tenant and id
+func (s *Store) Streams(ctx context.Context) ([]bucket.StreamRef, error) {
+	const op errors.Op = "bolt.Store.Streams"
+
+	ret := []bucket.StreamRef{}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(streamsBucket).ForEach(func(name, _ []byte) error {
+			k := backend.ParseName(string(name))
+			ret = append(ret, bucket.StreamRef{Tenant: k.Tenant, ID: k.ID})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not list streams", err)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Tenant != ret[j].Tenant {
+			return ret[i].Tenant < ret[j].Tenant
+		}
+		return ret[i].ID < ret[j].ID
+	})
+
+	return ret, nil
+}
+
+func (s *Store) ReadFeed(ctx context.Context, after uint64, limit int) ([]bucket.FeedEntry, error) {
+	const op errors.Op = "bolt.Store.ReadFeed"
+
+	ret := []bucket.FeedEntry{}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		streams := tx.Bucket(streamsBucket)
+		c := tx.Bucket(feedBucket).Cursor()
+
+		for key, data := c.Seek(itob(after + 1)); key != nil && len(ret) < limit; key, data = c.Next() {
+			var ref feedRef
+			if err := json.Unmarshal(data, &ref); err != nil {
+				return errors.New(op, errors.KindUnexpected, "decoding error", err)
+			}
+
+			k := backend.Key{Tenant: ref.Tenant, ID: ref.ID}
+
+			// purged events are left out
+			b := streams.Bucket([]byte(k.Name()))
+			if b == nil {
+				continue
+			}
+			data := b.Get(itob(uint64(ref.Version)))
+			if data == nil {
+				continue
+			}
+
+			e, err := s.decode(tenant.NewContext(ctx, k.Tenant), data)
+			if err != nil {
+				return errors.New(op, errors.KindUnexpected, "decoding error", err)
+			}
+
+			ret = append(ret, bucket.FeedEntry{Position: binary.BigEndian.Uint64(key), Tenant: k.Tenant, Event: e})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func (s *Store) ArchiveStream(ctx context.Context, e *bucket.Archived) error {
+	const op errors.Op = "bolt.Store.ArchiveStream"
+
+	return s.archive(ctx, op, e, time.Now())
+}
+
+// archive appends the event stored at given time and marks the stream archived
+func (s *Store) archive(ctx context.Context, op errors.Op, e *bucket.Archived, at time.Time) error {
+	k, err := backend.KeyOf(ctx, e.EntityID())
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := s.check(tx, op, k, e.EntityVersion()); err != nil {
+			return err
+		}
+
+		if err := s.append(ctx, tx, k, e, at); err != nil {
+			return err
+		}
+
+		return tx.Bucket(archivedBucket).Put([]byte(k.Name()), []byte{})
+	})
+}
+
+func (s *Store) PurgeStream(ctx context.Context, e *bucket.Purged) error {
+	const op errors.Op = "bolt.Store.PurgeStream"
+
+	return s.purge(ctx, op, e, false)
+}
+
+// ImportTombstone erases the stream like PurgeStream, but the tombstone can skip versions, see bucket.Importer
+func (s *Store) ImportTombstone(ctx context.Context, e *bucket.Purged) error {
+	const op errors.Op = "bolt.Store.ImportTombstone"
+
+	return s.purge(ctx, op, e, true)
+}
+
+// ImportEvent writes the event like OpenStream, InsertEvent or ArchiveStream, but keeps its store time, see bucket.Importer
+func (s *Store) ImportEvent(ctx context.Context, e events.Event) error {
+	const op errors.Op = "bolt.Store.ImportEvent"
+
+	at, err := backend.Imported(op, e)
+	if err != nil {
+		return err
+	}
+
+	switch e := e.(type) {
+	case *bucket.Opened:
+		return s.open(ctx, op, e, at)
+	case *bucket.Archived:
+		return s.archive(ctx, op, e, at)
+	default:
+		return s.insert(ctx, op, e, at)
+	}
+}
+
+// purge replaces the stream with the tombstone, which follows the last event or with skip any earlier event
+func (s *Store) purge(ctx context.Context, op errors.Op, e *bucket.Purged, skip bool) error {
+	k, err := backend.KeyOf(ctx, e.EntityID())
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		streams := tx.Bucket(streamsBucket)
+
+		b := streams.Bucket([]byte(k.Name()))
+		if b == nil {
+			return errors.New(op, errors.KindNotFound, "Stream not found")
+		}
+
+		last, _ := b.Cursor().Last()
+		if err := backend.Tombstone(op, e, events.EntityVersion(binary.BigEndian.Uint64(last)), skip); err != nil {
+			return err
+		}
+
+		if err := s.release(tx, k); err != nil {
+			return errors.New(op, errors.KindUnexpected, "Could not release stream", err)
+		}
+
+		if err := streams.DeleteBucket([]byte(k.Name())); err != nil {
+			return errors.New(op, errors.KindUnexpected, "Could not erase stream", err)
+		}
+
+		if err := tx.Bucket(archivedBucket).Delete([]byte(k.Name())); err != nil {
+			return errors.New(op, errors.KindUnexpected, "Could not erase stream", err)
+		}
+
+		return s.append(ctx, tx, k, e, time.Now())
+	})
+}
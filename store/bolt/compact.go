@@ -0,0 +1,118 @@
+package bolt
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/store/internal/backend"
+)
+
+// CompactReport tells the result of Compact
+type CompactReport struct {
+	Before int64 // size of the source file in bytes
+	After  int64 // size of the compacted file in bytes
+	Pruned int   // references to purged events removed from the feed
+}
+
+// Compact copies the database file src to new file dst, leaving out the pages freed by purged streams
+// and the feed references to purged events. Positions of the remaining events do not change.
+// Source must not be open by other process, and it is left as it is
+func Compact(src, dst string) (CompactReport, error) {
+	const op errors.Op = "bolt.Compact"
+
+	var r CompactReport
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return r, errors.New(op, errors.KindNotFound, "Source not found", err)
+	}
+	r.Before = info.Size()
+
+	if _, err := os.Stat(dst); err == nil {
+		return r, errors.New(op, errors.KindAllreadyExists, "Target allready exists")
+	}
+
+	from, err := bbolt.Open(src, 0600, &bbolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return r, errors.New(op, errors.KindUnexpected, "Could not open source", err)
+	}
+	defer from.Close()
+
+	if err := from.View(func(tx *bbolt.Tx) error {
+		if f := tx.Bucket(metaBucket); f == nil || string(f.Get(formatKey)) != Format {
+			return errors.New(op, errors.KindValidation, "Source is not a bucket store")
+		}
+		return nil
+	}); err != nil {
+		return r, err
+	}
+
+	to, err := bbolt.Open(dst, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return r, errors.New(op, errors.KindUnexpected, "Could not create target", err)
+	}
+
+	if err := bbolt.Compact(to, from, 64<<20); err != nil {
+		to.Close()
+		return r, errors.New(op, errors.KindUnexpected, "Could not copy database", err)
+	}
+
+	if r.Pruned, err = prune(to); err != nil {
+		to.Close()
+		return r, errors.New(op, errors.KindUnexpected, "Could not prune feed", err)
+	}
+
+	if err := to.Close(); err != nil {
+		return r, errors.New(op, errors.KindUnexpected, "Could not close target", err)
+	}
+
+	if info, err := os.Stat(dst); err == nil {
+		r.After = info.Size()
+	}
+
+	return r, nil
+}
+
+// prune removes the feed references to purged events
+func prune(db *bbolt.DB) (int, error) {
+	n := 0
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		streams := tx.Bucket(streamsBucket)
+		feed := tx.Bucket(feedBucket)
+		dangling := [][]byte{}
+
+		err := feed.ForEach(func(key, data []byte) error {
+			var ref feedRef
+			if err := json.Unmarshal(data, &ref); err != nil {
+				return err
+			}
+
+			b := streams.Bucket([]byte(backend.Key{Tenant: ref.Tenant, ID: ref.ID}.Name()))
+			if b == nil || b.Get(itob(uint64(ref.Version))) == nil {
+				dangling = append(dangling, append([]byte(nil), key...))
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range dangling {
+			if err := feed.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		n = len(dangling)
+
+		return nil
+	})
+
+	return n, err
+}
@@ -0,0 +1,400 @@
+// Package chaos provides bucket.Store injecting faults into the calls of another store, for testing how
+// the service behaves when the store is slow or fails midway. Faults are drawn from random numbers of
+// the seed, so the same seed and sequence of calls inject the same faults
+package chaos
+
+import (
+	"context"
+	stderrors "errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+)
+
+// ErrInjected is wrapped by the errors of the injected faults, except by timeouts which wrap context.DeadlineExceeded
+var ErrInjected = stderrors.New("injected fault")
+
+// Op is an operation of the store
+type Op string
+
+const (
+	OpenStream      Op = "OpenStream"
+	InsertEvent     Op = "InsertEvent"
+	GetStream       Op = "GetStream"
+	FindByTags      Op = "FindByTags"
+	ArchiveStream   Op = "ArchiveStream"
+	PurgeStream     Op = "PurgeStream"
+	ImportTombstone Op = "ImportTombstone"
+	ImportEvent     Op = "ImportEvent"
+	ShredStream     Op = "ShredStream"
+	VerifyStream    Op = "VerifyStream"
+	Streams         Op = "Streams"
+	ReadFeed        Op = "ReadFeed"
+)
+
+// Fault is the kind of injected fault
+type Fault int
+
+const (
+	// Latency delays the call by Rule.Delay, or until the context is done
+	Latency Fault = iota + 1
+	// Error fails the call with error of Rule.Kind without calling the store
+	Error
+	// Timeout fails the call without calling the store after Rule.Delay, or when the context is done, as if the context timed out
+	Timeout
+	// PartialWrite fails the write after its event has been appended but before the rest of it is done:
+	// archived stream is not moved to cold storage, purged stream is not erased and data keys of the forgotten
+	// bucket are not destroyed. Writes of single event are applied in full, as with LostAck
+	PartialWrite
+	// LostAck applies the write but fails the call, as if the acknowledgement of the store was lost
+	LostAck
+)
+
+var faultStrings = []string{"Unknown", "Latency", "Error", "Timeout", "PartialWrite", "LostAck"}
+
+func (f Fault) String() string {
+	if f < 1 || int(f) >= len(faultStrings) {
+		return faultStrings[0]
+	}
+	return faultStrings[f]
+}
+
+// writes lists the operations PartialWrite and LostAck apply to
+var writes = map[Op]bool{OpenStream: true, InsertEvent: true, ArchiveStream: true, PurgeStream: true, ImportTombstone: true, ImportEvent: true, ShredStream: true}
+
+// Rule injects the fault into the matching calls
+type Rule struct {
+	Fault       Fault
+	Ops         []Op              // operations the rule applies to, all if empty
+	Streams     []events.EntityID // streams the rule applies to, all if empty. Calls not about a stream match only if empty
+	Probability float64           // of injecting the fault into a matching call, always if zero
+	Limit       int               // number of faults injected at most, unlimited if zero
+	Kind        errors.Kind       // of Error faults, KindUnexpected if zero
+	Delay       time.Duration     // of Latency and Timeout faults
+}
+
+// matches reports whether the rule applies to the call
+func (r *Rule) matches(op Op, id events.EntityID) bool {
+	if (r.Fault == PartialWrite || r.Fault == LostAck) && !writes[op] {
+		return false
+	}
+
+	if len(r.Ops) > 0 && !containsOp(r.Ops, op) {
+		return false
+	}
+
+	if len(r.Streams) > 0 && (id == "" || !containsID(r.Streams, id)) {
+		return false
+	}
+
+	return true
+}
+
+func containsOp(ops []Op, op Op) bool {
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+func containsID(ids []events.EntityID, id events.EntityID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Injection is a fault injected into a call
+type Injection struct {
+	Op     Op
+	Stream events.EntityID // empty for calls not about a stream
+	Fault  Fault
+}
+
+// Store injects faults into the calls of the underlying store. Optional ports of bucket.Store are implemented
+// with calls failing if the underlying store does not implement them
+type Store struct {
+	next  bucket.Store
+	mtx   sync.Mutex
+	rnd   *rand.Rand
+	rules []Rule
+	used  []int
+	log   []Injection
+}
+
+// New returns store injecting faults of the rules into the calls of s. For each call the first rule matching it
+// and drawn to fire, in the order of the rules, injects its fault
+func New(s bucket.Store, seed int64, rules ...Rule) *Store {
+	return &Store{
+		next:  s,
+		rnd:   rand.New(rand.NewSource(seed)),
+		rules: rules,
+		used:  make([]int, len(rules)),
+	}
+}
+
+// Unwrap returns the store faults are injected into. Chaos implements all optional ports to inject faults
+// into their calls, the calls of ports missing from the store fail without faults
+func (s *Store) Unwrap() bucket.Store {
+	return s.next
+}
+
+// Injected returns the faults injected so far, in the order of the calls
+func (s *Store) Injected() []Injection {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return append([]Injection(nil), s.log...)
+}
+
+// fault returns the rule injecting its fault into the call, or nil if none. Number is drawn for every matching rule
+// with probability, so the faults depend only on the seed and the sequence of calls
+func (s *Store) fault(op Op, id events.EntityID) *Rule {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for i := range s.rules {
+		r := &s.rules[i]
+
+		if !r.matches(op, id) || (r.Limit > 0 && s.used[i] >= r.Limit) {
+			continue
+		}
+
+		if r.Probability > 0 && s.rnd.Float64() >= r.Probability {
+			continue
+		}
+
+		s.used[i]++
+		s.log = append(s.log, Injection{Op: op, Stream: id, Fault: r.Fault})
+
+		return r
+	}
+
+	return nil
+}
+
+// call runs f with the fault injected into it. Partial is the first step of the write used by PartialWrite, f if nil
+func (s *Store) call(ctx context.Context, op Op, id events.EntityID, f, partial func(context.Context) error) error {
+	name := errors.Op("chaos.Store." + string(op))
+
+	r := s.fault(op, id)
+	if r == nil {
+		return f(ctx)
+	}
+
+	switch r.Fault {
+	case Latency:
+		if err := wait(ctx, r.Delay); err != nil {
+			return errors.New(name, errors.KindUnexpected, "Context done", err)
+		}
+		return f(ctx)
+
+	case Error:
+		kind := r.Kind
+		if kind == 0 {
+			kind = errors.KindUnexpected
+		}
+		return errors.New(name, kind, "Injected error", ErrInjected)
+
+	case Timeout:
+		wait(ctx, r.Delay)
+		return errors.New(name, errors.KindUnexpected, "Timeout", context.DeadlineExceeded)
+
+	case PartialWrite:
+		if partial == nil {
+			partial = f
+		}
+		partial(ctx)
+		return errors.New(name, errors.KindUnexpected, "Partial write", ErrInjected)
+
+	case LostAck:
+		f(ctx)
+		return errors.New(name, errors.KindUnexpected, "Acknowledgement lost", ErrInjected)
+
+	default:
+		return f(ctx)
+	}
+}
+
+// wait waits for d or until ctx is done, when it returns the error of ctx
+func wait(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Store) OpenStream(ctx context.Context, o *bucket.Opened) error {
+	return s.call(ctx, OpenStream, o.EntityID(), func(ctx context.Context) error {
+		return s.next.OpenStream(ctx, o)
+	}, nil)
+}
+
+func (s *Store) InsertEvent(ctx context.Context, e events.Event) error {
+	return s.call(ctx, InsertEvent, e.EntityID(), func(ctx context.Context) error {
+		return s.next.InsertEvent(ctx, e)
+	}, nil)
+}
+
+func (s *Store) GetStream(ctx context.Context, id events.EntityID) ([]events.Event, error) {
+	ret := []events.Event{}
+
+	err := s.call(ctx, GetStream, id, func(ctx context.Context) (err error) {
+		ret, err = s.next.GetStream(ctx, id)
+		return err
+	}, nil)
+
+	return ret, err
+}
+
+func (s *Store) FindByTags(ctx context.Context, tags []bucket.Tag, match bucket.Match) ([]events.EntityID, error) {
+	const op errors.Op = "chaos.Store.FindByTags"
+
+	idx, ok := bucket.As[bucket.TagIndex](s.next)
+	if !ok {
+		return nil, errors.New(op, errors.KindUnexpected, "Store does not support tag queries")
+	}
+
+	var ret []events.EntityID
+
+	err := s.call(ctx, FindByTags, "", func(ctx context.Context) (err error) {
+		ret, err = idx.FindByTags(ctx, tags, match)
+		return err
+	}, nil)
+
+	return ret, err
+}
+
+func (s *Store) VerifyStream(ctx context.Context, id events.EntityID) error {
+	const op errors.Op = "chaos.Store.VerifyStream"
+
+	v, ok := bucket.As[bucket.Verifier](s.next)
+	if !ok {
+		return errors.New(op, errors.KindUnexpected, "Store does not support verification")
+	}
+
+	return s.call(ctx, VerifyStream, id, func(ctx context.Context) error {
+		return v.VerifyStream(ctx, id)
+	}, nil)
+}
+
+func (s *Store) Streams(ctx context.Context) ([]bucket.StreamRef, error) {
+	const op errors.Op = "chaos.Store.Streams"
+
+	sc, ok := bucket.As[bucket.Scanner](s.next)
+	if !ok {
+		return nil, errors.New(op, errors.KindUnexpected, "Store does not support scanning")
+	}
+
+	var ret []bucket.StreamRef
+
+	err := s.call(ctx, Streams, "", func(ctx context.Context) (err error) {
+		ret, err = sc.Streams(ctx)
+		return err
+	}, nil)
+
+	return ret, err
+}
+
+func (s *Store) ReadFeed(ctx context.Context, after uint64, limit int) ([]bucket.FeedEntry, error) {
+	const op errors.Op = "chaos.Store.ReadFeed"
+
+	f, ok := bucket.As[bucket.Feed](s.next)
+	if !ok {
+		return nil, errors.New(op, errors.KindUnexpected, "Store does not support feed")
+	}
+
+	var ret []bucket.FeedEntry
+
+	err := s.call(ctx, ReadFeed, "", func(ctx context.Context) (err error) {
+		ret, err = f.ReadFeed(ctx, after, limit)
+		return err
+	}, nil)
+
+	return ret, err
+}
+
+func (s *Store) ArchiveStream(ctx context.Context, e *bucket.Archived) error {
+	const op errors.Op = "chaos.Store.ArchiveStream"
+
+	a, ok := bucket.As[bucket.Archiver](s.next)
+	if !ok {
+		return errors.New(op, errors.KindUnexpected, "Store does not support archiving")
+	}
+
+	return s.call(ctx, ArchiveStream, e.EntityID(), func(ctx context.Context) error {
+		return a.ArchiveStream(ctx, e)
+	}, s.appendOnly(e))
+}
+
+func (s *Store) PurgeStream(ctx context.Context, e *bucket.Purged) error {
+	const op errors.Op = "chaos.Store.PurgeStream"
+
+	a, ok := bucket.As[bucket.Archiver](s.next)
+	if !ok {
+		return errors.New(op, errors.KindUnexpected, "Store does not support purging")
+	}
+
+	return s.call(ctx, PurgeStream, e.EntityID(), func(ctx context.Context) error {
+		return a.PurgeStream(ctx, e)
+	}, s.appendOnly(e))
+}
+
+func (s *Store) ImportTombstone(ctx context.Context, e *bucket.Purged) error {
+	const op errors.Op = "chaos.Store.ImportTombstone"
+
+	imp, ok := bucket.As[bucket.Importer](s.next)
+	if !ok {
+		return errors.New(op, errors.KindUnexpected, "Store does not support importing")
+	}
+
+	return s.call(ctx, ImportTombstone, e.EntityID(), func(ctx context.Context) error {
+		return imp.ImportTombstone(ctx, e)
+	}, s.appendOnly(e))
+}
+
+func (s *Store) ImportEvent(ctx context.Context, e events.Event) error {
+	const op errors.Op = "chaos.Store.ImportEvent"
+
+	imp, ok := bucket.As[bucket.Importer](s.next)
+	if !ok {
+		return errors.New(op, errors.KindUnexpected, "Store does not support importing")
+	}
+
+	return s.call(ctx, ImportEvent, e.EntityID(), func(ctx context.Context) error {
+		return imp.ImportEvent(ctx, e)
+	}, nil)
+}
+
+func (s *Store) ShredStream(ctx context.Context, e *bucket.Forgotten) error {
+	const op errors.Op = "chaos.Store.ShredStream"
+
+	sh, ok := bucket.As[bucket.Shredder](s.next)
+	if !ok {
+		return errors.New(op, errors.KindUnexpected, "Store does not support forgetting")
+	}
+
+	return s.call(ctx, ShredStream, e.EntityID(), func(ctx context.Context) error {
+		return sh.ShredStream(ctx, e)
+	}, s.appendOnly(e))
+}
+
+// appendOnly is the first step of archiving, purging and forgetting, which appends their event like any other
+func (s *Store) appendOnly(e events.Event) func(context.Context) error {
+	return func(ctx context.Context) error {
+		return s.next.InsertEvent(ctx, e)
+	}
+}
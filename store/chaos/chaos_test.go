@@ -0,0 +1,173 @@
+package chaos
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/principal"
+	"github.com/juelko/bucket/pkg/tenant"
+	svc "github.com/juelko/bucket/service"
+	"github.com/juelko/bucket/store/inmem"
+	"github.com/stretchr/testify/require"
+)
+
+func opened(id events.EntityID) *bucket.Opened {
+	return &bucket.Opened{Base: events.Base{ID: id, V: 1}, BucketData: bucket.BucketData{Title: "TestTitle"}, Owner: "TestOwner"}
+}
+
+// wrapped reports whether err wraps target through the errors of the service
+func wrapped(err, target error) bool {
+	for err != nil {
+		if err == target {
+			return true
+		}
+		e, ok := err.(*errors.Error)
+		if !ok {
+			return false
+		}
+		err = e.Wraps
+	}
+	return false
+}
+
+func TestDeterministic(t *testing.T) {
+	t.Parallel()
+
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+	run := func(seed int64) []Injection {
+		inner := inmem.NewBucketStore()
+		require.Nil(t, inner.OpenStream(ctx, opened("TestBucket")))
+
+		s := New(inner, seed,
+			Rule{Fault: Error, Ops: []Op{GetStream}, Probability: 0.3},
+			Rule{Fault: Latency, Probability: 0.3},
+		)
+
+		for i := 0; i < 50; i++ {
+			s.GetStream(ctx, "TestBucket")
+		}
+
+		return s.Injected()
+	}
+
+	first := run(42)
+	require.NotEmpty(t, first)
+	require.Less(t, len(first), 50)
+	require.Equal(t, first, run(42), "same seed should inject same faults")
+	require.NotEqual(t, first, run(7), "other seed should inject other faults")
+}
+
+func TestRules(t *testing.T) {
+	t.Parallel()
+
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	inner := inmem.NewBucketStore()
+
+	s := New(inner, 1,
+		Rule{Fault: Error, Ops: []Op{OpenStream}, Kind: errors.KindForbidden, Limit: 1},
+		Rule{Fault: Error, Streams: []events.EntityID{"FlakyBucket"}},
+	)
+
+	err := s.OpenStream(ctx, opened("FirstBucket"))
+	require.NotNil(t, err)
+	require.Equal(t, &errors.Error{Op: "chaos.Store.OpenStream", Kind: errors.KindForbidden, Msg: "Injected error", Wraps: ErrInjected}, err)
+
+	require.Nil(t, s.OpenStream(ctx, opened("FirstBucket")), "limit should stop the rule")
+	require.Nil(t, inner.OpenStream(ctx, opened("FlakyBucket")))
+
+	_, err = s.GetStream(ctx, "FlakyBucket")
+	require.NotNil(t, err, "rule should apply to the stream")
+
+	_, err = s.GetStream(ctx, "FirstBucket")
+	require.Nil(t, err, "rule should not apply to other streams")
+
+	_, err = s.Streams(ctx)
+	require.Nil(t, err, "rule of streams should not apply to calls not about a stream")
+
+	require.Equal(t, []Injection{
+		{Op: OpenStream, Stream: "FirstBucket", Fault: Error},
+		{Op: GetStream, Stream: "FlakyBucket", Fault: Error},
+	}, s.Injected())
+}
+
+func TestTiming(t *testing.T) {
+	t.Parallel()
+
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	inner := inmem.NewBucketStore()
+	require.Nil(t, inner.OpenStream(ctx, opened("SlowBucket")))
+	require.Nil(t, inner.OpenStream(ctx, opened("StuckBucket")))
+
+	s := New(inner, 1,
+		Rule{Fault: Latency, Streams: []events.EntityID{"SlowBucket"}, Delay: 20 * time.Millisecond},
+		Rule{Fault: Timeout, Streams: []events.EntityID{"StuckBucket"}, Delay: time.Hour},
+	)
+
+	start := time.Now()
+	_, err := s.GetStream(ctx, "SlowBucket")
+	require.Nil(t, err)
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(20*time.Millisecond))
+
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+
+	_, err = s.GetStream(tctx, "StuckBucket")
+	require.NotNil(t, err)
+	require.True(t, wrapped(err, context.DeadlineExceeded))
+	require.Less(t, int64(time.Since(start)), int64(time.Minute), "timeout should end when context is done")
+}
+
+func TestService(t *testing.T) {
+	t.Parallel()
+
+	ctx := principal.NewContext(tenant.NewContext(context.Background(), tenant.Default), "TestOwner")
+	inner := inmem.NewBucketStore()
+
+	s := New(inner, 1,
+		Rule{Fault: LostAck, Ops: []Op{InsertEvent}, Streams: []events.EntityID{"LostBucket"}, Limit: 1},
+		Rule{Fault: PartialWrite, Ops: []Op{ArchiveStream}, Limit: 1},
+		Rule{Fault: Error, Ops: []Op{GetStream}, Streams: []events.EntityID{"BrokenBucket"}},
+	)
+	service := svc.NewService(s)
+
+	for _, id := range []events.EntityID{"LostBucket", "PartialBucket", "BrokenBucket"} {
+		_, err := service.Open(ctx, &bucket.OpenRequest{ID: id, Title: "TestTitle"})
+		require.Nil(t, err)
+	}
+
+	t.Run("lost ack", func(t *testing.T) {
+		_, err := service.AddTag(ctx, &bucket.AddTagRequest{ID: "LostBucket", Tag: "team:a"})
+		require.NotNil(t, err, "service should report the failure")
+		require.True(t, wrapped(err, ErrInjected))
+
+		stream, err := inner.GetStream(ctx, "LostBucket")
+		require.Nil(t, err)
+		require.Len(t, stream, 2, "event should be written despite the failure")
+	})
+
+	t.Run("partial write", func(t *testing.T) {
+		_, err := service.Close(ctx, &bucket.CloseRequest{ID: "PartialBucket"})
+		require.Nil(t, err)
+
+		_, err = service.Archive(ctx, &bucket.ArchiveRequest{ID: "PartialBucket"})
+		require.NotNil(t, err)
+
+		stream, err := inner.GetStream(ctx, "PartialBucket")
+		require.Nil(t, err)
+		require.IsType(t, &bucket.Archived{}, stream[len(stream)-1])
+
+		require.Nil(t, inner.InsertEvent(ctx, &bucket.Reopened{Base: events.Base{ID: "PartialBucket", V: 4}}), "stream should not be archived")
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		_, err := service.Get(ctx, "BrokenBucket")
+		require.NotNil(t, err)
+		require.True(t, wrapped(err, ErrInjected))
+		require.Equal(t, errors.KindUnexpected, err.(*errors.Error).Kind, "store failure should not be reported as missing bucket")
+	})
+}
@@ -0,0 +1,147 @@
+// Package codec converts bucket events to records, which stores can persist as bytes
+package codec
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/principal"
+)
+
+// Record is the stored form of an event
+type Record struct {
+	Type    string               `json:"type"`
+	ID      events.EntityID      `json:"id"`
+	Version events.EntityVersion `json:"version"`
+	At      time.Time            `json:"at"`
+	Hash    string               `json:"hash,omitempty"` // hash chaining the event to the previous one, see events.Hash
+	KeyID   string               `json:"kid,omitempty"`  // id of the key encrypting Data, empty if not encrypted
+	Data    []byte               `json:"data,omitempty"`
+}
+
+// Codec encodes events to records and decodes them back
+type Codec interface {
+	Encode(ctx context.Context, e events.Event) (Record, error)
+	Decode(ctx context.Context, r Record) (events.Event, error)
+}
+
+// JSON returns Codec encoding the event data as JSON
+func JSON() Codec {
+	return jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(ctx context.Context, e events.Event) (Record, error) {
+	const op errors.Op = "codec.jsonCodec.Encode"
+
+	r := Record{
+		Type:    e.Type(),
+		ID:      e.EntityID(),
+		Version: e.EntityVersion(),
+		At:      e.Timestamp(),
+		Hash:    e.Hash(),
+	}
+
+	if d := e.Data(); d != nil {
+		b, err := json.Marshal(d)
+		if err != nil {
+			return Record{}, errors.New(op, errors.KindUnexpected, "Could not encode event data", err)
+		}
+		r.Data = b
+	}
+
+	return r, nil
+}
+
+func (jsonCodec) Decode(ctx context.Context, r Record) (events.Event, error) {
+	const op errors.Op = "codec.jsonCodec.Decode"
+
+	if r.KeyID != "" {
+		return nil, errors.New(op, errors.KindUnexpected, "Record is encrypted")
+	}
+
+	eb := events.Base{
+		ID:  r.ID,
+		V:   r.Version,
+		At:  r.At,
+		Sum: r.Hash,
+	}
+
+	var data interface{}
+	var build func() events.Event
+
+	switch r.Type {
+	case "bucket.Opened":
+		var d bucket.OpenedData
+		data, build = &d, func() events.Event {
+			return &bucket.Opened{Base: eb, BucketData: d.BucketData, Capacity: d.Capacity, Owner: d.Owner}
+		}
+
+	case "bucket.Updated":
+		var d bucket.BucketData
+		data, build = &d, func() events.Event { return &bucket.Updated{Base: eb, BucketData: d} }
+
+	case "bucket.Closed":
+		build = func() events.Event { return &bucket.Closed{Base: eb} }
+
+	case "bucket.Reopened":
+		build = func() events.Event { return &bucket.Reopened{Base: eb} }
+
+	case "bucket.ItemAdded":
+		var d bucket.Item
+		data, build = &d, func() events.Event { return &bucket.ItemAdded{Base: eb, Item: d} }
+
+	case "bucket.ItemRemoved":
+		var d bucket.ItemID
+		data, build = &d, func() events.Event { return &bucket.ItemRemoved{Base: eb, ItemID: d} }
+
+	case "bucket.ItemMoved":
+		var d bucket.ItemPosition
+		data, build = &d, func() events.Event { return &bucket.ItemMoved{Base: eb, ItemPosition: d} }
+
+	case "bucket.CapacityChanged":
+		var d bucket.Capacity
+		data, build = &d, func() events.Event { return &bucket.CapacityChanged{Base: eb, Capacity: d} }
+
+	case "bucket.AccessGranted":
+		var d bucket.Grant
+		data, build = &d, func() events.Event { return &bucket.AccessGranted{Base: eb, Grant: d} }
+
+	case "bucket.AccessRevoked":
+		var d principal.ID
+		data, build = &d, func() events.Event { return &bucket.AccessRevoked{Base: eb, Principal: d} }
+
+	case "bucket.TagAdded":
+		var d bucket.Tag
+		data, build = &d, func() events.Event { return &bucket.TagAdded{Base: eb, Tag: d} }
+
+	case "bucket.TagRemoved":
+		var d bucket.Tag
+		data, build = &d, func() events.Event { return &bucket.TagRemoved{Base: eb, Tag: d} }
+
+	case "bucket.Archived":
+		build = func() events.Event { return &bucket.Archived{Base: eb} }
+
+	case "bucket.Purged":
+		build = func() events.Event { return &bucket.Purged{Base: eb} }
+
+	case "bucket.Forgotten":
+		build = func() events.Event { return &bucket.Forgotten{Base: eb} }
+
+	default:
+		return nil, errors.New(op, errors.KindUnexpected, "Unkown event type: "+r.Type)
+	}
+
+	if data != nil {
+		if err := json.Unmarshal(r.Data, data); err != nil {
+			return nil, errors.New(op, errors.KindUnexpected, "Could not decode event data", err)
+		}
+	}
+
+	return build(), nil
+}
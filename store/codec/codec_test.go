@@ -0,0 +1,255 @@
+package codec
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/bucket/buckettest"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvents() []events.Event {
+	at := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+	base := func(v events.EntityVersion) events.Base {
+		return events.Base{ID: "TestBucket", V: v, At: at}
+	}
+
+	return []events.Event{
+		&bucket.Opened{Base: base(1), BucketData: bucket.BucketData{Title: "TestTitle", Description: "Test Description"}, Capacity: bucket.Capacity{MaxItems: 2, MaxSize: 10}, Owner: "TestOwner"},
+		&bucket.Updated{Base: base(2), BucketData: bucket.BucketData{Title: "NewTitle", Description: "New Description"}},
+		&bucket.ItemAdded{Base: base(3), Item: bucket.Item{ID: "Item1", Name: "First", Payload: bucket.Payload("data")}},
+		&bucket.ItemMoved{Base: base(4), ItemPosition: bucket.ItemPosition{ItemID: "Item1", Position: 0}},
+		&bucket.ItemRemoved{Base: base(5), ItemID: "Item1"},
+		&bucket.CapacityChanged{Base: base(6), Capacity: bucket.Capacity{MaxItems: 5}},
+		&bucket.AccessGranted{Base: base(7), Grant: bucket.Grant{Principal: "TestEditor", Role: bucket.RoleEditor}},
+		&bucket.AccessRevoked{Base: base(8), Principal: "TestEditor"},
+		&bucket.TagAdded{Base: base(9), Tag: "team:a"},
+		&bucket.TagRemoved{Base: base(10), Tag: "team:a"},
+		&bucket.Forgotten{Base: base(11)},
+		&bucket.Closed{Base: base(12)},
+		&bucket.Reopened{Base: base(13)},
+		&bucket.Closed{Base: base(14)},
+		&bucket.Archived{Base: base(15)},
+		&bucket.Purged{Base: base(16)},
+	}
+}
+
+func testKeys(t *testing.T, current string) KeyProvider {
+	keys, err := NewKeyRing(current, map[string][]byte{
+		"first":  []byte("0123456789abcdef0123456789abcdef"),
+		"second": []byte("fedcba9876543210"),
+	})
+	require.Nil(t, err)
+	return keys
+}
+
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		desc  string
+		codec Codec
+	}{
+		{desc: "json", codec: JSON()},
+		{desc: "encrypted", codec: NewEncrypted(JSON(), testKeys(t, "first"))},
+	}
+
+	for i := range testCases {
+		tC := testCases[i]
+
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			for _, e := range testEvents() {
+				r, err := tC.codec.Encode(ctx, e)
+				require.Nil(t, err)
+
+				got, err := tC.codec.Decode(ctx, r)
+				require.Nil(t, err)
+				require.Equal(t, e, got)
+			}
+		})
+	}
+}
+
+func TestEncrypted(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	open := testEvents()[0]
+
+	c := NewEncrypted(JSON(), testKeys(t, "first"))
+
+	r, err := c.Encode(ctx, open)
+	require.Nil(t, err)
+	require.Equal(t, "first", r.KeyID)
+	require.NotContains(t, string(r.Data), "TestTitle")
+
+	rotated := NewEncrypted(JSON(), testKeys(t, "second"))
+
+	got, err := rotated.Decode(ctx, r)
+	require.Nil(t, err, "records encrypted with old key should be readable after rotation")
+	require.Equal(t, open, got)
+
+	r2, err := rotated.Encode(ctx, open)
+	require.Nil(t, err)
+	require.Equal(t, "second", r2.KeyID)
+
+	tamper := func(f func(r *Record)) Record {
+		ret := r
+		ret.Data = append([]byte(nil), r.Data...)
+		f(&ret)
+		return ret
+	}
+
+	testCases := []struct {
+		desc string
+		ctx  context.Context
+		r    Record
+		msg  string
+	}{
+		{desc: "data", ctx: ctx, r: tamper(func(r *Record) { r.Data[len(r.Data)-1] ^= 1 }), msg: "Record is tampered"},
+		{desc: "version", ctx: ctx, r: tamper(func(r *Record) { r.Version = 2 }), msg: "Record is tampered"},
+		{desc: "type", ctx: ctx, r: tamper(func(r *Record) { r.Type = "bucket.Updated" }), msg: "Record is tampered"},
+		{desc: "id", ctx: ctx, r: tamper(func(r *Record) { r.ID = "OtherBucket" }), msg: "Record is tampered"},
+		{desc: "key id", ctx: ctx, r: tamper(func(r *Record) { r.KeyID = "second" }), msg: "Record is tampered"},
+		{desc: "tenant", ctx: tenant.NewContext(ctx, "Other"), r: r, msg: "Record is tampered"},
+		{desc: "truncated", ctx: ctx, r: tamper(func(r *Record) { r.Data = r.Data[:4] }), msg: "Record is tampered"},
+		{desc: "not encrypted", ctx: ctx, r: tamper(func(r *Record) { r.KeyID = "" }), msg: "Record is not encrypted"},
+		{desc: "unknown key", ctx: ctx, r: tamper(func(r *Record) { r.KeyID = "third" }), msg: "Could not get decryption key"},
+	}
+
+	for i := range testCases {
+		tC := testCases[i]
+
+		t.Run(tC.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := c.Decode(tC.ctx, tC.r)
+			require.Nil(t, got)
+			require.NotNil(t, err)
+			require.Equal(t, errors.KindUnexpected, err.(*errors.Error).Kind)
+			require.Equal(t, tC.msg, err.(*errors.Error).Msg)
+		})
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+	testCases := []struct {
+		desc    string
+		content string
+		err     bool
+	}{
+		{desc: "happy", content: `{"current": "2021-02", "keys": {"2021-01": "` + key + `", "2021-02": "` + key + `"}}`},
+		{desc: "current missing", content: `{"current": "2021-03", "keys": {"2021-01": "` + key + `"}}`, err: true},
+		{desc: "short key", content: `{"current": "short", "keys": {"short": "c2hvcnQ="}}`, err: true},
+		{desc: "not base64", content: `{"current": "bad", "keys": {"bad": "not base64!"}}`, err: true},
+		{desc: "not json", content: `current=2021-01`, err: true},
+	}
+
+	for i, tC := range testCases {
+		path := filepath.Join(dir, "keys"+string(rune('a'+i))+".json")
+		require.Nil(t, os.WriteFile(path, []byte(tC.content), 0600))
+
+		keys, err := LoadKeyFile(path)
+		if tC.err {
+			require.NotNil(t, err, tC.desc)
+			continue
+		}
+		require.Nil(t, err, tC.desc)
+
+		kid, _, err := keys.Current(context.Background())
+		require.Nil(t, err)
+		require.Equal(t, "2021-02", kid)
+
+		_, err = keys.Key(context.Background(), "2021-01")
+		require.Nil(t, err)
+	}
+
+	_, err := LoadKeyFile(filepath.Join(dir, "missing.json"))
+	require.NotNil(t, err)
+}
+
+// FuzzRoundTrip encodes events of all types with the codecs, stores the records as JSON and decodes them back
+func FuzzRoundTrip(f *testing.F) {
+	for kind := uint8(0); kind < buckettest.Kinds; kind++ {
+		f.Add(kind, "TestTitle", []byte("data"), uint(kind))
+	}
+	f.Add(uint8(4), "", []byte{0, 0xff, 0xfe}, uint(1<<40))
+	f.Add(uint8(1), "\xff\xfe invalid utf-8", []byte(" "), uint(0))
+
+	f.Fuzz(func(t *testing.T, kind uint8, text string, payload []byte, n uint) {
+		ctx := context.Background()
+		e := buckettest.Event(kind, "TestBucket", events.EntityVersion(n%1000+1), text, payload, n)
+
+		for name, c := range map[string]Codec{"json": JSON(), "encrypted": NewEncrypted(JSON(), testKeys(t, "first"))} {
+			r, err := c.Encode(ctx, e)
+			if err != nil {
+				t.Fatalf("%s: could not encode %s: %v", name, e.Type(), err)
+			}
+
+			b, err := json.Marshal(r)
+			if err != nil {
+				t.Fatalf("%s: could not marshal record: %v", name, err)
+			}
+
+			var stored Record
+			if err := json.Unmarshal(b, &stored); err != nil {
+				t.Fatalf("%s: could not unmarshal record: %v", name, err)
+			}
+
+			got, err := c.Decode(ctx, stored)
+			if err != nil {
+				t.Fatalf("%s: could not decode %s: %v", name, e.Type(), err)
+			}
+
+			if !events.Equal(e, got) || !e.Timestamp().Equal(got.Timestamp()) || e.Hash() != got.Hash() {
+				t.Fatalf("%s: got %#v, want %#v", name, got, e)
+			}
+		}
+	})
+}
+
+// FuzzDecode decodes arbitrary records, which must fail or return event of the record type without panicking
+func FuzzDecode(f *testing.F) {
+	for kind := uint8(0); kind < buckettest.Kinds; kind++ {
+		r, err := JSON().Encode(context.Background(), buckettest.Event(kind, "TestBucket", 1, "Item1", []byte("data"), 1))
+		require.Nil(f, err)
+		f.Add(r.Type, r.Data, "")
+	}
+	f.Add("bucket.Unknown", []byte("{}"), "")
+	f.Add("bucket.Updated", []byte("not json"), "first")
+
+	f.Fuzz(func(t *testing.T, typ string, data []byte, keyID string) {
+		ctx := context.Background()
+		r := Record{Type: typ, ID: "TestBucket", Version: 3, KeyID: keyID, Data: data}
+
+		for name, c := range map[string]Codec{"json": JSON(), "encrypted": NewEncrypted(JSON(), testKeys(t, "first"))} {
+			e, err := c.Decode(ctx, r)
+			if err != nil {
+				continue
+			}
+
+			if e.Type() != typ || e.EntityID() != r.ID || e.EntityVersion() != r.Version {
+				t.Fatalf("%s: record %s decoded to %s %s v%d", name, typ, e.Type(), e.EntityID(), e.EntityVersion())
+			}
+		}
+	})
+}
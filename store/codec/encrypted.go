@@ -0,0 +1,103 @@
+package codec
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"strconv"
+
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+)
+
+// NewEncrypted returns Codec encrypting the data encoded by c with AES-GCM.
+// Records are encrypted with the current key of the provider and the key id is stored in the record,
+// so records encrypted before key rotation can be decrypted as long as the provider has the old key.
+// Type, ID and Version of the record and the tenant are authenticated with the data, so tampering
+// any of them is detected on decoding
+func NewEncrypted(c Codec, keys KeyProvider) Codec {
+	return &encrypted{next: c, keys: keys}
+}
+
+type encrypted struct {
+	next Codec
+	keys KeyProvider
+}
+
+func (c *encrypted) Encode(ctx context.Context, e events.Event) (Record, error) {
+	const op errors.Op = "codec.encrypted.Encode"
+
+	r, err := c.next.Encode(ctx, e)
+	if err != nil {
+		return Record{}, err
+	}
+
+	kid, key, err := c.keys.Current(ctx)
+	if err != nil {
+		return Record{}, errors.New(op, errors.KindUnexpected, "Could not get encryption key", err)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return Record{}, errors.New(op, errors.KindUnexpected, "Invalid encryption key", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return Record{}, errors.New(op, errors.KindUnexpected, "Could not create nonce", err)
+	}
+
+	r.KeyID = kid
+	r.Data = aead.Seal(nonce, nonce, r.Data, additional(ctx, r))
+
+	return r, nil
+}
+
+func (c *encrypted) Decode(ctx context.Context, r Record) (events.Event, error) {
+	const op errors.Op = "codec.encrypted.Decode"
+
+	// unencrypted records are rejected, so encryption can not be stripped from stored records
+	if r.KeyID == "" {
+		return nil, errors.New(op, errors.KindUnexpected, "Record is not encrypted")
+	}
+
+	key, err := c.keys.Key(ctx, r.KeyID)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not get decryption key", err)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Invalid decryption key", err)
+	}
+
+	if len(r.Data) < aead.NonceSize() {
+		return nil, errors.New(op, errors.KindUnexpected, "Record is tampered")
+	}
+
+	plain, err := aead.Open(nil, r.Data[:aead.NonceSize()], r.Data[aead.NonceSize():], additional(ctx, r))
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Record is tampered", err)
+	}
+
+	r.KeyID = ""
+	r.Data = plain
+
+	return c.next.Decode(ctx, r)
+}
+
+// additional returns the authenticated data binding the encrypted data to the record
+func additional(ctx context.Context, r Record) []byte {
+	return []byte(string(tenant.Of(ctx)) + "/" + r.Type + "/" + string(r.ID) + "/" + strconv.FormatUint(uint64(r.Version), 10) + "/" + r.KeyID)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
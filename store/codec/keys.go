@@ -0,0 +1,108 @@
+package codec
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+
+	"github.com/juelko/bucket/pkg/errors"
+)
+
+// KeyProvider provides the keys for encrypting and decrypting records
+type KeyProvider interface {
+	// Current returns id and value of the key used for encrypting
+	Current(ctx context.Context) (string, []byte, error)
+	// Key returns the key with id for decrypting
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// NewKeyRing returns KeyProvider with fixed keys. Keys maps key id to AES-128, AES-192 or AES-256 key
+// and current is the id of the key used for encrypting
+func NewKeyRing(current string, keys map[string][]byte) (KeyProvider, error) {
+	const op errors.Op = "codec.NewKeyRing"
+
+	if _, ok := keys[current]; !ok {
+		return nil, errors.New(op, errors.KindValidation, "Current key missing")
+	}
+
+	ring := keyRing{current: current, keys: map[string][]byte{}}
+
+	for id, key := range keys {
+		if id == "" {
+			return nil, errors.New(op, errors.KindValidation, "Empty key id")
+		}
+
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return nil, errors.New(op, errors.KindValidation, "Invalid key length for key "+id)
+		}
+
+		ring.keys[id] = append([]byte(nil), key...)
+	}
+
+	return &ring, nil
+}
+
+type keyRing struct {
+	current string
+	keys    map[string][]byte
+}
+
+func (r *keyRing) Current(ctx context.Context) (string, []byte, error) {
+	return r.current, r.keys[r.current], nil
+}
+
+func (r *keyRing) Key(ctx context.Context, id string) ([]byte, error) {
+	const op errors.Op = "codec.keyRing.Key"
+
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, errors.New(op, errors.KindNotFound, "Unknown key: "+id)
+	}
+
+	return key, nil
+}
+
+// keyFile is the format of local key files, e.g.
+//
+//	{"current": "2021-02", "keys": {"2021-01": "<base64 key>", "2021-02": "<base64 key>"}}
+//
+// Keys are rotated by adding a new key and making it current. Old keys are kept for decrypting
+type keyFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// LoadKeyFile returns KeyProvider with the keys read from local JSON file at path
+func LoadKeyFile(path string) (KeyProvider, error) {
+	const op errors.Op = "codec.LoadKeyFile"
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not read key file", err)
+	}
+
+	var f keyFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "Invalid key file", err)
+	}
+
+	keys := map[string][]byte{}
+
+	for id, v := range f.Keys {
+		key, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, errors.New(op, errors.KindValidation, "Invalid encoding for key "+id, err)
+		}
+		keys[id] = key
+	}
+
+	ring, err := NewKeyRing(f.Current, keys)
+	if err != nil {
+		return nil, errors.New(op, errors.KindValidation, "Invalid key file", err)
+	}
+
+	return ring, nil
+}
@@ -1,27 +1,125 @@
 package inmem
 
 import (
+	"container/list"
 	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/juelko/bucket/bucket"
 
 	"github.com/juelko/bucket/pkg/errors"
 	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/principal"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/juelko/bucket/store/codec"
+	"github.com/juelko/bucket/store/internal/backend"
 )
 
-func NewBucketStore() bucket.Store {
-	return &store{
-		mtx:  sync.RWMutex{},
-		data: map[events.EntityID][]dao{},
+// DefaultShards is the number of shards the streams are spread to, unless set with Shards
+const DefaultShards = 32
+
+func NewBucketStore(opts ...Option) bucket.Store {
+	s := &store{
+		tags:  map[tenant.ID]map[bucket.Tag]map[events.EntityID]bool{},
+		lru:   list.New(),
+		lruAt: map[backend.Key]*list.Element{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.shards == nil {
+		s.shards = newShards(DefaultShards)
+	}
+
+	return s
+}
+
+// Option configures the store
+type Option func(*store)
+
+// UniqueTitles makes store to reserve bucket titles per tenant, so two buckets can not have the same title.
+// With ignoreCase titles differing only by case are considered the same
+func UniqueTitles(ignoreCase bool) Option {
+	return func(s *store) {
+		s.titles = map[tenant.ID]map[string]events.EntityID{}
+		s.held = map[backend.Key]string{}
+		s.ignoreCase = ignoreCase
+	}
+}
+
+// Encoding makes store to keep the events encoded with c, e.g. to keep them encrypted in memory
+func Encoding(c codec.Codec) Option {
+	return func(s *store) {
+		s.codec = c
+	}
+}
+
+// Shards sets the number of shards the streams are spread to. Streams in different shards
+// are written in parallel, streams in the same shard one at a time
+func Shards(n int) Option {
+	return func(s *store) {
+		if n < 1 {
+			n = 1
+		}
+		s.shards = newShards(n)
+	}
+}
+
+// Bounded limits the streams kept in memory. When a write exceeds a limit, closed and archived buckets
+// are evicted, least recently used first, to the Spilling store or dropped if there is none.
+// IDs of the dropped streams stay reserved and using them fails with ErrDropped, so lost events are not
+// mistaken for missing or purged ones. Open buckets are never evicted, so the limits are exceeded if they alone do not fit
+func Bounded(l Limits) Option {
+	return func(s *store) {
+		s.limits = l
+	}
+}
+
+// Spilling makes store to save the evicted streams to sp. Reads of the spilled streams go to sp,
+// and writes load the stream back to memory
+func Spilling(sp Spill) Option {
+	return func(s *store) {
+		s.spill = sp
 	}
 }
 
+// Limits of the streams kept in memory, zero is unlimited
+type Limits struct {
+	MaxStreams int64
+	MaxEvents  int64
+	MaxBytes   int64 // approximate size of the events, see Usage
+}
+
+// Usage tells the memory used by the store
+type Usage struct {
+	Streams     int64 // streams in memory
+	Events      int64 // events in memory
+	Bytes       int64 // approximate size of the events in memory, encoded data and fixed overhead per event
+	Spilled     int64 // streams in the spill store
+	Evictions   int64 // streams evicted since the store was created
+	SpillErrors int64 // evictions failed because of the spill store
+}
+
+// Meter is implemented by the store returned by NewBucketStore
+type Meter interface {
+	Usage() Usage
+}
+
 func NewTestBucketStore() bucket.Store {
 	open := dao{
 		t:    "bucket.Opened",
 		v:    1,
-		data: bucket.BucketData{Title: "OpenTitle", Description: "Open Description"},
+		data: bucket.OpenedData{BucketData: bucket.BucketData{Title: "OpenTitle", Description: "Open Description"}, Owner: "TestOwner"},
 	}
 	updated := dao{
 		t:    "bucket.Updated",
@@ -33,82 +131,366 @@ func NewTestBucketStore() bucket.Store {
 		v:    3,
 		data: nil,
 	}
+	viewer := dao{
+		t:    "bucket.AccessGranted",
+		v:    2,
+		data: bucket.Grant{Principal: "TestViewer", Role: bucket.RoleViewer},
+	}
+	editor := dao{
+		t:    "bucket.AccessGranted",
+		v:    3,
+		data: bucket.Grant{Principal: "TestEditor", Role: bucket.RoleEditor},
+	}
+
+	s := NewBucketStore().(*store)
 
-	return &store{
-		mtx: sync.RWMutex{},
-		data: map[events.EntityID][]dao{
-			"OpenID":    {open},
-			"UpdatedID": {open, updated},
-			"ClosedID":  {open, updated, closed},
-		},
+	for k, daos := range map[backend.Key][]dao{
+		{Tenant: tenant.Default, ID: "OpenID"}:    {open},
+		{Tenant: tenant.Default, ID: "UpdatedID"}: {open, updated},
+		{Tenant: tenant.Default, ID: "ClosedID"}:  {open, updated, closed},
+		{Tenant: tenant.Default, ID: "SharedID"}:  {open, viewer, editor},
+	} {
+		for i := range daos {
+			daos[i].size = daos[i].sizeOf()
+		}
+		s.shard(k).data[k] = daos
+		s.account(daos, 1)
 	}
+
+	return s
 }
 
+// store keeps the streams in shards, each guarded by its own lock, so writes to streams in different
+// shards do not block each other. Indexes and feed shared by all streams have their own locks,
+// which are taken after the shard lock, never before it
 type store struct {
-	mtx  sync.RWMutex
-	data map[events.EntityID][]dao
+	shards []*shard
+
+	tagMtx sync.RWMutex
+	tags   map[tenant.ID]map[bucket.Tag]map[events.EntityID]bool // tag index
+
+	titleMtx   sync.Mutex
+	titles     map[tenant.ID]map[string]events.EntityID // title reservations, nil if titles are not unique
+	held       map[backend.Key]string                   // title reserved by each stream
+	ignoreCase bool                                     // titles are reserved case insensitively
+
+	feedMtx sync.RWMutex
+	feed    []feedRef // global feed, position is index + 1
+
+	codec codec.Codec // encoding of stored events, nil keeps events as they are
+
+	limits Limits
+	spill  Spill // nil drops the evicted streams
+	lruMtx sync.Mutex
+	lru    *list.List                    // evictable streams, least recently used at the back
+	lruAt  map[backend.Key]*list.Element // element of the stream in lru
+
+	// usage, accessed atomically
+	streams, events, bytes, spilled, evictions, spillErrors int64
+}
+
+// shard holds part of the streams. Version check and append of an event are done holding the lock of the shard
+type shard struct {
+	mtx     sync.RWMutex
+	data    map[backend.Key][]dao
+	cold    map[backend.Key][]dao // archived streams
+	spilled map[backend.Key]bool  // streams evicted to the spill store, true if archived
+	dropped map[backend.Key]bool  // streams evicted without spill store
+}
+
+func newShards(n int) []*shard {
+	ret := make([]*shard, n)
+
+	for i := range ret {
+		ret[i] = &shard{
+			data:    map[backend.Key][]dao{},
+			cold:    map[backend.Key][]dao{},
+			spilled: map[backend.Key]bool{},
+			dropped: map[backend.Key]bool{},
+		}
+	}
+
+	return ret
+}
+
+// shard returns the shard of the stream
+func (s *store) shard(k backend.Key) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(k.Tenant))
+	h.Write([]byte{0})
+	h.Write([]byte(k.ID))
+
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+// feedRef refers to the event in the stream, so purged events are not kept in the feed
+type feedRef struct {
+	k backend.Key
+	v events.EntityVersion
 }
 
 func (s *store) OpenStream(ctx context.Context, o *bucket.Opened) error {
 	const op errors.Op = "inmem.store.OpenStream"
-	s.mtx.Lock()
-	defer s.mtx.Unlock()
 
-	if s.exists(o.EntityID()) {
+	return s.open(ctx, op, o, time.Now())
+}
+
+// open starts the stream with the event stored at given time
+func (s *store) open(ctx context.Context, op errors.Op, o *bucket.Opened, at time.Time) error {
+	k, err := backend.KeyOf(ctx, o.EntityID())
+	if err != nil {
+		return err
+	}
+
+	// deferred before unlocking, so evicting runs without the shard lock
+	defer s.enforce(ctx)
+
+	sh := s.shard(k)
+	sh.mtx.Lock()
+	defer sh.mtx.Unlock()
+
+	if sh.exists(k) || sh.archived(k) || sh.isSpilled(k) || sh.dropped[k] {
 		return errors.New(op, errors.KindAllreadyExists, "Allready exists")
 	}
 
-	return s.insert(o)
+	if err := s.reserve(k, o.Title); err != nil {
+		return errors.New(op, errors.KindAllreadyExists, "Title allready in use", err)
+	}
 
+	return s.insert(ctx, sh, k, o, at)
 }
 
 func (s *store) InsertEvent(ctx context.Context, e events.Event) error {
 	const op errors.Op = "inmem.store.InsertEvent"
-	s.mtx.Lock()
-	defer s.mtx.Unlock()
 
-	if !s.exists(e.EntityID()) {
+	return s.add(ctx, op, e, time.Now())
+}
+
+// add appends the event stored at given time to the stream
+func (s *store) add(ctx context.Context, op errors.Op, e events.Event, at time.Time) error {
+	k, err := backend.KeyOf(ctx, e.EntityID())
+	if err != nil {
+		return err
+	}
+
+	defer s.enforce(ctx)
+
+	sh := s.shard(k)
+	sh.mtx.Lock()
+	defer sh.mtx.Unlock()
+
+	if err := s.restore(ctx, sh, k); err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not load spilled stream", err)
+	}
+
+	if sh.archived(k) {
+		return errors.New(op, errors.KindExpected, "Stream is archived")
+	}
+
+	if !sh.exists(k) {
 		return errors.New(op, errors.KindNotFound, "Stream not found")
 	}
 
-	if s.nextVersion(e.EntityID()) != e.EntityVersion() {
+	if sh.nextVersion(k) != e.EntityVersion() {
 		return errors.New(op, errors.KindUnexpected, "version error")
 	}
 
-	return s.insert(e)
+	if u, ok := e.(*bucket.Updated); ok {
+		if err := s.reserve(k, u.Title); err != nil {
+			return errors.New(op, errors.KindAllreadyExists, "Title allready in use", err)
+		}
+	}
+
+	return s.insert(ctx, sh, k, e, at)
 }
 
-func (s *store) insert(e events.Event) error {
+// insert appends the event stored at given time to the stream in the shard, caller holds the lock of the shard
+func (s *store) insert(ctx context.Context, sh *shard, k backend.Key, e events.Event, at time.Time) error {
+	const op errors.Op = "inmem.store.insert"
+
 	var d dao
 
 	d.encode(e)
+	d.at = at
+
+	prev := ""
+	if n := len(sh.data[k]); n > 0 {
+		prev = sh.data[k][n-1].hash
+	}
+
+	h, err := events.Hash(prev, d.at, e)
+	if err != nil {
+		return errors.New(op, errors.KindUnexpected, "hashing error", err)
+	}
+	d.hash = h
+
+	if s.codec != nil {
+		r, err := s.codec.Encode(ctx, e)
+		if err != nil {
+			return errors.New(op, errors.KindUnexpected, "encoding error", err)
+		}
+		r.Hash = d.hash
+		d.data = r
+	}
+
+	d.size = d.sizeOf()
+
+	if len(sh.data[k]) == 0 {
+		atomic.AddInt64(&s.streams, 1)
+	}
+	atomic.AddInt64(&s.events, 1)
+	atomic.AddInt64(&s.bytes, d.size)
+
+	sh.data[k] = append(sh.data[k], d)
+
+	// appended holding the shard lock, so events of a stream are in the feed in version order
+	s.feedMtx.Lock()
+	s.feed = append(s.feed, feedRef{k, d.v})
+	s.feedMtx.Unlock()
+
+	s.index(k, e)
 
-	s.data[e.EntityID()] = append(s.data[e.EntityID()], d)
+	switch e.(type) {
+	case *bucket.Closed, *bucket.Archived, *bucket.Purged:
+		s.track(k, true)
+	case *bucket.Opened, *bucket.Reopened:
+		s.track(k, false)
+	}
 
 	return nil
 }
 
+// index updates tag index with the tag events. Other events do not take the lock of the index
+func (s *store) index(k backend.Key, e events.Event) {
+	switch e := e.(type) {
+	case *bucket.TagAdded:
+		s.tagMtx.Lock()
+		defer s.tagMtx.Unlock()
+
+		if s.tags[k.Tenant] == nil {
+			s.tags[k.Tenant] = map[bucket.Tag]map[events.EntityID]bool{}
+		}
+		if s.tags[k.Tenant][e.Tag] == nil {
+			s.tags[k.Tenant][e.Tag] = map[events.EntityID]bool{}
+		}
+		s.tags[k.Tenant][e.Tag][k.ID] = true
+
+	case *bucket.TagRemoved:
+		s.tagMtx.Lock()
+		defer s.tagMtx.Unlock()
+
+		delete(s.tags[k.Tenant][e.Tag], k.ID)
+	}
+}
+
+// reserve moves the title reservation of the stream to given title.
+// Returns *bucket.TitleConflict if other bucket of the tenant has the title
+func (s *store) reserve(k backend.Key, to bucket.Title) error {
+	if s.titles == nil {
+		return nil
+	}
+
+	s.titleMtx.Lock()
+	defer s.titleMtx.Unlock()
+
+	reserved := s.titles[k.Tenant]
+	if reserved == nil {
+		reserved = map[string]events.EntityID{}
+		s.titles[k.Tenant] = reserved
+	}
+
+	if id, ok := reserved[s.titleKey(to)]; ok && id != k.ID {
+		return &bucket.TitleConflict{ID: id}
+	}
+
+	if from, ok := s.held[k]; ok {
+		delete(reserved, from)
+	}
+	reserved[s.titleKey(to)] = k.ID
+	s.held[k] = s.titleKey(to)
+
+	return nil
+}
+
+func (s *store) titleKey(t bucket.Title) string {
+	if s.ignoreCase {
+		return strings.ToLower(string(t))
+	}
+	return string(t)
+}
+
+func (s *store) FindByTags(ctx context.Context, tags []bucket.Tag, match bucket.Match) ([]events.EntityID, error) {
+	const op errors.Op = "inmem.store.FindByTags"
+
+	t, err := backend.TenantOf(ctx)
+	if err != nil {
+		return nil, errors.New(op, errors.KindValidation, "Invalid tenant", err)
+	}
+
+	s.tagMtx.RLock()
+	defer s.tagMtx.RUnlock()
+
+	counts := map[events.EntityID]int{}
+	for _, tag := range tags {
+		for id := range s.tags[t][tag] {
+			counts[id]++
+		}
+	}
+
+	ret := []events.EntityID{}
+	for id, n := range counts {
+		if match == bucket.MatchAny || n == len(tags) {
+			ret = append(ret, id)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+
+	return ret, nil
+}
+
 func (s *store) GetStream(ctx context.Context, id events.EntityID) ([]events.Event, error) {
 	const op errors.Op = "inmem.store.GetStream"
-	s.mtx.RLock()
-	defer s.mtx.RUnlock()
 
-	daos, ok := s.data[id]
-	if !ok {
+	k, err := backend.KeyOf(ctx, id)
+	if err != nil {
+		return []events.Event{}, err
+	}
+
+	sh := s.shard(k)
+	sh.mtx.RLock()
+	defer sh.mtx.RUnlock()
+
+	daos, ok := sh.stream(k)
+	if ok {
+		s.touch(k)
+		return s.decodeToEvents(ctx, id, daos)
+	}
+
+	if sh.dropped[k] {
+		return []events.Event{}, errors.New(op, errors.KindUnexpected, "Stream is dropped", ErrDropped)
+	}
+
+	if !sh.isSpilled(k) {
 		return []events.Event{}, errors.New(op, errors.KindNotFound, "Stream not found")
 	}
 
-	return decodeToEvents(id, daos)
+	daos, err = s.load(ctx, k)
+	if err != nil {
+		return []events.Event{}, errors.New(op, errors.KindUnexpected, "Could not read spilled stream", err)
+	}
+
+	return s.decodeToEvents(ctx, id, daos)
 }
 
-func decodeToEvents(id events.EntityID, daos []dao) ([]events.Event, error) {
+func (s *store) decodeToEvents(ctx context.Context, id events.EntityID, daos []dao) ([]events.Event, error) {
 	const op errors.Op = "inmem.decodeToEvents"
 
 	ret := make([]events.Event, len(daos))
 
 	for i, dao := range daos {
 
-		e, err := dao.decode(id)
+		e, err := s.decode(ctx, id, dao)
 		if err != nil {
 			return []events.Event{}, errors.New(op, errors.KindUnexpected, "decoding error", err)
 		}
@@ -118,22 +500,306 @@ func decodeToEvents(id events.EntityID, daos []dao) ([]events.Event, error) {
 	return ret, nil
 }
 
-func (s *store) exists(id events.EntityID) bool {
+func (s *store) VerifyStream(ctx context.Context, id events.EntityID) error {
+	stream, err := s.GetStream(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return events.Verify(stream)
+}
+
+func (s *store) ReadFeed(ctx context.Context, after uint64, limit int) ([]bucket.FeedEntry, error) {
+	const op errors.Op = "inmem.store.ReadFeed"
+
+	// feed is only appended, so the refs can be read without the lock,
+	// which is not held while taking shard locks
+	s.feedMtx.RLock()
+	feed := s.feed
+	s.feedMtx.RUnlock()
+
+	ret := []bucket.FeedEntry{}
+	spilled := map[backend.Key][]dao{} // spilled streams are read once per call
+
+	for i := after; i < uint64(len(feed)) && len(ret) < limit; i++ {
+		ref := feed[i]
+
+		e, ok, err := s.event(ctx, ref, spilled)
+		if stderrors.Is(err, ErrDropped) {
+			lost := errors.New(op, errors.KindUnexpected, fmt.Sprintf("Event %d of stream %s is lost", ref.v, ref.k.ID), err)
+			ret = append(ret, bucket.FeedEntry{Position: i + 1, Tenant: ref.k.Tenant, Err: lost})
+			continue
+		}
+		if err != nil {
+			return nil, errors.New(op, errors.KindUnexpected, "Could not read event", err)
+		}
+		if !ok {
+			continue
+		}
+
+		ret = append(ret, bucket.FeedEntry{Position: i + 1, Tenant: ref.k.Tenant, Event: e})
+	}
+
+	return ret, nil
+}
+
+// event returns the event referred from the feed, or false if it has been purged
+func (s *store) event(ctx context.Context, ref feedRef, spilled map[backend.Key][]dao) (events.Event, bool, error) {
+	sh := s.shard(ref.k)
+	sh.mtx.RLock()
+	defer sh.mtx.RUnlock()
+
+	if sh.dropped[ref.k] {
+		return nil, false, ErrDropped
+	}
+
+	daos, ok := sh.stream(ref.k)
+	if !ok && sh.isSpilled(ref.k) {
+		if daos, ok = spilled[ref.k]; !ok {
+			var err error
+			if daos, err = s.load(ctx, ref.k); err != nil {
+				return nil, false, err
+			}
+			spilled[ref.k] = daos
+		}
+	}
+
+	// streams start from version 1, or from the tombstone if purged
+	if len(daos) == 0 || ref.v < daos[0].v {
+		return nil, false, nil
+	}
+
+	idx := int(ref.v - daos[0].v)
+	if idx >= len(daos) || daos[idx].v != ref.v {
+		return nil, false, nil
+	}
+
+	e, err := s.decode(tenant.NewContext(ctx, ref.k.Tenant), ref.k.ID, daos[idx])
+	if err != nil {
+		return nil, false, err
+	}
+
+	return e, true, nil
+}
+
+// Streams returns references to the hot, archived, spilled and dropped streams of all tenants, sorted by tenant and id
+func (s *store) Streams(ctx context.Context) ([]bucket.StreamRef, error) {
+	ret := []bucket.StreamRef{}
+
+	for _, sh := range s.shards {
+		sh.mtx.RLock()
+		for _, m := range []map[backend.Key][]dao{sh.data, sh.cold} {
+			for k := range m {
+				ret = append(ret, bucket.StreamRef{Tenant: k.Tenant, ID: k.ID})
+			}
+		}
+		for _, m := range []map[backend.Key]bool{sh.spilled, sh.dropped} {
+			for k := range m {
+				ret = append(ret, bucket.StreamRef{Tenant: k.Tenant, ID: k.ID})
+			}
+		}
+		sh.mtx.RUnlock()
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Tenant != ret[j].Tenant {
+			return ret[i].Tenant < ret[j].Tenant
+		}
+		return ret[i].ID < ret[j].ID
+	})
+
+	return ret, nil
+}
+
+func (s *store) ArchiveStream(ctx context.Context, e *bucket.Archived) error {
+	const op errors.Op = "inmem.store.ArchiveStream"
+
+	return s.archive(ctx, op, e, time.Now())
+}
+
+// archive appends the event stored at given time and moves the stream to cold streams
+func (s *store) archive(ctx context.Context, op errors.Op, e *bucket.Archived, at time.Time) error {
+	k, err := backend.KeyOf(ctx, e.EntityID())
+	if err != nil {
+		return err
+	}
+
+	defer s.enforce(ctx)
+
+	sh := s.shard(k)
+	sh.mtx.Lock()
+	defer sh.mtx.Unlock()
+
+	if err := s.restore(ctx, sh, k); err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not load spilled stream", err)
+	}
+
+	if sh.archived(k) {
+		return errors.New(op, errors.KindExpected, "Stream is archived")
+	}
+
+	if !sh.exists(k) {
+		return errors.New(op, errors.KindNotFound, "Stream not found")
+	}
+
+	if sh.nextVersion(k) != e.EntityVersion() {
+		return errors.New(op, errors.KindUnexpected, "version error")
+	}
+
+	if err := s.insert(ctx, sh, k, e, at); err != nil {
+		return err
+	}
+
+	sh.cold[k] = sh.data[k]
+	delete(sh.data, k)
+
+	return nil
+}
+
+func (s *store) PurgeStream(ctx context.Context, e *bucket.Purged) error {
+	const op errors.Op = "inmem.store.PurgeStream"
+
+	return s.purge(ctx, op, e, false)
+}
+
+// ImportTombstone erases the stream like PurgeStream, but the tombstone can skip versions, see bucket.Importer
+func (s *store) ImportTombstone(ctx context.Context, e *bucket.Purged) error {
+	const op errors.Op = "inmem.store.ImportTombstone"
 
-	_, ok := s.data[id]
+	return s.purge(ctx, op, e, true)
+}
+
+// ImportEvent writes the event like OpenStream, InsertEvent or ArchiveStream, but keeps its store time, see bucket.Importer
+func (s *store) ImportEvent(ctx context.Context, e events.Event) error {
+	const op errors.Op = "inmem.store.ImportEvent"
+
+	at, err := backend.Imported(op, e)
+	if err != nil {
+		return err
+	}
+
+	switch e := e.(type) {
+	case *bucket.Opened:
+		return s.open(ctx, op, e, at)
+	case *bucket.Archived:
+		return s.archive(ctx, op, e, at)
+	default:
+		return s.add(ctx, op, e, at)
+	}
+}
+
+// purge replaces the stream with the tombstone, which follows the last event or with skip any earlier event
+func (s *store) purge(ctx context.Context, op errors.Op, e *bucket.Purged, skip bool) error {
+	k, err := backend.KeyOf(ctx, e.EntityID())
+	if err != nil {
+		return err
+	}
+
+	defer s.enforce(ctx)
+
+	sh := s.shard(k)
+	sh.mtx.Lock()
+	defer sh.mtx.Unlock()
+
+	if err := s.restore(ctx, sh, k); err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not load spilled stream", err)
+	}
+
+	daos, ok := sh.stream(k)
+	if !ok {
+		return errors.New(op, errors.KindNotFound, "Stream not found")
+	}
+
+	if err := backend.Tombstone(op, e, daos[len(daos)-1].v, skip); err != nil {
+		return err
+	}
+
+	s.release(k)
+	s.account(daos, -1)
+	delete(sh.data, k)
+	delete(sh.cold, k)
+
+	return s.insert(ctx, sh, k, e, time.Now())
+}
+
+// release removes title reservation and tags of the stream
+func (s *store) release(k backend.Key) {
+	s.titleMtx.Lock()
+	if title, ok := s.held[k]; ok {
+		delete(s.titles[k.Tenant], title)
+		delete(s.held, k)
+	}
+	s.titleMtx.Unlock()
+
+	s.tagMtx.Lock()
+	for _, ids := range s.tags[k.Tenant] {
+		delete(ids, k.ID)
+	}
+	s.tagMtx.Unlock()
+}
+
+// stream returns the hot or archived stream
+func (sh *shard) stream(k backend.Key) ([]dao, bool) {
+	daos, ok := sh.data[k]
+	if !ok {
+		daos, ok = sh.cold[k]
+	}
+
+	return daos, ok
+}
+
+func (sh *shard) isSpilled(k backend.Key) bool {
+
+	_, ok := sh.spilled[k]
+
+	return ok
+}
+
+func (sh *shard) archived(k backend.Key) bool {
+
+	_, ok := sh.cold[k]
+
+	return ok
+}
+
+func (sh *shard) exists(k backend.Key) bool {
+
+	_, ok := sh.data[k]
 
 	return ok
 }
 
-func (s *store) nextVersion(id events.EntityID) events.EntityVersion {
-	return events.EntityVersion(len(s.data[id]) + 1)
+func (sh *shard) nextVersion(k backend.Key) events.EntityVersion {
+	return events.EntityVersion(len(sh.data[k]) + 1)
 }
 
 // data access object
 type dao struct {
 	t    string // value from events.Event.Type()
 	v    events.EntityVersion
+	at   time.Time // time when event was stored
+	hash string    // hash chaining the event to the previous one
 	data interface{}
+	size int64 // approximate size in memory, see sizeOf
+}
+
+// daoOverhead is the approximate size of dao and its slice entry without the data
+const daoOverhead = 128
+
+// sizeOf returns the approximate size of the dao, encoding its data if not allready encoded
+func (d *dao) sizeOf() int64 {
+	n := daoOverhead + len(d.t) + len(d.hash)
+
+	switch data := d.data.(type) {
+	case nil:
+	case codec.Record:
+		n += len(data.Type) + len(data.ID) + len(data.KeyID) + len(data.Data)
+	default:
+		b, _ := json.Marshal(data)
+		n += len(b)
+	}
+
+	return int64(n)
 }
 
 func (d *dao) encode(e events.Event) {
@@ -142,28 +808,117 @@ func (d *dao) encode(e events.Event) {
 	d.data = e.Data()
 }
 
+// decode decodes the dao with the codec of the store, if it was encoded with one
+func (s *store) decode(ctx context.Context, id events.EntityID, d dao) (events.Event, error) {
+	r, ok := d.data.(codec.Record)
+	if !ok {
+		return d.decode(id)
+	}
+
+	r.At = d.at
+	r.Hash = d.hash
+
+	return s.codec.Decode(ctx, r)
+}
+
 // decodes dao and given id to returned event,
 // returns error and nil if type string dao.ts has unknown value
 func (d dao) decode(id events.EntityID) (events.Event, error) {
 	const op errors.Op = "inmem.dao.build"
 
 	eb := events.Base{
-		ID: id,
-		V:  d.v,
+		ID:  id,
+		V:   d.v,
+		At:  d.at,
+		Sum: d.hash,
 	}
 
 	switch d.t {
 	case "bucket.Opened":
-		data := d.data.(bucket.BucketData)
-		return &bucket.Opened{Base: eb, BucketData: data}, nil
+		data, ok := d.data.(bucket.OpenedData)
+		if !ok {
+			return nil, errors.New(op, errors.KindUnexpected, "Invalid data for event type: "+d.t)
+		}
+		return &bucket.Opened{Base: eb, BucketData: data.BucketData, Capacity: data.Capacity, Owner: data.Owner}, nil
 
 	case "bucket.Updated":
-		data := d.data.(bucket.BucketData)
+		data, ok := d.data.(bucket.BucketData)
+		if !ok {
+			return nil, errors.New(op, errors.KindUnexpected, "Invalid data for event type: "+d.t)
+		}
 		return &bucket.Updated{Base: eb, BucketData: data}, nil
 
 	case "bucket.Closed":
 		return &bucket.Closed{Base: eb}, nil
 
+	case "bucket.Reopened":
+		return &bucket.Reopened{Base: eb}, nil
+
+	case "bucket.ItemAdded":
+		data, ok := d.data.(bucket.Item)
+		if !ok {
+			return nil, errors.New(op, errors.KindUnexpected, "Invalid data for event type: "+d.t)
+		}
+		return &bucket.ItemAdded{Base: eb, Item: data}, nil
+
+	case "bucket.ItemRemoved":
+		data, ok := d.data.(bucket.ItemID)
+		if !ok {
+			return nil, errors.New(op, errors.KindUnexpected, "Invalid data for event type: "+d.t)
+		}
+		return &bucket.ItemRemoved{Base: eb, ItemID: data}, nil
+
+	case "bucket.ItemMoved":
+		data, ok := d.data.(bucket.ItemPosition)
+		if !ok {
+			return nil, errors.New(op, errors.KindUnexpected, "Invalid data for event type: "+d.t)
+		}
+		return &bucket.ItemMoved{Base: eb, ItemPosition: data}, nil
+
+	case "bucket.CapacityChanged":
+		data, ok := d.data.(bucket.Capacity)
+		if !ok {
+			return nil, errors.New(op, errors.KindUnexpected, "Invalid data for event type: "+d.t)
+		}
+		return &bucket.CapacityChanged{Base: eb, Capacity: data}, nil
+
+	case "bucket.AccessGranted":
+		data, ok := d.data.(bucket.Grant)
+		if !ok {
+			return nil, errors.New(op, errors.KindUnexpected, "Invalid data for event type: "+d.t)
+		}
+		return &bucket.AccessGranted{Base: eb, Grant: data}, nil
+
+	case "bucket.AccessRevoked":
+		data, ok := d.data.(principal.ID)
+		if !ok {
+			return nil, errors.New(op, errors.KindUnexpected, "Invalid data for event type: "+d.t)
+		}
+		return &bucket.AccessRevoked{Base: eb, Principal: data}, nil
+
+	case "bucket.TagAdded":
+		data, ok := d.data.(bucket.Tag)
+		if !ok {
+			return nil, errors.New(op, errors.KindUnexpected, "Invalid data for event type: "+d.t)
+		}
+		return &bucket.TagAdded{Base: eb, Tag: data}, nil
+
+	case "bucket.TagRemoved":
+		data, ok := d.data.(bucket.Tag)
+		if !ok {
+			return nil, errors.New(op, errors.KindUnexpected, "Invalid data for event type: "+d.t)
+		}
+		return &bucket.TagRemoved{Base: eb, Tag: data}, nil
+
+	case "bucket.Archived":
+		return &bucket.Archived{Base: eb}, nil
+
+	case "bucket.Forgotten":
+		return &bucket.Forgotten{Base: eb}, nil
+
+	case "bucket.Purged":
+		return &bucket.Purged{Base: eb}, nil
+
 	default:
 		return nil, errors.New(op, errors.KindUnexpected, "Unkown event type: "+d.t)
 	}
@@ -0,0 +1,276 @@
+package inmem
+
+import (
+	"context"
+	stderrors "errors"
+	"sync/atomic"
+
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/juelko/bucket/store/codec"
+	"github.com/juelko/bucket/store/internal/backend"
+)
+
+// ErrDropped is wrapped in the errors of using streams evicted without Spilling store
+var ErrDropped = stderrors.New("stream is evicted without spill store and its events are lost")
+
+// Usage returns the current memory usage of the store
+func (s *store) Usage() Usage {
+	return Usage{
+		Streams:     atomic.LoadInt64(&s.streams),
+		Events:      atomic.LoadInt64(&s.events),
+		Bytes:       atomic.LoadInt64(&s.bytes),
+		Spilled:     atomic.LoadInt64(&s.spilled),
+		Evictions:   atomic.LoadInt64(&s.evictions),
+		SpillErrors: atomic.LoadInt64(&s.spillErrors),
+	}
+}
+
+// account adds the stream to the usage with sign 1, or removes it with sign -1
+func (s *store) account(daos []dao, sign int64) {
+	var size int64
+	for _, d := range daos {
+		size += d.size
+	}
+
+	atomic.AddInt64(&s.streams, sign)
+	atomic.AddInt64(&s.events, sign*int64(len(daos)))
+	atomic.AddInt64(&s.bytes, sign*size)
+}
+
+func (s *store) bounded() bool {
+	return s.limits != Limits{}
+}
+
+// over returns true if the usage exceeds any of the limits
+func (s *store) over() bool {
+	u, l := s.Usage(), s.limits
+
+	return (l.MaxStreams > 0 && u.Streams > l.MaxStreams) ||
+		(l.MaxEvents > 0 && u.Events > l.MaxEvents) ||
+		(l.MaxBytes > 0 && u.Bytes > l.MaxBytes)
+}
+
+// track adds the closed stream to the evictable streams as most recently used, or removes the reopened stream
+func (s *store) track(k backend.Key, closed bool) {
+	if !s.bounded() {
+		return
+	}
+
+	s.lruMtx.Lock()
+	defer s.lruMtx.Unlock()
+
+	el, ok := s.lruAt[k]
+
+	switch {
+	case closed && ok:
+		s.lru.MoveToFront(el)
+	case closed:
+		s.lruAt[k] = s.lru.PushFront(k)
+	case ok:
+		s.lru.Remove(el)
+		delete(s.lruAt, k)
+	}
+}
+
+// touch marks the evictable stream as most recently used
+func (s *store) touch(k backend.Key) {
+	if !s.bounded() {
+		return
+	}
+
+	s.lruMtx.Lock()
+	defer s.lruMtx.Unlock()
+
+	if el, ok := s.lruAt[k]; ok {
+		s.lru.MoveToFront(el)
+	}
+}
+
+// victim removes the least recently used stream from the evictable streams
+func (s *store) victim() (backend.Key, bool) {
+	s.lruMtx.Lock()
+	defer s.lruMtx.Unlock()
+
+	el := s.lru.Back()
+	if el == nil {
+		return backend.Key{}, false
+	}
+
+	k := s.lru.Remove(el).(backend.Key)
+	delete(s.lruAt, k)
+
+	return k, true
+}
+
+// enforce evicts streams until the usage is within the limits or there are no closed streams in memory.
+// Caller must not hold any shard lock
+func (s *store) enforce(ctx context.Context) {
+	if !s.bounded() {
+		return
+	}
+
+	for s.over() {
+		k, ok := s.victim()
+		if !ok {
+			return
+		}
+
+		if !s.evict(ctx, k) {
+			// spill store failing, try again on next write
+			s.lruMtx.Lock()
+			if _, ok := s.lruAt[k]; !ok {
+				s.lruAt[k] = s.lru.PushBack(k)
+			}
+			s.lruMtx.Unlock()
+			return
+		}
+	}
+}
+
+// evict moves the stream to the spill store or drops it. Returns false if the spill store failed
+func (s *store) evict(ctx context.Context, k backend.Key) bool {
+	sh := s.shard(k)
+	sh.mtx.Lock()
+	defer sh.mtx.Unlock()
+
+	daos, ok := sh.stream(k)
+
+	// stream may have been reopened or purged after it was chosen
+	if !ok || !closed(daos) {
+		return true
+	}
+
+	archived := sh.archived(k)
+
+	if s.spill != nil {
+		records, err := s.toRecords(ctx, k, daos)
+		if err == nil {
+			err = s.spill.Save(ctx, k.Tenant, k.ID, records)
+		}
+		if err != nil {
+			atomic.AddInt64(&s.spillErrors, 1)
+			return false
+		}
+
+		sh.spilled[k] = archived
+		atomic.AddInt64(&s.spilled, 1)
+	} else {
+		s.release(k)
+		sh.dropped[k] = true
+	}
+
+	delete(sh.data, k)
+	delete(sh.cold, k)
+	s.account(daos, -1)
+	atomic.AddInt64(&s.evictions, 1)
+
+	return true
+}
+
+// restore loads the spilled stream back to memory, caller holds the lock of the shard
+func (s *store) restore(ctx context.Context, sh *shard, k backend.Key) error {
+	const op errors.Op = "inmem.store.restore"
+
+	if sh.dropped[k] {
+		return errors.New(op, errors.KindUnexpected, "Stream is dropped", ErrDropped)
+	}
+
+	archived, ok := sh.spilled[k]
+	if !ok {
+		return nil
+	}
+
+	daos, err := s.load(ctx, k)
+	if err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not load stream", err)
+	}
+
+	if err := s.spill.Remove(ctx, k.Tenant, k.ID); err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not remove stream from spill store", err)
+	}
+
+	if archived {
+		sh.cold[k] = daos
+	} else {
+		sh.data[k] = daos
+	}
+	delete(sh.spilled, k)
+
+	atomic.AddInt64(&s.spilled, -1)
+	s.account(daos, 1)
+	s.track(k, closed(daos))
+
+	return nil
+}
+
+// load reads the spilled stream
+func (s *store) load(ctx context.Context, k backend.Key) ([]dao, error) {
+	const op errors.Op = "inmem.store.load"
+
+	records, err := s.spill.Load(ctx, k.Tenant, k.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = tenant.NewContext(ctx, k.Tenant)
+	ret := make([]dao, len(records))
+
+	for i, r := range records {
+		d := dao{t: r.Type, v: r.Version, at: r.At, hash: r.Hash, data: r}
+
+		// events of store without encoding are kept decoded
+		if s.codec == nil {
+			e, err := codec.JSON().Decode(ctx, r)
+			if err != nil {
+				return nil, errors.New(op, errors.KindUnexpected, "decoding error", err)
+			}
+			d.data = e.Data()
+		}
+
+		d.size = d.sizeOf()
+		ret[i] = d
+	}
+
+	return ret, nil
+}
+
+// toRecords returns the records saved to the spill store, keeping the store times and hashes
+func (s *store) toRecords(ctx context.Context, k backend.Key, daos []dao) ([]codec.Record, error) {
+	const op errors.Op = "inmem.store.toRecords"
+
+	ret := make([]codec.Record, len(daos))
+
+	for i, d := range daos {
+		if r, ok := d.data.(codec.Record); ok {
+			r.At, r.Hash = d.at, d.hash
+			ret[i] = r
+			continue
+		}
+
+		e, err := d.decode(k.ID)
+		if err != nil {
+			return nil, errors.New(op, errors.KindUnexpected, "decoding error", err)
+		}
+
+		if ret[i], err = codec.JSON().Encode(ctx, e); err != nil {
+			return nil, errors.New(op, errors.KindUnexpected, "encoding error", err)
+		}
+	}
+
+	return ret, nil
+}
+
+// closed returns true if the last lifecycle event of the stream closes, archives or purges it
+func closed(daos []dao) bool {
+	for i := len(daos) - 1; i >= 0; i-- {
+		switch daos[i].t {
+		case "bucket.Closed", "bucket.Archived", "bucket.Purged":
+			return true
+		case "bucket.Opened", "bucket.Reopened":
+			return false
+		}
+	}
+
+	return false
+}
@@ -2,14 +2,34 @@ package inmem
 
 import (
 	"context"
+	stderrors "errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/bucket/buckettest"
 	"github.com/juelko/bucket/pkg/errors"
 	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/juelko/bucket/store/codec"
+	"github.com/juelko/bucket/store/internal/backend"
+	"github.com/juelko/bucket/store/storetest"
 	"github.com/stretchr/testify/require"
 )
 
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.Check(t, func(t *testing.T, unique bool) storetest.Store {
+		if unique {
+			return NewBucketStore(UniqueTitles(true)).(*store)
+		}
+		return NewBucketStore().(*store)
+	})
+}
+
 func TestGetStream(t *testing.T) {
 	t.Parallel()
 
@@ -28,6 +48,7 @@ func TestGetStream(t *testing.T) {
 				&bucket.Opened{
 					Base:       events.Base{ID: "OpenID", V: 1},
 					BucketData: bucket.BucketData{Title: "OpenTitle", Description: "Open Description"},
+					Owner:      "TestOwner",
 				},
 			},
 			err: nil,
@@ -44,7 +65,7 @@ func TestGetStream(t *testing.T) {
 		t.Run(tC.desc, func(t *testing.T) {
 			t.Parallel()
 
-			got, err := store.GetStream(context.Background(), tC.args)
+			got, err := store.GetStream(tenant.NewContext(context.Background(), tenant.Default), tC.args)
 
 			if len(tC.want) != 0 {
 				require.Nil(t, err, "error should be nil")
@@ -90,10 +111,514 @@ func TestOpenStream(t *testing.T) {
 		t.Run(tC.desc, func(t *testing.T) {
 			t.Parallel()
 
-			got := store.OpenStream(context.Background(), tC.args)
+			got := store.OpenStream(tenant.NewContext(context.Background(), tenant.Default), tC.args)
 
 			require.Equal(t, tC.want, got, "got should be equal")
 		})
 	}
 
 }
+
+func TestTenants(t *testing.T) {
+	t.Parallel()
+
+	store := NewBucketStore()
+
+	first := tenant.NewContext(context.Background(), "First")
+	second := tenant.NewContext(context.Background(), "Second")
+
+	open := func(title bucket.Title) *bucket.Opened {
+		return &bucket.Opened{
+			Base:       events.Base{ID: "SameID", V: 1},
+			BucketData: bucket.BucketData{Title: title},
+		}
+	}
+
+	require.Nil(t, store.OpenStream(first, open("FirstTitle")))
+	require.Nil(t, store.OpenStream(second, open("SecondTitle")))
+	require.Equal(t, &errors.Error{Op: "inmem.store.OpenStream", Kind: 5, Msg: "Allready exists"}, store.OpenStream(first, open("FirstTitle")))
+
+	require.Nil(t, store.InsertEvent(second, &bucket.Closed{Base: events.Base{ID: "SameID", V: 2}}))
+
+	got, err := store.GetStream(first, "SameID")
+	require.Nil(t, err)
+	require.Len(t, got, 1)
+	require.Equal(t, bucket.Title("FirstTitle"), got[0].(*bucket.Opened).Title)
+
+	got, err = store.GetStream(second, "SameID")
+	require.Nil(t, err)
+	require.Len(t, got, 2)
+	require.Equal(t, bucket.Title("SecondTitle"), got[0].(*bucket.Opened).Title)
+
+	_, err = store.GetStream(tenant.NewContext(context.Background(), tenant.Default), "SameID")
+	require.Equal(t, &errors.Error{Op: "inmem.store.GetStream", Kind: 4, Msg: "Stream not found"}, err)
+
+	_, err = store.GetStream(tenant.NewContext(context.Background(), "Invalid/Tenant"), "SameID")
+	require.NotNil(t, err)
+	require.Equal(t, errors.KindValidation, err.(*errors.Error).Kind)
+}
+
+func TestUniqueTitles(t *testing.T) {
+	t.Parallel()
+
+	conflict := func(op errors.Op, id events.EntityID) error {
+		return &errors.Error{Op: op, Kind: errors.KindAllreadyExists, Msg: "Title allready in use", Wraps: &bucket.TitleConflict{ID: id}}
+	}
+	opened := func(id events.EntityID, title bucket.Title) *bucket.Opened {
+		return &bucket.Opened{Base: events.Base{ID: id, V: 1}, BucketData: bucket.BucketData{Title: title}}
+	}
+	updated := func(id events.EntityID, v events.EntityVersion, title bucket.Title) *bucket.Updated {
+		return &bucket.Updated{Base: events.Base{ID: id, V: v}, BucketData: bucket.BucketData{Title: title}}
+	}
+
+	t.Run("case sensitive", func(t *testing.T) {
+		t.Parallel()
+
+		store := NewBucketStore(UniqueTitles(false))
+		ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+		require.Nil(t, store.OpenStream(ctx, opened("FirstID", "Title")))
+		require.Equal(t, conflict("inmem.store.OpenStream", "FirstID"), store.OpenStream(ctx, opened("SecondID", "Title")))
+		require.Nil(t, store.OpenStream(ctx, opened("SecondID", "TITLE")))
+
+		require.Equal(t, conflict("inmem.store.InsertEvent", "SecondID"), store.InsertEvent(ctx, updated("FirstID", 2, "TITLE")))
+		require.Nil(t, store.InsertEvent(ctx, updated("FirstID", 2, "Title")), "bucket can keep its own title")
+		require.Nil(t, store.InsertEvent(ctx, updated("FirstID", 3, "Renamed")))
+		require.Nil(t, store.OpenStream(ctx, opened("ThirdID", "Title")), "old title should be released")
+	})
+
+	t.Run("case insensitive", func(t *testing.T) {
+		t.Parallel()
+
+		store := NewBucketStore(UniqueTitles(true))
+		ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+		require.Nil(t, store.OpenStream(ctx, opened("FirstID", "Title")))
+		require.Equal(t, conflict("inmem.store.OpenStream", "FirstID"), store.OpenStream(ctx, opened("SecondID", "TITLE")))
+	})
+
+	t.Run("per tenant", func(t *testing.T) {
+		t.Parallel()
+
+		store := NewBucketStore(UniqueTitles(false))
+
+		require.Nil(t, store.OpenStream(tenant.NewContext(context.Background(), "First"), opened("FirstID", "Title")))
+		require.Nil(t, store.OpenStream(tenant.NewContext(context.Background(), "Second"), opened("SecondID", "Title")))
+	})
+
+	t.Run("not unique", func(t *testing.T) {
+		t.Parallel()
+
+		store := NewBucketStore()
+		ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+		require.Nil(t, store.OpenStream(ctx, opened("FirstID", "Title")))
+		require.Nil(t, store.OpenStream(ctx, opened("SecondID", "Title")))
+	})
+}
+
+func TestEncoding(t *testing.T) {
+	t.Parallel()
+
+	keys, err := codec.NewKeyRing("TestKey", map[string][]byte{"TestKey": []byte("0123456789abcdef")})
+	require.Nil(t, err)
+
+	s := NewBucketStore(Encoding(codec.NewEncrypted(codec.JSON(), keys)), UniqueTitles(false)).(*store)
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+	require.Nil(t, s.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: "TestBucket", V: 1}, BucketData: bucket.BucketData{Title: "TestTitle"}, Owner: "TestOwner"}))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.Updated{Base: events.Base{ID: "TestBucket", V: 2}, BucketData: bucket.BucketData{Title: "NewTitle"}}))
+
+	k := backend.Key{Tenant: tenant.Default, ID: "TestBucket"}
+	r, ok := s.shard(k).data[k][0].data.(codec.Record)
+	require.True(t, ok, "event should be stored encoded")
+	require.Equal(t, "TestKey", r.KeyID)
+
+	stream, err := s.GetStream(ctx, "TestBucket")
+	require.Nil(t, err)
+	require.Len(t, stream, 2)
+	require.Equal(t, bucket.Title("NewTitle"), stream[1].(*bucket.Updated).Title)
+	require.False(t, stream[1].Timestamp().IsZero())
+
+	err = s.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: "OtherBucket", V: 1}, BucketData: bucket.BucketData{Title: "NewTitle"}, Owner: "TestOwner"})
+	require.NotNil(t, err, "title reservation should work with encoded events")
+
+	s.shard(k).data[k][0].data = codec.Record{Type: r.Type, ID: r.ID, Version: r.Version, KeyID: r.KeyID, Data: r.Data[:len(r.Data)-1]}
+
+	_, err = s.GetStream(ctx, "TestBucket")
+	require.NotNil(t, err, "tampering should be detected")
+}
+
+func TestVerifyStream(t *testing.T) {
+	t.Parallel()
+
+	s := NewBucketStore().(*store)
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	other := tenant.NewContext(ctx, "Other")
+
+	require.Nil(t, s.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: "TestBucket", V: 1}, BucketData: bucket.BucketData{Title: "TestTitle"}, Owner: "TestOwner"}))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.Updated{Base: events.Base{ID: "TestBucket", V: 2}, BucketData: bucket.BucketData{Title: "NewTitle"}}))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.Closed{Base: events.Base{ID: "TestBucket", V: 3}}))
+	require.Nil(t, s.ArchiveStream(ctx, &bucket.Archived{Base: events.Base{ID: "TestBucket", V: 4}}))
+	require.Nil(t, s.OpenStream(other, &bucket.Opened{Base: events.Base{ID: "OtherBucket", V: 1}, BucketData: bucket.BucketData{Title: "TestTitle"}, Owner: "TestOwner"}))
+	require.Nil(t, s.PurgeStream(other, &bucket.Purged{Base: events.Base{ID: "OtherBucket", V: 2}}))
+
+	require.Nil(t, s.VerifyStream(ctx, "TestBucket"))
+	require.Nil(t, s.VerifyStream(other, "OtherBucket"), "tombstone should start a new chain")
+
+	refs, err := s.Streams(ctx)
+	require.Nil(t, err)
+	require.Equal(t, []bucket.StreamRef{{Tenant: "Other", ID: "OtherBucket"}, {Tenant: tenant.Default, ID: "TestBucket"}}, refs)
+
+	k := backend.Key{Tenant: tenant.Default, ID: "TestBucket"}
+	s.shard(k).cold[k][1].data = bucket.BucketData{Title: "Tampered"}
+
+	err = s.VerifyStream(ctx, "TestBucket")
+	require.NotNil(t, err)
+	require.Equal(t, "Hash chain broken at version 2", err.(*errors.Error).Msg)
+}
+
+func TestReadFeed(t *testing.T) {
+	t.Parallel()
+
+	s := NewBucketStore().(*store)
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	other := tenant.NewContext(ctx, "Other")
+
+	require.Nil(t, s.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: "TestBucket", V: 1}, BucketData: bucket.BucketData{Title: "TestTitle"}, Owner: "TestOwner"}))
+	require.Nil(t, s.OpenStream(other, &bucket.Opened{Base: events.Base{ID: "OtherBucket", V: 1}, BucketData: bucket.BucketData{Title: "TestTitle"}, Owner: "TestOwner"}))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.Closed{Base: events.Base{ID: "TestBucket", V: 2}}))
+	require.Nil(t, s.PurgeStream(other, &bucket.Purged{Base: events.Base{ID: "OtherBucket", V: 2}}))
+
+	entries, err := s.ReadFeed(ctx, 0, 10)
+	require.Nil(t, err)
+
+	got := []string{}
+	for _, e := range entries {
+		got = append(got, fmt.Sprintf("%d %s %s %d", e.Position, e.Tenant, e.Event.EntityID(), e.Event.EntityVersion()))
+	}
+	require.Equal(t, []string{"1 default TestBucket 1", "3 default TestBucket 2", "4 Other OtherBucket 2"}, got, "purged events should be skipped")
+
+	entries, err = s.ReadFeed(ctx, 1, 1)
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, uint64(3), entries[0].Position)
+
+	entries, err = s.ReadFeed(ctx, 4, 10)
+	require.Nil(t, err)
+	require.Empty(t, entries)
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	t.Parallel()
+
+	s := NewBucketStore()
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+	require.Nil(t, s.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: "SharedBucket", V: 1}, BucketData: bucket.BucketData{Title: "Shared"}, Owner: "TestOwner"}))
+
+	var wg sync.WaitGroup
+	var written int32
+
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// only one of the writers of the same version may succeed
+			if s.InsertEvent(ctx, &bucket.Closed{Base: events.Base{ID: "SharedBucket", V: 2}}) == nil {
+				atomic.AddInt32(&written, 1)
+			}
+
+			// writers of other buckets do not interfere
+			id := events.EntityID(fmt.Sprintf("Bucket%d", i))
+			require.Nil(t, s.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: id, V: 1}, BucketData: bucket.BucketData{Title: bucket.Title(id)}, Owner: "TestOwner"}))
+			for v := events.EntityVersion(2); v <= 10; v++ {
+				require.Nil(t, s.InsertEvent(ctx, &bucket.TagAdded{Base: events.Base{ID: id, V: v}, Tag: bucket.Tag(fmt.Sprintf("tag%d", v))}))
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	require.Equal(t, int32(1), written)
+
+	for i := 0; i < 16; i++ {
+		id := events.EntityID(fmt.Sprintf("Bucket%d", i))
+		require.Nil(t, s.(bucket.Verifier).VerifyStream(ctx, id))
+	}
+
+	entries, err := s.(bucket.Feed).ReadFeed(ctx, 0, 1000)
+	require.Nil(t, err)
+	require.Len(t, entries, 2+16*10)
+
+	ids, err := s.(bucket.TagIndex).FindByTags(ctx, []bucket.Tag{"tag10"}, bucket.MatchAll)
+	require.Nil(t, err)
+	require.Len(t, ids, 16)
+}
+
+// benchmarkWrites opens a bucket for each goroutine and appends events to it in parallel
+func benchmarkWrites(b *testing.B, s bucket.Store) {
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	var n int64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		id := events.EntityID(fmt.Sprintf("Bucket%d", atomic.AddInt64(&n, 1)))
+		if err := s.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: id, V: 1}, BucketData: bucket.BucketData{Title: bucket.Title(id)}, Owner: "TestOwner"}); err != nil {
+			b.Fatal(err)
+		}
+
+		v := events.EntityVersion(1)
+		for pb.Next() {
+			v++
+			if err := s.InsertEvent(ctx, &bucket.ItemAdded{Base: events.Base{ID: id, V: v}, Item: bucket.Item{ID: bucket.ItemID(fmt.Sprint(v)), Name: "Item"}}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkParallelWrites compares one lock for all streams to the sharded store,
+// run with -cpu 1,4,16 to see the scaling
+func BenchmarkParallelWrites(b *testing.B) {
+	b.Run("shards=1", func(b *testing.B) { benchmarkWrites(b, NewBucketStore(Shards(1))) })
+	b.Run(fmt.Sprintf("shards=%d", DefaultShards), func(b *testing.B) { benchmarkWrites(b, NewBucketStore()) })
+}
+
+// BenchmarkUpdateTitles renames buckets of a tenant having many buckets with reserved titles
+func BenchmarkUpdateTitles(b *testing.B) {
+	s := NewBucketStore(UniqueTitles(false))
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+	ids := make([]events.EntityID, 10000)
+	for i := range ids {
+		ids[i] = events.EntityID(fmt.Sprintf("Bucket%d", i))
+		if err := s.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: ids[i], V: 1}, BucketData: bucket.BucketData{Title: bucket.Title(ids[i])}, Owner: "TestOwner"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		id := ids[i%len(ids)]
+		title := bucket.Title(fmt.Sprintf("Title%d", i))
+		if err := s.InsertEvent(ctx, &bucket.Updated{Base: events.Base{ID: id, V: events.EntityVersion(i/len(ids) + 2)}, BucketData: bucket.BucketData{Title: title}}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkParallelReadWrite reads shared buckets and writes to own bucket of each goroutine, one write in four operations
+func BenchmarkParallelReadWrite(b *testing.B) {
+	for _, shards := range []int{1, DefaultShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			s := NewBucketStore(Shards(shards))
+			ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+			ids := make([]events.EntityID, 64)
+			for i := range ids {
+				ids[i] = events.EntityID(fmt.Sprintf("Bucket%d", i))
+				if err := s.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: ids[i], V: 1}, BucketData: bucket.BucketData{Title: bucket.Title(ids[i])}, Owner: "TestOwner"}); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			var n int64
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				i := atomic.AddInt64(&n, 1)
+				own := events.EntityID(fmt.Sprintf("Writer%d", i))
+				if err := s.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: own, V: 1}, BucketData: bucket.BucketData{Title: bucket.Title(own)}, Owner: "TestOwner"}); err != nil {
+					b.Fatal(err)
+				}
+
+				v := events.EntityVersion(1)
+				for pb.Next() {
+					i++
+					if i%4 == 0 {
+						v++
+						if err := s.InsertEvent(ctx, &bucket.TagAdded{Base: events.Base{ID: own, V: v}, Tag: "tag"}); err != nil {
+							b.Fatal(err)
+						}
+						continue
+					}
+					if _, err := s.GetStream(ctx, ids[i%int64(len(ids))]); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestBounded(t *testing.T) {
+	t.Parallel()
+
+	spill, err := NewFileSpill(t.TempDir())
+	require.Nil(t, err)
+
+	s := NewBucketStore(Bounded(Limits{MaxStreams: 2}), Spilling(spill))
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+	open := func(id events.EntityID) {
+		require.Nil(t, s.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: id, V: 1}, BucketData: bucket.BucketData{Title: bucket.Title(id)}, Owner: "TestOwner"}))
+	}
+
+	open("FirstBucket")
+	open("SecondBucket")
+	open("ThirdBucket")
+	require.Equal(t, int64(3), s.(Meter).Usage().Streams, "open buckets should not be evicted")
+
+	require.Nil(t, s.InsertEvent(ctx, &bucket.Closed{Base: events.Base{ID: "FirstBucket", V: 2}}))
+	before, err := s.GetStream(ctx, "FirstBucket")
+	require.Nil(t, err)
+
+	require.Nil(t, s.InsertEvent(ctx, &bucket.Closed{Base: events.Base{ID: "SecondBucket", V: 2}}))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.Closed{Base: events.Base{ID: "ThirdBucket", V: 2}}))
+
+	u := s.(Meter).Usage()
+	require.Equal(t, int64(2), u.Streams)
+	require.Equal(t, int64(4), u.Events)
+	require.Equal(t, int64(1), u.Spilled)
+	require.Equal(t, int64(1), u.Evictions)
+	require.True(t, u.Bytes > 0)
+
+	after, err := s.GetStream(ctx, "FirstBucket")
+	require.Nil(t, err, "spilled stream should be read from spill store")
+	require.Len(t, after, 2)
+	for i := range before {
+		require.True(t, events.Equal(before[i], after[i]))
+		require.Equal(t, before[i].Hash(), after[i].Hash(), "spilled stream should keep its hashes")
+	}
+	require.Equal(t, int64(1), s.(Meter).Usage().Spilled, "reading should not load stream back")
+
+	err = s.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: "FirstBucket", V: 1}, BucketData: bucket.BucketData{Title: "Other"}, Owner: "TestOwner"})
+	require.NotNil(t, err, "spilled stream should exist")
+
+	// writing loads the stream back and evicts the least recently used closed one
+	require.Nil(t, s.InsertEvent(ctx, &bucket.Reopened{Base: events.Base{ID: "FirstBucket", V: 3}}))
+	require.Nil(t, s.(bucket.Verifier).VerifyStream(ctx, "FirstBucket"))
+
+	u = s.(Meter).Usage()
+	require.Equal(t, int64(2), u.Streams)
+	require.Equal(t, int64(1), u.Spilled)
+	require.Equal(t, int64(2), u.Evictions)
+
+	_, err = spill.Load(ctx, tenant.Default, "SecondBucket")
+	require.Nil(t, err, "second bucket should be least recently used")
+
+	refs, err := s.(bucket.Scanner).Streams(ctx)
+	require.Nil(t, err)
+	require.Len(t, refs, 3)
+
+	entries, err := s.(bucket.Feed).ReadFeed(ctx, 0, 100)
+	require.Nil(t, err)
+	require.Len(t, entries, 7, "feed should include spilled streams")
+}
+
+func TestBoundedWithoutSpill(t *testing.T) {
+	t.Parallel()
+
+	s := NewBucketStore(Bounded(Limits{MaxEvents: 3}), UniqueTitles(false))
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+	require.Nil(t, s.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: "FirstBucket", V: 1}, BucketData: bucket.BucketData{Title: "Title"}, Owner: "TestOwner"}))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.Closed{Base: events.Base{ID: "FirstBucket", V: 2}}))
+	require.Nil(t, s.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: "SecondBucket", V: 1}, BucketData: bucket.BucketData{Title: "Other"}, Owner: "TestOwner"}))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.TagAdded{Base: events.Base{ID: "SecondBucket", V: 2}, Tag: "tag"}))
+
+	_, err := s.GetStream(ctx, "FirstBucket")
+	require.NotNil(t, err, "evicted stream should be dropped")
+	require.Equal(t, errors.KindUnexpected, err.(*errors.Error).Kind, "dropped stream should not read as missing")
+	require.True(t, stderrors.Is(err, ErrDropped))
+
+	err = s.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: "FirstBucket", V: 1}, BucketData: bucket.BucketData{Title: "New"}, Owner: "TestOwner"})
+	require.NotNil(t, err)
+	require.Equal(t, errors.KindAllreadyExists, err.(*errors.Error).Kind, "id of dropped stream should stay reserved")
+
+	err = s.InsertEvent(ctx, &bucket.Reopened{Base: events.Base{ID: "FirstBucket", V: 3}})
+	require.True(t, stderrors.Is(err, ErrDropped))
+
+	entries, err := s.(bucket.Feed).ReadFeed(ctx, 0, 10)
+	require.Nil(t, err, "feed should be readable past the lost events")
+	require.Len(t, entries, 4)
+	for i, entry := range entries[:2] {
+		require.Equal(t, uint64(i+1), entry.Position)
+		require.Nil(t, entry.Event)
+		require.True(t, stderrors.Is(entry.Err, ErrDropped), "feed should not skip the lost events as purged")
+	}
+	require.Nil(t, entries[2].Err)
+	require.Equal(t, events.EntityID("SecondBucket"), entries[2].Event.EntityID())
+
+	require.Nil(t, s.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: "ThirdBucket", V: 1}, BucketData: bucket.BucketData{Title: "Title"}, Owner: "TestOwner"}), "title should be released")
+
+	u := s.(Meter).Usage()
+	require.Equal(t, Usage{Streams: 2, Events: 3, Bytes: u.Bytes, Evictions: 1}, u)
+}
+
+func TestSpillEncoded(t *testing.T) {
+	t.Parallel()
+
+	keys, err := codec.NewKeyRing("TestKey", map[string][]byte{"TestKey": []byte("0123456789abcdef")})
+	require.Nil(t, err)
+
+	dir := t.TempDir()
+	spill, err := NewFileSpill(dir)
+	require.Nil(t, err)
+
+	s := NewBucketStore(Encoding(codec.NewEncrypted(codec.JSON(), keys)), Bounded(Limits{MaxStreams: 1}), Spilling(spill))
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+	require.Nil(t, s.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: "TestBucket", V: 1}, BucketData: bucket.BucketData{Title: "SecretTitle"}, Owner: "TestOwner"}))
+	require.Nil(t, s.(bucket.Archiver).ArchiveStream(ctx, &bucket.Archived{Base: events.Base{ID: "TestBucket", V: 2}}))
+	require.Nil(t, s.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: "OtherBucket", V: 1}, BucketData: bucket.BucketData{Title: "Other"}, Owner: "TestOwner"}))
+
+	records, err := spill.Load(ctx, tenant.Default, "TestBucket")
+	require.Nil(t, err)
+	require.Equal(t, "TestKey", records[0].KeyID, "spilled events should stay encrypted")
+	require.NotContains(t, string(records[0].Data), "SecretTitle")
+
+	require.Nil(t, s.(bucket.Verifier).VerifyStream(ctx, "TestBucket"))
+
+	err = s.InsertEvent(ctx, &bucket.Reopened{Base: events.Base{ID: "TestBucket", V: 3}})
+	require.NotNil(t, err, "spilled archived stream should stay archived")
+	require.Equal(t, "Stream is archived", err.(*errors.Error).Msg)
+}
+
+// FuzzDao stores events of all types in daos and decodes them back, and decodes daos with data of other
+// event types, which must fail instead of panicking
+func FuzzDao(f *testing.F) {
+	for kind := uint8(0); kind < buckettest.Kinds; kind++ {
+		f.Add(kind, kind, "Item1", []byte("data"), uint(kind))
+		f.Add(kind, kind+1, "team:a", []byte{}, uint(0))
+	}
+
+	f.Fuzz(func(t *testing.T, kind, dataKind uint8, text string, payload []byte, n uint) {
+		e := buckettest.Event(kind, "TestBucket", events.EntityVersion(n%1000+1), text, payload, n)
+
+		d := dao{at: e.Timestamp(), hash: e.Hash()}
+		d.encode(e)
+
+		got, err := d.decode(e.EntityID())
+		if err != nil {
+			t.Fatalf("could not decode %s: %v", e.Type(), err)
+		}
+		if !events.Equal(e, got) || !e.Timestamp().Equal(got.Timestamp()) || e.Hash() != got.Hash() {
+			t.Fatalf("got %#v, want %#v", got, e)
+		}
+
+		d.data = buckettest.Event(dataKind, "TestBucket", 1, text, payload, n).Data()
+
+		if got, err := d.decode(e.EntityID()); err == nil && got.Type() != d.t {
+			t.Fatalf("dao of %s decoded to %s", d.t, got.Type())
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package inmem
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/juelko/bucket/store/codec"
+)
+
+// Spill keeps the streams evicted from memory. Records have the store times and hashes of the events,
+// and they are encoded with the Encoding of the store, so encrypted events stay encrypted
+type Spill interface {
+	Save(ctx context.Context, t tenant.ID, id events.EntityID, records []codec.Record) error
+	// Load returns error with KindNotFound if the stream is not saved
+	Load(ctx context.Context, t tenant.ID, id events.EntityID) ([]codec.Record, error)
+	Remove(ctx context.Context, t tenant.ID, id events.EntityID) error
+}
+
+// NewFileSpill returns Spill saving each stream to a JSON file in dir
+func NewFileSpill(dir string) (Spill, error) {
+	const op errors.Op = "inmem.NewFileSpill"
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not create directory", err)
+	}
+
+	return &fileSpill{dir: dir}, nil
+}
+
+type fileSpill struct {
+	dir string
+}
+
+// path returns the file of the stream, names are hex encoded as ids may have any characters
+func (f *fileSpill) path(t tenant.ID, id events.EntityID) string {
+	return filepath.Join(f.dir, hex.EncodeToString([]byte(t))+"-"+hex.EncodeToString([]byte(id))+".json")
+}
+
+func (f *fileSpill) Save(ctx context.Context, t tenant.ID, id events.EntityID, records []codec.Record) error {
+	const op errors.Op = "inmem.fileSpill.Save"
+
+	b, err := json.Marshal(records)
+	if err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not encode stream", err)
+	}
+
+	// written to temporary file first, so a crash does not leave partial stream
+	tmp, err := os.CreateTemp(f.dir, "spill-*")
+	if err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not create file", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return errors.New(op, errors.KindUnexpected, "Could not write file", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not write file", err)
+	}
+
+	if err := os.Rename(tmp.Name(), f.path(t, id)); err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not write file", err)
+	}
+
+	return nil
+}
+
+func (f *fileSpill) Load(ctx context.Context, t tenant.ID, id events.EntityID) ([]codec.Record, error) {
+	const op errors.Op = "inmem.fileSpill.Load"
+
+	b, err := os.ReadFile(f.path(t, id))
+	if os.IsNotExist(err) {
+		return nil, errors.New(op, errors.KindNotFound, "Stream not found")
+	}
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not read file", err)
+	}
+
+	var ret []codec.Record
+	if err := json.Unmarshal(b, &ret); err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not decode stream", err)
+	}
+
+	return ret, nil
+}
+
+func (f *fileSpill) Remove(ctx context.Context, t tenant.ID, id events.EntityID) error {
+	const op errors.Op = "inmem.fileSpill.Remove"
+
+	if err := os.Remove(f.path(t, id)); err != nil && !os.IsNotExist(err) {
+		return errors.New(op, errors.KindUnexpected, "Could not remove file", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+// Package backend provides the helpers shared by the stores keeping the event streams.
+package backend
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/juelko/bucket/store/codec"
+)
+
+// Key identifies stream in the store, streams are isolated by tenant
+type Key struct {
+	Tenant tenant.ID
+	ID     events.EntityID
+}
+
+// KeyOf returns the key of the stream of the tenant in context
+func KeyOf(ctx context.Context, id events.EntityID) (Key, error) {
+	const op errors.Op = "backend.KeyOf"
+
+	t, err := TenantOf(ctx)
+	if err != nil {
+		return Key{}, errors.New(op, errors.KindValidation, "Invalid tenant", err)
+	}
+
+	return Key{Tenant: t, ID: id}, nil
+}
+
+// TenantOf returns the tenant in context. Context without tenant is rejected instead of using
+// tenant.Default, so a caller forgetting the tenant can not read or write the streams of Default
+func TenantOf(ctx context.Context) (tenant.ID, error) {
+	const op errors.Op = "backend.TenantOf"
+
+	t, ok := tenant.FromContext(ctx)
+	if !ok {
+		return "", errors.New(op, errors.KindValidation, "Tenant missing")
+	}
+
+	if err := t.Validate(); err != nil {
+		return "", err
+	}
+
+	return t, nil
+}
+
+// Name returns the key as one string, tenant can not have the separator
+func (k Key) Name() string {
+	return string(k.Tenant) + "\x00" + string(k.ID)
+}
+
+// ParseName returns the key of the name
+func ParseName(name string) Key {
+	t, id, _ := strings.Cut(name, "\x00")
+	return Key{Tenant: tenant.ID(t), ID: events.EntityID(id)}
+}
+
+// Head is the last version and hash of the stream
+type Head struct {
+	Version  events.EntityVersion
+	Hash     string
+	Archived bool
+}
+
+// Record encodes the event with c, chained to the previous hash at the store time
+func Record(ctx context.Context, c codec.Codec, prev string, at time.Time, e events.Event) (codec.Record, error) {
+	const op errors.Op = "backend.Record"
+
+	h, err := events.Hash(prev, at, e)
+	if err != nil {
+		return codec.Record{}, errors.New(op, errors.KindUnexpected, "hashing error", err)
+	}
+
+	r, err := c.Encode(ctx, e)
+	if err != nil {
+		return codec.Record{}, errors.New(op, errors.KindUnexpected, "encoding error", err)
+	}
+	r.At, r.Hash = at, h
+
+	return r, nil
+}
+
+// Tombstone checks that the tombstone can replace the stream ending at version last. Purged tombstone
+// follows the last event, and imported one, with skip, can follow any earlier event, see bucket.Importer
+func Tombstone(op errors.Op, e *bucket.Purged, last events.EntityVersion, skip bool) error {
+	if e.EntityVersion() <= last || (!skip && e.EntityVersion() != last+1) {
+		return errors.New(op, errors.KindUnexpected, "version error")
+	}
+	return nil
+}
+
+// Imported returns the store time of the event to import. Tombstones are imported with ImportTombstone,
+// see bucket.Importer
+func Imported(op errors.Op, e events.Event) (time.Time, error) {
+	if _, ok := e.(*bucket.Purged); ok {
+		return time.Time{}, errors.New(op, errors.KindValidation, "Tombstone is imported with ImportTombstone")
+	}
+	if e.Timestamp().IsZero() {
+		return time.Time{}, errors.New(op, errors.KindValidation, "Missing store time")
+	}
+	return e.Timestamp(), nil
+}
+
+// Wrap returns the errors of the store as they are, and wraps the other errors with msg
+func Wrap(op errors.Op, msg string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*errors.Error); ok {
+		return err
+	}
+	return errors.New(op, errors.KindUnexpected, msg, err)
+}
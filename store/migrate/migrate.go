@@ -0,0 +1,300 @@
+// Package migrate copies the streams of a bucket.Store to another store without downtime.
+//
+// Migrator follows the global feed of the source store, so events written during the migration
+// are copied as well. When writes are switched to the target, CutOver stops the tailing after the
+// remaining events are copied, and the streams of the stores are verified to be equal
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+)
+
+// Options controls the Migrator
+type Options struct {
+	From         uint64        // feed position to continue from, e.g. Position of earlier run
+	BatchSize    int           // events read from the feed at once, defaults to 100
+	PollInterval time.Duration // wait between reads when the target has caught up, defaults to 100ms
+}
+
+// Report tells the result of the migration
+type Report struct {
+	Position   uint64     // feed position of the last copied event
+	Events     int        // events copied
+	Lost       int        // events the source had lost, left out of the copy
+	Streams    int        // streams verified
+	Mismatches []Mismatch // streams differing between the stores
+}
+
+// Mismatch is a stream, which differs between the stores
+type Mismatch struct {
+	Stream bucket.StreamRef
+	Reason string
+}
+
+// Migrator copies events from the source store to the target store
+type Migrator struct {
+	src     bucket.Store
+	feed    bucket.Feed
+	scanner bucket.Scanner
+	dst     bucket.Store
+	opts    Options
+
+	position uint64 // accessed atomically
+	events   int
+	lost     int
+	cutOver  chan struct{}
+	once     sync.Once
+}
+
+// New returns Migrator from src to dst. Source has to implement bucket.Feed and bucket.Scanner
+func New(src, dst bucket.Store, opts Options) (*Migrator, error) {
+	const op errors.Op = "migrate.New"
+
+	feed, ok := bucket.As[bucket.Feed](src)
+	if !ok {
+		return nil, errors.New(op, errors.KindValidation, "Source does not support feed")
+	}
+
+	scanner, ok := bucket.As[bucket.Scanner](src)
+	if !ok {
+		return nil, errors.New(op, errors.KindValidation, "Source does not support scanning")
+	}
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 100 * time.Millisecond
+	}
+
+	return &Migrator{
+		src:      src,
+		feed:     feed,
+		scanner:  scanner,
+		dst:      dst,
+		opts:     opts,
+		position: opts.From,
+		cutOver:  make(chan struct{}),
+	}, nil
+}
+
+// Position returns the feed position of the last copied event
+func (m *Migrator) Position() uint64 {
+	return atomic.LoadUint64(&m.position)
+}
+
+// CutOver makes Run to stop tailing and verify the stores, once the events in the feed are copied.
+// Call it after writes to the source are stopped
+func (m *Migrator) CutOver() {
+	m.once.Do(func() { close(m.cutOver) })
+}
+
+// Run copies the events of the feed to the target and keeps tailing the feed until CutOver is called
+// or ctx is done. After cut-over the streams are verified and error is returned if any of them differs
+func (m *Migrator) Run(ctx context.Context) (Report, error) {
+	const op errors.Op = "migrate.Migrator.Run"
+
+	for {
+		n, err := m.Sync(ctx)
+		if err != nil {
+			return m.report(), errors.New(op, errors.KindUnexpected, "Copying failed", err)
+		}
+
+		// keep reading while feed has events
+		if n > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return m.report(), errors.New(op, errors.KindUnexpected, "Migration cancelled", ctx.Err())
+		case <-m.cutOver:
+			return m.finish(ctx)
+		case <-time.After(m.opts.PollInterval):
+		}
+	}
+}
+
+// finish copies the events written before the cut-over and verifies the stores
+func (m *Migrator) finish(ctx context.Context) (Report, error) {
+	const op errors.Op = "migrate.Migrator.finish"
+
+	for {
+		n, err := m.Sync(ctx)
+		if err != nil {
+			return m.report(), errors.New(op, errors.KindUnexpected, "Copying failed", err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+
+	r, err := m.Verify(ctx)
+	if err != nil {
+		return r, err
+	}
+
+	if len(r.Mismatches) > 0 {
+		return r, errors.New(op, errors.KindUnexpected, fmt.Sprintf("%d streams differ", len(r.Mismatches)))
+	}
+
+	return r, nil
+}
+
+// Sync copies one batch of events from the feed and returns the number of entries read
+func (m *Migrator) Sync(ctx context.Context) (int, error) {
+	const op errors.Op = "migrate.Migrator.Sync"
+
+	entries, err := m.feed.ReadFeed(ctx, m.Position(), m.opts.BatchSize)
+	if err != nil {
+		return 0, errors.New(op, errors.KindUnexpected, "Could not read feed", err)
+	}
+
+	for _, entry := range entries {
+		// the stream missing the event is reported by Verify
+		if entry.Err != nil {
+			atomic.StoreUint64(&m.position, entry.Position)
+			m.lost++
+			continue
+		}
+
+		if err := m.apply(tenant.NewContext(ctx, entry.Tenant), entry.Event); err != nil {
+			return 0, errors.New(op, errors.KindUnexpected, fmt.Sprintf("Could not copy event at position %d", entry.Position), err)
+		}
+
+		atomic.StoreUint64(&m.position, entry.Position)
+		m.events++
+	}
+
+	return len(entries), nil
+}
+
+// apply writes the event to the target with the operation which wrote it to the source
+func (m *Migrator) apply(ctx context.Context, e events.Event) error {
+	const op errors.Op = "migrate.Migrator.apply"
+
+	switch e := e.(type) {
+	case *bucket.Opened:
+		return m.dst.OpenStream(ctx, e)
+
+	case *bucket.Archived:
+		a, ok := bucket.As[bucket.Archiver](m.dst)
+		if !ok {
+			return errors.New(op, errors.KindUnexpected, "Target does not support archiving")
+		}
+		return a.ArchiveStream(ctx, e)
+
+	case *bucket.Purged:
+		a, ok := bucket.As[bucket.Archiver](m.dst)
+		if !ok {
+			return errors.New(op, errors.KindUnexpected, "Target does not support purging")
+		}
+
+		// stream purged before it was copied, tombstone alone can not be written
+		_, err := m.dst.GetStream(ctx, e.EntityID())
+		if gerr, ok := err.(*errors.Error); ok && gerr.Kind == errors.KindNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// stream purged while it was copied misses the erased events, which only importing tombstone can skip
+		if imp, ok := bucket.As[bucket.Importer](m.dst); ok {
+			return imp.ImportTombstone(ctx, e)
+		}
+		return a.PurgeStream(ctx, e)
+
+	case *bucket.Forgotten:
+		// target encrypting personal data has to destroy its keys as well
+		if s, ok := bucket.As[bucket.Shredder](m.dst); ok {
+			return s.ShredStream(ctx, e)
+		}
+		return m.dst.InsertEvent(ctx, e)
+
+	default:
+		return m.dst.InsertEvent(ctx, e)
+	}
+}
+
+// Verify compares the streams of the source to the target by versions and content.
+// Target streams missing from the source are reported, if target implements bucket.Scanner
+func (m *Migrator) Verify(ctx context.Context) (Report, error) {
+	const op errors.Op = "migrate.Migrator.Verify"
+
+	r := m.report()
+
+	refs, err := m.scanner.Streams(ctx)
+	if err != nil {
+		return r, errors.New(op, errors.KindUnexpected, "Could not list source streams", err)
+	}
+
+	known := map[bucket.StreamRef]bool{}
+
+	for _, ref := range refs {
+		known[ref] = true
+		r.Streams++
+
+		if reason := m.compare(tenant.NewContext(ctx, ref.Tenant), ref.ID); reason != "" {
+			r.Mismatches = append(r.Mismatches, Mismatch{Stream: ref, Reason: reason})
+		}
+	}
+
+	if sc, ok := bucket.As[bucket.Scanner](m.dst); ok {
+		refs, err := sc.Streams(ctx)
+		if err != nil {
+			return r, errors.New(op, errors.KindUnexpected, "Could not list target streams", err)
+		}
+
+		for _, ref := range refs {
+			if !known[ref] {
+				r.Mismatches = append(r.Mismatches, Mismatch{Stream: ref, Reason: "Stream missing from source"})
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// compare returns the reason why the stream differs between the stores, or empty string if it does not
+func (m *Migrator) compare(ctx context.Context, id events.EntityID) string {
+	src, err := m.src.GetStream(ctx, id)
+	if err != nil {
+		return "Could not read source stream: " + err.Error()
+	}
+
+	dst, err := m.dst.GetStream(ctx, id)
+	if err != nil {
+		// tombstones are not copied, see apply
+		if _, ok := src[0].(*bucket.Purged); ok && len(src) == 1 {
+			return ""
+		}
+		return "Could not read target stream: " + err.Error()
+	}
+
+	if len(src) != len(dst) {
+		return fmt.Sprintf("Source has %d events, target %d", len(src), len(dst))
+	}
+
+	for i := range src {
+		if !events.Equal(src[i], dst[i]) {
+			return fmt.Sprintf("Version %d differs", src[i].EntityVersion())
+		}
+	}
+
+	return ""
+}
+
+func (m *Migrator) report() Report {
+	return Report{Position: m.Position(), Events: m.events, Lost: m.lost}
+}
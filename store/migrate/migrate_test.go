@@ -0,0 +1,181 @@
+package migrate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/juelko/bucket/store/inmem"
+	"github.com/stretchr/testify/require"
+)
+
+func base(id events.EntityID, v events.EntityVersion) events.Base {
+	return events.Base{ID: id, V: v}
+}
+
+func open(t *testing.T, ctx context.Context, s bucket.Store, id events.EntityID) {
+	require.Nil(t, s.OpenStream(ctx, &bucket.Opened{Base: base(id, 1), BucketData: bucket.BucketData{Title: bucket.Title(id)}, Owner: "TestOwner"}))
+}
+
+func TestMigration(t *testing.T) {
+	t.Parallel()
+
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	other := tenant.NewContext(ctx, "Other")
+
+	src := inmem.NewBucketStore()
+	dst := inmem.NewBucketStore()
+
+	open(t, ctx, src, "FirstID")
+	require.Nil(t, src.InsertEvent(ctx, &bucket.ItemAdded{Base: base("FirstID", 2), Item: bucket.Item{ID: "Item1", Name: "Item"}}))
+	open(t, other, src, "FirstID")
+	require.Nil(t, src.InsertEvent(other, &bucket.Closed{Base: base("FirstID", 2)}))
+	open(t, ctx, src, "PurgedID")
+	require.Nil(t, src.(bucket.Archiver).PurgeStream(ctx, &bucket.Purged{Base: base("PurgedID", 2)}))
+
+	m, err := New(src, dst, Options{BatchSize: 2, PollInterval: time.Millisecond})
+	require.Nil(t, err)
+
+	type result struct {
+		r   Report
+		err error
+	}
+	done := make(chan result)
+
+	go func() {
+		r, err := m.Run(ctx)
+		done <- result{r, err}
+	}()
+
+	// events written during the migration
+	require.Eventually(t, func() bool { return m.Position() >= 5 }, time.Second, time.Millisecond)
+
+	open(t, ctx, src, "SecondID")
+	require.Nil(t, src.InsertEvent(ctx, &bucket.Updated{Base: base("FirstID", 3), BucketData: bucket.BucketData{Title: "Updated"}}))
+	require.Nil(t, src.(bucket.Archiver).ArchiveStream(other, &bucket.Archived{Base: base("FirstID", 3)}))
+
+	require.Eventually(t, func() bool { return m.Position() >= 8 }, time.Second, time.Millisecond)
+
+	require.Nil(t, src.(bucket.Archiver).PurgeStream(ctx, &bucket.Purged{Base: base("SecondID", 2)}))
+
+	m.CutOver()
+
+	res := <-done
+	require.Nil(t, res.err)
+	require.Equal(t, 4, res.r.Streams)
+	require.Empty(t, res.r.Mismatches)
+
+	refs, err := dst.(bucket.Scanner).Streams(ctx)
+	require.Nil(t, err)
+	require.Equal(t, []bucket.StreamRef{{Tenant: "Other", ID: "FirstID"}, {Tenant: tenant.Default, ID: "FirstID"}, {Tenant: tenant.Default, ID: "SecondID"}}, refs)
+
+	stream, err := dst.GetStream(ctx, "FirstID")
+	require.Nil(t, err)
+	require.Nil(t, events.Verify(stream), "target should chain copied events")
+
+	stream, err = dst.GetStream(other, "FirstID")
+	require.Nil(t, err)
+	require.IsType(t, &bucket.Archived{}, stream[len(stream)-1], "stream should be archived in target")
+}
+
+func TestLaggingPurge(t *testing.T) {
+	t.Parallel()
+
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	src := inmem.NewBucketStore()
+	dst := inmem.NewBucketStore()
+
+	m, err := New(src, dst, Options{})
+	require.Nil(t, err)
+
+	open(t, ctx, src, "TestID")
+	_, err = m.Sync(ctx)
+	require.Nil(t, err)
+
+	// second event is erased before it is copied
+	require.Nil(t, src.InsertEvent(ctx, &bucket.Closed{Base: base("TestID", 2)}))
+	require.Nil(t, src.(bucket.Archiver).PurgeStream(ctx, &bucket.Purged{Base: base("TestID", 3)}))
+
+	err = dst.(bucket.Archiver).PurgeStream(ctx, &bucket.Purged{Base: base("TestID", 3)})
+	require.NotNil(t, err, "purging should not skip versions outside of migration")
+
+	n, err := m.Sync(ctx)
+	require.Nil(t, err)
+	require.Equal(t, 1, n)
+
+	r, err := m.Verify(ctx)
+	require.Nil(t, err)
+	require.Empty(t, r.Mismatches)
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	src := inmem.NewBucketStore()
+	dst := inmem.NewBucketStore()
+
+	open(t, ctx, src, "FirstID")
+	open(t, ctx, src, "SecondID")
+	open(t, ctx, src, "ThirdID")
+
+	m, err := New(src, dst, Options{})
+	require.Nil(t, err)
+
+	_, err = m.Sync(ctx)
+	require.Nil(t, err)
+
+	require.Nil(t, dst.InsertEvent(ctx, &bucket.Closed{Base: base("FirstID", 2)}))
+	require.Nil(t, src.InsertEvent(ctx, &bucket.Updated{Base: base("SecondID", 2), BucketData: bucket.BucketData{Title: "Source"}}))
+	require.Nil(t, dst.InsertEvent(ctx, &bucket.Updated{Base: base("SecondID", 2), BucketData: bucket.BucketData{Title: "Target"}}))
+	open(t, ctx, dst, "ExtraID")
+
+	r, err := m.Verify(ctx)
+	require.Nil(t, err)
+	require.Equal(t, []Mismatch{
+		{Stream: bucket.StreamRef{Tenant: tenant.Default, ID: "FirstID"}, Reason: "Source has 1 events, target 2"},
+		{Stream: bucket.StreamRef{Tenant: tenant.Default, ID: "SecondID"}, Reason: "Version 2 differs"},
+		{Stream: bucket.StreamRef{Tenant: tenant.Default, ID: "ExtraID"}, Reason: "Stream missing from source"},
+	}, r.Mismatches)
+
+	m.CutOver()
+	_, err = m.Run(ctx)
+	require.NotNil(t, err, "copying SecondID version 2 should fail in target")
+
+	_, err = New(struct{ bucket.Store }{src}, dst, Options{})
+	require.Equal(t, errors.KindValidation, err.(*errors.Error).Kind)
+}
+
+func TestDroppedStream(t *testing.T) {
+	t.Parallel()
+
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	src := inmem.NewBucketStore(inmem.Bounded(inmem.Limits{MaxEvents: 3}), inmem.UniqueTitles(false))
+	dst := inmem.NewBucketStore()
+
+	// first stream is evicted without spill store and its events are lost
+	open(t, ctx, src, "FirstID")
+	require.Nil(t, src.InsertEvent(ctx, &bucket.Closed{Base: base("FirstID", 2)}))
+	open(t, ctx, src, "SecondID")
+	require.Nil(t, src.InsertEvent(ctx, &bucket.Closed{Base: base("SecondID", 2)}))
+
+	m, err := New(src, dst, Options{PollInterval: time.Millisecond})
+	require.Nil(t, err)
+
+	m.CutOver()
+	r, err := m.Run(ctx)
+	require.NotNil(t, err, "lost stream should fail the verification")
+	require.Equal(t, uint64(4), r.Position, "migration should continue past the lost events")
+	require.Equal(t, 2, r.Events)
+	require.Equal(t, 2, r.Lost)
+	require.Len(t, r.Mismatches, 1)
+	require.Equal(t, bucket.StreamRef{Tenant: tenant.Default, ID: "FirstID"}, r.Mismatches[0].Stream)
+
+	stream, err := dst.GetStream(ctx, "SecondID")
+	require.Nil(t, err)
+	require.Len(t, stream, 2)
+}
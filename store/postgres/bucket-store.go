@@ -0,0 +1,613 @@
+// Package postgres provides bucket.Store keeping the event streams in PostgreSQL 13 or newer.
+//
+// Appends are guarded by version constraints instead of locks: an append updates the version of the
+// stream only if it is still the one the event follows, and the events table has a unique version
+// per stream, so of two concurrent appends of the same version one fails with a version error.
+// Streams are read with one query, and committed events are announced with NOTIFY to the
+// subscribers listening with Subscribe.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/juelko/bucket/store/codec"
+	"github.com/juelko/bucket/store/internal/backend"
+)
+
+// Channel is the channel of the notifications sent on commit of each event
+const Channel = "bucket_events"
+
+// schema is created when the store is opened. Position orders the events of all streams in the feed.
+// It is the id of the transaction writing the event, each transaction writes one event, so the positions
+// that can still be taken by transactions in progress are known, see ReadFeed
+const schema = `
+CREATE TABLE IF NOT EXISTS bucket_streams (
+	tenant    TEXT    NOT NULL,
+	stream_id TEXT    NOT NULL,
+	version   BIGINT  NOT NULL,
+	hash      TEXT    NOT NULL,
+	archived  BOOLEAN NOT NULL DEFAULT FALSE,
+	title     TEXT,
+	PRIMARY KEY (tenant, stream_id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS bucket_streams_title ON bucket_streams (tenant, title) WHERE title IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS bucket_events (
+	position  BIGINT      PRIMARY KEY DEFAULT pg_current_xact_id()::TEXT::BIGINT,
+	tenant    TEXT        NOT NULL,
+	stream_id TEXT        NOT NULL,
+	version   BIGINT      NOT NULL,
+	type      TEXT        NOT NULL,
+	at        TIMESTAMPTZ NOT NULL,
+	hash      TEXT        NOT NULL,
+	key_id    TEXT        NOT NULL DEFAULT '',
+	data      BYTEA,
+	CONSTRAINT bucket_events_version UNIQUE (tenant, stream_id, version)
+);
+
+CREATE TABLE IF NOT EXISTS bucket_tags (
+	tenant    TEXT NOT NULL,
+	tag       TEXT NOT NULL,
+	stream_id TEXT NOT NULL,
+	PRIMARY KEY (tenant, tag, stream_id)
+);
+`
+
+// Option configures the store
+type Option func(*Store)
+
+// UniqueTitles makes store to reserve bucket titles per tenant, see inmem.UniqueTitles
+func UniqueTitles(ignoreCase bool) Option {
+	return func(s *Store) {
+		s.uniqueTitles = true
+		s.ignoreCase = ignoreCase
+	}
+}
+
+// Encoding sets the codec of the stored events, codec.JSON by default
+func Encoding(c codec.Codec) Option {
+	return func(s *Store) {
+		s.codec = c
+	}
+}
+
+// Store keeps the streams in PostgreSQL database. It implements bucket.Store
+// with bucket.TagIndex, bucket.Archiver, bucket.Importer, bucket.Verifier, bucket.Scanner and bucket.Feed
+type Store struct {
+	db           *sql.DB
+	dsn          string
+	codec        codec.Codec
+	uniqueTitles bool
+	ignoreCase   bool
+}
+
+// Open connects to the database with lib/pq connection string dsn and creates the tables if they do not exist
+func Open(ctx context.Context, dsn string, opts ...Option) (*Store, error) {
+	const op errors.Op = "postgres.Open"
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not open database", err)
+	}
+
+	s := &Store{db: db, dsn: dsn, codec: codec.JSON()}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		db.Close()
+		return nil, errors.New(op, errors.KindUnexpected, "Could not create tables", err)
+	}
+
+	return s, nil
+}
+
+// Close closes the connections to the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// tx runs f in a transaction, which is committed if f returns nil
+func (s *Store) tx(ctx context.Context, f func(*sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) OpenStream(ctx context.Context, o *bucket.Opened) error {
+	const op errors.Op = "postgres.Store.OpenStream"
+
+	return s.open(ctx, op, o, now())
+}
+
+// open starts the stream with the event stored at given time
+func (s *Store) open(ctx context.Context, op errors.Op, o *bucket.Opened, at time.Time) error {
+	k, err := backend.KeyOf(ctx, o.EntityID())
+	if err != nil {
+		return err
+	}
+
+	err = s.tx(ctx, func(tx *sql.Tx) error {
+		r, err := backend.Record(ctx, s.codec, "", at, o)
+		if err != nil {
+			return err
+		}
+
+		title := s.titleKey(o.Title)
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO bucket_streams (tenant, stream_id, version, hash, title) VALUES ($1, $2, $3, $4, $5)`,
+			k.Tenant, k.ID, r.Version, r.Hash, title)
+		if err != nil {
+			return s.conflict(ctx, op, k, title, err)
+		}
+
+		return s.insert(ctx, tx, k, r)
+	})
+	if err != nil {
+		return backend.Wrap(op, "Database error", err)
+	}
+
+	return nil
+}
+
+func (s *Store) InsertEvent(ctx context.Context, e events.Event) error {
+	const op errors.Op = "postgres.Store.InsertEvent"
+
+	k, err := backend.KeyOf(ctx, e.EntityID())
+	if err != nil {
+		return err
+	}
+
+	return backend.Wrap(op, "Database error", s.append(ctx, op, k, e, false, now()))
+}
+
+func (s *Store) ArchiveStream(ctx context.Context, e *bucket.Archived) error {
+	const op errors.Op = "postgres.Store.ArchiveStream"
+
+	k, err := backend.KeyOf(ctx, e.EntityID())
+	if err != nil {
+		return err
+	}
+
+	return backend.Wrap(op, "Database error", s.append(ctx, op, k, e, true, now()))
+}
+
+// ImportEvent writes the event like OpenStream, InsertEvent or ArchiveStream, but keeps its store time, see bucket.Importer.
+// The time is truncated to the precision of the database like the times of the other events
+func (s *Store) ImportEvent(ctx context.Context, e events.Event) error {
+	const op errors.Op = "postgres.Store.ImportEvent"
+
+	at, err := backend.Imported(op, e)
+	if err != nil {
+		return err
+	}
+	at = at.UTC().Truncate(time.Microsecond)
+
+	if o, ok := e.(*bucket.Opened); ok {
+		return s.open(ctx, op, o, at)
+	}
+
+	k, err := backend.KeyOf(ctx, e.EntityID())
+	if err != nil {
+		return err
+	}
+
+	_, archive := e.(*bucket.Archived)
+
+	return backend.Wrap(op, "Database error", s.append(ctx, op, k, e, archive, at))
+}
+
+// append writes the event stored at given time following the head of the stream. Head is updated only if its version
+// has not changed after it was read, so concurrent appends of the same version fail without locking the stream
+func (s *Store) append(ctx context.Context, op errors.Op, k backend.Key, e events.Event, archive bool, at time.Time) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		h, err := s.head(ctx, tx, k)
+		if err != nil {
+			return err
+		}
+
+		if h.Archived {
+			return errors.New(op, errors.KindExpected, "Stream is archived")
+		}
+
+		if h.Version+1 != e.EntityVersion() {
+			return errors.New(op, errors.KindUnexpected, "version error")
+		}
+
+		r, err := backend.Record(ctx, s.codec, h.Hash, at, e)
+		if err != nil {
+			return err
+		}
+
+		// title is changed only by updates, NULL keeps the reservation
+		var title interface{}
+		if u, ok := e.(*bucket.Updated); ok {
+			title = s.titleKey(u.Title)
+		}
+
+		res, err := tx.ExecContext(ctx,
+			`UPDATE bucket_streams SET version = $3, hash = $4, archived = $5, title = COALESCE($6, title)
+			WHERE tenant = $1 AND stream_id = $2 AND version = $7`,
+			k.Tenant, k.ID, r.Version, r.Hash, archive, title, h.Version)
+		if err != nil {
+			return s.conflict(ctx, op, k, title, err)
+		}
+
+		if n, err := res.RowsAffected(); err != nil || n != 1 {
+			return errors.New(op, errors.KindUnexpected, "version error")
+		}
+
+		if err := s.insert(ctx, tx, k, r); err != nil {
+			return err
+		}
+
+		return s.index(ctx, tx, k, e)
+	})
+}
+
+// head reads the head of the stream
+func (s *Store) head(ctx context.Context, tx *sql.Tx, k backend.Key) (backend.Head, error) {
+	const op errors.Op = "postgres.Store.head"
+
+	var h backend.Head
+
+	err := tx.QueryRowContext(ctx,
+		`SELECT version, hash, archived FROM bucket_streams WHERE tenant = $1 AND stream_id = $2`,
+		k.Tenant, k.ID).Scan(&h.Version, &h.Hash, &h.Archived)
+	if err == sql.ErrNoRows {
+		return h, errors.New(op, errors.KindNotFound, "Stream not found")
+	}
+	if err != nil {
+		return h, errors.New(op, errors.KindUnexpected, "Could not read stream", err)
+	}
+
+	return h, nil
+}
+
+// now returns the store time truncated to the precision of the database, so the hash can be recomputed
+// from the stored event
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// notification is the payload of the notifications
+type notification struct {
+	Position uint64               `json:"p"`
+	Tenant   tenant.ID            `json:"t"`
+	ID       events.EntityID      `json:"id"`
+	Version  events.EntityVersion `json:"v"`
+}
+
+// insert writes the record to the events and notifies the subscribers, which get the notification on commit
+func (s *Store) insert(ctx context.Context, tx *sql.Tx, k backend.Key, r codec.Record) error {
+	const op errors.Op = "postgres.Store.insert"
+
+	var position uint64
+
+	err := tx.QueryRowContext(ctx,
+		`INSERT INTO bucket_events (tenant, stream_id, version, type, at, hash, key_id, data)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING position`,
+		k.Tenant, k.ID, r.Version, r.Type, r.At, r.Hash, r.KeyID, r.Data).Scan(&position)
+	if isConstraint(err, "bucket_events_version") {
+		return errors.New(op, errors.KindUnexpected, "version error")
+	}
+	if isConstraint(err, "bucket_events_pkey") {
+		return errors.New(op, errors.KindUnexpected, "Only one event can be written in a transaction")
+	}
+	if err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not write event", err)
+	}
+
+	payload, err := json.Marshal(notification{Position: position, Tenant: k.Tenant, ID: k.ID, Version: r.Version})
+	if err != nil {
+		return errors.New(op, errors.KindUnexpected, "encoding error", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, Channel, string(payload)); err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not notify", err)
+	}
+
+	return nil
+}
+
+// index updates tag index with the tag events
+func (s *Store) index(ctx context.Context, tx *sql.Tx, k backend.Key, e events.Event) error {
+	const op errors.Op = "postgres.Store.index"
+
+	var err error
+
+	switch e := e.(type) {
+	case *bucket.TagAdded:
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO bucket_tags (tenant, tag, stream_id) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`,
+			k.Tenant, e.Tag, k.ID)
+
+	case *bucket.TagRemoved:
+		_, err = tx.ExecContext(ctx,
+			`DELETE FROM bucket_tags WHERE tenant = $1 AND tag = $2 AND stream_id = $3`,
+			k.Tenant, e.Tag, k.ID)
+	}
+
+	if err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not update tag index", err)
+	}
+
+	return nil
+}
+
+// titleKey returns the reserved form of the title, or nil if titles are not reserved
+func (s *Store) titleKey(t bucket.Title) interface{} {
+	if !s.uniqueTitles {
+		return nil
+	}
+	if s.ignoreCase {
+		return strings.ToLower(string(t))
+	}
+	return string(t)
+}
+
+// conflict converts the unique violations of writing bucket_streams to errors of the store
+func (s *Store) conflict(ctx context.Context, op errors.Op, k backend.Key, title interface{}, err error) error {
+	switch {
+	case isConstraint(err, "bucket_streams_pkey"):
+		return errors.New(op, errors.KindAllreadyExists, "Allready exists")
+
+	case isConstraint(err, "bucket_streams_title"):
+		// holder is read outside of the failed transaction
+		var id events.EntityID
+		_ = s.db.QueryRowContext(ctx,
+			`SELECT stream_id FROM bucket_streams WHERE tenant = $1 AND title = $2`,
+			k.Tenant, title).Scan(&id)
+		return errors.New(op, errors.KindAllreadyExists, "Title allready in use", &bucket.TitleConflict{ID: id})
+
+	default:
+		return errors.New(op, errors.KindUnexpected, "Could not write stream", err)
+	}
+}
+
+func isConstraint(err error, constraint string) bool {
+	e, ok := err.(*pq.Error)
+	return ok && e.Code == "23505" && e.Constraint == constraint
+}
+
+func (s *Store) FindByTags(ctx context.Context, tags []bucket.Tag, match bucket.Match) ([]events.EntityID, error) {
+	const op errors.Op = "postgres.Store.FindByTags"
+
+	t, err := backend.TenantOf(ctx)
+	if err != nil {
+		return nil, errors.New(op, errors.KindValidation, "Invalid tenant", err)
+	}
+
+	names := make([]string, len(tags))
+	for i, tag := range tags {
+		names[i] = string(tag)
+	}
+
+	required := 1
+	if match != bucket.MatchAny {
+		required = len(tags)
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT stream_id FROM bucket_tags WHERE tenant = $1 AND tag = ANY($2)
+		GROUP BY stream_id HAVING COUNT(*) >= $3`,
+		t, pq.Array(names), required)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not read tag index", err)
+	}
+	defer rows.Close()
+
+	ret := []events.EntityID{}
+	for rows.Next() {
+		var id events.EntityID
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.New(op, errors.KindUnexpected, "Could not read tag index", err)
+		}
+		ret = append(ret, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not read tag index", err)
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+
+	return ret, nil
+}
+
+// GetStream reads all events of the stream with one query
+func (s *Store) GetStream(ctx context.Context, id events.EntityID) ([]events.Event, error) {
+	const op errors.Op = "postgres.Store.GetStream"
+
+	k, err := backend.KeyOf(ctx, id)
+	if err != nil {
+		return []events.Event{}, err
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT stream_id, version, type, at, hash, key_id, data FROM bucket_events
+		WHERE tenant = $1 AND stream_id = $2 ORDER BY version`,
+		k.Tenant, k.ID)
+	if err != nil {
+		return []events.Event{}, errors.New(op, errors.KindUnexpected, "Could not read stream", err)
+	}
+	defer rows.Close()
+
+	ret := []events.Event{}
+	for rows.Next() {
+		var r codec.Record
+		if err := rows.Scan(&r.ID, &r.Version, &r.Type, &r.At, &r.Hash, &r.KeyID, &r.Data); err != nil {
+			return []events.Event{}, errors.New(op, errors.KindUnexpected, "Could not read stream", err)
+		}
+
+		e, err := s.codec.Decode(ctx, r)
+		if err != nil {
+			return []events.Event{}, errors.New(op, errors.KindUnexpected, "decoding error", err)
+		}
+		ret = append(ret, e)
+	}
+	if err := rows.Err(); err != nil {
+		return []events.Event{}, errors.New(op, errors.KindUnexpected, "Could not read stream", err)
+	}
+
+	if len(ret) == 0 {
+		return ret, errors.New(op, errors.KindNotFound, "Stream not found")
+	}
+
+	return ret, nil
+}
+
+func (s *Store) VerifyStream(ctx context.Context, id events.EntityID) error {
+	stream, err := s.GetStream(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return events.Verify(stream)
+}
+
+// Streams returns references to the hot and archived streams of all tenants, sorted by tenant and id
+func (s *Store) Streams(ctx context.Context) ([]bucket.StreamRef, error) {
+	const op errors.Op = "postgres.Store.Streams"
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT tenant, stream_id FROM bucket_streams ORDER BY tenant COLLATE "C", stream_id COLLATE "C"`)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not list streams", err)
+	}
+	defer rows.Close()
+
+	ret := []bucket.StreamRef{}
+	for rows.Next() {
+		var ref bucket.StreamRef
+		if err := rows.Scan(&ref.Tenant, &ref.ID); err != nil {
+			return nil, errors.New(op, errors.KindUnexpected, "Could not list streams", err)
+		}
+		ret = append(ret, ref)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not list streams", err)
+	}
+
+	return ret, nil
+}
+
+// ReadFeed returns the events ordered by position. Positions are the ids of the writing transactions and
+// become visible on commit, so only positions before the oldest transaction in progress are returned.
+// Those are final, all later commits take greater positions, so a reader can not pass a position,
+// which is committed later
+func (s *Store) ReadFeed(ctx context.Context, after uint64, limit int) ([]bucket.FeedEntry, error) {
+	const op errors.Op = "postgres.Store.ReadFeed"
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT position, tenant, stream_id, version, type, at, hash, key_id, data FROM bucket_events
+		WHERE position > $1 AND position < pg_snapshot_xmin(pg_current_snapshot())::TEXT::BIGINT
+		ORDER BY position LIMIT $2`,
+		after, limit)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not read feed", err)
+	}
+	defer rows.Close()
+
+	ret := []bucket.FeedEntry{}
+	for rows.Next() {
+		var entry bucket.FeedEntry
+		var r codec.Record
+		if err := rows.Scan(&entry.Position, &entry.Tenant, &r.ID, &r.Version, &r.Type, &r.At, &r.Hash, &r.KeyID, &r.Data); err != nil {
+			return nil, errors.New(op, errors.KindUnexpected, "Could not read feed", err)
+		}
+
+		if entry.Event, err = s.codec.Decode(tenant.NewContext(ctx, entry.Tenant), r); err != nil {
+			return nil, errors.New(op, errors.KindUnexpected, "decoding error", err)
+		}
+		ret = append(ret, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not read feed", err)
+	}
+
+	return ret, nil
+}
+
+func (s *Store) PurgeStream(ctx context.Context, e *bucket.Purged) error {
+	const op errors.Op = "postgres.Store.PurgeStream"
+
+	return s.purge(ctx, op, e, false)
+}
+
+// ImportTombstone erases the stream like PurgeStream, but the tombstone can skip versions, see bucket.Importer
+func (s *Store) ImportTombstone(ctx context.Context, e *bucket.Purged) error {
+	const op errors.Op = "postgres.Store.ImportTombstone"
+
+	return s.purge(ctx, op, e, true)
+}
+
+// purge replaces the stream with the tombstone, which follows the last event or with skip any earlier event
+func (s *Store) purge(ctx context.Context, op errors.Op, e *bucket.Purged, skip bool) error {
+	k, err := backend.KeyOf(ctx, e.EntityID())
+	if err != nil {
+		return err
+	}
+
+	err = s.tx(ctx, func(tx *sql.Tx) error {
+		h, err := s.head(ctx, tx, k)
+		if err != nil {
+			return err
+		}
+
+		if err := backend.Tombstone(op, e, h.Version, skip); err != nil {
+			return err
+		}
+
+		r, err := backend.Record(ctx, s.codec, "", now(), e)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx,
+			`UPDATE bucket_streams SET version = $3, hash = $4, archived = FALSE, title = NULL
+			WHERE tenant = $1 AND stream_id = $2 AND version = $5`,
+			k.Tenant, k.ID, r.Version, r.Hash, h.Version)
+		if err != nil {
+			return errors.New(op, errors.KindUnexpected, "Could not erase stream", err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n != 1 {
+			return errors.New(op, errors.KindUnexpected, "version error")
+		}
+
+		for _, q := range []string{
+			`DELETE FROM bucket_events WHERE tenant = $1 AND stream_id = $2`,
+			`DELETE FROM bucket_tags WHERE tenant = $1 AND stream_id = $2`,
+		} {
+			if _, err := tx.ExecContext(ctx, q, k.Tenant, k.ID); err != nil {
+				return errors.New(op, errors.KindUnexpected, "Could not erase stream", err)
+			}
+		}
+
+		return s.insert(ctx, tx, k, r)
+	})
+
+	return backend.Wrap(op, "Database error", err)
+}
@@ -0,0 +1,220 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/juelko/bucket/store/internal/backend"
+	"github.com/juelko/bucket/store/storetest"
+	"github.com/stretchr/testify/require"
+)
+
+// adminDSN connects to the server the tests create their databases in, empty if there is none
+var adminDSN string
+
+// TestMain starts a throwaway server with initdb and pg_ctl found in PATH or by pg_config.
+// BUCKET_POSTGRES_DSN, in key=value form, uses existing server instead. Tests are skipped if neither works
+func TestMain(m *testing.M) {
+	os.Exit(func() int {
+		dsn, stop, err := startPostgres()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "postgres tests are skipped:", err)
+		} else {
+			adminDSN = dsn
+			defer stop()
+		}
+
+		return m.Run()
+	}())
+}
+
+func startPostgres() (string, func(), error) {
+	if dsn := os.Getenv("BUCKET_POSTGRES_DSN"); dsn != "" {
+		return dsn, func() {}, nil
+	}
+
+	bin := ""
+	if _, err := exec.LookPath("initdb"); err != nil {
+		out, err := exec.Command("pg_config", "--bindir").Output()
+		if err != nil {
+			return "", nil, fmt.Errorf("initdb not found")
+		}
+		bin = strings.TrimSpace(string(out))
+	}
+
+	dir, err := os.MkdirTemp("", "pg")
+	if err != nil {
+		return "", nil, err
+	}
+
+	// free port for the server, which listens only on the socket in dir
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	data := filepath.Join(dir, "data")
+
+	cmds := [][]string{
+		{filepath.Join(bin, "initdb"), "-D", data, "-U", "postgres", "-A", "trust", "--no-sync"},
+		{filepath.Join(bin, "pg_ctl"), "-D", data, "-l", filepath.Join(dir, "log"), "-w", "start",
+			"-o", fmt.Sprintf("-p %d -k %s -c listen_addresses='' -c fsync=off", port, dir)},
+	}
+
+	for _, c := range cmds {
+		if out, err := exec.Command(c[0], c[1:]...).CombinedOutput(); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, fmt.Errorf("%s: %v: %s", filepath.Base(c[0]), err, out)
+		}
+	}
+
+	stop := func() {
+		exec.Command(filepath.Join(bin, "pg_ctl"), "-D", data, "-m", "immediate", "stop").Run()
+		os.RemoveAll(dir)
+	}
+
+	return fmt.Sprintf("host=%s port=%d user=postgres dbname=postgres sslmode=disable", dir, port), stop, nil
+}
+
+var databases int32
+
+// testStore returns store on a new database, which is dropped after the test
+func testStore(t *testing.T, opts ...Option) *Store {
+	if adminDSN == "" {
+		t.Skip("no postgres server")
+	}
+
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+	admin, err := sql.Open("postgres", adminDSN)
+	require.Nil(t, err)
+
+	name := fmt.Sprintf("bucket_test_%d_%d", os.Getpid(), atomic.AddInt32(&databases, 1))
+	_, err = admin.ExecContext(ctx, "CREATE DATABASE "+name)
+	require.Nil(t, err)
+
+	s, err := Open(ctx, adminDSN+" dbname="+name, opts...)
+	require.Nil(t, err)
+
+	t.Cleanup(func() {
+		s.Close()
+		admin.ExecContext(ctx, "DROP DATABASE "+name+" WITH (FORCE)")
+		admin.Close()
+	})
+
+	return s
+}
+
+func opened(id events.EntityID, title bucket.Title) *bucket.Opened {
+	return &bucket.Opened{Base: events.Base{ID: id, V: 1}, BucketData: bucket.BucketData{Title: title}, Owner: "TestOwner"}
+}
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.Check(t, func(t *testing.T, unique bool) storetest.Store {
+		if unique {
+			return testStore(t, UniqueTitles(true))
+		}
+		return testStore(t)
+	})
+}
+
+// TestFeedInterleaved is not parallel, as its open transactions hold back the feeds of the other tests
+func TestFeedInterleaved(t *testing.T) {
+	s := testStore(t)
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+	begin := func() *sql.Tx {
+		tx, err := s.db.BeginTx(ctx, nil)
+		require.Nil(t, err)
+
+		// transaction id is taken before the event is written, like it is by the update of the head
+		_, err = tx.ExecContext(ctx, `SELECT pg_current_xact_id()`)
+		require.Nil(t, err)
+
+		return tx
+	}
+
+	write := func(tx *sql.Tx, id events.EntityID) {
+		r, err := backend.Record(ctx, s.codec, "", now(), opened(id, "Title"))
+		require.Nil(t, err)
+		require.Nil(t, s.insert(ctx, tx, backend.Key{Tenant: tenant.Default, ID: id}, r))
+	}
+
+	var after uint64
+	seen := []events.EntityID{}
+
+	read := func() {
+		entries, err := s.ReadFeed(ctx, after, 10)
+		require.Nil(t, err)
+		for _, e := range entries {
+			require.Greater(t, e.Position, after)
+			after = e.Position
+			seen = append(seen, e.Event.EntityID())
+		}
+	}
+
+	// older transaction writes its event after the newer one and commits first
+	older, newer := begin(), begin()
+	write(newer, "Newer")
+	write(older, "Older")
+
+	require.Nil(t, older.Commit())
+	read()
+
+	require.Nil(t, newer.Commit())
+
+	// any transaction in progress on the server holds back the feed for a while
+	for deadline := time.Now().Add(5 * time.Second); len(seen) < 2 && time.Now().Before(deadline); {
+		read()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	require.Equal(t, []events.EntityID{"Older", "Newer"}, seen, "no position should be skipped")
+}
+
+func TestSubscribe(t *testing.T) {
+	t.Parallel()
+
+	s := testStore(t)
+	ctx, cancel := context.WithCancel(tenant.NewContext(context.Background(), tenant.Default))
+	defer cancel()
+
+	ch, err := s.Subscribe(ctx)
+	require.Nil(t, err)
+
+	require.Nil(t, s.OpenStream(ctx, opened("TestBucket", "Title")))
+
+	select {
+	case n := <-ch:
+		require.Equal(t, Notification{Position: n.Position, Tenant: tenant.Default, ID: "TestBucket", Version: 1}, n)
+
+		entries, err := s.ReadFeed(ctx, n.Position-1, 1)
+		require.Nil(t, err)
+		require.Len(t, entries, 1, "notified event should be in the feed")
+		require.Equal(t, n.Position, entries[0].Position)
+
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification not received")
+	}
+
+	cancel()
+
+	for range ch {
+	}
+}
@@ -0,0 +1,73 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+)
+
+// Notification tells that an event has been committed
+type Notification struct {
+	Position uint64 // position of the event in the feed
+	Tenant   tenant.ID
+	ID       events.EntityID
+	Version  events.EntityVersion
+}
+
+// Subscribe listens to the notifications of committed events until ctx is done, when the returned channel is closed.
+// Zero Notification is sent after the connection has been lost and restored, as notifications may have been missed,
+// so subscribers should read the feed from their last position. Slow subscriber blocks the delivery
+func (s *Store) Subscribe(ctx context.Context) (<-chan Notification, error) {
+	const op errors.Op = "postgres.Store.Subscribe"
+
+	l := pq.NewListener(s.dsn, 100*time.Millisecond, 10*time.Second, nil)
+
+	if err := l.Listen(Channel); err != nil {
+		l.Close()
+		return nil, errors.New(op, errors.KindUnexpected, "Could not listen", err)
+	}
+
+	ch := make(chan Notification)
+
+	go func() {
+		defer close(ch)
+		defer l.Close()
+
+		for {
+			var n Notification
+
+			select {
+			case <-ctx.Done():
+				return
+
+			case pn := <-l.Notify:
+				if pn != nil {
+					var payload notification
+					if err := json.Unmarshal([]byte(pn.Extra), &payload); err != nil {
+						continue
+					}
+					n = Notification{Position: payload.Position, Tenant: payload.Tenant, ID: payload.ID, Version: payload.Version}
+				}
+
+			case <-time.After(time.Minute):
+				// detects lost connection, which is reported by a nil notification
+				go l.Ping()
+				continue
+			}
+
+			select {
+			case ch <- n:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
@@ -0,0 +1,652 @@
+// Package redis provides bucket.Store keeping each event stream in a Redis Stream.
+//
+// Appends are made by a Lua script, which writes the event only if the stream has not changed after
+// the previous event was read for the hash chain, so concurrent appends of the same version fail
+// with a version error without locking. The script also appends a reference to the event to the
+// feed stream, which can be read with ReadFeed or consumed by consumer groups with Subscribe.
+//
+// All keys of the store share the hash tag of the prefix, so the scripts can touch them in Redis Cluster too.
+package redis
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/juelko/bucket/store/codec"
+	"github.com/juelko/bucket/store/internal/backend"
+)
+
+// DefaultPrefix is the prefix of the keys of the store
+const DefaultPrefix = "{bucket}"
+
+// Option configures the store
+type Option func(*Store)
+
+// Prefix sets the prefix of the keys, so more than one store can share a database
+func Prefix(p string) Option {
+	return func(s *Store) {
+		s.prefix = p
+	}
+}
+
+// UniqueTitles makes store to reserve bucket titles per tenant, see inmem.UniqueTitles
+func UniqueTitles(ignoreCase bool) Option {
+	return func(s *Store) {
+		s.uniqueTitles = true
+		s.ignoreCase = ignoreCase
+	}
+}
+
+// Encoding sets the codec of the stored events, codec.JSON by default
+func Encoding(c codec.Codec) Option {
+	return func(s *Store) {
+		s.codec = c
+	}
+}
+
+// Store keeps the streams in Redis. It implements bucket.Store
+// with bucket.TagIndex, bucket.Archiver, bucket.Importer, bucket.Verifier, bucket.Scanner and bucket.Feed
+type Store struct {
+	client       redis.UniversalClient
+	prefix       string
+	codec        codec.Codec
+	uniqueTitles bool
+	ignoreCase   bool
+}
+
+// New returns store using the client, which is left for the caller to close
+func New(client redis.UniversalClient, opts ...Option) *Store {
+	s := &Store{client: client, prefix: DefaultPrefix, codec: codec.JSON()}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Keys of the store. Tenant ids can not have colons, so stream ids can
+func (s *Store) streamName(k backend.Key) string {
+	return s.prefix + ":stream:" + string(k.Tenant) + ":" + string(k.ID)
+}
+func (s *Store) headName(k backend.Key) string {
+	return s.prefix + ":head:" + string(k.Tenant) + ":" + string(k.ID)
+}
+func (s *Store) tagsName(k backend.Key) string {
+	return s.prefix + ":tags:" + string(k.Tenant) + ":" + string(k.ID)
+}
+func (s *Store) tagPrefix(t tenant.ID) string  { return s.prefix + ":tag:" + string(t) + ":" }
+func (s *Store) titlesName(t tenant.ID) string { return s.prefix + ":titles:" + string(t) }
+func (s *Store) feedName() string              { return s.prefix + ":feed" }
+func (s *Store) seqName() string               { return s.prefix + ":seq" }
+func (s *Store) streamsName() string           { return s.prefix + ":streams" }
+
+// entryID is the id of the event in its Redis Stream, or of the feed entry at position
+func entryID(n uint64) string {
+	return strconv.FormatUint(n, 10) + "-0"
+}
+
+// Script modes
+const (
+	modeOpen    = "open"
+	modeAppend  = "append"
+	modeArchive = "archive"
+	modePurge   = "purge"
+)
+
+// appendScript writes the event if the head of the stream still has the version the event was chained to,
+// and updates the indexes and the feed. It returns the position of the event in the feed, or the reason
+// of failure with the title holder in case of title conflict.
+//
+// The tag index keys written are given after the fixed keys: the key of the tag added or removed, or
+// the keys of all tags of the purged stream. Purge fails with 'tags' if the stream has tags not given
+var appendScript = redis.NewScript(`
+local head, stream, tags, titles, streams, seq, feed = KEYS[1], KEYS[2], KEYS[3], KEYS[4], KEYS[5], KEYS[6], KEYS[7]
+local mode, prev, version, hash, title, tagop = ARGV[1], ARGV[2], ARGV[3], ARGV[4], ARGV[5], ARGV[6]
+local tenant, id, member, tagprefix = ARGV[7], ARGV[8], ARGV[9], ARGV[10]
+
+local current = redis.call('HMGET', head, 'version', 'title')
+
+if mode == 'open' then
+	if current[1] then return {'exists'} end
+else
+	if not current[1] then return {'notfound'} end
+	if current[1] ~= prev then return {'version'} end
+end
+
+if title ~= '' then
+	local holder = redis.call('HGET', titles, title)
+	if holder and holder ~= id then return {'title', holder} end
+end
+
+if mode == 'purge' then
+	local declared = {}
+	for i = 8, #KEYS do declared[KEYS[i]] = true end
+	for _, t in ipairs(redis.call('SMEMBERS', tags)) do
+		if not declared[tagprefix .. t] then return {'tags'} end
+	end
+
+	redis.call('DEL', stream)
+	for i = 8, #KEYS do
+		redis.call('SREM', KEYS[i], id)
+	end
+	redis.call('DEL', tags)
+	if current[2] then
+		redis.call('HDEL', titles, current[2])
+		redis.call('HDEL', head, 'title')
+	end
+end
+
+if title ~= '' then
+	if current[2] and current[2] ~= title then redis.call('HDEL', titles, current[2]) end
+	redis.call('HSET', titles, title, id)
+	redis.call('HSET', head, 'title', title)
+end
+
+if tagop == 'add' then
+	redis.call('SADD', tags, ARGV[15])
+	redis.call('SADD', KEYS[8], id)
+elseif tagop == 'remove' then
+	redis.call('SREM', tags, ARGV[15])
+	redis.call('SREM', KEYS[8], id)
+end
+
+local archived = '0'
+if mode == 'archive' then archived = '1' end
+
+redis.call('XADD', stream, version .. '-0', 'type', ARGV[11], 'at', ARGV[12], 'hash', hash, 'key', ARGV[13], 'data', ARGV[14])
+redis.call('HSET', head, 'version', version, 'hash', hash, 'archived', archived)
+redis.call('SADD', streams, member)
+
+local position = redis.call('INCR', seq)
+redis.call('XADD', feed, position .. '-0', 'tenant', tenant, 'id', id, 'version', version)
+
+return {'ok', position}
+`)
+
+// change is the write of the script besides the event
+type change struct {
+	mode  string
+	prev  events.EntityVersion
+	title string
+	tagop string
+	tag   bucket.Tag
+	tags  []bucket.Tag // tags of the purged stream
+}
+
+// errTagsChanged is wrapped by run when the tags of the purged stream changed after they were read
+var errTagsChanged = stderrors.New("tags of stream changed during purge")
+
+// run writes the record with the script, and converts its failures to errors of the store
+func (s *Store) run(ctx context.Context, op errors.Op, k backend.Key, r codec.Record, c change) error {
+	keys := []string{
+		s.headName(k), s.streamName(k), s.tagsName(k), s.titlesName(k.Tenant),
+		s.streamsName(), s.seqName(), s.feedName(),
+	}
+
+	if c.tagop != "" {
+		keys = append(keys, s.tagPrefix(k.Tenant)+string(c.tag))
+	}
+	for _, t := range c.tags {
+		keys = append(keys, s.tagPrefix(k.Tenant)+string(t))
+	}
+
+	args := []interface{}{
+		c.mode, uint64(c.prev), uint64(r.Version), r.Hash, c.title, c.tagop,
+		string(k.Tenant), string(k.ID), k.Name(), s.tagPrefix(k.Tenant),
+		r.Type, r.At.Format(time.RFC3339Nano), r.KeyID, r.Data, string(c.tag),
+	}
+
+	res, err := appendScript.Run(ctx, s.client, keys, args...).Slice()
+	if err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not write event", err)
+	}
+
+	switch res[0] {
+	case "ok":
+		return nil
+	case "exists":
+		return errors.New(op, errors.KindAllreadyExists, "Allready exists")
+	case "notfound":
+		return errors.New(op, errors.KindNotFound, "Stream not found")
+	case "title":
+		holder, _ := res[1].(string)
+		return errors.New(op, errors.KindAllreadyExists, "Title allready in use", &bucket.TitleConflict{ID: events.EntityID(holder)})
+	case "tags":
+		return errors.New(op, errors.KindUnexpected, "Tags of stream changed", errTagsChanged)
+	default:
+		return errors.New(op, errors.KindUnexpected, "version error")
+	}
+}
+
+// head reads the head of the stream
+func (s *Store) head(ctx context.Context, k backend.Key) (backend.Head, error) {
+	const op errors.Op = "redis.Store.head"
+
+	res, err := s.client.HMGet(ctx, s.headName(k), "version", "hash", "archived").Result()
+	if err != nil {
+		return backend.Head{}, errors.New(op, errors.KindUnexpected, "Could not read stream", err)
+	}
+
+	if res[0] == nil {
+		return backend.Head{}, errors.New(op, errors.KindNotFound, "Stream not found")
+	}
+
+	v, err := strconv.ParseUint(fmt.Sprint(res[0]), 10, 64)
+	if err != nil {
+		return backend.Head{}, errors.New(op, errors.KindUnexpected, "Could not read stream", err)
+	}
+
+	return backend.Head{Version: events.EntityVersion(v), Hash: fmt.Sprint(res[1]), Archived: res[2] == "1"}, nil
+}
+
+// titleKey returns the reserved form of the title, or empty if titles are not reserved
+func (s *Store) titleKey(t bucket.Title) string {
+	if !s.uniqueTitles {
+		return ""
+	}
+	if s.ignoreCase {
+		return strings.ToLower(string(t))
+	}
+	return string(t)
+}
+
+func (s *Store) OpenStream(ctx context.Context, o *bucket.Opened) error {
+	const op errors.Op = "redis.Store.OpenStream"
+
+	return s.open(ctx, op, o, time.Now().UTC())
+}
+
+// open starts the stream with the event stored at given time
+func (s *Store) open(ctx context.Context, op errors.Op, o *bucket.Opened, at time.Time) error {
+	k, err := backend.KeyOf(ctx, o.EntityID())
+	if err != nil {
+		return err
+	}
+
+	r, err := backend.Record(ctx, s.codec, "", at, o)
+	if err != nil {
+		return err
+	}
+
+	return s.run(ctx, op, k, r, change{mode: modeOpen, title: s.titleKey(o.Title)})
+}
+
+func (s *Store) InsertEvent(ctx context.Context, e events.Event) error {
+	const op errors.Op = "redis.Store.InsertEvent"
+
+	k, err := backend.KeyOf(ctx, e.EntityID())
+	if err != nil {
+		return err
+	}
+
+	return s.append(ctx, op, k, e, modeAppend, time.Now().UTC())
+}
+
+func (s *Store) ArchiveStream(ctx context.Context, e *bucket.Archived) error {
+	const op errors.Op = "redis.Store.ArchiveStream"
+
+	k, err := backend.KeyOf(ctx, e.EntityID())
+	if err != nil {
+		return err
+	}
+
+	return s.append(ctx, op, k, e, modeArchive, time.Now().UTC())
+}
+
+// ImportEvent writes the event like OpenStream, InsertEvent or ArchiveStream, but keeps its store time, see bucket.Importer
+func (s *Store) ImportEvent(ctx context.Context, e events.Event) error {
+	const op errors.Op = "redis.Store.ImportEvent"
+
+	at, err := backend.Imported(op, e)
+	if err != nil {
+		return err
+	}
+
+	if o, ok := e.(*bucket.Opened); ok {
+		return s.open(ctx, op, o, at.UTC())
+	}
+
+	k, err := backend.KeyOf(ctx, e.EntityID())
+	if err != nil {
+		return err
+	}
+
+	if _, ok := e.(*bucket.Archived); ok {
+		return s.append(ctx, op, k, e, modeArchive, at.UTC())
+	}
+
+	return s.append(ctx, op, k, e, modeAppend, at.UTC())
+}
+
+// append writes the event stored at given time following the head of the stream
+func (s *Store) append(ctx context.Context, op errors.Op, k backend.Key, e events.Event, mode string, at time.Time) error {
+	h, err := s.head(ctx, k)
+	if err != nil {
+		return err
+	}
+
+	if h.Archived {
+		return errors.New(op, errors.KindExpected, "Stream is archived")
+	}
+
+	if h.Version+1 != e.EntityVersion() {
+		return errors.New(op, errors.KindUnexpected, "version error")
+	}
+
+	r, err := backend.Record(ctx, s.codec, h.Hash, at, e)
+	if err != nil {
+		return err
+	}
+
+	c := change{mode: mode, prev: h.Version}
+
+	switch e := e.(type) {
+	case *bucket.Updated:
+		c.title = s.titleKey(e.Title)
+	case *bucket.TagAdded:
+		c.tagop, c.tag = "add", e.Tag
+	case *bucket.TagRemoved:
+		c.tagop, c.tag = "remove", e.Tag
+	}
+
+	return s.run(ctx, op, k, r, c)
+}
+
+func (s *Store) PurgeStream(ctx context.Context, e *bucket.Purged) error {
+	const op errors.Op = "redis.Store.PurgeStream"
+
+	return s.purge(ctx, op, e, false)
+}
+
+// ImportTombstone erases the stream like PurgeStream, but the tombstone can skip versions, see bucket.Importer
+func (s *Store) ImportTombstone(ctx context.Context, e *bucket.Purged) error {
+	const op errors.Op = "redis.Store.ImportTombstone"
+
+	return s.purge(ctx, op, e, true)
+}
+
+// purgeAttempts limits the retries of purge when the tags of the stream change while it is purged
+const purgeAttempts = 3
+
+// purge replaces the stream with the tombstone, which follows the last event or with skip any earlier event.
+// The tags are read before the script, which has to be given the keys of their indexes, and the purge is
+// retried if they changed in between
+func (s *Store) purge(ctx context.Context, op errors.Op, e *bucket.Purged, skip bool) error {
+	k, err := backend.KeyOf(ctx, e.EntityID())
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		h, err := s.head(ctx, k)
+		if err != nil {
+			return err
+		}
+
+		if err := backend.Tombstone(op, e, h.Version, skip); err != nil {
+			return err
+		}
+
+		members, err := s.client.SMembers(ctx, s.tagsName(k)).Result()
+		if err != nil {
+			return errors.New(op, errors.KindUnexpected, "Could not read tags", err)
+		}
+
+		tags := make([]bucket.Tag, len(members))
+		for i, m := range members {
+			tags[i] = bucket.Tag(m)
+		}
+
+		r, err := backend.Record(ctx, s.codec, "", time.Now().UTC(), e)
+		if err != nil {
+			return err
+		}
+
+		err = s.run(ctx, op, k, r, change{mode: modePurge, prev: h.Version, tags: tags})
+		if !stderrors.Is(err, errTagsChanged) || attempt == purgeAttempts {
+			return err
+		}
+	}
+}
+
+// decode converts the entry of the Redis Stream to event
+func (s *Store) decode(ctx context.Context, id events.EntityID, m redis.XMessage) (events.Event, error) {
+	const op errors.Op = "redis.Store.decode"
+
+	v, err := strconv.ParseUint(strings.TrimSuffix(m.ID, "-0"), 10, 64)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "decoding error", err)
+	}
+
+	field := func(name string) string {
+		f, _ := m.Values[name].(string)
+		return f
+	}
+
+	at, err := time.Parse(time.RFC3339Nano, field("at"))
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "decoding error", err)
+	}
+
+	r := codec.Record{
+		Type:    field("type"),
+		ID:      id,
+		Version: events.EntityVersion(v),
+		At:      at,
+		Hash:    field("hash"),
+		KeyID:   field("key"),
+		Data:    []byte(field("data")),
+	}
+
+	e, err := s.codec.Decode(ctx, r)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "decoding error", err)
+	}
+
+	return e, nil
+}
+
+func (s *Store) GetStream(ctx context.Context, id events.EntityID) ([]events.Event, error) {
+	const op errors.Op = "redis.Store.GetStream"
+
+	k, err := backend.KeyOf(ctx, id)
+	if err != nil {
+		return []events.Event{}, err
+	}
+
+	msgs, err := s.client.XRange(ctx, s.streamName(k), "-", "+").Result()
+	if err != nil {
+		return []events.Event{}, errors.New(op, errors.KindUnexpected, "Could not read stream", err)
+	}
+
+	if len(msgs) == 0 {
+		return []events.Event{}, errors.New(op, errors.KindNotFound, "Stream not found")
+	}
+
+	ret := make([]events.Event, 0, len(msgs))
+	for _, m := range msgs {
+		e, err := s.decode(ctx, id, m)
+		if err != nil {
+			return []events.Event{}, err
+		}
+		ret = append(ret, e)
+	}
+
+	return ret, nil
+}
+
+func (s *Store) VerifyStream(ctx context.Context, id events.EntityID) error {
+	stream, err := s.GetStream(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return events.Verify(stream)
+}
+
+func (s *Store) FindByTags(ctx context.Context, tags []bucket.Tag, match bucket.Match) ([]events.EntityID, error) {
+	const op errors.Op = "redis.Store.FindByTags"
+
+	t, err := backend.TenantOf(ctx)
+	if err != nil {
+		return nil, errors.New(op, errors.KindValidation, "Invalid tenant", err)
+	}
+
+	ret := []events.EntityID{}
+
+	if len(tags) == 0 {
+		return ret, nil
+	}
+
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = s.tagPrefix(t) + string(tag)
+	}
+
+	var ids []string
+
+	if match == bucket.MatchAny {
+		ids, err = s.client.SUnion(ctx, keys...).Result()
+	} else {
+		ids, err = s.client.SInter(ctx, keys...).Result()
+	}
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not read tag index", err)
+	}
+
+	for _, id := range ids {
+		ret = append(ret, events.EntityID(id))
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+
+	return ret, nil
+}
+
+// Streams returns references to the hot and archived streams of all tenants, sorted by tenant and id
+func (s *Store) Streams(ctx context.Context) ([]bucket.StreamRef, error) {
+	const op errors.Op = "redis.Store.Streams"
+
+	members, err := s.client.SMembers(ctx, s.streamsName()).Result()
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not list streams", err)
+	}
+
+	ret := make([]bucket.StreamRef, 0, len(members))
+	for _, m := range members {
+		parts := strings.SplitN(m, "\x00", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		ret = append(ret, bucket.StreamRef{Tenant: tenant.ID(parts[0]), ID: events.EntityID(parts[1])})
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Tenant != ret[j].Tenant {
+			return ret[i].Tenant < ret[j].Tenant
+		}
+		return ret[i].ID < ret[j].ID
+	})
+
+	return ret, nil
+}
+
+// ReadFeed returns the events ordered by position. Feed has references to the events,
+// which are read from their streams, so purged events are left out
+func (s *Store) ReadFeed(ctx context.Context, after uint64, limit int) ([]bucket.FeedEntry, error) {
+	const op errors.Op = "redis.Store.ReadFeed"
+
+	ret := []bucket.FeedEntry{}
+
+	for len(ret) < limit {
+		n := limit - len(ret)
+
+		msgs, err := s.client.XRangeN(ctx, s.feedName(), entryID(after+1), "+", int64(n)).Result()
+		if err != nil {
+			return nil, errors.New(op, errors.KindUnexpected, "Could not read feed", err)
+		}
+
+		entries, err := s.resolve(ctx, msgs)
+		if err != nil {
+			return nil, backend.Wrap(op, "Redis error", err)
+		}
+		ret = append(ret, entries...)
+
+		if len(msgs) < n {
+			break
+		}
+
+		if after, err = position(msgs[len(msgs)-1].ID); err != nil {
+			return nil, errors.New(op, errors.KindUnexpected, "Could not read feed", err)
+		}
+	}
+
+	return ret, nil
+}
+
+// position parses the position from the id of the feed entry
+func position(id string) (uint64, error) {
+	return strconv.ParseUint(strings.TrimSuffix(id, "-0"), 10, 64)
+}
+
+// resolve reads the events referenced by the feed entries in one round trip. Entries of purged events are left out
+func (s *Store) resolve(ctx context.Context, msgs []redis.XMessage) ([]bucket.FeedEntry, error) {
+	const op errors.Op = "redis.Store.resolve"
+
+	type ref struct {
+		position uint64
+		k        backend.Key
+		cmd      *redis.XMessageSliceCmd
+	}
+
+	refs := make([]ref, 0, len(msgs))
+
+	_, err := s.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, m := range msgs {
+			pos, err := position(m.ID)
+			if err != nil {
+				return err
+			}
+
+			t, _ := m.Values["tenant"].(string)
+			id, _ := m.Values["id"].(string)
+			v, _ := m.Values["version"].(string)
+
+			k := backend.Key{Tenant: tenant.ID(t), ID: events.EntityID(id)}
+			refs = append(refs, ref{position: pos, k: k, cmd: p.XRange(ctx, s.streamName(k), v+"-0", v+"-0")})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not read feed", err)
+	}
+
+	ret := make([]bucket.FeedEntry, 0, len(refs))
+	for _, r := range refs {
+		found := r.cmd.Val()
+		if len(found) == 0 {
+			continue
+		}
+
+		e, err := s.decode(tenant.NewContext(ctx, r.k.Tenant), r.k.ID, found[0])
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, bucket.FeedEntry{Position: r.position, Tenant: r.k.Tenant, Event: e})
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,229 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/juelko/bucket/store/codec"
+	"github.com/juelko/bucket/store/internal/backend"
+	"github.com/juelko/bucket/store/storetest"
+)
+
+// testStore returns store on an in-process Redis, which is closed after the test
+func testStore(t *testing.T, opts ...Option) *Store {
+	m := miniredis.RunT(t)
+
+	c := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { c.Close() })
+
+	return New(c, opts...)
+}
+
+func opened(id events.EntityID, title bucket.Title) *bucket.Opened {
+	return &bucket.Opened{Base: events.Base{ID: id, V: 1}, BucketData: bucket.BucketData{Title: title}, Owner: "TestOwner"}
+}
+
+func TestConformance(t *testing.T) {
+	storetest.Check(t, func(t *testing.T, unique bool) storetest.Store {
+		if unique {
+			return testStore(t, UniqueTitles(true))
+		}
+		return testStore(t)
+	})
+}
+
+func TestErrors(t *testing.T) {
+	s := testStore(t)
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+	require.Nil(t, s.OpenStream(ctx, opened("TestBucket", "TestTitle")))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.ItemAdded{Base: events.Base{ID: "TestBucket", V: 2}, Item: bucket.Item{ID: "Item1", Name: "Item", Payload: bucket.Payload("data")}}))
+
+	testCases := []struct {
+		desc string
+		err  error
+		want *errors.Error
+	}{
+		{
+			desc: "existing",
+			err:  s.OpenStream(ctx, opened("TestBucket", "Other")),
+			want: &errors.Error{Op: "redis.Store.OpenStream", Kind: errors.KindAllreadyExists, Msg: "Allready exists"},
+		},
+		{
+			desc: "version",
+			err:  s.InsertEvent(ctx, &bucket.Closed{Base: events.Base{ID: "TestBucket", V: 2}}),
+			want: &errors.Error{Op: "redis.Store.InsertEvent", Kind: errors.KindUnexpected, Msg: "version error"},
+		},
+		{
+			desc: "not found",
+			err:  s.InsertEvent(ctx, &bucket.Closed{Base: events.Base{ID: "MissingBucket", V: 2}}),
+			want: &errors.Error{Op: "redis.Store.head", Kind: errors.KindNotFound, Msg: "Stream not found"},
+		},
+		{
+			desc: "invalid tenant",
+			err:  s.InsertEvent(tenant.NewContext(ctx, "in:valid"), &bucket.Closed{Base: events.Base{ID: "TestBucket", V: 3}}),
+			want: &errors.Error{Op: "backend.KeyOf", Kind: errors.KindValidation, Msg: "Invalid tenant"},
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			require.NotNil(t, tC.err)
+			got := tC.err.(*errors.Error)
+			require.Equal(t, tC.want.Op, got.Op)
+			require.Equal(t, tC.want.Kind, got.Kind)
+			require.Equal(t, tC.want.Msg, got.Msg)
+		})
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	keys, err := codec.NewKeyRing("k1", map[string][]byte{"k1": make([]byte, 32)})
+	require.Nil(t, err)
+
+	s := testStore(t, Encoding(codec.NewEncrypted(codec.JSON(), keys)))
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+	require.Nil(t, s.OpenStream(ctx, opened("TestBucket", "Title")))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.ItemAdded{Base: events.Base{ID: "TestBucket", V: 2}, Item: bucket.Item{ID: "Item1", Payload: bucket.Payload{0, 1, 2, 255}}}))
+
+	stream, err := s.GetStream(ctx, "TestBucket")
+	require.Nil(t, err)
+	require.Equal(t, bucket.Payload{0, 1, 2, 255}, stream[1].(*bucket.ItemAdded).Payload)
+	require.Nil(t, s.VerifyStream(ctx, "TestBucket"))
+}
+
+func TestPurgeTags(t *testing.T) {
+	t.Parallel()
+
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	s := testStore(t)
+
+	require.Nil(t, s.OpenStream(ctx, opened("TestBucket", "Title")))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.TagAdded{Base: events.Base{ID: "TestBucket", V: 2}, Tag: "team:a"}))
+
+	k, err := backend.KeyOf(ctx, "TestBucket")
+	require.Nil(t, err)
+
+	r, err := backend.Record(ctx, s.codec, "", time.Now(), &bucket.Purged{Base: events.Base{ID: "TestBucket", V: 3}})
+	require.Nil(t, err)
+
+	// tags read before the tag was added
+	err = s.run(ctx, "test", k, r, change{mode: modePurge, prev: 2})
+	require.NotNil(t, err)
+	require.ErrorIs(t, err, errTagsChanged)
+
+	ids, err := s.FindByTags(ctx, []bucket.Tag{"team:a"}, bucket.MatchAny)
+	require.Nil(t, err)
+	require.Equal(t, []events.EntityID{"TestBucket"}, ids, "failed purge should not change the stream")
+
+	require.Nil(t, s.PurgeStream(ctx, &bucket.Purged{Base: events.Base{ID: "TestBucket", V: 3}}))
+
+	ids, err = s.FindByTags(ctx, []bucket.Tag{"team:a"}, bucket.MatchAny)
+	require.Nil(t, err)
+	require.Empty(t, ids)
+}
+
+func TestReadFeed(t *testing.T) {
+	s := testStore(t)
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	other := tenant.NewContext(ctx, "Other")
+
+	require.Nil(t, s.OpenStream(ctx, opened("FirstBucket", "Title")))
+	require.Nil(t, s.OpenStream(other, opened("SecondBucket", "Title")))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.Closed{Base: events.Base{ID: "FirstBucket", V: 2}}))
+	require.Nil(t, s.InsertEvent(other, &bucket.Closed{Base: events.Base{ID: "SecondBucket", V: 2}}))
+	require.Nil(t, s.PurgeStream(ctx, &bucket.Purged{Base: events.Base{ID: "FirstBucket", V: 3}}))
+
+	entries, err := s.ReadFeed(ctx, 0, 10)
+	require.Nil(t, err)
+	require.Len(t, entries, 3, "purged events should be left out")
+
+	positions := []uint64{}
+	for _, e := range entries {
+		positions = append(positions, e.Position)
+	}
+	require.Equal(t, []uint64{2, 4, 5}, positions)
+	require.Equal(t, tenant.ID("Other"), entries[0].Tenant)
+	require.IsType(t, &bucket.Purged{}, entries[2].Event)
+
+	entries, err = s.ReadFeed(ctx, 0, 2)
+	require.Nil(t, err)
+	require.Len(t, entries, 2, "limit should be filled over the gaps")
+	require.Equal(t, uint64(4), entries[1].Position)
+
+	entries, err = s.ReadFeed(ctx, 5, 10)
+	require.Nil(t, err)
+	require.Empty(t, entries)
+}
+
+func TestSubscribe(t *testing.T) {
+	s := testStore(t)
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+	receive := func(ch <-chan bucket.FeedEntry, n int) []uint64 {
+		ret := []uint64{}
+		for len(ret) < n {
+			select {
+			case e := <-ch:
+				ret = append(ret, e.Position)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("received %v, want %d entries", ret, n)
+			}
+		}
+		return ret
+	}
+
+	_, err := s.Subscribe(ctx, "missing", "c1")
+	require.NotNil(t, err, "group should be created first")
+
+	require.Nil(t, s.OpenStream(ctx, opened("FirstBucket", "Title")))
+	require.Nil(t, s.CreateGroup(ctx, "projector", 1))
+	require.Nil(t, s.CreateGroup(ctx, "projector", 0), "existing group should not be an error")
+
+	sub, cancel := context.WithCancel(ctx)
+	ch, err := s.Subscribe(sub, "projector", "c1")
+	require.Nil(t, err)
+
+	require.Nil(t, s.OpenStream(ctx, opened("SecondBucket", "Title")))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.Closed{Base: events.Base{ID: "FirstBucket", V: 2}}))
+
+	require.Equal(t, []uint64{2, 3}, receive(ch, 2), "entries after the start of the group should be delivered")
+	require.Nil(t, s.Ack(ctx, "projector", 2))
+
+	cancel()
+	for range ch {
+	}
+
+	// unacknowledged entry is redelivered, and purged events are acknowledged without delivery
+	require.Nil(t, s.InsertEvent(ctx, &bucket.Reopened{Base: events.Base{ID: "SecondBucket", V: 2}}))
+	require.Nil(t, s.PurgeStream(ctx, &bucket.Purged{Base: events.Base{ID: "SecondBucket", V: 3}}))
+
+	sub, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	ch, err = s.Subscribe(sub, "projector", "c1")
+	require.Nil(t, err)
+	require.Equal(t, []uint64{3, 5}, receive(ch, 2))
+	require.Nil(t, s.Ack(ctx, "projector", 3, 5))
+
+	// other group gets all entries from its start
+	require.Nil(t, s.CreateGroup(ctx, "audit", 0))
+
+	audit, err := s.Subscribe(sub, "audit", "c1")
+	require.Nil(t, err)
+	require.Equal(t, []uint64{1, 3, 5}, receive(audit, 3))
+
+	pending, err := s.client.XPending(ctx, s.feedName(), "projector").Result()
+	require.Nil(t, err)
+	require.Zero(t, pending.Count, "all entries should be acknowledged")
+}
@@ -0,0 +1,153 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+)
+
+// batch is the number of feed entries read at once by the consumers
+const batch = 100
+
+// CreateGroup creates consumer group of the feed, which is delivered the entries after position.
+// Creating an existing group is not an error, and does not move it
+func (s *Store) CreateGroup(ctx context.Context, group string, after uint64) error {
+	const op errors.Op = "redis.Store.CreateGroup"
+
+	err := s.client.XGroupCreateMkStream(ctx, s.feedName(), group, entryID(after)).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return errors.New(op, errors.KindUnexpected, "Could not create group", err)
+	}
+
+	return nil
+}
+
+// Subscribe delivers the feed entries of the group to the consumer until ctx is done, when the returned channel is closed.
+// Each entry is delivered to one consumer of the group, and is redelivered to the same consumer when it subscribes
+// again, until the entry is acknowledged with Ack. Entries of purged events are acknowledged without delivery
+func (s *Store) Subscribe(ctx context.Context, group, consumer string) (<-chan bucket.FeedEntry, error) {
+	const op errors.Op = "redis.Store.Subscribe"
+
+	// entries left pending by earlier subscription of the consumer, which also tells if the group exists
+	pending, err := s.read(ctx, group, consumer, "0", -1)
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not read group", err)
+	}
+
+	ch := make(chan bucket.FeedEntry)
+
+	go func() {
+		defer close(ch)
+
+		// pending entries are read in batches from the last one delivered
+		for msgs := pending; len(msgs) > 0; {
+			if !s.deliver(ctx, group, msgs, ch) {
+				return
+			}
+
+			if msgs, err = s.read(ctx, group, consumer, msgs[len(msgs)-1].ID, -1); err != nil {
+				break
+			}
+		}
+
+		for ctx.Err() == nil {
+			msgs, err := s.read(ctx, group, consumer, ">", time.Second)
+			if err != nil {
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Second):
+				}
+				continue
+			}
+
+			if !s.deliver(ctx, group, msgs, ch) {
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// read reads the entries of the group after id, or new entries when id is ">". Negative block does not block
+func (s *Store) read(ctx context.Context, group, consumer, id string, block time.Duration) ([]redis.XMessage, error) {
+	res, err := s.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{s.feedName(), id},
+		Count:    batch,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return res[0].Messages, nil
+}
+
+// deliver sends the events of the feed entries to the channel, and acknowledges the entries of purged events.
+// Returns false if ctx is done
+func (s *Store) deliver(ctx context.Context, group string, msgs []redis.XMessage, ch chan<- bucket.FeedEntry) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	entries, err := s.resolve(ctx, msgs)
+	if err != nil {
+		// entries stay pending and are redelivered on next subscription
+		return true
+	}
+
+	found := make(map[uint64]bool, len(entries))
+	for _, e := range entries {
+		found[e.Position] = true
+	}
+
+	purged := []string{}
+	for _, m := range msgs {
+		if p, err := position(m.ID); err == nil && !found[p] {
+			purged = append(purged, m.ID)
+		}
+	}
+	if len(purged) > 0 {
+		s.client.XAck(ctx, s.feedName(), group, purged...)
+	}
+
+	for _, e := range entries {
+		select {
+		case ch <- e:
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	return true
+}
+
+// Ack acknowledges the entries at positions delivered to a consumer of the group
+func (s *Store) Ack(ctx context.Context, group string, positions ...uint64) error {
+	const op errors.Op = "redis.Store.Ack"
+
+	if len(positions) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(positions))
+	for i, p := range positions {
+		ids[i] = entryID(p)
+	}
+
+	if err := s.client.XAck(ctx, s.feedName(), group, ids...).Err(); err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not acknowledge", err)
+	}
+
+	return nil
+}
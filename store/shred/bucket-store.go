@@ -0,0 +1,358 @@
+// Package shred provides crypto-shredding for personal data in bucket event streams.
+//
+// Selected fields of bucket.BucketData are encrypted with AES-GCM before the events are stored,
+// using a data key of the bucket from KeyStore. Forgetting the bucket destroys its data keys,
+// after which the encrypted fields are read as empty while the stream itself stays intact.
+// Fields encrypted with keys the KeyStore does not know, e.g. lost with the keys kept in memory,
+// fail the reads instead.
+package shred
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+)
+
+// Field selects a field of bucket.BucketData for encryption
+type Field int
+
+const (
+	Description Field = iota + 1
+	// Title should be encrypted only when the underlying store does not index titles,
+	// as each encryption of the same title gives a different value
+	Title
+)
+
+func (f Field) String() string {
+	switch f {
+	case Description:
+		return "Description"
+	case Title:
+		return "Title"
+	default:
+		return "Unknown"
+	}
+}
+
+// prefix marks encrypted field values, values without it are stored before shredding was enabled
+const prefix = "shred:v1:"
+
+// NewBucketStore returns bucket.Store encrypting the fields of bucket.BucketData in events stored to s.
+// Only Description is encrypted, unless fields are given
+func NewBucketStore(s bucket.Store, keys KeyStore, fields ...Field) bucket.Store {
+	if len(fields) == 0 {
+		fields = []Field{Description}
+	}
+
+	return &store{
+		next:   s,
+		keys:   keys,
+		fields: fields,
+	}
+}
+
+type store struct {
+	next   bucket.Store
+	keys   KeyStore
+	fields []Field
+}
+
+func (s *store) OpenStream(ctx context.Context, o *bucket.Opened) error {
+	const op errors.Op = "shred.store.OpenStream"
+
+	enc := *o
+
+	if err := s.encrypt(ctx, o.EntityID(), &enc.BucketData); err != nil {
+		return errors.New(op, errors.KindUnexpected, "Encryption failed", err)
+	}
+
+	return s.next.OpenStream(ctx, &enc)
+}
+
+func (s *store) InsertEvent(ctx context.Context, e events.Event) error {
+	const op errors.Op = "shred.store.InsertEvent"
+
+	if u, ok := e.(*bucket.Updated); ok {
+		enc := *u
+
+		if err := s.encrypt(ctx, u.EntityID(), &enc.BucketData); err != nil {
+			return errors.New(op, errors.KindUnexpected, "Encryption failed", err)
+		}
+
+		e = &enc
+	}
+
+	return s.next.InsertEvent(ctx, e)
+}
+
+func (s *store) GetStream(ctx context.Context, id events.EntityID) ([]events.Event, error) {
+	const op errors.Op = "shred.store.GetStream"
+
+	stream, err := s.next.GetStream(ctx, id)
+	if err != nil {
+		return stream, err
+	}
+
+	ret := make([]events.Event, len(stream))
+
+	for i, e := range stream {
+		if ret[i], err = s.decrypted(ctx, e); err != nil {
+			return []events.Event{}, errors.New(op, errors.KindUnexpected, "Decryption failed", err)
+		}
+	}
+
+	return ret, nil
+}
+
+// decrypted returns copy of the event with decrypted fields
+func (s *store) decrypted(ctx context.Context, e events.Event) (events.Event, error) {
+	switch e := e.(type) {
+	case *bucket.Opened:
+		dec := *e
+		err := s.decrypt(ctx, e.EntityID(), &dec.BucketData)
+		return &dec, err
+	case *bucket.Updated:
+		dec := *e
+		err := s.decrypt(ctx, e.EntityID(), &dec.BucketData)
+		return &dec, err
+	default:
+		return e, nil
+	}
+}
+
+func (s *store) ShredStream(ctx context.Context, e *bucket.Forgotten) error {
+	const op errors.Op = "shred.store.ShredStream"
+
+	if err := s.next.InsertEvent(ctx, e); err != nil {
+		return err
+	}
+
+	if err := s.keys.Destroy(ctx, e.EntityID()); err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not destroy data keys", err)
+	}
+
+	return nil
+}
+
+// Encrypted returns the underlying store, which has the events with the fields encrypted
+// and hashed as they are stored
+func (s *store) Encrypted() bucket.Store {
+	return s.next
+}
+
+// Unwrap returns the underlying store. Tag queries, verification and scanning are done by it,
+// as they do not need the fields decrypted
+func (s *store) Unwrap() bucket.Store {
+	return s.next
+}
+
+// ReadFeed reads the feed of the underlying store and decrypts the events like GetStream
+func (s *store) ReadFeed(ctx context.Context, after uint64, limit int) ([]bucket.FeedEntry, error) {
+	const op errors.Op = "shred.store.ReadFeed"
+
+	f, ok := bucket.As[bucket.Feed](s.next)
+	if !ok {
+		return nil, errors.New(op, errors.KindUnexpected, "Store does not support feed")
+	}
+
+	entries, err := f.ReadFeed(ctx, after, limit)
+	if err != nil {
+		return entries, err
+	}
+
+	for i, entry := range entries {
+		if entry.Err != nil {
+			continue
+		}
+
+		e, err := s.decrypted(tenant.NewContext(ctx, entry.Tenant), entry.Event)
+		if err != nil {
+			return nil, errors.New(op, errors.KindUnexpected, "Decryption failed", err)
+		}
+		entries[i].Event = e
+	}
+
+	return entries, nil
+}
+
+func (s *store) ArchiveStream(ctx context.Context, e *bucket.Archived) error {
+	const op errors.Op = "shred.store.ArchiveStream"
+
+	a, ok := bucket.As[bucket.Archiver](s.next)
+	if !ok {
+		return errors.New(op, errors.KindUnexpected, "Store does not support archiving")
+	}
+
+	return a.ArchiveStream(ctx, e)
+}
+
+// PurgeStream destroys also the data keys, as there is nothing left to decrypt
+func (s *store) PurgeStream(ctx context.Context, e *bucket.Purged) error {
+	const op errors.Op = "shred.store.PurgeStream"
+
+	a, ok := bucket.As[bucket.Archiver](s.next)
+	if !ok {
+		return errors.New(op, errors.KindUnexpected, "Store does not support purging")
+	}
+
+	if err := a.PurgeStream(ctx, e); err != nil {
+		return err
+	}
+
+	if err := s.keys.Destroy(ctx, e.EntityID()); err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not destroy data keys", err)
+	}
+
+	return nil
+}
+
+// ImportTombstone destroys also the data keys like PurgeStream
+func (s *store) ImportTombstone(ctx context.Context, e *bucket.Purged) error {
+	const op errors.Op = "shred.store.ImportTombstone"
+
+	imp, ok := bucket.As[bucket.Importer](s.next)
+	if !ok {
+		return errors.New(op, errors.KindUnexpected, "Store does not support importing")
+	}
+
+	if err := imp.ImportTombstone(ctx, e); err != nil {
+		return err
+	}
+
+	if err := s.keys.Destroy(ctx, e.EntityID()); err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not destroy data keys", err)
+	}
+
+	return nil
+}
+
+// ImportEvent imports the event without encrypting it, as events are exported from the store returned by Encrypted
+// with their fields encrypted, see package backup. Plain fields of streams exported from stores without shredding
+// stay plain and are read like the fields stored before shredding was enabled
+func (s *store) ImportEvent(ctx context.Context, e events.Event) error {
+	const op errors.Op = "shred.store.ImportEvent"
+
+	imp, ok := bucket.As[bucket.Importer](s.next)
+	if !ok {
+		return errors.New(op, errors.KindUnexpected, "Store does not support importing")
+	}
+
+	return imp.ImportEvent(ctx, e)
+}
+
+// encrypt replaces the selected fields of data with their encrypted values. Values looking encrypted are
+// encrypted too, as they are given by the user, and encrypted events are restored only by ImportEvent
+func (s *store) encrypt(ctx context.Context, id events.EntityID, data *bucket.BucketData) error {
+	const op errors.Op = "shred.store.encrypt"
+
+	kid, key, err := s.keys.DataKey(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return errors.New(op, errors.KindUnexpected, "Invalid data key", err)
+	}
+
+	for _, f := range s.fields {
+		v := field(data, f)
+		if *v == "" {
+			continue
+		}
+
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return errors.New(op, errors.KindUnexpected, "Could not create nonce", err)
+		}
+
+		sealed := aead.Seal(nonce, nonce, []byte(*v), additional(ctx, id, f))
+
+		*v = prefix + kid + ":" + base64.RawURLEncoding.EncodeToString(sealed)
+	}
+
+	return nil
+}
+
+// decrypt replaces the encrypted fields of data with their plain values.
+// Fields encrypted with destroyed keys are set empty, unknown keys are errors
+func (s *store) decrypt(ctx context.Context, id events.EntityID, data *bucket.BucketData) error {
+	const op errors.Op = "shred.store.decrypt"
+
+	for _, f := range []Field{Description, Title} {
+		v := field(data, f)
+		if !strings.HasPrefix(*v, prefix) {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(*v, prefix), ":", 2)
+		if len(parts) != 2 {
+			return errors.New(op, errors.KindUnexpected, "Malformed encrypted "+f.String())
+		}
+
+		key, err := s.keys.Key(ctx, id, parts[0])
+		if e, ok := err.(*errors.Error); ok && e.Kind == errors.KindNotFound {
+			// data key is destroyed and the value forgotten
+			*v = ""
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		sealed, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			return errors.New(op, errors.KindUnexpected, "Malformed encrypted "+f.String(), err)
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return errors.New(op, errors.KindUnexpected, "Invalid data key", err)
+		}
+
+		if len(sealed) < aead.NonceSize() {
+			return errors.New(op, errors.KindUnexpected, "Malformed encrypted "+f.String())
+		}
+
+		plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional(ctx, id, f))
+		if err != nil {
+			return errors.New(op, errors.KindUnexpected, "Encrypted "+f.String()+" is tampered", err)
+		}
+
+		*v = string(plain)
+	}
+
+	return nil
+}
+
+// field returns pointer to the field of data as string
+func field(data *bucket.BucketData, f Field) *string {
+	switch f {
+	case Title:
+		return (*string)(&data.Title)
+	default:
+		return (*string)(&data.Description)
+	}
+}
+
+// additional binds the ciphertext to the tenant, bucket and field, so it can not be moved elsewhere
+func additional(ctx context.Context, id events.EntityID, f Field) []byte {
+	return []byte(string(tenant.Of(ctx)) + "/" + string(id) + "/" + f.String())
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,288 @@
+package shred
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+)
+
+// KeyStore holds the data keys of buckets. Data keys are scoped by the tenant in context
+type KeyStore interface {
+	// DataKey returns id and value of the current data key of the bucket. Key is created if bucket has none
+	DataKey(ctx context.Context, id events.EntityID) (string, []byte, error)
+	// Key returns the data key of the bucket with key id, error has KindNotFound if the key is destroyed.
+	// Keys the store does not know are not read as destroyed, their error has KindUnexpected
+	Key(ctx context.Context, id events.EntityID, keyID string) ([]byte, error)
+	// Destroy destroys all data keys of the bucket, the ids of the keys are kept to tell they were destroyed
+	Destroy(ctx context.Context, id events.EntityID) error
+}
+
+// NewKeyStore returns KeyStore holding the data keys in memory. The keys are lost when the process exits,
+// so it is for tests only. Use OpenKeyFile to keep the keys
+func NewKeyStore() KeyStore {
+	return newKeyStore("")
+}
+
+// OpenKeyFile returns KeyStore keeping the data keys in local JSON file at path, which is created if it does not exist.
+// File is rewritten on each change, and has to be protected like the keys. Destroyed keys are removed from the file,
+// but earlier copies of it, e.g. in backups, have them
+func OpenKeyFile(path string) (KeyStore, error) {
+	const op errors.Op = "shred.OpenKeyFile"
+
+	ks := newKeyStore(path)
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ks, nil
+	}
+	if err != nil {
+		return nil, errors.New(op, errors.KindUnexpected, "Could not read key file", err)
+	}
+
+	var f keyFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, errors.New(op, errors.KindValidation, "Invalid key file", err)
+	}
+
+	for _, fr := range f.Rings {
+		r := keyRing{fr.Tenant, fr.ID}
+
+		ks.keys[r] = map[string][]byte{}
+		for kid, v := range fr.Keys {
+			if ks.keys[r][kid], err = base64.StdEncoding.DecodeString(v); err != nil {
+				return nil, errors.New(op, errors.KindValidation, "Invalid encoding for key "+kid, err)
+			}
+		}
+
+		if fr.Current != "" {
+			ks.curr[r] = fr.Current
+		}
+
+		ks.destroyed[r] = map[string]bool{}
+		for _, kid := range fr.Destroyed {
+			ks.destroyed[r][kid] = true
+		}
+	}
+
+	return ks, nil
+}
+
+func newKeyStore(path string) *keyStore {
+	return &keyStore{
+		mtx:       sync.Mutex{},
+		keys:      map[keyRing]map[string][]byte{},
+		curr:      map[keyRing]string{},
+		destroyed: map[keyRing]map[string]bool{},
+		path:      path,
+	}
+}
+
+type keyStore struct {
+	mtx       sync.Mutex
+	keys      map[keyRing]map[string][]byte // data keys by key id
+	curr      map[keyRing]string            // id of the key used for encrypting
+	destroyed map[keyRing]map[string]bool   // ids of the destroyed keys
+	path      string                        // key file, empty if keys are kept in memory only
+}
+
+// keyRing identifies the data keys of one bucket
+type keyRing struct {
+	tenant tenant.ID
+	id     events.EntityID
+}
+
+// keyFile is the format of the key files, e.g.
+//
+//	{"rings": [{"tenant": "default", "id": "...", "current": "<key id>", "keys": {"<key id>": "<base64 key>"}, "destroyed": []}]}
+type keyFile struct {
+	Rings []fileRing `json:"rings"`
+}
+
+type fileRing struct {
+	Tenant    tenant.ID         `json:"tenant"`
+	ID        events.EntityID   `json:"id"`
+	Current   string            `json:"current,omitempty"`
+	Keys      map[string]string `json:"keys,omitempty"`
+	Destroyed []string          `json:"destroyed,omitempty"`
+}
+
+// save writes the keys to the key file, if there is one. File is replaced by renaming a new file over it,
+// so it is never left half written
+func (ks *keyStore) save() error {
+	if ks.path == "" {
+		return nil
+	}
+
+	rings := map[keyRing]*fileRing{}
+	ring := func(r keyRing) *fileRing {
+		if rings[r] == nil {
+			rings[r] = &fileRing{Tenant: r.tenant, ID: r.id}
+		}
+		return rings[r]
+	}
+
+	for r, keys := range ks.keys {
+		fr := ring(r)
+		fr.Current = ks.curr[r]
+		fr.Keys = map[string]string{}
+		for kid, key := range keys {
+			fr.Keys[kid] = base64.StdEncoding.EncodeToString(key)
+		}
+	}
+
+	for r, kids := range ks.destroyed {
+		fr := ring(r)
+		for kid := range kids {
+			fr.Destroyed = append(fr.Destroyed, kid)
+		}
+		sort.Strings(fr.Destroyed)
+	}
+
+	f := keyFile{Rings: []fileRing{}}
+	for _, fr := range rings {
+		f.Rings = append(f.Rings, *fr)
+	}
+	sort.Slice(f.Rings, func(i, j int) bool {
+		if f.Rings[i].Tenant != f.Rings[j].Tenant {
+			return f.Rings[i].Tenant < f.Rings[j].Tenant
+		}
+		return f.Rings[i].ID < f.Rings[j].ID
+	})
+
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(ks.path), filepath.Base(ks.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), ks.path)
+}
+
+func (ks *keyStore) DataKey(ctx context.Context, id events.EntityID) (string, []byte, error) {
+	const op errors.Op = "shred.keyStore.DataKey"
+
+	r := keyRing{tenant.Of(ctx), id}
+
+	ks.mtx.Lock()
+	defer ks.mtx.Unlock()
+
+	if kid, ok := ks.curr[r]; ok {
+		return kid, ks.keys[r][kid], nil
+	}
+
+	kid, key, err := newKey()
+	if err != nil {
+		return "", nil, errors.New(op, errors.KindUnexpected, "Could not create data key", err)
+	}
+
+	if ks.keys[r] == nil {
+		ks.keys[r] = map[string][]byte{}
+	}
+	ks.keys[r][kid] = key
+	ks.curr[r] = kid
+
+	if err := ks.save(); err != nil {
+		delete(ks.keys[r], kid)
+		delete(ks.curr, r)
+		return "", nil, errors.New(op, errors.KindUnexpected, "Could not save data key", err)
+	}
+
+	return kid, key, nil
+}
+
+func (ks *keyStore) Key(ctx context.Context, id events.EntityID, keyID string) ([]byte, error) {
+	const op errors.Op = "shred.keyStore.Key"
+
+	r := keyRing{tenant.Of(ctx), id}
+
+	ks.mtx.Lock()
+	defer ks.mtx.Unlock()
+
+	if key, ok := ks.keys[r][keyID]; ok {
+		return key, nil
+	}
+
+	if ks.destroyed[r][keyID] {
+		return nil, errors.New(op, errors.KindNotFound, "Data key is destroyed")
+	}
+
+	return nil, errors.New(op, errors.KindUnexpected, "Unknown data key: "+keyID)
+}
+
+func (ks *keyStore) Destroy(ctx context.Context, id events.EntityID) error {
+	const op errors.Op = "shred.keyStore.Destroy"
+
+	r := keyRing{tenant.Of(ctx), id}
+
+	ks.mtx.Lock()
+	defer ks.mtx.Unlock()
+
+	if len(ks.keys[r]) > 0 && ks.destroyed[r] == nil {
+		ks.destroyed[r] = map[string]bool{}
+	}
+
+	keys := ks.keys[r]
+	for kid := range keys {
+		ks.destroyed[r][kid] = true
+	}
+
+	delete(ks.keys, r)
+	delete(ks.curr, r)
+
+	if err := ks.save(); err != nil {
+		return errors.New(op, errors.KindUnexpected, "Could not save destroyed keys", err)
+	}
+
+	// overwrite the keys only after they are removed from the file, so they do not linger in memory
+	for _, key := range keys {
+		for i := range key {
+			key[i] = 0
+		}
+	}
+
+	return nil
+}
+
+// newKey returns random key id and 256 bit AES key
+func newKey() (string, []byte, error) {
+	kid := make([]byte, 8)
+	if _, err := rand.Read(kid); err != nil {
+		return "", nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", nil, err
+	}
+
+	return hex.EncodeToString(kid), key, nil
+}
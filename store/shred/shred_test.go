@@ -0,0 +1,189 @@
+package shred
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/juelko/bucket/store/inmem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShredding(t *testing.T) {
+	t.Parallel()
+
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	inner := inmem.NewBucketStore()
+	keys := NewKeyStore()
+	store := NewBucketStore(inner, keys)
+
+	open := &bucket.Opened{
+		Base:       events.Base{ID: "TestBucket", V: 1},
+		BucketData: bucket.BucketData{Title: "TestTitle", Description: "Personal data"},
+		Owner:      "TestOwner",
+	}
+	require.Nil(t, store.OpenStream(ctx, open))
+	require.Equal(t, bucket.Description("Personal data"), open.Description, "event given to store should not change")
+
+	update := &bucket.Updated{
+		Base:       events.Base{ID: "TestBucket", V: 2},
+		BucketData: bucket.BucketData{Title: "NewTitle", Description: "More personal data"},
+	}
+	require.Nil(t, store.InsertEvent(ctx, update))
+
+	stored, err := inner.GetStream(ctx, "TestBucket")
+	require.Nil(t, err)
+	require.Equal(t, bucket.Title("TestTitle"), stored[0].(*bucket.Opened).Title)
+	require.True(t, strings.HasPrefix(string(stored[0].(*bucket.Opened).Description), prefix))
+	require.True(t, strings.HasPrefix(string(stored[1].(*bucket.Updated).Description), prefix))
+
+	stream, err := store.GetStream(ctx, "TestBucket")
+	require.Nil(t, err)
+	require.Equal(t, bucket.Description("Personal data"), stream[0].(*bucket.Opened).Description)
+	require.Equal(t, bucket.Description("More personal data"), stream[1].(*bucket.Updated).Description)
+
+	other := tenant.NewContext(ctx, "Other")
+	require.Nil(t, store.OpenStream(other, &bucket.Opened{Base: events.Base{ID: "TestBucket", V: 1}, BucketData: bucket.BucketData{Title: "TestTitle", Description: "Other data"}, Owner: "TestOwner"}))
+
+	require.Nil(t, store.(bucket.Shredder).ShredStream(ctx, &bucket.Forgotten{Base: events.Base{ID: "TestBucket", V: 3}}))
+
+	stream, err = store.GetStream(ctx, "TestBucket")
+	require.Nil(t, err)
+	require.Len(t, stream, 3, "stream should stay intact")
+	require.Equal(t, bucket.BucketData{Title: "TestTitle"}, stream[0].(*bucket.Opened).BucketData)
+	require.Equal(t, bucket.BucketData{Title: "NewTitle"}, stream[1].(*bucket.Updated).BucketData)
+
+	stream, err = store.GetStream(other, "TestBucket")
+	require.Nil(t, err)
+	require.Equal(t, bucket.Description("Other data"), stream[0].(*bucket.Opened).Description, "other tenant should keep its key")
+
+	require.Nil(t, store.InsertEvent(ctx, &bucket.Updated{Base: events.Base{ID: "TestBucket", V: 4}, BucketData: bucket.BucketData{Title: "NewTitle", Description: "New data"}}))
+
+	stream, err = store.GetStream(ctx, "TestBucket")
+	require.Nil(t, err)
+	require.Equal(t, bucket.Description(""), stream[1].(*bucket.Updated).Description)
+	require.Equal(t, bucket.Description("New data"), stream[3].(*bucket.Updated).Description, "new data should use new key")
+}
+
+func TestPrefixedValue(t *testing.T) {
+	t.Parallel()
+
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	inner := inmem.NewBucketStore()
+	store := NewBucketStore(inner, NewKeyStore())
+
+	desc := bucket.Description(prefix + "abc:secret plaintext")
+	require.Nil(t, store.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: "TestBucket", V: 1}, BucketData: bucket.BucketData{Title: "TestTitle", Description: desc}, Owner: "TestOwner"}))
+
+	stored, err := inner.GetStream(ctx, "TestBucket")
+	require.Nil(t, err)
+	require.NotContains(t, string(stored[0].(*bucket.Opened).Description), "secret plaintext", "value looking encrypted should be encrypted")
+
+	stream, err := store.GetStream(ctx, "TestBucket")
+	require.Nil(t, err)
+	require.Equal(t, desc, stream[0].(*bucket.Opened).Description)
+}
+
+func TestTitleEncryption(t *testing.T) {
+	t.Parallel()
+
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	inner := inmem.NewBucketStore()
+	store := NewBucketStore(inner, NewKeyStore(), Title, Description)
+
+	require.Nil(t, store.OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: "TestBucket", V: 1}, BucketData: bucket.BucketData{Title: "TestTitle"}, Owner: "TestOwner"}))
+
+	stored, err := inner.GetStream(ctx, "TestBucket")
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(string(stored[0].(*bucket.Opened).Title), prefix))
+	require.Equal(t, bucket.Description(""), stored[0].(*bucket.Opened).Description, "empty values are not encrypted")
+
+	stream, err := store.GetStream(ctx, "TestBucket")
+	require.Nil(t, err)
+	require.Equal(t, bucket.BucketData{Title: "TestTitle"}, stream[0].(*bucket.Opened).BucketData)
+}
+
+func TestTampering(t *testing.T) {
+	t.Parallel()
+
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	keys := NewKeyStore()
+	store := &store{keys: keys, fields: []Field{Description}}
+
+	data := bucket.BucketData{Description: "Personal data"}
+	require.Nil(t, store.encrypt(ctx, "TestBucket", &data))
+
+	moved := bucket.BucketData{Title: bucket.Title(data.Description)}
+	err := store.decrypt(ctx, "TestBucket", &moved)
+	require.NotNil(t, err, "ciphertext should be bound to the field")
+
+	enc := []byte(data.Description)
+	if i := len(enc) - 10; enc[i] == 'A' {
+		enc[i] = 'B'
+	} else {
+		enc[i] = 'A'
+	}
+	tampered := bucket.BucketData{Description: bucket.Description(enc)}
+	err = store.decrypt(ctx, "TestBucket", &tampered)
+	require.NotNil(t, err)
+	require.Equal(t, errors.KindUnexpected, err.(*errors.Error).Kind)
+
+	plain := bucket.BucketData{Description: "Stored before shredding"}
+	require.Nil(t, store.decrypt(ctx, "TestBucket", &plain))
+	require.Equal(t, bucket.Description("Stored before shredding"), plain.Description)
+}
+
+func TestKeyStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	path := filepath.Join(t.TempDir(), "keys.json")
+
+	keys, err := OpenKeyFile(path)
+	require.Nil(t, err)
+
+	kept, key, err := keys.DataKey(ctx, "KeptBucket")
+	require.Nil(t, err)
+
+	destroyed, _, err := keys.DataKey(ctx, "ForgottenBucket")
+	require.Nil(t, err)
+	require.Nil(t, keys.Destroy(ctx, "ForgottenBucket"))
+
+	keys, err = OpenKeyFile(path)
+	require.Nil(t, err)
+
+	got, err := keys.Key(ctx, "KeptBucket", kept)
+	require.Nil(t, err, "keys should survive reopening")
+	require.Equal(t, key, got)
+
+	kid, _, err := keys.DataKey(ctx, "KeptBucket")
+	require.Nil(t, err)
+	require.Equal(t, kept, kid, "current key should survive reopening")
+
+	_, err = keys.Key(ctx, "ForgottenBucket", destroyed)
+	require.NotNil(t, err)
+	require.Equal(t, errors.KindNotFound, err.(*errors.Error).Kind, "destroyed key should be remembered")
+
+	_, err = keys.Key(tenant.NewContext(ctx, "Other"), "KeptBucket", kept)
+	require.NotNil(t, err)
+	require.Equal(t, errors.KindUnexpected, err.(*errors.Error).Kind, "unknown key should not be read as destroyed")
+}
+
+func TestLostKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	inner := inmem.NewBucketStore()
+
+	require.Nil(t, NewBucketStore(inner, NewKeyStore()).OpenStream(ctx, &bucket.Opened{Base: events.Base{ID: "TestBucket", V: 1}, BucketData: bucket.BucketData{Title: "TestTitle", Description: "Personal data"}, Owner: "TestOwner"}))
+
+	// keys kept in memory are lost on restart
+	_, err := NewBucketStore(inner, NewKeyStore()).GetStream(ctx, "TestBucket")
+	require.NotNil(t, err, "lost keys should not read as forgotten data")
+	require.Equal(t, errors.KindUnexpected, err.(*errors.Error).Kind)
+}
@@ -0,0 +1,299 @@
+// Package storetest checks that a store keeping the event streams behaves like the other stores.
+//
+// Each store calls Check from its tests with a function returning a new, empty store. The checks cover
+// the streams, the tag and title indexes, imported events, concurrent appends and the service model of modeltest.
+// Behaviour of the store alone, like reopening a file or the ops of its errors, is tested by the store
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/juelko/bucket/bucket"
+	"github.com/juelko/bucket/pkg/errors"
+	"github.com/juelko/bucket/pkg/events"
+	"github.com/juelko/bucket/pkg/tenant"
+	"github.com/juelko/bucket/service/modeltest"
+)
+
+// Store is the store checked, with the ports of the durable stores
+type Store interface {
+	bucket.Store
+	bucket.TagIndex
+	bucket.Archiver
+	bucket.Importer
+	bucket.Verifier
+	bucket.Scanner
+	bucket.Feed
+}
+
+// NewStore returns a new, empty store, which reserves titles ignoring case if unique is set
+type NewStore func(t *testing.T, unique bool) Store
+
+// Check runs the checks against the stores returned by newStore
+func Check(t *testing.T, newStore NewStore) {
+	t.Run("streams", func(t *testing.T) {
+		t.Parallel()
+		checkStreams(t, newStore(t, false))
+	})
+	t.Run("indexes", func(t *testing.T) {
+		t.Parallel()
+		checkIndexes(t, newStore(t, true))
+	})
+	t.Run("import", func(t *testing.T) {
+		t.Parallel()
+		checkImport(t, newStore(t, false))
+	})
+	t.Run("concurrent appends", func(t *testing.T) {
+		t.Parallel()
+		checkConcurrentAppends(t, newStore(t, false))
+	})
+	t.Run("model", func(t *testing.T) {
+		t.Parallel()
+		modeltest.Check(t, func() bucket.Store { return newStore(t, false) }, modeltest.Config{Seed: 1, Runs: 20})
+	})
+}
+
+func opened(id events.EntityID, title bucket.Title) *bucket.Opened {
+	return &bucket.Opened{Base: events.Base{ID: id, V: 1}, BucketData: bucket.BucketData{Title: title}, Owner: "TestOwner"}
+}
+
+func checkStreams(t *testing.T, s Store) {
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	other := tenant.NewContext(ctx, "Other")
+
+	require.Nil(t, s.OpenStream(ctx, opened("TestBucket", "TestTitle")))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.ItemAdded{Base: events.Base{ID: "TestBucket", V: 2}, Item: bucket.Item{ID: "Item1", Name: "Item", Payload: bucket.Payload("data")}}))
+	require.Nil(t, s.OpenStream(other, opened("TestBucket", "TestTitle")))
+
+	testCases := []struct {
+		desc string
+		err  error
+		kind errors.Kind
+		msg  string
+	}{
+		{
+			desc: "existing",
+			err:  s.OpenStream(ctx, opened("TestBucket", "Other")),
+			kind: errors.KindAllreadyExists,
+			msg:  "Allready exists",
+		},
+		{
+			desc: "version",
+			err:  s.InsertEvent(ctx, &bucket.Closed{Base: events.Base{ID: "TestBucket", V: 2}}),
+			kind: errors.KindUnexpected,
+			msg:  "version error",
+		},
+		{
+			desc: "not found",
+			err:  s.InsertEvent(ctx, &bucket.Closed{Base: events.Base{ID: "MissingBucket", V: 2}}),
+			kind: errors.KindNotFound,
+			msg:  "Stream not found",
+		},
+		{
+			desc: "invalid tenant",
+			err:  s.InsertEvent(tenant.NewContext(ctx, "in:valid"), &bucket.Closed{Base: events.Base{ID: "TestBucket", V: 3}}),
+			kind: errors.KindValidation,
+			msg:  "Invalid tenant",
+		},
+		{
+			desc: "missing tenant",
+			err:  s.InsertEvent(context.Background(), &bucket.Closed{Base: events.Base{ID: "TestBucket", V: 3}}),
+			kind: errors.KindValidation,
+			msg:  "Invalid tenant",
+		},
+	}
+
+	for _, tC := range testCases {
+		require.NotNil(t, tC.err, tC.desc)
+		require.Equal(t, tC.kind, tC.err.(*errors.Error).Kind, tC.desc)
+		require.Equal(t, tC.msg, tC.err.(*errors.Error).Msg, tC.desc)
+	}
+
+	require.Nil(t, s.InsertEvent(ctx, &bucket.Closed{Base: events.Base{ID: "TestBucket", V: 3}}))
+	require.Nil(t, s.ArchiveStream(ctx, &bucket.Archived{Base: events.Base{ID: "TestBucket", V: 4}}))
+
+	err := s.InsertEvent(ctx, &bucket.Reopened{Base: events.Base{ID: "TestBucket", V: 5}})
+	require.NotNil(t, err)
+	require.Equal(t, "Stream is archived", err.(*errors.Error).Msg)
+
+	stream, err := s.GetStream(ctx, "TestBucket")
+	require.Nil(t, err)
+	require.Len(t, stream, 4)
+	require.Nil(t, s.VerifyStream(ctx, "TestBucket"), "hashes should survive the precision of stored times")
+
+	v, err := bucket.NewView("TestBucket", stream...)
+	require.Nil(t, err)
+	require.True(t, v.IsArchived)
+	require.Equal(t, []byte("data"), v.Items[0].Payload)
+
+	_, err = s.GetStream(ctx, "MissingBucket")
+	require.NotNil(t, err)
+	require.Equal(t, errors.KindNotFound, err.(*errors.Error).Kind)
+
+	_, err = s.GetStream(other, "TestBucket")
+	require.Nil(t, err)
+
+	refs, err := s.Streams(ctx)
+	require.Nil(t, err)
+	require.Equal(t, []bucket.StreamRef{{Tenant: "Other", ID: "TestBucket"}, {Tenant: tenant.Default, ID: "TestBucket"}}, refs)
+
+	entries, err := s.ReadFeed(ctx, 0, 10)
+	require.Nil(t, err)
+	require.Len(t, entries, 5)
+	require.Equal(t, tenant.ID("Other"), entries[2].Tenant)
+	require.Equal(t, stream[3].Hash(), entries[4].Event.Hash())
+
+	entries, err = s.ReadFeed(ctx, entries[1].Position, 2)
+	require.Nil(t, err)
+	require.Len(t, entries, 2, "feed should continue after the position")
+	require.Equal(t, tenant.ID("Other"), entries[0].Tenant)
+}
+
+func checkIndexes(t *testing.T, s Store) {
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+	require.Nil(t, s.OpenStream(ctx, opened("FirstBucket", "Title")))
+	require.Nil(t, s.OpenStream(ctx, opened("SecondBucket", "Other")))
+
+	err := s.OpenStream(ctx, opened("ThirdBucket", "TITLE"))
+	require.NotNil(t, err)
+	require.Equal(t, &bucket.TitleConflict{ID: "FirstBucket"}, err.(*errors.Error).Wraps)
+
+	err = s.InsertEvent(ctx, &bucket.Updated{Base: events.Base{ID: "SecondBucket", V: 2}, BucketData: bucket.BucketData{Title: "title"}})
+	require.NotNil(t, err)
+	require.Equal(t, &bucket.TitleConflict{ID: "FirstBucket"}, err.(*errors.Error).Wraps)
+
+	require.Nil(t, s.InsertEvent(ctx, &bucket.Updated{Base: events.Base{ID: "FirstBucket", V: 2}, BucketData: bucket.BucketData{Title: "Renamed"}}))
+	require.Nil(t, s.OpenStream(ctx, opened("ThirdBucket", "Title")), "old title should be released")
+
+	require.Nil(t, s.InsertEvent(ctx, &bucket.TagAdded{Base: events.Base{ID: "FirstBucket", V: 3}, Tag: "team:a"}))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.TagAdded{Base: events.Base{ID: "FirstBucket", V: 4}, Tag: "team:b"}))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.TagAdded{Base: events.Base{ID: "FirstBucket", V: 5}, Tag: "team"}))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.TagAdded{Base: events.Base{ID: "SecondBucket", V: 2}, Tag: "team:a"}))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.TagAdded{Base: events.Base{ID: "SecondBucket", V: 3}, Tag: "team:c"}))
+	require.Nil(t, s.InsertEvent(ctx, &bucket.TagRemoved{Base: events.Base{ID: "SecondBucket", V: 4}, Tag: "team:c"}))
+
+	ids, err := s.FindByTags(ctx, []bucket.Tag{"team:a", "team:b"}, bucket.MatchAll)
+	require.Nil(t, err)
+	require.Equal(t, []events.EntityID{"FirstBucket"}, ids)
+
+	ids, err = s.FindByTags(ctx, []bucket.Tag{"team:a", "team:b", "team:c"}, bucket.MatchAny)
+	require.Nil(t, err)
+	require.Equal(t, []events.EntityID{"FirstBucket", "SecondBucket"}, ids)
+
+	ids, err = s.FindByTags(ctx, []bucket.Tag{"team"}, bucket.MatchAll)
+	require.Nil(t, err)
+	require.Equal(t, []events.EntityID{"FirstBucket"}, ids, "tag should not match by prefix")
+
+	ids, err = s.FindByTags(tenant.NewContext(ctx, "Other"), []bucket.Tag{"team:a"}, bucket.MatchAny)
+	require.Nil(t, err)
+	require.Empty(t, ids, "tags should be isolated by tenant")
+
+	require.Nil(t, s.PurgeStream(ctx, &bucket.Purged{Base: events.Base{ID: "FirstBucket", V: 6}}))
+
+	ids, err = s.FindByTags(ctx, []bucket.Tag{"team:a", "team:b", "team"}, bucket.MatchAny)
+	require.Nil(t, err)
+	require.Equal(t, []events.EntityID{"SecondBucket"}, ids, "purge should remove tags")
+
+	require.Nil(t, s.OpenStream(ctx, opened("FourthBucket", "Renamed")), "purge should release title")
+
+	err = s.PurgeStream(ctx, &bucket.Purged{Base: events.Base{ID: "FirstBucket", V: 6}})
+	require.NotNil(t, err)
+	require.Equal(t, "version error", err.(*errors.Error).Msg)
+
+	stream, err := s.GetStream(ctx, "FirstBucket")
+	require.Nil(t, err)
+	require.Len(t, stream, 1)
+	require.Nil(t, events.Verify(stream), "tombstone should start a new chain")
+
+	entries, err := s.ReadFeed(ctx, 0, 100)
+	require.Nil(t, err)
+	for _, e := range entries {
+		if e.Event.EntityID() == "FirstBucket" {
+			require.IsType(t, &bucket.Purged{}, e.Event, "purged events should be left out of feed")
+		}
+	}
+}
+
+func checkImport(t *testing.T, s Store) {
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+	at := time.Date(2021, 2, 3, 4, 5, 6, 7000, time.UTC)
+	base := func(v events.EntityVersion) events.Base {
+		return events.Base{ID: "TestBucket", V: v, At: at.Add(time.Duration(v) * time.Hour)}
+	}
+
+	stream := []events.Event{
+		&bucket.Opened{Base: base(1), BucketData: bucket.BucketData{Title: "TestTitle"}, Owner: "TestOwner"},
+		&bucket.Closed{Base: base(2)},
+		&bucket.Archived{Base: base(3)},
+	}
+
+	for _, e := range stream {
+		require.Nil(t, s.ImportEvent(ctx, e))
+	}
+
+	got, err := s.GetStream(ctx, "TestBucket")
+	require.Nil(t, err)
+	require.Len(t, got, len(stream))
+	for i := range stream {
+		require.True(t, stream[i].Timestamp().Equal(got[i].Timestamp()), "version %d should keep its store time", i+1)
+	}
+	require.Nil(t, s.VerifyStream(ctx, "TestBucket"))
+
+	err = s.InsertEvent(ctx, &bucket.Reopened{Base: events.Base{ID: "TestBucket", V: 4}})
+	require.Equal(t, errors.KindExpected, err.(*errors.Error).Kind, "imported archive event should archive the stream")
+
+	err = s.ImportEvent(ctx, &bucket.Opened{Base: events.Base{ID: "OtherBucket", V: 1}, BucketData: bucket.BucketData{Title: "Other"}, Owner: "TestOwner"})
+	require.Equal(t, errors.KindValidation, err.(*errors.Error).Kind, "event without store time should not be imported")
+
+	err = s.ImportEvent(ctx, &bucket.Purged{Base: base(4)})
+	require.Equal(t, errors.KindValidation, err.(*errors.Error).Kind, "tombstone should be imported with ImportTombstone")
+}
+
+func checkConcurrentAppends(t *testing.T, s Store) {
+	ctx := tenant.NewContext(context.Background(), tenant.Default)
+
+	require.Nil(t, s.OpenStream(ctx, opened("TestBucket", "Title")))
+
+	var wg sync.WaitGroup
+	var written int32
+	errs := make(chan error, 8)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			err := s.InsertEvent(ctx, &bucket.TagAdded{Base: events.Base{ID: "TestBucket", V: 2}, Tag: bucket.Tag(fmt.Sprint(i))})
+			if err == nil {
+				atomic.AddInt32(&written, 1)
+				return
+			}
+			errs <- err
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	require.Equal(t, int32(1), written, "only one append of the same version should succeed")
+	for err := range errs {
+		require.Equal(t, "version error", err.(*errors.Error).Msg)
+	}
+
+	stream, err := s.GetStream(ctx, "TestBucket")
+	require.Nil(t, err)
+	require.Len(t, stream, 2)
+	require.Nil(t, events.Verify(stream))
+
+	entries, err := s.ReadFeed(ctx, 0, 10)
+	require.Nil(t, err)
+	require.Len(t, entries, 2)
+}